@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/apipb"
+)
+
+// usesGRPC reports whether requests to path go over the gRPC socket, which
+// serves only ADD, DEL and CHECK.
+func (d daemonClient) usesGRPC(path string) bool {
+	if d.grpcSocketPath == "" {
+		return false
+	}
+	switch path {
+	case "/add", "/del", "/check":
+		return true
+	}
+	return false
+}
+
+// grpcRequest sends the request for path over the daemon's gRPC socket,
+// converting between the api types and their protobuf messages.
+func (d daemonClient) grpcRequest(path string, reqBody, respBody interface{}) error {
+	conn, err := grpc.NewClient("unix://"+d.grpcSocketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("daemon request failed: %v", err)
+	}
+	defer conn.Close()
+	client := apipb.NewDaemonClient(conn)
+
+	// ResourceExhausted means the daemon is shedding load, as a 429 does
+	// over HTTP, unless the pod is over its rate limit. The daemon's
+	// Retry-After hint and error reason come in trailers.
+	var trailer metadata.MD
+	for attempt := 1; ; attempt++ {
+		trailer = nil
+		err = d.grpcCall(client, path, reqBody, respBody, grpc.Trailer(&trailer))
+		if status.Code(err) != codes.ResourceExhausted || attempt >= daemonBusyAttempts || firstValue(trailer, "reason") == api.ReasonRateLimited {
+			break
+		}
+		time.Sleep(retryAfter(firstValue(trailer, "retry-after")))
+	}
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.AlreadyExists:
+		return types.NewError(types.ErrInvalidNetworkConfig, "daemon conflict", st.Message())
+	case codes.Unavailable:
+		// Without a hint, Unavailable comes from gRPC itself, e.g. when
+		// the socket cannot be reached, rather than from the daemon.
+		if hint := firstValue(trailer, "retry-after"); hint != "" {
+			return unavailableError(st.Message(), hint)
+		}
+		return fmt.Errorf("daemon request failed: %s", st.Message())
+	}
+	return fmt.Errorf("daemon error: %s", st.Message())
+}
+
+// firstValue returns the first value of key in md, or "".
+func firstValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (d daemonClient) grpcCall(client apipb.DaemonClient, path string, reqBody, respBody interface{}, opts ...grpc.CallOption) error {
+	ctx := context.Background()
+	switch path {
+	case "/add":
+		req, err := apipb.FromAddRequest(reqBody.(api.AddRequest))
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		resp, err := client.Add(ctx, req, opts...)
+		if err != nil {
+			return err
+		}
+		if out, ok := respBody.(*api.AddResponse); ok {
+			*out = resp.ToAPI()
+		}
+	case "/del":
+		resp, err := client.Del(ctx, apipb.FromDelRequest(reqBody.(api.DelRequest)), opts...)
+		if err != nil {
+			return err
+		}
+		if out, ok := respBody.(*api.DelResponse); ok {
+			*out = resp.ToAPI()
+		}
+	case "/check":
+		resp, err := client.Check(ctx, apipb.FromCheckRequest(reqBody.(api.CheckRequest)), opts...)
+		if err != nil {
+			return err
+		}
+		if out, ok := respBody.(*api.CheckResponse); ok {
+			*out = resp.ToAPI()
+		}
+	default:
+		return fmt.Errorf("%s is not served over gRPC", path)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/apipb"
+)
+
+// mockGRPCDaemon records the calls it receives and answers like
+// setupMockDaemon, or with addErr for Add when set. addTrailer is sent
+// with Add responses.
+type mockGRPCDaemon struct {
+	apipb.UnimplementedDaemonServer
+	addErr     error
+	addTrailer metadata.MD
+
+	mu    sync.Mutex
+	calls []string
+	add   api.AddRequest
+}
+
+func (m *mockGRPCDaemon) record(call string) {
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	m.mu.Unlock()
+}
+
+func (m *mockGRPCDaemon) Add(ctx context.Context, req *apipb.AddRequest) (*apipb.AddResponse, error) {
+	m.record("add")
+	if m.addTrailer != nil {
+		_ = grpc.SetTrailer(ctx, m.addTrailer)
+	}
+	m.mu.Lock()
+	m.add = req.ToAPI()
+	m.mu.Unlock()
+	if m.addErr != nil {
+		return nil, m.addErr
+	}
+	return apipb.FromAddResponse(api.AddResponse{
+		PortID:       "port-grpc",
+		MACAddress:   "fa:16:3e:aa:bb:cc",
+		IPAddress:    "10.0.0.5",
+		PrefixLength: "24",
+		GatewayIP:    "10.0.0.1",
+	}), nil
+}
+
+func (m *mockGRPCDaemon) Del(context.Context, *apipb.DelRequest) (*apipb.DelResponse, error) {
+	m.record("del")
+	return &apipb.DelResponse{Ok: true}, nil
+}
+
+func (m *mockGRPCDaemon) Check(context.Context, *apipb.CheckRequest) (*apipb.CheckResponse, error) {
+	m.record("check")
+	return &apipb.CheckResponse{Exists: true, PortId: "port-grpc"}, nil
+}
+
+func setupMockGRPCDaemon(t *testing.T, mock *mockGRPCDaemon) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "grpc.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	apipb.RegisterDaemonServer(srv, mock)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+	return sock
+}
+
+func TestUsesGRPC(t *testing.T) {
+	d := daemonClient{grpcSocketPath: "/tmp/grpc.sock"}
+	for path, want := range map[string]bool{"/add": true, "/del": true, "/check": true, "/up": false, "/observe": false} {
+		if got := d.usesGRPC(path); got != want {
+			t.Errorf("usesGRPC(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if (daemonClient{}).usesGRPC("/add") {
+		t.Error("usesGRPC without a gRPC socket = true, want false")
+	}
+}
+
+func TestDaemonRequestGRPC(t *testing.T) {
+	mock := &mockGRPCDaemon{}
+	d := daemonClient{socketPath: "/nonexistent.sock", grpcSocketPath: setupMockGRPCDaemon(t, mock)}
+
+	var addResp api.AddResponse
+	err := d.request(http.MethodPost, "/add", api.AddRequest{
+		ContainerID: "ctr-1",
+		NetworkID:   "net-1",
+		PortSpec:    api.PortSpec{ExtraCreateOpts: map[string]interface{}{"propagate_uplink_status": true}},
+	}, &addResp)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if addResp.PortID != "port-grpc" || addResp.MACAddress != "fa:16:3e:aa:bb:cc" {
+		t.Errorf("add response = %+v", addResp)
+	}
+	if mock.add.ContainerID != "ctr-1" || mock.add.ExtraCreateOpts["propagate_uplink_status"] != true {
+		t.Errorf("daemon got %+v", mock.add)
+	}
+
+	var checkResp api.CheckResponse
+	if err := d.request(http.MethodPost, "/check", api.CheckRequest{ContainerID: "ctr-1"}, &checkResp); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !checkResp.Exists {
+		t.Error("check response Exists = false, want true")
+	}
+	if err := d.request(http.MethodPost, "/del", api.DelRequest{ContainerID: "ctr-1"}, nil); err != nil {
+		t.Fatalf("del: %v", err)
+	}
+}
+
+func TestDaemonRequestGRPCConflict(t *testing.T) {
+	mock := &mockGRPCDaemon{addErr: status.Error(codes.AlreadyExists, "ip_address 10.0.0.5 is already allocated")}
+	d := daemonClient{grpcSocketPath: setupMockGRPCDaemon(t, mock)}
+
+	err := d.request(http.MethodPost, "/add", api.AddRequest{PortSpec: api.PortSpec{IPAddress: "10.0.0.5"}}, &api.AddResponse{})
+	cniErr, ok := err.(*types.Error)
+	if !ok {
+		t.Fatalf("expected *types.Error, got %T: %v", err, err)
+	}
+	if cniErr.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("error code = %d, want %d", cniErr.Code, types.ErrInvalidNetworkConfig)
+	}
+}
+
+func TestDaemonRequestGRPCUnavailable(t *testing.T) {
+	mock := &mockGRPCDaemon{
+		addErr:     status.Error(codes.Unavailable, "subnet has no delegated IPv6 prefix yet"),
+		addTrailer: metadata.Pairs("retry-after", "30"),
+	}
+	d := daemonClient{grpcSocketPath: setupMockGRPCDaemon(t, mock)}
+
+	err := d.request(http.MethodPost, "/add", api.AddRequest{}, &api.AddResponse{})
+	var unavailable *daemonUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected *daemonUnavailableError, got %T: %v", err, err)
+	}
+	if unavailable.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", unavailable.RetryAfter)
+	}
+}
+
+func TestDaemonRequestGRPCRateLimited(t *testing.T) {
+	mock := &mockGRPCDaemon{
+		addErr:     status.Error(codes.ResourceExhausted, "too many ADDs for pod default/web-0"),
+		addTrailer: metadata.Pairs("retry-after", "0", "reason", api.ReasonRateLimited),
+	}
+	d := daemonClient{grpcSocketPath: setupMockGRPCDaemon(t, mock)}
+
+	if err := d.request(http.MethodPost, "/add", api.AddRequest{}, &api.AddResponse{}); err == nil {
+		t.Fatal("expected an error for a rate limited ADD")
+	}
+	if len(mock.calls) != 1 {
+		t.Errorf("gRPC calls = %v, want a single add", mock.calls)
+	}
+}
+
+func TestCmdAddDelOverGRPC(t *testing.T) {
+	mock := &mockGRPCDaemon{}
+	grpcSock := setupMockGRPCDaemon(t, mock)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "http.sock")), &conf)
+	conf["grpc_socket_path"] = grpcSock
+	stdinData, _ := json.Marshal(conf)
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-grpc",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(args)
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd: %v", err)
+	}
+	if err := cmdDel(args); err != nil {
+		t.Fatalf("cmdDel: %v", err)
+	}
+
+	if len(mock.calls) != 2 || mock.calls[0] != "add" || mock.calls[1] != "del" {
+		t.Errorf("gRPC calls = %v, want [add del]", mock.calls)
+	}
+}
@@ -4,31 +4,147 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/cni/pkg/version"
 	ovs_types "github.com/k8snetworkplumbingwg/ovs-cni/pkg/types"
+	"golang.org/x/sys/unix"
 
 	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
 )
 
 // PluginConf is the config for the openstack-port wrapper CNI plugin.
 type PluginConf struct {
 	ovs_types.NetConf
-	NetworkID        string `json:"network_id"`
-	SubnetID         string `json:"subnet_id"`
+	NetworkID string `json:"network_id"`
+	SubnetID  string `json:"subnet_id"`
+	SegmentID string `json:"segment_id,omitempty"`
+	// SubnetIDs are fallback subnets tried in order when subnet_id has no
+	// free address left.
+	SubnetIDs []string `json:"subnet_ids,omitempty"`
+	// SubnetName selects the subnet of network_id by name instead of
+	// subnet_id; SubnetMatch picks among several subnets of that name.
+	SubnetName       string `json:"subnet_name,omitempty"`
+	SubnetMatch      string `json:"subnet_match,omitempty"`
 	SecurityGroupIDs string `json:"security_group_ids,omitempty"`
+	// IPAddress requests a specific fixed IP on the subnet.
+	IPAddress string `json:"ip_address,omitempty"`
+	// IPCount asks for that many addresses on the subnet, all of them
+	// configured on the pod interface.
+	IPCount int `json:"ip_count,omitempty"`
+	// GatewayIP overrides the subnet's gateway. With OnLink set it may lie
+	// outside the subnet and an on-link route to it is emitted.
+	GatewayIP string `json:"gateway_ip,omitempty"`
+	OnLink    bool   `json:"on_link,omitempty"`
+	// PrefixLength overrides the prefix length of the pod's addresses,
+	// taken from the subnet's CIDR otherwise.
+	PrefixLength *int `json:"prefix_length,omitempty"`
+	// FallbackIPAM allocates the address with host-local from the subnet
+	// CIDR when Neutron creates the port without an IP.
+	FallbackIPAM bool `json:"fallback_ipam,omitempty"`
+	// StrictDel makes DEL fail when the Neutron port cannot be deleted so
+	// the runtime retries, instead of returning success and leaking it.
+	StrictDel bool `json:"strict_del,omitempty"`
+	// DetachOnly makes DEL keep the Neutron port, unbound and down, so a
+	// rescheduled pod asking for the same ip_address adopts it.
+	DetachOnly bool `json:"detach_only,omitempty"`
+	// AdminStateDown creates the port administratively down and brings it
+	// up only after the delegate has wired it, so ML2 does not bind early.
+	AdminStateDown bool `json:"admin_state_down,omitempty"`
+	// RouterID has the daemon route RouterRouteDestinations via the pod IP
+	// on that Neutron router for the lifetime of the pod.
+	RouterID                string   `json:"router_id,omitempty"`
+	RouterRouteDestinations []string `json:"router_route_destinations,omitempty"`
+	// Routes are static routes added to the pod's IPAM routes after the
+	// subnet's host routes, e.g. to the cluster's service CIDR.
+	Routes []api.Route `json:"routes,omitempty"`
+	// PortNaming selects how the daemon names the port: default, full_id
+	// or hashed. Empty uses the daemon's OPENSTACK_CNI_PORT_NAMING.
+	PortNaming string `json:"port_naming,omitempty"`
+	// EndpointOverride sends the daemon's Neutron calls for this network
+	// to that endpoint instead of the catalog's, e.g. a canary Neutron.
+	// The daemon must allow it with OPENSTACK_CNI_ALLOW_ENDPOINT_OVERRIDE.
+	EndpointOverride string `json:"endpoint_override,omitempty"`
 	DelegatePlugin   string `json:"delegate_plugin"`
 	SocketPath       string `json:"socket_path,omitempty"`
+	// FallbackSocketPaths are daemon sockets tried in order when nothing
+	// listens on socket_path, e.g. a standby daemon.
+	FallbackSocketPaths []string `json:"fallback_socket_paths,omitempty"`
+	// GRPCSocketPath, when set, sends ADD, DEL and CHECK to the daemon's
+	// gRPC socket (OPENSTACK_CNI_GRPC_SOCKET) instead of socket_path.
+	GRPCSocketPath string `json:"grpc_socket_path,omitempty"`
+	// DaemonHost overrides the HTTP Host header sent to the daemon.
+	DaemonHost string `json:"daemon_host,omitempty"`
+	// DelegateAddAttempts bounds how many times a retriable delegate ADD
+	// failure is retried before the Neutron port is torn down.
+	DelegateAddAttempts int `json:"delegate_add_attempts,omitempty"`
+	// DelegateDelayMs pauses that many milliseconds, plus a random
+	// jitter of up to DelegateDelayJitterMs, between the daemon's ADD and
+	// the delegate ADD, giving slow ML2 backends time to set the port up.
+	DelegateDelayMs       int `json:"delegate_delay_ms,omitempty"`
+	DelegateDelayJitterMs int `json:"delegate_delay_jitter_ms,omitempty"`
+	// ExtraCreateOpts is passed through to the daemon and merged into the
+	// Neutron port create request.
+	ExtraCreateOpts map[string]interface{} `json:"extra_create_opts,omitempty"`
+	// BindingProfile creates the port as an OVN remote-managed port plugged
+	// through a Smart-NIC.
+	BindingProfile *api.BindingProfile `json:"binding_profile,omitempty"`
+	// Bandwidth gives the port a QoS policy with these egress rates,
+	// deleted along with the port.
+	Bandwidth *api.Bandwidth `json:"bandwidth,omitempty"`
+	// Tags are Neutron tags the daemon sets on the port along with its
+	// static ones.
+	Tags []string `json:"tags,omitempty"`
+	// StrictIPCheck fails the ADD when the delegate result does not carry
+	// the Neutron-allocated IP, e.g. after IPAM config drift.
+	StrictIPCheck bool `json:"strict_ip_check,omitempty"`
+	// ReportPortID adds the Neutron port ID to the CNI result under
+	// api.ResultPortIDKey.
+	ReportPortID bool `json:"report_port_id,omitempty"`
+	// DelegatePassthrough is an object whose keys are added to the config
+	// handed to the delegate, e.g. runtimeConfig or interface sysctls.
+	DelegatePassthrough json.RawMessage `json:"delegate_passthrough,omitempty"`
+	// MaxResponseBytes bounds the daemon response bodies the plugin reads,
+	// so a misbehaving daemon cannot make it buffer without end.
+	MaxResponseBytes int64 `json:"max_response_bytes,omitempty"`
+	// KeepOnFailure is a debugging aid that keeps the Neutron port when the
+	// delegate ADD fails, so it can be inspected. The port then leaks until
+	// DEL.
+	KeepOnFailure bool `json:"keep_on_failure,omitempty"`
+	// ReportResult sends the delegate's result of a successful ADD to the
+	// daemon's /report, which logs it with the port ID.
+	ReportResult bool `json:"report_result,omitempty"`
 }
 
+// defaultDelegateAddAttempts is used when delegate_add_attempts is unset.
+const defaultDelegateAddAttempts = 3
+
+// defaultMaxResponseBytes is used when max_response_bytes is unset.
+const defaultMaxResponseBytes = 1 << 20
+
+// maxDelegateDelayMs bounds delegate_delay_ms and delegate_delay_jitter_ms
+// together, so that a typo cannot stall every ADD.
+const maxDelegateDelayMs = 10000
+
+// delegateAddRetryDelay is the pause between delegate ADD attempts.
+var delegateAddRetryDelay = 500 * time.Millisecond
+
 func (c *PluginConf) socketPath() string {
 	if c.SocketPath != "" {
 		return c.SocketPath
@@ -36,8 +152,475 @@ func (c *PluginConf) socketPath() string {
 	return api.SocketPath
 }
 
-// daemonRequest sends an HTTP request over a Unix domain socket to the daemon.
-func daemonRequest(socketPath, method, path string, reqBody, respBody interface{}) error {
+// checkSocketPaths rejects a relative socket_path or grpc_socket_path,
+// which would otherwise fail later as an obscure dial error depending on the
+// runtime's working directory.
+func (c *PluginConf) checkSocketPaths() error {
+	paths := []struct{ key, path string }{
+		{"socket_path", c.SocketPath},
+		{"grpc_socket_path", c.GRPCSocketPath},
+	}
+	for _, path := range c.FallbackSocketPaths {
+		paths = append(paths, struct{ key, path string }{"fallback_socket_paths entry", path})
+	}
+	for _, p := range paths {
+		if p.path != "" && !filepath.IsAbs(p.path) {
+			return types.NewError(types.ErrInvalidNetworkConfig, "invalid "+p.key, fmt.Sprintf("%s %q must be an absolute path", p.key, p.path))
+		}
+	}
+	return nil
+}
+
+// checkDaemonHost rejects a daemon_host that is not a bare host[:port],
+// which would fail every daemon request.
+func (c *PluginConf) checkDaemonHost() error {
+	if c.DaemonHost == "" {
+		return nil
+	}
+	u, err := url.Parse("http://" + c.DaemonHost)
+	if err == nil && u.Host != c.DaemonHost {
+		err = fmt.Errorf("not a host[:port]")
+	}
+	if err != nil {
+		return types.NewError(types.ErrInvalidNetworkConfig, "invalid daemon_host", fmt.Sprintf("daemon_host %q: %v", c.DaemonHost, err))
+	}
+	return nil
+}
+
+// checkDelegatePlugin rejects a config without delegate_plugin before ADD
+// creates a port that invoking an unnamed delegate could only leak.
+func (c *PluginConf) checkDelegatePlugin() error {
+	if c.DelegatePlugin == "" {
+		return types.NewError(types.ErrInvalidNetworkConfig, "missing delegate_plugin", "delegate_plugin is required")
+	}
+	return nil
+}
+
+// checkDelegateDelay rejects a negative delegate delay or jitter, or one
+// adding up to more than maxDelegateDelayMs.
+func (c *PluginConf) checkDelegateDelay() error {
+	if c.DelegateDelayMs < 0 || c.DelegateDelayJitterMs < 0 || c.DelegateDelayMs+c.DelegateDelayJitterMs > maxDelegateDelayMs {
+		return types.NewError(types.ErrInvalidNetworkConfig, "invalid delegate delay",
+			fmt.Sprintf("delegate_delay_ms and delegate_delay_jitter_ms must not be negative nor add up to more than %d", maxDelegateDelayMs))
+	}
+	return nil
+}
+
+// delegateDelay returns the pause before the delegate ADD: the configured
+// delay plus a random jitter.
+func (c *PluginConf) delegateDelay() time.Duration {
+	ms := c.DelegateDelayMs
+	if c.DelegateDelayJitterMs > 0 {
+		ms += rand.IntN(c.DelegateDelayJitterMs + 1)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c *PluginConf) delegateAddAttempts() int {
+	if c.DelegateAddAttempts > 0 {
+		return c.DelegateAddAttempts
+	}
+	return defaultDelegateAddAttempts
+}
+
+// portSpec returns the attributes of the Neutron port the config asks for.
+func (c *PluginConf) portSpec() api.PortSpec {
+	return api.PortSpec{
+		SecurityGroupIDs: api.ParseSecurityGroupIDs(c.SecurityGroupIDs),
+		IPAddress:        c.IPAddress,
+		IPCount:          c.IPCount,
+		AdminStateDown:   c.AdminStateDown,
+		ExtraCreateOpts:  c.ExtraCreateOpts,
+		BindingProfile:   c.BindingProfile,
+		Bandwidth:        c.Bandwidth,
+		Tags:             c.Tags,
+	}
+}
+
+// checkDelegatedPrefix verifies that an address allocated on an IPv6 prefix
+// delegation subnet lies in the currently delegated prefix; a mismatch means
+// the prefix was re-delegated between allocation and lookup.
+func checkDelegatedPrefix(ipAddress, delegatedPrefix string) error {
+	_, prefix, err := net.ParseCIDR(delegatedPrefix)
+	if err != nil {
+		return fmt.Errorf("invalid delegated prefix %q: %v", delegatedPrefix, err)
+	}
+	if ip := net.ParseIP(ipAddress); ip == nil || !prefix.Contains(ip) {
+		return fmt.Errorf("address %s is outside delegated prefix %s", ipAddress, delegatedPrefix)
+	}
+	return nil
+}
+
+// delegatePassthrough decodes the delegate_passthrough block, which must be a
+// JSON object. An absent or null block yields nil.
+func (c *PluginConf) delegatePassthrough() (map[string]interface{}, error) {
+	raw := bytes.TrimSpace(c.DelegatePassthrough)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		return nil, fmt.Errorf("delegate_passthrough must be a JSON object")
+	}
+	var passthrough map[string]interface{}
+	if err := json.Unmarshal(raw, &passthrough); err != nil {
+		return nil, fmt.Errorf("invalid delegate_passthrough: %v", err)
+	}
+	return passthrough, nil
+}
+
+// mergePassthrough adds the passthrough keys to the delegate config. Keys
+// the plugin already generates, such as ipam or args, cannot be overridden.
+func mergePassthrough(confMap, passthrough map[string]interface{}) error {
+	for key := range passthrough {
+		if _, ok := confMap[key]; ok {
+			return fmt.Errorf("delegate_passthrough may not override %q", key)
+		}
+	}
+	for key, value := range passthrough {
+		confMap[key] = value
+	}
+	return nil
+}
+
+// checkResultIP verifies that the delegate result configured ipAddress, the
+// address Neutron allocated to the port.
+func checkResultIP(result types.Result, ipAddress string) error {
+	want := net.ParseIP(ipAddress)
+	if want == nil {
+		return fmt.Errorf("invalid neutron IP %q", ipAddress)
+	}
+	res, err := current.NewResultFromResult(result)
+	if err != nil {
+		return fmt.Errorf("failed to convert delegate result: %v", err)
+	}
+	var got []string
+	for _, ip := range res.IPs {
+		if ip.Address.IP.Equal(want) {
+			return nil
+		}
+		got = append(got, ip.Address.IP.String())
+	}
+	return fmt.Errorf("delegate configured IPs %v, not the neutron IP %s", got, ipAddress)
+}
+
+// fallbackIPAM returns a host-local IPAM config allocating from the Neutron
+// subnet's CIDR, used in degraded mode when Neutron assigned no IP. The
+// allocation is limited to rangeStart-rangeEnd when set, the block the
+// daemon keeps clear of Neutron's allocation pools.
+func fallbackIPAM(subnetCIDR, gatewayIP, rangeStart, rangeEnd string) map[string]interface{} {
+	r := map[string]interface{}{"subnet": subnetCIDR}
+	if rangeStart != "" {
+		r["rangeStart"] = rangeStart
+	}
+	if rangeEnd != "" {
+		r["rangeEnd"] = rangeEnd
+	}
+	if gatewayIP != "" {
+		r["gateway"] = gatewayIP
+	}
+	return map[string]interface{}{
+		"type":   "host-local",
+		"ranges": [][]map[string]interface{}{{r}},
+	}
+}
+
+// delegateDelConf returns the config the delegate DEL is invoked with. With
+// fallback_ipam it carries a host-local ipam block as the ADD did, so that
+// host-local releases a fallback lease. host-local releases by container,
+// so any range of the subnet does: it is taken from the runtime's
+// prevResult.
+func (c *PluginConf) delegateDelConf() ([]byte, error) {
+	netConf, err := json.Marshal(c.NetConf)
+	if err != nil || !c.FallbackIPAM {
+		return netConf, err
+	}
+	ipam := c.prevResultIPAM()
+	if ipam == nil {
+		fmt.Fprintf(os.Stderr, "warning: no prevResult, a fallback IPAM lease may not be released\n")
+		return netConf, nil
+	}
+	var confMap map[string]interface{}
+	if err := json.Unmarshal(netConf, &confMap); err != nil {
+		return nil, err
+	}
+	confMap["ipam"] = ipam
+	return json.Marshal(confMap)
+}
+
+// prevResultIPAM returns a host-local IPAM config on the subnet of the
+// first address of the prevResult, or nil without one.
+func (c *PluginConf) prevResultIPAM() map[string]interface{} {
+	if c.RawPrevResult == nil || version.ParsePrevResult(&c.NetConf.NetConf) != nil {
+		return nil
+	}
+	res, err := current.NewResultFromResult(c.PrevResult)
+	if err != nil || len(res.IPs) == 0 {
+		return nil
+	}
+	addr := res.IPs[0].Address
+	subnet := net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+	return fallbackIPAM(subnet.String(), "", "", "")
+}
+
+// staticIPAM returns a static IPAM config with ipAddress, gatewayed through
+// gatewayIP when the subnet has one, and the other ipAddresses, all with
+// the subnet's prefixLength.
+func staticIPAM(ipAddress string, ipAddresses []string, prefixLength, gatewayIP string) map[string]interface{} {
+	primary := map[string]interface{}{"address": ipamAddress(ipAddress, prefixLength)}
+	if gatewayIP != "" {
+		primary["gateway"] = ipamAddress(gatewayIP, "")
+	}
+	addresses := []map[string]interface{}{primary}
+	for _, ip := range ipAddresses {
+		if ip != ipAddress {
+			addresses = append(addresses, map[string]interface{}{"address": ipamAddress(ip, prefixLength)})
+		}
+	}
+	return map[string]interface{}{
+		"type":      "static",
+		"addresses": addresses,
+	}
+}
+
+// ipamAddress returns ip in its canonical form, followed by /prefixLength
+// when that is set. An ip that does not parse is kept as is, for the
+// delegate to reject.
+func ipamAddress(ip, prefixLength string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	if prefixLength == "" {
+		return ip
+	}
+	return ip + "/" + prefixLength
+}
+
+// onLinkRoutes returns the static IPAM routes for an on-link gateway: a
+// link-scoped host route to the gateway itself, so it is reachable even when
+// outside the pod's subnet, followed by the default route through it. An
+// IPv6 link-local gateway is on every link already and gets no host route.
+func onLinkRoutes(gatewayIP string) ([]map[string]interface{}, error) {
+	gw := net.ParseIP(gatewayIP)
+	if gw == nil {
+		return nil, fmt.Errorf("invalid gateway IP %q", gatewayIP)
+	}
+	if gw.To4() == nil && gw.IsLinkLocalUnicast() {
+		return []map[string]interface{}{{"dst": "::/0", "gw": gw.String()}}, nil
+	}
+	hostRoute, defaultRoute := gw.String()+"/32", "0.0.0.0/0"
+	if gw.To4() == nil {
+		hostRoute, defaultRoute = gw.String()+"/128", "::/0"
+	}
+	return []map[string]interface{}{
+		{"dst": hostRoute, "scope": unix.RT_SCOPE_LINK},
+		{"dst": defaultRoute, "gw": gw.String()},
+	}, nil
+}
+
+// appendRoutes appends to routes the subnet's hostRoutes, then the config's
+// confRoutes, as IPAM routes. A route to a destination already routed
+// replaces the earlier one, so config routes win over subnet routes.
+func appendRoutes(routes []map[string]interface{}, hostRoutes, confRoutes []api.Route) []map[string]interface{} {
+	for _, r := range append(append([]api.Route{}, hostRoutes...), confRoutes...) {
+		route := map[string]interface{}{"dst": r.Dst}
+		if r.GW != "" {
+			route["gw"] = r.GW
+		}
+		replaced := false
+		for i, existing := range routes {
+			if sameDestination(existing["dst"], r.Dst) {
+				routes[i], replaced = route, true
+				break
+			}
+		}
+		if !replaced {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// sameDestination reports whether the route destinations a and b, CIDRs,
+// are the same network.
+func sameDestination(a interface{}, b string) bool {
+	s, ok := a.(string)
+	if !ok {
+		return false
+	}
+	_, na, errA := net.ParseCIDR(s)
+	_, nb, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return s == b
+	}
+	return na.String() == nb.String()
+}
+
+// isRetriableDelegateError reports whether a delegate failure may be
+// transient. Errors reported by the plugin itself with an unknown, internal
+// or try-again-later code are retried; anything else (e.g. the plugin binary
+// not being found, or a config error) is returned immediately.
+func isRetriableDelegateError(err error) bool {
+	cniErr, ok := err.(*types.Error)
+	if !ok {
+		return false
+	}
+	switch cniErr.Code {
+	case types.ErrUnknown, types.ErrTryAgainLater, types.ErrInternal:
+		return true
+	}
+	return false
+}
+
+// delegateAdd invokes the delegate plugin's ADD, retrying retriable failures
+// up to attempts times.
+func delegateAdd(plugin string, stdinData []byte, attempts int) (types.Result, error) {
+	var err error
+	for i := 1; i <= attempts; i++ {
+		var result types.Result
+		result, err = invoke.DelegateAdd(context.TODO(), plugin, stdinData, nil)
+		if err == nil {
+			return result, nil
+		}
+		if !isRetriableDelegateError(err) || i == attempts {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "warning: delegate %s ADD attempt %d/%d failed, retrying: %v\n", plugin, i, attempts, err)
+		time.Sleep(delegateAddRetryDelay)
+	}
+	return nil, err
+}
+
+// defaultDaemonHost is the HTTP Host used for daemon requests when
+// daemon_host is unset.
+const defaultDaemonHost = "localhost"
+
+// daemonBusyAttempts bounds how many times a request is sent while the
+// daemon answers 429 Too Many Requests.
+const daemonBusyAttempts = 5
+
+var (
+	// defaultDaemonRetryAfter is the wait after a 429 without a usable
+	// Retry-After header.
+	defaultDaemonRetryAfter = time.Second
+	// maxDaemonRetryAfter caps the wait requested by Retry-After.
+	maxDaemonRetryAfter = 5 * time.Second
+)
+
+// parseRetryAfter converts a Retry-After header given in seconds into a
+// wait. It reports false when the header is missing or malformed.
+func parseRetryAfter(header string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// isRateLimited reports whether body is the daemon's error for a pod over
+// its rate limit.
+func isRateLimited(body []byte) bool {
+	var errResp api.ErrorResponse
+	return json.Unmarshal(body, &errResp) == nil && errResp.Reason == api.ReasonRateLimited
+}
+
+// retryAfter converts a Retry-After header given in seconds into a wait,
+// capped at maxDaemonRetryAfter.
+func retryAfter(header string) time.Duration {
+	d, ok := parseRetryAfter(header)
+	if !ok {
+		return defaultDaemonRetryAfter
+	}
+	if d < maxDaemonRetryAfter {
+		return d
+	}
+	return maxDaemonRetryAfter
+}
+
+// daemonUnavailableError is returned when the daemon answers 503 Service
+// Unavailable, e.g. while an IPv6 prefix is not delegated yet. RetryAfter is
+// the wait the daemon hinted at, or 0 without a hint.
+type daemonUnavailableError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *daemonUnavailableError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("daemon unavailable, retry after %s: %s", e.RetryAfter, e.Message)
+	}
+	return "daemon unavailable: " + e.Message
+}
+
+// unavailableError builds the daemonUnavailableError for a 503 carrying msg
+// and the Retry-After header value hint.
+func unavailableError(msg, hint string) *daemonUnavailableError {
+	d, _ := parseRetryAfter(hint)
+	return &daemonUnavailableError{Message: msg, RetryAfter: d}
+}
+
+// daemonClient sends requests to the daemon over its Unix domain socket.
+type daemonClient struct {
+	socketPath string
+	// fallbackSocketPaths are dialed in order when socketPath cannot be.
+	fallbackSocketPaths []string
+	// host is sent as the HTTP Host header; the daemon logs it so requests
+	// can be correlated. Defaults to defaultDaemonHost.
+	host string
+	// grpcSocketPath, when set, is used for the requests usesGRPC selects.
+	grpcSocketPath string
+	// maxResponseBytes bounds HTTP response bodies; 0 uses
+	// defaultMaxResponseBytes.
+	maxResponseBytes int64
+}
+
+func (c *PluginConf) daemon() daemonClient {
+	return daemonClient{socketPath: c.socketPath(), fallbackSocketPaths: c.FallbackSocketPaths, host: c.DaemonHost, grpcSocketPath: c.GRPCSocketPath, maxResponseBytes: c.MaxResponseBytes}
+}
+
+// dial connects to the first of the daemon's sockets that accepts a
+// connection. Only failing to connect moves on to the next socket, so a
+// request a daemon has received is never sent to another one.
+func (d daemonClient) dial() (net.Conn, error) {
+	var errs []error
+	for _, path := range append([]string{d.socketPath}, d.fallbackSocketPaths...) {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// readResponse reads body up to the client's limit, failing rather than
+// truncating a larger one.
+func (d daemonClient) readResponse(body io.Reader) ([]byte, error) {
+	limit := d.maxResponseBytes
+	if limit <= 0 {
+		limit = defaultMaxResponseBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("daemon response exceeds %d bytes", limit)
+	}
+	return data, nil
+}
+
+// request sends an HTTP request over a Unix domain socket to the daemon, or
+// a gRPC call when the daemon client uses gRPC for path.
+func (d daemonClient) request(method, path string, reqBody, respBody interface{}) error {
+	if d.usesGRPC(path) {
+		return d.grpcRequest(path, reqBody, respBody)
+	}
+
+	host := d.host
+	if host == "" {
+		host = defaultDaemonHost
+	}
+
 	data, err := json.Marshal(reqBody)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
@@ -46,29 +629,49 @@ func daemonRequest(socketPath, method, path string, reqBody, respBody interface{
 	client := &http.Client{
 		Transport: &http.Transport{
 			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", socketPath)
+				return d.dial()
 			},
 		},
 	}
 
-	resp, err := client.Do(func() *http.Request {
-		req, _ := http.NewRequest(method, "http://localhost"+path, bytes.NewReader(data))
+	// A 429 means the daemon is shedding load; wait as hinted by
+	// Retry-After and try again a bounded number of times. A pod over its
+	// rate limit is not retried: the wait is far longer.
+	var resp *http.Response
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, "http://"+host+path, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to build daemon request: %v", err)
+		}
 		req.Header.Set("Content-Type", "application/json")
-		return req
-	}())
-	if err != nil {
-		return fmt.Errorf("daemon request failed: %v", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		resp, err = client.Do(req)
+		if err != nil {
+			return fmt.Errorf("daemon request failed: %v", err)
+		}
+		body, err = d.readResponse(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %v", err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= daemonBusyAttempts || isRateLimited(body) {
+			break
+		}
+		time.Sleep(retryAfter(resp.Header.Get("Retry-After")))
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		var errResp api.ErrorResponse
 		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			// A conflict (e.g. a static IP held by another pod) will not
+			// resolve by retrying, so report it as a config error rather
+			// than a transient one.
+			if resp.StatusCode == http.StatusConflict {
+				return types.NewError(types.ErrInvalidNetworkConfig, "daemon conflict", errResp.Error)
+			}
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				return unavailableError(errResp.Error, resp.Header.Get("Retry-After"))
+			}
 			return fmt.Errorf("daemon error: %s", errResp.Error)
 		}
 		return fmt.Errorf("daemon returned status %d: %s", resp.StatusCode, string(body))
@@ -87,26 +690,102 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err := json.Unmarshal(args.StdinData, conf); err != nil {
 		return fmt.Errorf("failed to parse network config: %v", err)
 	}
-
-	socketPath := conf.socketPath()
-
-	var securityGroupIDs []string
-	for _, id := range strings.Split(conf.SecurityGroupIDs, ",") {
-		if trimmed := strings.TrimSpace(id); trimmed != "" {
-			securityGroupIDs = append(securityGroupIDs, trimmed)
+	if conf.PortNaming != "" {
+		if _, err := portname.New(conf.PortNaming); err != nil {
+			return fmt.Errorf("invalid port_naming: %v", err)
+		}
+	}
+	if conf.EndpointOverride != "" {
+		if err := api.ValidateEndpointOverride(conf.EndpointOverride); err != nil {
+			return types.NewError(types.ErrInvalidNetworkConfig, "invalid endpoint_override", err.Error())
 		}
 	}
+	if err := api.ValidateSubnetMatch(conf.SubnetMatch); err != nil {
+		return types.NewError(types.ErrInvalidNetworkConfig, "invalid subnet_match", err.Error())
+	}
+	if err := api.ValidateRoutes(conf.Routes); err != nil {
+		return types.NewError(types.ErrInvalidNetworkConfig, "invalid routes", err.Error())
+	}
+	if err := conf.checkSocketPaths(); err != nil {
+		return err
+	}
+	if err := conf.checkDaemonHost(); err != nil {
+		return err
+	}
+	if err := conf.checkDelegatePlugin(); err != nil {
+		return err
+	}
+	if err := conf.checkDelegateDelay(); err != nil {
+		return err
+	}
+	passthrough, err := conf.delegatePassthrough()
+	if err != nil {
+		return err
+	}
+
+	spec := conf.portSpec()
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	daemon := conf.daemon()
+	podNamespace, podName := podFromArgs(args.Args)
 
 	var resp api.AddResponse
-	err := daemonRequest(socketPath, http.MethodPost, "/add", api.AddRequest{
-		ContainerID:      args.ContainerID,
-		NetworkID:        conf.NetworkID,
-		SubnetID:         conf.SubnetID,
-		SecurityGroupIDs: securityGroupIDs,
+	err = daemon.request(http.MethodPost, "/add", api.AddRequest{
+		ContainerID:             args.ContainerID,
+		NetworkID:               conf.NetworkID,
+		SubnetID:                conf.SubnetID,
+		SegmentID:               conf.SegmentID,
+		SubnetIDs:               conf.SubnetIDs,
+		SubnetName:              conf.SubnetName,
+		SubnetMatch:             conf.SubnetMatch,
+		PortSpec:                spec,
+		GatewayIP:               conf.GatewayIP,
+		OnLink:                  conf.OnLink,
+		PrefixLength:            conf.PrefixLength,
+		FallbackIPAM:            conf.FallbackIPAM,
+		MTU:                     conf.MTU,
+		RouterID:                conf.RouterID,
+		RouterRouteDestinations: conf.RouterRouteDestinations,
+		PortNaming:              conf.PortNaming,
+		EndpointOverride:        conf.EndpointOverride,
+		PodNamespace:            podNamespace,
+		PodName:                 podName,
 	}, &resp)
 	if err != nil {
 		return err
 	}
+	for _, warning := range resp.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: daemon: %s\n", warning)
+	}
+	// Without network_id the daemon picked the network of the pod's
+	// namespace.
+	networkID := conf.NetworkID
+	if networkID == "" {
+		networkID = resp.NetworkID
+	}
+
+	// cleanupReq releases the port, and any router routes to it, when a
+	// later step fails.
+	cleanupReq := api.DelRequest{
+		ContainerID:      args.ContainerID,
+		NetworkID:        conf.NetworkID,
+		RouterID:         conf.RouterID,
+		PortNaming:       conf.PortNaming,
+		QoSPolicy:        conf.Bandwidth != nil,
+		EndpointOverride: conf.EndpointOverride,
+		PodNamespace:     podNamespace,
+		PodName:          podName,
+	}
+
+	// A fallback IPAM allocation is checked by host-local, not here.
+	if resp.DelegatedPrefix != "" && resp.IPAddress != "" {
+		if err := checkDelegatedPrefix(resp.IPAddress, resp.DelegatedPrefix); err != nil {
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+			return err
+		}
+	}
 
 	// Initialize Args and CNI structs if they're nil
 	if conf.Args == nil {
@@ -121,70 +800,181 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	conf.Args.CNI.OvnPort = resp.PortID
 	conf.Args.CNI.MAC = resp.MACAddress
+	if resp.MTU != 0 {
+		conf.MTU = resp.MTU
+	}
 
 	// Marshal NetConf to a map so we can add IPAM config
 	var confMap map[string]interface{}
 	netConfBytes, err := json.Marshal(conf.NetConf)
 	if err != nil {
-		_ = daemonRequest(socketPath, http.MethodPost, "/del", api.DelRequest{
-			ContainerID: args.ContainerID,
-			NetworkID:   conf.NetworkID,
-		}, nil)
+		_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
 		return fmt.Errorf("failed to marshal NetConf: %v", err)
 	}
 	if err := json.Unmarshal(netConfBytes, &confMap); err != nil {
-		_ = daemonRequest(socketPath, http.MethodPost, "/del", api.DelRequest{
-			ContainerID: args.ContainerID,
-			NetworkID:   conf.NetworkID,
-		}, nil)
+		_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
 		return fmt.Errorf("failed to unmarshal NetConf to map: %v", err)
 	}
 
-	// Add IPAM configuration for static plugin
-	confMap["ipam"] = map[string]interface{}{
-		"type": "static",
-		"addresses": []map[string]interface{}{
-			{
-				"address": fmt.Sprintf("%s/%s", resp.IPAddress, resp.PrefixLength),
-				"gateway": resp.GatewayIP,
-			},
-		},
+	// Add IPAM configuration for static plugin, or for host-local when
+	// Neutron assigned no IP and fallback IPAM is enabled.
+	var ipam map[string]interface{}
+	if resp.IPAddress == "" {
+		if !conf.FallbackIPAM || resp.SubnetCIDR == "" || resp.FallbackStart == "" {
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+			return fmt.Errorf("neutron port %s has no IP address", resp.PortID)
+		}
+		ipam = fallbackIPAM(resp.SubnetCIDR, resp.GatewayIP, resp.FallbackStart, resp.FallbackEnd)
+	} else {
+		ipam = staticIPAM(resp.IPAddress, resp.IPAddresses, resp.PrefixLength, resp.GatewayIP)
+	}
+	var routes []map[string]interface{}
+	if conf.OnLink && resp.GatewayIP != "" {
+		routes, err = onLinkRoutes(resp.GatewayIP)
+		if err != nil {
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+			return err
+		}
+	}
+	if routes = appendRoutes(routes, resp.HostRoutes, conf.Routes); len(routes) > 0 {
+		ipam["routes"] = routes
+	}
+	confMap["ipam"] = ipam
+	if err := mergePassthrough(confMap, passthrough); err != nil {
+		_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+		return err
 	}
 
 	// Marshal final config for delegation
 	stdinData, err := json.Marshal(confMap)
 	if err != nil {
-		_ = daemonRequest(socketPath, http.MethodPost, "/del", api.DelRequest{
-			ContainerID: args.ContainerID,
-			NetworkID:   conf.NetworkID,
-		}, nil)
+		_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
 		return fmt.Errorf("failed to marshal final config: %v", err)
 	}
 
-	// Delegate to OVS CNI
-	result, err := invoke.DelegateAdd(context.TODO(), conf.DelegatePlugin, stdinData, nil)
+	// Give slow ML2 backends a moment to set the port up first.
+	if delay := conf.delegateDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	// Delegate to OVS CNI, reporting its duration for the daemon's latency
+	// metrics on a best-effort basis.
+	delegateStart := time.Now()
+	result, err := delegateAdd(conf.DelegatePlugin, stdinData, conf.delegateAddAttempts())
+	_ = daemon.request(http.MethodPost, "/observe", api.ObserveRequest{
+		Phase:   api.PhaseDelegate,
+		Seconds: time.Since(delegateStart).Seconds(),
+	}, nil)
 	if err != nil {
-		// Clean up the Neutron port on failure
-		_ = daemonRequest(socketPath, http.MethodPost, "/del", api.DelRequest{
-			ContainerID: args.ContainerID,
-			NetworkID:   conf.NetworkID,
-		}, nil)
+		// Clean up the Neutron port on failure, unless it is kept for
+		// inspection.
+		if conf.KeepOnFailure {
+			fmt.Fprintf(os.Stderr, "warning: keep_on_failure is set, keeping neutron port %s after the delegate failure\n", resp.PortID)
+		} else {
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+		}
 		return fmt.Errorf("failed to delegate to %s: %v", conf.DelegatePlugin, err)
 	}
 
+	if conf.StrictIPCheck && resp.IPAddress != "" {
+		if err := checkResultIP(result, resp.IPAddress); err != nil {
+			if delErr := invoke.DelegateDel(context.TODO(), conf.DelegatePlugin, stdinData, nil); delErr != nil {
+				fmt.Fprintf(os.Stderr, "warning: local OVS delegate delete failed: %v\n", delErr)
+			}
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+			return err
+		}
+	}
+
+	if conf.AdminStateDown {
+		err := daemon.request(http.MethodPost, "/up", api.UpRequest{
+			ContainerID:      args.ContainerID,
+			NetworkID:        networkID,
+			PortID:           resp.PortID,
+			PortNaming:       conf.PortNaming,
+			EndpointOverride: conf.EndpointOverride,
+		}, nil)
+		if err != nil {
+			if delErr := invoke.DelegateDel(context.TODO(), conf.DelegatePlugin, stdinData, nil); delErr != nil {
+				fmt.Fprintf(os.Stderr, "warning: local OVS delegate delete failed: %v\n", delErr)
+			}
+			_ = daemon.request(http.MethodPost, "/del", cleanupReq, nil)
+			return fmt.Errorf("failed to bring up neutron port %s: %v", resp.PortID, err)
+		}
+	}
+
+	if conf.ReportResult {
+		reportResult(daemon, args.ContainerID, networkID, resp.PortID, result)
+	}
+
+	if conf.ReportPortID {
+		return printResultWithPortID(os.Stdout, result, resp.PortID)
+	}
 	return result.Print()
 }
 
+// podFromArgs returns the pod namespace and name Kubernetes runtimes pass
+// in CNI_ARGS, or empty strings outside Kubernetes.
+func podFromArgs(cniArgs string) (namespace, name string) {
+	for _, pair := range strings.Split(cniArgs, ";") {
+		key, value, _ := strings.Cut(pair, "=")
+		switch key {
+		case "K8S_POD_NAMESPACE":
+			namespace = value
+		case "K8S_POD_NAME":
+			name = value
+		}
+	}
+	return namespace, name
+}
+
+// reportResult sends the delegate's result to the daemon for logging. It is
+// best effort: a failure only prints a warning.
+func reportResult(daemon daemonClient, containerID, networkID, portID string, result types.Result) {
+	data, err := json.Marshal(result)
+	if err == nil {
+		err = daemon.request(http.MethodPost, "/report", api.ReportRequest{
+			ContainerID: containerID,
+			NetworkID:   networkID,
+			PortID:      portID,
+			Result:      data,
+		}, nil)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: reporting the delegate result of port %s to the daemon failed: %v\n", portID, err)
+	}
+}
+
+// printResultWithPortID writes result as types.Result.Print does, with the
+// Neutron port ID added under api.ResultPortIDKey.
+func printResultWithPortID(w io.Writer, result types.Result, portID string) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+	var resultMap map[string]interface{}
+	if err := json.Unmarshal(data, &resultMap); err != nil {
+		return fmt.Errorf("failed to unmarshal result to map: %v", err)
+	}
+	resultMap[api.ResultPortIDKey] = portID
+	data, err = json.MarshalIndent(resultMap, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func cmdDel(args *skel.CmdArgs) error {
 	conf := &PluginConf{}
 	if err := json.Unmarshal(args.StdinData, conf); err != nil {
 		return nil // Ignore parse errors on delete per CNI spec
 	}
 
-	socketPath := conf.socketPath()
+	daemon := conf.daemon()
 
 	// Delegate the DEL command to OVS CNI first
-	netConf, err := json.Marshal(conf.NetConf)
+	netConf, err := conf.delegateDelConf()
 	if err != nil {
 		return nil // Ignore marshal errors on delete per CNI spec
 	}
@@ -193,10 +983,22 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 
 	// Clean up the Neutron port via daemon
-	_ = daemonRequest(socketPath, http.MethodPost, "/del", api.DelRequest{
-		ContainerID: args.ContainerID,
-		NetworkID:   conf.NetworkID,
+	podNamespace, podName := podFromArgs(args.Args)
+	err = daemon.request(http.MethodPost, "/del", api.DelRequest{
+		ContainerID:      args.ContainerID,
+		NetworkID:        conf.NetworkID,
+		Strict:           conf.StrictDel,
+		RouterID:         conf.RouterID,
+		PortNaming:       conf.PortNaming,
+		DetachOnly:       conf.DetachOnly,
+		QoSPolicy:        conf.Bandwidth != nil,
+		EndpointOverride: conf.EndpointOverride,
+		PodNamespace:     podNamespace,
+		PodName:          podName,
 	}, nil)
+	if err != nil && conf.StrictDel {
+		return fmt.Errorf("failed to delete neutron port: %v", err)
+	}
 
 	return nil
 }
@@ -206,19 +1008,39 @@ func cmdCheck(args *skel.CmdArgs) error {
 	if err := json.Unmarshal(args.StdinData, conf); err != nil {
 		return fmt.Errorf("failed to parse network config: %v", err)
 	}
+	if err := conf.checkSocketPaths(); err != nil {
+		return err
+	}
+	if err := conf.checkDaemonHost(); err != nil {
+		return err
+	}
+	if err := conf.checkDelegatePlugin(); err != nil {
+		return err
+	}
+	passthrough, err := conf.delegatePassthrough()
+	if err != nil {
+		return err
+	}
 
-	socketPath := conf.socketPath()
+	daemon := conf.daemon()
 
+	podNamespace, _ := podFromArgs(args.Args)
 	var resp api.CheckResponse
-	err := daemonRequest(socketPath, http.MethodPost, "/check", api.CheckRequest{
-		ContainerID: args.ContainerID,
-		NetworkID:   conf.NetworkID,
+	err = daemon.request(http.MethodPost, "/check", api.CheckRequest{
+		ContainerID:      args.ContainerID,
+		NetworkID:        conf.NetworkID,
+		PortNaming:       conf.PortNaming,
+		EndpointOverride: conf.EndpointOverride,
+		PodNamespace:     podNamespace,
 	}, &resp)
 	if err != nil {
 		return err
 	}
 
 	if !resp.Exists {
+		if resp.Detail != "" {
+			return fmt.Errorf("neutron port not found: %s", resp.Detail)
+		}
 		return fmt.Errorf("neutron port not found")
 	}
 
@@ -231,6 +1053,9 @@ func cmdCheck(args *skel.CmdArgs) error {
 	if err := json.Unmarshal(netConfBytes, &confMap); err != nil {
 		return fmt.Errorf("failed to unmarshal NetConf to map: %v", err)
 	}
+	if err := mergePassthrough(confMap, passthrough); err != nil {
+		return err
+	}
 
 	stdinData, err := json.Marshal(confMap)
 	if err != nil {
@@ -240,6 +1065,47 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return invoke.DelegateCheck(context.TODO(), conf.DelegatePlugin, stdinData, nil)
 }
 
+// pluginName and supportedVersions can be overridden at build time, e.g.
+// -ldflags "-X main.pluginName=openstack-port-v2 -X main.supportedVersions=1.0.0".
+// supportedVersions is a comma-separated list; empty advertises every
+// version the CNI library supports.
+var (
+	pluginName        = "openstack-port"
+	supportedVersions = ""
+)
+
+// pluginVersionInfo returns the versions the plugin advertises given the
+// comma-separated list, rejecting versions the CNI library does not know.
+func pluginVersionInfo(list string) (version.PluginInfo, error) {
+	if strings.TrimSpace(list) == "" {
+		return version.All, nil
+	}
+	known := make(map[string]bool)
+	for _, v := range version.All.SupportedVersions() {
+		known[v] = true
+	}
+	var versions []string
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !known[v] {
+			return nil, fmt.Errorf("unsupported CNI version %q", v)
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return version.All, nil
+	}
+	return version.PluginSupports(versions...), nil
+}
+
 func main() {
-	skel.PluginMainFuncs(skel.CNIFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel}, version.All, "openstack-port")
+	versionInfo, err := pluginVersionInfo(supportedVersions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", pluginName, err)
+		os.Exit(1)
+	}
+	skel.PluginMainFuncs(skel.CNIFuncs{Add: cmdAdd, Check: cmdCheck, Del: cmdDel}, versionInfo, pluginName)
 }
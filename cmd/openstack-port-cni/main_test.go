@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+	"golang.org/x/sys/unix"
 
 	"openstack-port/internal/api"
 )
@@ -34,54 +43,24 @@ echo '{"cniVersion":"0.4.0","interfaces":[{"name":"eth0"}],"ips":[{"address":"10
 	return dir
 }
 
+// setupMockDaemon starts a mockDaemon with its default responses and
+// returns its socket.
 func setupMockDaemon(t *testing.T) string {
 	t.Helper()
-	sock := filepath.Join(t.TempDir(), "test.sock")
-	listener, err := net.Listen("unix", sock)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewEncoder(w).Encode(api.AddResponse{
-			PortID:       "port-123",
-			MACAddress:   "fa:16:3e:aa:bb:cc",
-			IPAddress:    "10.0.0.5",
-			PrefixLength: "24",
-			GatewayIP:    "10.0.0.1",
-		})
-	})
-	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
-	})
-	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true})
-	})
-
-	srv := &http.Server{Handler: mux}
-	go func() { _ = srv.Serve(listener) }()
-	t.Cleanup(func() { _ = srv.Close() })
-	return sock
+	return newMockDaemon(t).sock
 }
 
+// setupMockDaemonCheckNotFound starts a mockDaemon whose /check finds no
+// port and returns its socket.
 func setupMockDaemonCheckNotFound(t *testing.T) string {
 	t.Helper()
-	sock := filepath.Join(t.TempDir(), "test.sock")
-	listener, err := net.Listen("unix", sock)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: false})
+	d := newMockDaemon(t)
+	d.respond("/check", http.StatusOK, api.CheckResponse{
+		Exists: false,
+		Reason: api.CheckReasonNoMatchingPort,
+		Detail: "no ports matched name k8s-pod-ctr-check-2 on network net-123",
 	})
-
-	srv := &http.Server{Handler: mux}
-	go func() { _ = srv.Serve(listener) }()
-	t.Cleanup(func() { _ = srv.Close() })
-	return sock
+	return d.sock
 }
 
 func makeStdinData(sock string) []byte {
@@ -116,6 +95,389 @@ func TestSocketPathOverride(t *testing.T) {
 	}
 }
 
+func TestCheckSocketPaths(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		conf    PluginConf
+		wantErr bool
+	}{
+		{name: "Default", conf: PluginConf{}},
+		{name: "Absolute", conf: PluginConf{SocketPath: "/run/cni.sock", GRPCSocketPath: "/run/grpc.sock"}},
+		{name: "RelativeSocket", conf: PluginConf{SocketPath: "run/cni.sock"}, wantErr: true},
+		{name: "RelativeGRPCSocket", conf: PluginConf{GRPCSocketPath: "./grpc.sock"}, wantErr: true},
+		{name: "AbsoluteFallbacks", conf: PluginConf{FallbackSocketPaths: []string{"/run/standby.sock"}}},
+		{name: "RelativeFallback", conf: PluginConf{FallbackSocketPaths: []string{"/run/standby.sock", "standby.sock"}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.conf.checkSocketPaths()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("checkSocketPaths() error = %v", err)
+				}
+				return
+			}
+			cniErr, ok := err.(*types.Error)
+			if !ok || cniErr.Code != types.ErrInvalidNetworkConfig {
+				t.Fatalf("checkSocketPaths() error = %v, want an invalid network config error", err)
+			}
+		})
+	}
+}
+
+func TestCheckDaemonHost(t *testing.T) {
+	for _, tt := range []struct {
+		host    string
+		wantErr bool
+	}{
+		{host: ""},
+		{host: "openstack-cni.local"},
+		{host: "localhost:8080"},
+		{host: "[::1]:8080"},
+		{host: "bad host", wantErr: true},
+		{host: "a%zz", wantErr: true},
+		{host: "[::1", wantErr: true},
+		{host: "host/path", wantErr: true},
+	} {
+		t.Run(tt.host, func(t *testing.T) {
+			err := (&PluginConf{DaemonHost: tt.host}).checkDaemonHost()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("checkDaemonHost() error = %v", err)
+				}
+				return
+			}
+			cniErr, ok := err.(*types.Error)
+			if !ok || cniErr.Code != types.ErrInvalidNetworkConfig {
+				t.Fatalf("checkDaemonHost() error = %v, want an invalid network config error", err)
+			}
+		})
+	}
+}
+
+func TestDaemonRequestInvalidHost(t *testing.T) {
+	conf := &PluginConf{SocketPath: "/nonexistent.sock", DaemonHost: "bad host"}
+	if err := conf.daemon().request(http.MethodPost, "/del", api.DelRequest{}, nil); err == nil {
+		t.Fatal("request() error = nil, want an error for an invalid daemon_host")
+	}
+}
+
+func TestDaemonRequestFallbackSocket(t *testing.T) {
+	d := newMockDaemon(t)
+	// A socket file left behind by a stopped daemon refuses connections.
+	stale := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+
+	client := daemonClient{socketPath: missing, fallbackSocketPaths: []string{stale, d.sock}}
+	var resp api.CheckResponse
+	if err := client.request(http.MethodPost, "/check", api.CheckRequest{ContainerID: "ctr", NetworkID: "net-uuid"}, &resp); err != nil {
+		t.Fatalf("request() error = %v", err)
+	}
+	if !resp.Exists || len(d.received("/check")) != 1 {
+		t.Errorf("response = %+v after %d requests, want the fallback daemon's answer", resp, len(d.received("/check")))
+	}
+
+	client = daemonClient{socketPath: missing, fallbackSocketPaths: []string{stale}}
+	if err := client.request(http.MethodPost, "/check", api.CheckRequest{ContainerID: "ctr", NetworkID: "net-uuid"}, nil); err == nil {
+		t.Error("request() succeeded with no daemon listening")
+	}
+}
+
+func TestCmdAddFallbackSocket(t *testing.T) {
+	d := newMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "down.sock")), &conf)
+	conf["fallback_socket_paths"] = []string{d.sock}
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-fallback", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	if got := d.received("/add"); len(got) != 1 {
+		t.Errorf("fallback daemon got %d ADDs, want 1", len(got))
+	}
+}
+
+func TestCmdAddRelativeSocketPath(t *testing.T) {
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-relative",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData("relative/cni.sock"),
+	}
+	err := cmdAdd(args)
+	if err == nil || !strings.Contains(err.Error(), "must be an absolute path") {
+		t.Fatalf("cmdAdd() error = %v, want an absolute path error", err)
+	}
+}
+
+func TestMissingDelegatePlugin(t *testing.T) {
+	d := newMockDaemon(t)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	delete(conf, "delegate_plugin")
+	stdinData, _ := json.Marshal(conf)
+	args := &skel.CmdArgs{ContainerID: "ctr-nodelegate", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData}
+
+	for name, cmd := range map[string]func(*skel.CmdArgs) error{"ADD": cmdAdd, "CHECK": cmdCheck} {
+		err := cmd(args)
+		cniErr, ok := err.(*types.Error)
+		if !ok || cniErr.Code != types.ErrInvalidNetworkConfig || !strings.Contains(cniErr.Details, "delegate_plugin is required") {
+			t.Errorf("%s error = %v, want an invalid network config error naming delegate_plugin", name, err)
+		}
+	}
+	for _, path := range []string{"/add", "/check"} {
+		if n := len(d.received(path)); n != 0 {
+			t.Errorf("daemon received %d %s request(s), want none", n, path)
+		}
+	}
+}
+
+func TestCmdAddInvalidEndpointOverride(t *testing.T) {
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "unused.sock")), &conf)
+	conf["endpoint_override"] = "neutron-canary:9696"
+	stdinData, _ := json.Marshal(conf)
+
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-endpoint", StdinData: stdinData})
+	cniErr, ok := err.(*types.Error)
+	if !ok || cniErr.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("cmdAdd error = %v, want an invalid network config error", err)
+	}
+}
+
+func TestCmdAddSubnetName(t *testing.T) {
+	d := newMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	delete(conf, "subnet_id")
+	conf["subnet_name"] = "pods"
+	conf["subnet_match"] = "ipv4"
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-subnet-name", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+
+	var req api.AddRequest
+	d.decodeLast(t, "/add", &req)
+	if req.SubnetID != "" || req.SubnetName != "pods" || req.SubnetMatch != "ipv4" {
+		t.Errorf("add request subnet = %q, name %q, match %q, want name pods matched by ipv4", req.SubnetID, req.SubnetName, req.SubnetMatch)
+	}
+}
+
+func TestCmdAddTags(t *testing.T) {
+	d := newMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	conf["tags"] = []string{"team=payments"}
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-tags", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+
+	var req api.AddRequest
+	d.decodeLast(t, "/add", &req)
+	if want := []string{"team=payments"}; !reflect.DeepEqual(req.Tags, want) {
+		t.Errorf("add request tags = %q, want [team=payments]", req.Tags)
+	}
+}
+
+func TestPodFromArgs(t *testing.T) {
+	tests := []struct {
+		args          string
+		wantNamespace string
+		wantName      string
+	}{
+		{args: "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-0;K8S_POD_INFRA_CONTAINER_ID=abcdef", wantNamespace: "default", wantName: "web-0"},
+		{args: "K8S_POD_NAME=web-0", wantName: "web-0"},
+		{args: ""},
+	}
+	for _, tt := range tests {
+		namespace, name := podFromArgs(tt.args)
+		if namespace != tt.wantNamespace || name != tt.wantName {
+			t.Errorf("podFromArgs(%q) = %q, %q, want %q, %q", tt.args, namespace, name, tt.wantNamespace, tt.wantName)
+		}
+	}
+}
+
+func TestDelegateDelay(t *testing.T) {
+	if got := (&PluginConf{}).delegateDelay(); got != 0 {
+		t.Errorf("delegateDelay() = %v by default, want 0", got)
+	}
+	conf := &PluginConf{DelegateDelayMs: 100, DelegateDelayJitterMs: 50}
+	for i := 0; i < 100; i++ {
+		if got := conf.delegateDelay(); got < 100*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("delegateDelay() = %v, want between 100ms and 150ms", got)
+		}
+	}
+}
+
+func TestCheckDelegateDelay(t *testing.T) {
+	tests := []struct {
+		delay, jitter int
+		wantErr       bool
+	}{
+		{},
+		{delay: 200, jitter: 100},
+		{delay: maxDelegateDelayMs},
+		{delay: -1, wantErr: true},
+		{jitter: -1, wantErr: true},
+		{delay: maxDelegateDelayMs, jitter: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		conf := &PluginConf{DelegateDelayMs: tt.delay, DelegateDelayJitterMs: tt.jitter}
+		if err := conf.checkDelegateDelay(); (err != nil) != tt.wantErr {
+			t.Errorf("checkDelegateDelay() with delay %d, jitter %d error = %v, wantErr %t", tt.delay, tt.jitter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCmdAddDelegateDelay(t *testing.T) {
+	d := newMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	conf["delegate_delay_ms"] = 100
+	conf["delegate_delay_jitter_ms"] = 20
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	start := time.Now()
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-delay", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	elapsed := time.Since(start)
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("cmdAdd took %v, want at least the 100ms delegate delay", elapsed)
+	}
+}
+
+func TestCmdAddReportResult(t *testing.T) {
+	for _, report := range []bool{false, true} {
+		t.Run(fmt.Sprintf("report_result=%t", report), func(t *testing.T) {
+			d := newMockDaemon(t)
+			t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+			var conf map[string]interface{}
+			_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+			conf["report_result"] = report
+			stdinData, _ := json.Marshal(conf)
+
+			oldStdout := os.Stdout
+			r, w, _ := os.Pipe()
+			defer r.Close()
+			os.Stdout = w
+			err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-report", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+			_ = w.Close()
+			os.Stdout = oldStdout
+			if err != nil {
+				t.Fatalf("cmdAdd returned error: %v", err)
+			}
+
+			if !report {
+				if got := d.received("/report"); len(got) != 0 {
+					t.Errorf("got %d reports without report_result, want none", len(got))
+				}
+				return
+			}
+			var req api.ReportRequest
+			d.decodeLast(t, "/report", &req)
+			if req.ContainerID != "ctr-report" || req.NetworkID != "net-uuid" || req.PortID != "port-123" {
+				t.Errorf("report = %+v, want container ctr-report, network net-uuid and port port-123", req)
+			}
+			var result struct {
+				IPs []struct {
+					Address string `json:"address"`
+				} `json:"ips"`
+			}
+			if err := json.Unmarshal(req.Result, &result); err != nil || len(result.IPs) != 1 || result.IPs[0].Address != "10.0.0.5/24" {
+				t.Errorf("reported result = %s, want the delegate's result with 10.0.0.5/24", req.Result)
+			}
+		})
+	}
+}
+
+func TestCmdAddReportResultFailureIsIgnored(t *testing.T) {
+	d := newMockDaemon(t)
+	d.respond("/report", http.StatusInternalServerError, api.ErrorResponse{Error: "boom"})
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	conf["report_result"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-report", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error %v after a failed report, want success", err)
+	}
+	if got := d.received("/del"); len(got) != 0 {
+		t.Errorf("port deleted after a failed report")
+	}
+}
+
+func TestCmdAddInvalidSubnetMatch(t *testing.T) {
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "unused.sock")), &conf)
+	conf["subnet_name"] = "pods"
+	conf["subnet_match"] = "last"
+	stdinData, _ := json.Marshal(conf)
+
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-subnet-match", StdinData: stdinData})
+	cniErr, ok := err.(*types.Error)
+	if !ok || cniErr.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("cmdAdd error = %v, want an invalid network config error", err)
+	}
+}
+
 func TestDaemonRequestSuccess(t *testing.T) {
 	tmpDir := t.TempDir()
 	sock := filepath.Join(tmpDir, "test.sock")
@@ -151,7 +513,7 @@ func TestDaemonRequestSuccess(t *testing.T) {
 	defer func() { _ = srv.Close() }()
 
 	var resp api.AddResponse
-	err = daemonRequest(sock, http.MethodPost, "/add", api.AddRequest{
+	err = daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{
 		ContainerID: "ctr-1",
 		NetworkID:   "net-1",
 		SubnetID:    "sub-1",
@@ -183,7 +545,7 @@ func TestDaemonRequestErrorResponse(t *testing.T) {
 	defer func() { _ = srv.Close() }()
 
 	var resp api.AddResponse
-	err = daemonRequest(sock, http.MethodPost, "/add", api.AddRequest{}, &resp)
+	err = daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{}, &resp)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -208,7 +570,7 @@ func TestDaemonRequestNon200(t *testing.T) {
 	defer func() { _ = srv.Close() }()
 
 	var resp api.AddResponse
-	err = daemonRequest(sock, http.MethodPost, "/add", api.AddRequest{}, &resp)
+	err = daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{}, &resp)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -217,10 +579,268 @@ func TestDaemonRequestNon200(t *testing.T) {
 	}
 }
 
+func TestDaemonRequestOversizedResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		body     func(w io.Writer)
+		wantErr  string
+	}{
+		{
+			name:     "OverConfiguredLimit",
+			maxBytes: 1024,
+			body:     func(w io.Writer) { _, _ = w.Write(bytes.Repeat([]byte(" "), 4096)) },
+			wantErr:  "exceeds 1024 bytes",
+		},
+		{
+			name: "EndlessBody",
+			body: func(w io.Writer) {
+				chunk := bytes.Repeat([]byte(" "), 64<<10)
+				for {
+					if _, err := w.Write(chunk); err != nil {
+						return
+					}
+				}
+			},
+			wantErr: fmt.Sprintf("exceeds %d bytes", defaultMaxResponseBytes),
+		},
+		{
+			name:     "WithinLimit",
+			maxBytes: 1024,
+			body:     func(w io.Writer) { _ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true}) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sock := filepath.Join(t.TempDir(), "test.sock")
+			listener, err := net.Listen("unix", sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.body(w)
+			})}
+			go func() { _ = srv.Serve(listener) }()
+			defer func() { _ = srv.Close() }()
+
+			var resp api.CheckResponse
+			err = daemonClient{socketPath: sock, maxResponseBytes: tt.maxBytes}.request(http.MethodPost, "/check", api.CheckRequest{}, &resp)
+			if tt.wantErr == "" {
+				if err != nil || !resp.Exists {
+					t.Errorf("request() = %+v, %v, want exists", resp, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("request() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDaemonRequestHost(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"default", "", "localhost"},
+		{"custom", "openstack-cni.local", "openstack-cni.local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sock := filepath.Join(t.TempDir(), "test.sock")
+			listener, err := net.Listen("unix", sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hostCh := make(chan string, 1)
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hostCh <- r.Host
+				_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true})
+			})}
+			go func() { _ = srv.Serve(listener) }()
+			defer func() { _ = srv.Close() }()
+
+			conf := &PluginConf{SocketPath: sock, DaemonHost: tt.host}
+			var resp api.CheckResponse
+			if err := conf.daemon().request(http.MethodPost, "/check", api.CheckRequest{}, &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := <-hostCh; got != tt.want {
+				t.Errorf("Host = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", defaultDaemonRetryAfter},
+		{"bogus", defaultDaemonRetryAfter},
+		{"-1", defaultDaemonRetryAfter},
+		{"0", 0},
+		{"2", 2 * time.Second},
+		{"3600", maxDaemonRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDaemonRequestRetriesTooManyRequests(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "daemon busy, retry later"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true})
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	defer func() { _ = srv.Close() }()
+
+	var resp api.CheckResponse
+	if err := (daemonClient{socketPath: sock}).request(http.MethodPost, "/check", api.CheckRequest{}, &resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Exists {
+		t.Error("expected Exists=true")
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestDaemonRequestTooManyRequestsExhausted(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "daemon busy, retry later"})
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	defer func() { _ = srv.Close() }()
+
+	err = daemonClient{socketPath: sock}.request(http.MethodPost, "/check", api.CheckRequest{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "daemon busy") {
+		t.Fatalf("expected daemon busy error, got: %v", err)
+	}
+	if calls != daemonBusyAttempts {
+		t.Errorf("expected %d calls, got %d", daemonBusyAttempts, calls)
+	}
+}
+
+func TestDaemonRequestRateLimited(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "too many ADDs for pod default/web-0", Reason: api.ReasonRateLimited})
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	defer func() { _ = srv.Close() }()
+
+	// A pod over its rate limit is not retried like a busy daemon.
+	err = daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "too many ADDs") {
+		t.Fatalf("expected rate limit error, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestDaemonRequestUnavailable(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"", 0},
+	} {
+		t.Run("Retry-After="+tt.header, func(t *testing.T) {
+			sock := filepath.Join(t.TempDir(), "test.sock")
+			listener, err := net.Listen("unix", sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			calls := 0
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "subnet has no delegated IPv6 prefix yet"})
+			})}
+			go func() { _ = srv.Serve(listener) }()
+			defer func() { _ = srv.Close() }()
+
+			err = daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{}, &api.AddResponse{})
+			var unavailable *daemonUnavailableError
+			if !errors.As(err, &unavailable) {
+				t.Fatalf("expected *daemonUnavailableError, got %T: %v", err, err)
+			}
+			if unavailable.RetryAfter != tt.want {
+				t.Errorf("RetryAfter = %v, want %v", unavailable.RetryAfter, tt.want)
+			}
+			if !strings.Contains(err.Error(), "no delegated IPv6 prefix") {
+				t.Errorf("error = %q, want the daemon message", err)
+			}
+			if calls != 1 {
+				t.Errorf("expected 1 call, got %d", calls)
+			}
+		})
+	}
+}
+
 func TestDaemonRequestConnectionRefused(t *testing.T) {
 	sock := filepath.Join(t.TempDir(), "nonexistent.sock")
 	var resp api.AddResponse
-	err := daemonRequest(sock, http.MethodPost, "/add", api.AddRequest{}, &resp)
+	err := daemonClient{socketPath: sock}.request(http.MethodPost, "/add", api.AddRequest{}, &resp)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -285,6 +905,56 @@ func TestIntegrationCmdDel(t *testing.T) {
 	}
 }
 
+func TestCmdDelStrict(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		strict  bool
+		wantErr bool
+	}{
+		{name: "Lenient", strict: false, wantErr: false},
+		{name: "Strict", strict: true, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sock := filepath.Join(t.TempDir(), "test.sock")
+			listener, err := net.Listen("unix", sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got api.DelRequest
+			mux := http.NewServeMux()
+			mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(http.StatusInternalServerError)
+				_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "failed to delete port port-123"})
+			})
+			srv := &http.Server{Handler: mux}
+			go func() { _ = srv.Serve(listener) }()
+			t.Cleanup(func() { _ = srv.Close() })
+
+			cniPath := setupFakeDelegatePlugin(t)
+			t.Setenv("CNI_PATH", cniPath)
+
+			var conf map[string]interface{}
+			_ = json.Unmarshal(makeStdinData(sock), &conf)
+			conf["strict_del"] = tt.strict
+			stdinData, _ := json.Marshal(conf)
+
+			err = cmdDel(&skel.CmdArgs{
+				ContainerID: "ctr-del-strict",
+				Netns:       "/proc/1/ns/net",
+				IfName:      "eth0",
+				StdinData:   stdinData,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cmdDel error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Strict != tt.strict {
+				t.Errorf("forwarded strict = %t, want %t", got.Strict, tt.strict)
+			}
+		})
+	}
+}
+
 func TestIntegrationCmdCheck(t *testing.T) {
 	sock := setupMockDaemon(t)
 	cniPath := setupFakeDelegatePlugin(t)
@@ -321,6 +991,9 @@ func TestIntegrationCmdCheckNotFound(t *testing.T) {
 	if !strings.Contains(err.Error(), "neutron port not found") {
 		t.Fatalf("expected 'neutron port not found', got: %v", err)
 	}
+	if !strings.Contains(err.Error(), "on network net-123") {
+		t.Errorf("expected the daemon's detail in the error, got: %v", err)
+	}
 }
 
 func TestIntegrationCmdAddDaemonDown(t *testing.T) {
@@ -400,7 +1073,8 @@ func TestCmdAddForwardsSecurityGroupIDs(t *testing.T) {
 	}
 
 	oldStdout := os.Stdout
-	_, w, _ := os.Pipe()
+	r, w, _ := os.Pipe()
+	defer r.Close()
 	os.Stdout = w
 
 	cmdErr := cmdAdd(args)
@@ -465,7 +1139,8 @@ func TestCmdAddNoSecurityGroupIDs(t *testing.T) {
 	}
 
 	oldStdout := os.Stdout
-	_, w, _ := os.Pipe()
+	r, w, _ := os.Pipe()
+	defer r.Close()
 	os.Stdout = w
 
 	cmdErr := cmdAdd(args)
@@ -481,3 +1156,1650 @@ func TestCmdAddNoSecurityGroupIDs(t *testing.T) {
 		t.Errorf("expected no security group IDs, got %v", receivedSGIDs)
 	}
 }
+
+// setupFlakyDelegatePlugin installs a fake delegate whose ADD fails with a
+// CNI internal error the first `failures` times and then succeeds.
+func setupFlakyDelegatePlugin(t *testing.T, failures int) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "attempts")
+	script := filepath.Join(dir, "ovs")
+	content := fmt.Sprintf(`#!/bin/sh
+if [ "$CNI_COMMAND" = "DEL" ]; then exit 0; fi
+if [ "$CNI_COMMAND" = "CHECK" ]; then exit 0; fi
+echo x >> %[1]s
+if [ "$(wc -l < %[1]s)" -le %[2]d ]; then
+  echo '{"cniVersion":"0.4.0","code":999,"msg":"ovsdb unavailable"}'
+  exit 1
+fi
+echo '{"cniVersion":"0.4.0","interfaces":[{"name":"eth0"}],"ips":[{"address":"10.0.0.5/24","gateway":"10.0.0.1"}]}'
+`, counter, failures)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, counter
+}
+
+func countAttempts(t *testing.T, counter string) int {
+	t.Helper()
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestDelegateAddAttemptsDefault(t *testing.T) {
+	c := &PluginConf{}
+	if got := c.delegateAddAttempts(); got != defaultDelegateAddAttempts {
+		t.Fatalf("expected %d, got %d", defaultDelegateAddAttempts, got)
+	}
+	c.DelegateAddAttempts = 5
+	if got := c.delegateAddAttempts(); got != 5 {
+		t.Fatalf("expected 5, got %d", got)
+	}
+}
+
+func TestIsRetriableDelegateError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal", &types.Error{Code: types.ErrInternal}, true},
+		{"try again later", &types.Error{Code: types.ErrTryAgainLater}, true},
+		{"unknown", &types.Error{Code: types.ErrUnknown}, true},
+		{"invalid config", &types.Error{Code: types.ErrInvalidNetworkConfig}, false},
+		{"plain error", fmt.Errorf("plugin not found"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetriableDelegateError(tt.err); got != tt.want {
+				t.Errorf("isRetriableDelegateError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdAddRetriesDelegateAdd(t *testing.T) {
+	oldDelay := delegateAddRetryDelay
+	delegateAddRetryDelay = 0
+	t.Cleanup(func() { delegateAddRetryDelay = oldDelay })
+
+	sock := setupMockDaemon(t)
+	cniPath, counter := setupFlakyDelegatePlugin(t, 1)
+	t.Setenv("CNI_PATH", cniPath)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-retry-1",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData(sock),
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err := cmdAdd(args)
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	if got := countAttempts(t, counter); got != 2 {
+		t.Fatalf("expected 2 delegate ADD attempts, got %d", got)
+	}
+}
+
+func TestCmdAddDelegateAddAttemptsExhausted(t *testing.T) {
+	oldDelay := delegateAddRetryDelay
+	delegateAddRetryDelay = 0
+	t.Cleanup(func() { delegateAddRetryDelay = oldDelay })
+
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delCh := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		delCh <- struct{}{}
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath, counter := setupFlakyDelegatePlugin(t, 5)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["delegate_add_attempts"] = 2
+	stdinData, _ := json.Marshal(conf)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-retry-2",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	}
+
+	if err := cmdAdd(args); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if got := countAttempts(t, counter); got != 2 {
+		t.Fatalf("expected 2 delegate ADD attempts, got %d", got)
+	}
+	select {
+	case <-delCh:
+	default:
+		t.Fatal("expected Neutron port cleanup after delegate ADD attempts were exhausted")
+	}
+}
+
+func TestCmdAddKeepOnFailure(t *testing.T) {
+	oldDelay := delegateAddRetryDelay
+	delegateAddRetryDelay = 0
+	t.Cleanup(func() { delegateAddRetryDelay = oldDelay })
+
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delCh := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		delCh <- struct{}{}
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath, _ := setupFlakyDelegatePlugin(t, 5)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["delegate_add_attempts"] = 1
+	conf["keep_on_failure"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-keep",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	}
+
+	if err := cmdAdd(args); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	select {
+	case <-delCh:
+		t.Fatal("Neutron port was cleaned up despite keep_on_failure")
+	default:
+	}
+}
+
+// setupCapturingDelegatePlugin installs a fake delegate that records the
+// config it receives on ADD into the returned file.
+func setupCapturingDelegatePlugin(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	captured := filepath.Join(dir, "stdin.json")
+	script := filepath.Join(dir, "ovs")
+	content := fmt.Sprintf(`#!/bin/sh
+if [ "$CNI_COMMAND" = "DEL" ]; then exit 0; fi
+if [ "$CNI_COMMAND" = "CHECK" ]; then exit 0; fi
+cat > %s
+echo '{"cniVersion":"0.4.0","interfaces":[{"name":"eth0"}],"ips":[{"address":"10.0.0.5/24","gateway":"10.0.0.1"}]}'
+`, captured)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, captured
+}
+
+func TestOnLinkRoutes(t *testing.T) {
+	routes, err := onLinkRoutes("192.168.1.1")
+	if err != nil {
+		t.Fatalf("onLinkRoutes returned error: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %v", routes)
+	}
+	if routes[0]["dst"] != "192.168.1.1/32" || routes[0]["scope"] != unix.RT_SCOPE_LINK {
+		t.Errorf("unexpected host route %v", routes[0])
+	}
+	if routes[1]["dst"] != "0.0.0.0/0" || routes[1]["gw"] != "192.168.1.1" {
+		t.Errorf("unexpected default route %v", routes[1])
+	}
+
+	routes, err = onLinkRoutes("fd00::1")
+	if err != nil {
+		t.Fatalf("onLinkRoutes returned error: %v", err)
+	}
+	if routes[0]["dst"] != "fd00::1/128" || routes[1]["dst"] != "::/0" {
+		t.Errorf("unexpected IPv6 routes %v", routes)
+	}
+
+	routes, err = onLinkRoutes("fe80::1")
+	if err != nil {
+		t.Fatalf("onLinkRoutes returned error: %v", err)
+	}
+	if len(routes) != 1 || routes[0]["dst"] != "::/0" || routes[0]["gw"] != "fe80::1" {
+		t.Errorf("unexpected IPv6 link-local routes %v", routes)
+	}
+
+	if _, err := onLinkRoutes("bogus"); err == nil {
+		t.Error("expected error for invalid gateway")
+	}
+}
+
+func TestStaticIPAM(t *testing.T) {
+	tests := []struct {
+		name         string
+		ipAddress    string
+		ipAddresses  []string
+		prefixLength string
+		gatewayIP    string
+		want         []map[string]interface{}
+	}{
+		{
+			name: "ipv4", ipAddress: "10.0.0.5", prefixLength: "24", gatewayIP: "10.0.0.1",
+			want: []map[string]interface{}{{"address": "10.0.0.5/24", "gateway": "10.0.0.1"}},
+		},
+		{
+			name: "ipv6", ipAddress: "2001:db8::5", prefixLength: "64", gatewayIP: "2001:db8::1",
+			want: []map[string]interface{}{{"address": "2001:db8::5/64", "gateway": "2001:db8::1"}},
+		},
+		{
+			name: "ipv6 link-local gateway", ipAddress: "2001:db8::5", prefixLength: "64", gatewayIP: "fe80::1",
+			want: []map[string]interface{}{{"address": "2001:db8::5/64", "gateway": "fe80::1"}},
+		},
+		{
+			name: "ipv6 non-canonical", ipAddress: "2001:0db8:0000::0005", prefixLength: "64", gatewayIP: "2001:DB8::1",
+			want: []map[string]interface{}{{"address": "2001:db8::5/64", "gateway": "2001:db8::1"}},
+		},
+		{
+			name: "ipv6 two addresses", ipAddress: "2001:db8::5", ipAddresses: []string{"2001:db8::5", "2001:db8::6"}, prefixLength: "64",
+			want: []map[string]interface{}{{"address": "2001:db8::5/64"}, {"address": "2001:db8::6/64"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipam := staticIPAM(tt.ipAddress, tt.ipAddresses, tt.prefixLength, tt.gatewayIP)
+			if ipam["type"] != "static" {
+				t.Errorf("type = %v, want static", ipam["type"])
+			}
+			if got := ipam["addresses"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addresses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdAddOnLinkGatewayEmitsRoutes(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCh := make(chan api.AddRequest, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		var req api.AddRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reqCh <- req
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    req.GatewayIP,
+		})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath, captured := setupCapturingDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["gateway_ip"] = "192.168.1.1"
+	conf["on_link"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-onlink",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	cmdErr := cmdAdd(args)
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if cmdErr != nil {
+		t.Fatalf("cmdAdd returned error: %v", cmdErr)
+	}
+	req := <-reqCh
+	if req.GatewayIP != "192.168.1.1" || !req.OnLink {
+		t.Errorf("expected gateway override to be forwarded, got %+v", req)
+	}
+
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delegated struct {
+		IPAM struct {
+			Addresses []struct {
+				Gateway string `json:"gateway"`
+			} `json:"addresses"`
+			Routes []struct {
+				Dst   string `json:"dst"`
+				GW    string `json:"gw"`
+				Scope *int   `json:"scope"`
+			} `json:"routes"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(data, &delegated); err != nil {
+		t.Fatalf("failed to decode delegated config: %v", err)
+	}
+	if len(delegated.IPAM.Addresses) != 1 || delegated.IPAM.Addresses[0].Gateway != "192.168.1.1" {
+		t.Errorf("unexpected delegated addresses %+v", delegated.IPAM.Addresses)
+	}
+	if len(delegated.IPAM.Routes) != 2 {
+		t.Fatalf("expected 2 delegated routes, got %+v", delegated.IPAM.Routes)
+	}
+	if r := delegated.IPAM.Routes[0]; r.Dst != "192.168.1.1/32" || r.Scope == nil || *r.Scope != unix.RT_SCOPE_LINK {
+		t.Errorf("unexpected on-link route %+v", r)
+	}
+	if r := delegated.IPAM.Routes[1]; r.Dst != "0.0.0.0/0" || r.GW != "192.168.1.1" {
+		t.Errorf("unexpected default route %+v", r)
+	}
+}
+
+func TestAppendRoutes(t *testing.T) {
+	onLink, err := onLinkRoutes("192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostRoutes := []api.Route{{Dst: "172.16.0.0/16", GW: "10.0.0.254"}, {Dst: "10.96.0.0/12", GW: "10.0.0.253"}}
+	confRoutes := []api.Route{{Dst: "10.96.0.1/12", GW: "10.0.0.1"}, {Dst: "192.0.2.0/24"}}
+
+	routes := appendRoutes(onLink, hostRoutes, confRoutes)
+	want := []map[string]interface{}{
+		{"dst": "192.168.1.1/32", "scope": unix.RT_SCOPE_LINK},
+		{"dst": "0.0.0.0/0", "gw": "192.168.1.1"},
+		{"dst": "172.16.0.0/16", "gw": "10.0.0.254"},
+		{"dst": "10.96.0.1/12", "gw": "10.0.0.1"},
+		{"dst": "192.0.2.0/24"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("appendRoutes() = %v, want %v", routes, want)
+	}
+	if routes := appendRoutes(nil, nil, nil); routes != nil {
+		t.Errorf("appendRoutes() without routes = %v, want nil", routes)
+	}
+}
+
+func TestCmdAddRoutes(t *testing.T) {
+	d := newMockDaemon(t)
+	d.respond("/add", http.StatusOK, api.AddResponse{
+		PortID:       "port-123",
+		MACAddress:   "fa:16:3e:aa:bb:cc",
+		IPAddress:    "10.0.0.5",
+		PrefixLength: "24",
+		GatewayIP:    "10.0.0.1",
+		HostRoutes:   []api.Route{{Dst: "172.16.0.0/16", GW: "10.0.0.254"}},
+	})
+	cniPath, captured := setupCapturingDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	conf["routes"] = []api.Route{{Dst: "10.96.0.0/12"}}
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-routes", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData})
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delegated struct {
+		IPAM struct {
+			Routes []api.Route `json:"routes"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(data, &delegated); err != nil {
+		t.Fatalf("failed to decode delegated config: %v", err)
+	}
+	want := []api.Route{{Dst: "172.16.0.0/16", GW: "10.0.0.254"}, {Dst: "10.96.0.0/12"}}
+	if !reflect.DeepEqual(delegated.IPAM.Routes, want) {
+		t.Errorf("delegated routes = %+v, want %+v", delegated.IPAM.Routes, want)
+	}
+}
+
+func TestCmdAddInvalidRoutes(t *testing.T) {
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "unused.sock")), &conf)
+	conf["routes"] = []api.Route{{Dst: "10.96.0.0"}}
+	stdinData, _ := json.Marshal(conf)
+
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-routes", StdinData: stdinData})
+	cniErr, ok := err.(*types.Error)
+	if !ok || cniErr.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("cmdAdd error = %v, want an invalid network config error", err)
+	}
+}
+
+func TestCheckDelegatedPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		prefix  string
+		wantErr bool
+	}{
+		{"inside prefix", "2001:db8:1::5", "2001:db8:1::/64", false},
+		{"outside prefix", "2001:db8:2::5", "2001:db8:1::/64", true},
+		{"invalid prefix", "2001:db8:1::5", "bogus", true},
+		{"invalid address", "", "2001:db8:1::/64", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDelegatedPrefix(tt.ip, tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDelegatedPrefix(%q, %q) error = %v, wantErr %v", tt.ip, tt.prefix, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// setupMockDaemonNoIP starts a mock daemon whose /add returns a port without
+// an IP address, on a subnet with delegatedPrefix when set. The returned
+// channel receives a value for every /del.
+func setupMockDaemonNoIP(t *testing.T, delegatedPrefix string) (string, chan struct{}) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delCh := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:          "port-123",
+			MACAddress:      "fa:16:3e:aa:bb:cc",
+			PrefixLength:    "24",
+			GatewayIP:       "10.0.0.1",
+			SubnetCIDR:      "10.0.0.0/24",
+			FallbackStart:   "10.0.0.128",
+			FallbackEnd:     "10.0.0.191",
+			DelegatedPrefix: delegatedPrefix,
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		delCh <- struct{}{}
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return sock, delCh
+}
+
+func TestFallbackIPAM(t *testing.T) {
+	got := fallbackIPAM("10.0.0.0/24", "10.0.0.1", "10.0.0.128", "10.0.0.191")
+	data, _ := json.Marshal(got)
+	want := `{"ranges":[[{"gateway":"10.0.0.1","rangeEnd":"10.0.0.191","rangeStart":"10.0.0.128","subnet":"10.0.0.0/24"}]],"type":"host-local"}`
+	if string(data) != want {
+		t.Errorf("fallbackIPAM() = %s, want %s", data, want)
+	}
+}
+
+func TestCmdAddFallbackIPAM(t *testing.T) {
+	// A delegated prefix is no reason to fail: the address fallback IPAM
+	// allocates is not known yet.
+	for _, delegatedPrefix := range []string{"", "10.0.0.0/24"} {
+		t.Run("DelegatedPrefix="+delegatedPrefix, func(t *testing.T) {
+			sock, _ := setupMockDaemonNoIP(t, delegatedPrefix)
+			cniPath, captured := setupCapturingDelegatePlugin(t)
+			t.Setenv("CNI_PATH", cniPath)
+
+			var conf map[string]interface{}
+			_ = json.Unmarshal(makeStdinData(sock), &conf)
+			conf["fallback_ipam"] = true
+			stdinData, _ := json.Marshal(conf)
+
+			args := &skel.CmdArgs{
+				ContainerID: "ctr-fallback",
+				Netns:       "/proc/1/ns/net",
+				IfName:      "eth0",
+				StdinData:   stdinData,
+			}
+
+			oldStdout := os.Stdout
+			r, w, _ := os.Pipe()
+			defer r.Close()
+			os.Stdout = w
+
+			cmdErr := cmdAdd(args)
+
+			_ = w.Close()
+			os.Stdout = oldStdout
+
+			if cmdErr != nil {
+				t.Fatalf("cmdAdd returned error: %v", cmdErr)
+			}
+			data, err := os.ReadFile(captured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var delegated struct {
+				IPAM struct {
+					Type   string `json:"type"`
+					Ranges [][]struct {
+						Subnet     string `json:"subnet"`
+						RangeStart string `json:"rangeStart"`
+						RangeEnd   string `json:"rangeEnd"`
+						Gateway    string `json:"gateway"`
+					} `json:"ranges"`
+				} `json:"ipam"`
+			}
+			if err := json.Unmarshal(data, &delegated); err != nil {
+				t.Fatalf("failed to decode delegated config: %v", err)
+			}
+			if delegated.IPAM.Type != "host-local" {
+				t.Errorf("ipam type = %q, want host-local", delegated.IPAM.Type)
+			}
+			if len(delegated.IPAM.Ranges) != 1 || len(delegated.IPAM.Ranges[0]) != 1 {
+				t.Fatalf("unexpected ranges %+v", delegated.IPAM.Ranges)
+			}
+			if r := delegated.IPAM.Ranges[0][0]; r.Subnet != "10.0.0.0/24" || r.RangeStart != "10.0.0.128" || r.RangeEnd != "10.0.0.191" {
+				t.Errorf("range = %+v, want 10.0.0.128-10.0.0.191 of 10.0.0.0/24", r)
+			}
+		})
+	}
+}
+
+func TestCmdDelFallbackIPAM(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		fallback bool
+		wantIPAM string
+	}{
+		{name: "Fallback", fallback: true, wantIPAM: "host-local"},
+		{name: "Static", fallback: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sock, delCh := setupMockDaemonNoIP(t, "")
+			dir := t.TempDir()
+			captured := filepath.Join(dir, "del.json")
+			script := fmt.Sprintf("#!/bin/sh\nif [ \"$CNI_COMMAND\" = \"DEL\" ]; then cat > %s; fi\n", captured)
+			if err := os.WriteFile(filepath.Join(dir, "ovs"), []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CNI_PATH", dir)
+
+			var conf map[string]interface{}
+			_ = json.Unmarshal(makeStdinData(sock), &conf)
+			conf["fallback_ipam"] = tt.fallback
+			conf["prevResult"] = map[string]interface{}{
+				"cniVersion": "0.4.0",
+				"ips":        []map[string]interface{}{{"version": "4", "address": "10.0.0.130/24", "gateway": "10.0.0.1"}},
+			}
+			stdinData, _ := json.Marshal(conf)
+			if err := cmdDel(&skel.CmdArgs{ContainerID: "ctr-fallback", Netns: "/proc/1/ns/net", IfName: "eth0", StdinData: stdinData}); err != nil {
+				t.Fatalf("cmdDel returned error: %v", err)
+			}
+			<-delCh
+
+			data, err := os.ReadFile(captured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var delegated struct {
+				IPAM struct {
+					Type   string `json:"type"`
+					Ranges [][]struct {
+						Subnet string `json:"subnet"`
+					} `json:"ranges"`
+				} `json:"ipam"`
+			}
+			if err := json.Unmarshal(data, &delegated); err != nil {
+				t.Fatalf("failed to decode delegated config: %v", err)
+			}
+			// host-local releases the container's lease only when the DEL
+			// names it as the IPAM plugin.
+			if delegated.IPAM.Type != tt.wantIPAM {
+				t.Errorf("delegate DEL ipam type = %q, want %q", delegated.IPAM.Type, tt.wantIPAM)
+			}
+			if tt.fallback && (len(delegated.IPAM.Ranges) != 1 || len(delegated.IPAM.Ranges[0]) != 1 || delegated.IPAM.Ranges[0][0].Subnet != "10.0.0.0/24") {
+				t.Errorf("delegate DEL ranges = %+v, want the prevResult's subnet", delegated.IPAM.Ranges)
+			}
+		})
+	}
+}
+
+func TestCmdAddNoIPWithoutFallback(t *testing.T) {
+	sock, delCh := setupMockDaemonNoIP(t, "")
+	cniPath := setupFakeDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-noip",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData(sock),
+	}
+
+	err := cmdAdd(args)
+	if err == nil || !strings.Contains(err.Error(), "no IP address") {
+		t.Fatalf("expected no IP address error, got: %v", err)
+	}
+	select {
+	case <-delCh:
+	default:
+		t.Error("expected the Neutron port to be cleaned up")
+	}
+}
+
+func TestCmdAddIPConflict(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got api.AddRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "ip_address 10.0.0.5 is already allocated on network net-123"})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["ip_address"] = "10.0.0.5"
+	stdinData, _ := json.Marshal(conf)
+
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-conflict",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	}
+
+	err = cmdAdd(args)
+	cniErr, ok := err.(*types.Error)
+	if !ok {
+		t.Fatalf("expected *types.Error, got %T: %v", err, err)
+	}
+	if cniErr.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("error code = %d, want %d", cniErr.Code, types.ErrInvalidNetworkConfig)
+	}
+	if !strings.Contains(cniErr.Details, "10.0.0.5") {
+		t.Errorf("error details = %q, want it to name the address", cniErr.Details)
+	}
+	if got.IPAddress != "10.0.0.5" {
+		t.Errorf("forwarded ip_address = %q, want %q", got.IPAddress, "10.0.0.5")
+	}
+}
+
+// setupMockDaemonAdminDown starts a mock daemon that records the order of
+// requests and whether the delegate had already run when /up arrived.
+func setupMockDaemonAdminDown(t *testing.T, captured string, upStatus int) (string, *[]string, *bool) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	calls := []string{}
+	wiredBeforeUp := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		var req api.AddRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.AdminStateDown {
+			t.Error("expected admin_state_down in AddRequest")
+		}
+		mu.Lock()
+		calls = append(calls, "/add")
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/up", func(w http.ResponseWriter, r *http.Request) {
+		var req api.UpRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.PortID != "port-123" {
+			t.Errorf("UpRequest port_id = %q, want port-123", req.PortID)
+		}
+		mu.Lock()
+		calls = append(calls, "/up")
+		_, err := os.Stat(captured)
+		wiredBeforeUp = err == nil
+		mu.Unlock()
+		if upStatus != http.StatusOK {
+			w.WriteHeader(upStatus)
+			_ = json.NewEncoder(w).Encode(api.ErrorResponse{Error: "failed to set port admin state up"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.UpResponse{OK: true})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, "/del")
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return sock, &calls, &wiredBeforeUp
+}
+
+func TestCmdAddAdminStateDown(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		upStatus  int
+		wantErr   bool
+		wantCalls []string
+	}{
+		{name: "BroughtUpAfterDelegate", upStatus: http.StatusOK, wantCalls: []string{"/add", "/up"}},
+		{name: "UpFailsCleansUp", upStatus: http.StatusInternalServerError, wantErr: true, wantCalls: []string{"/add", "/up", "/del"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cniPath, captured := setupCapturingDelegatePlugin(t)
+			t.Setenv("CNI_PATH", cniPath)
+			sock, calls, wiredBeforeUp := setupMockDaemonAdminDown(t, captured, tt.upStatus)
+
+			var conf map[string]interface{}
+			_ = json.Unmarshal(makeStdinData(sock), &conf)
+			conf["admin_state_down"] = true
+			stdinData, _ := json.Marshal(conf)
+
+			oldStdout := os.Stdout
+			r, w, _ := os.Pipe()
+			defer r.Close()
+			os.Stdout = w
+
+			err := cmdAdd(&skel.CmdArgs{
+				ContainerID: "ctr-admin-down",
+				Netns:       "/proc/1/ns/net",
+				IfName:      "eth0",
+				StdinData:   stdinData,
+			})
+
+			_ = w.Close()
+			os.Stdout = oldStdout
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cmdAdd error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*calls, tt.wantCalls) {
+				t.Errorf("daemon calls = %v, want %v", *calls, tt.wantCalls)
+			}
+			if !*wiredBeforeUp {
+				t.Error("expected the delegate ADD to run before /up")
+			}
+		})
+	}
+}
+
+func TestCmdAddMTU(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got api.AddRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+			MTU:          1400,
+		})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath, captured := setupCapturingDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["mtu"] = 1400
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err = cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-mtu",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	if got.MTU != 1400 {
+		t.Errorf("forwarded mtu = %d, want 1400", got.MTU)
+	}
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delegated struct {
+		MTU int `json:"mtu"`
+	}
+	if err := json.Unmarshal(data, &delegated); err != nil {
+		t.Fatalf("failed to decode delegated config: %v", err)
+	}
+	if delegated.MTU != 1400 {
+		t.Errorf("delegated mtu = %d, want 1400", delegated.MTU)
+	}
+}
+
+func TestCmdAddIPCount(t *testing.T) {
+	d := newMockDaemon(t)
+	d.respond("/add", http.StatusOK, api.AddResponse{
+		PortID:       "port-123",
+		MACAddress:   "fa:16:3e:aa:bb:cc",
+		IPAddress:    "10.0.0.5",
+		IPAddresses:  []string{"10.0.0.5", "10.0.0.6"},
+		PrefixLength: "24",
+		GatewayIP:    "10.0.0.1",
+	})
+
+	cniPath, captured := setupCapturingDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(d.sock), &conf)
+	conf["ip_count"] = 2
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-ipcount",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	var got api.AddRequest
+	d.decodeLast(t, "/add", &got)
+	if got.IPCount != 2 {
+		t.Errorf("forwarded ip_count = %d, want 2", got.IPCount)
+	}
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delegated struct {
+		IPAM struct {
+			Addresses []struct {
+				Address string `json:"address"`
+				Gateway string `json:"gateway"`
+			} `json:"addresses"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(data, &delegated); err != nil {
+		t.Fatalf("failed to decode delegated config: %v", err)
+	}
+	addresses := delegated.IPAM.Addresses
+	if len(addresses) != 2 || addresses[0].Address != "10.0.0.5/24" || addresses[1].Address != "10.0.0.6/24" {
+		t.Fatalf("delegated addresses = %+v, want 10.0.0.5/24 and 10.0.0.6/24", addresses)
+	}
+	if addresses[0].Gateway != "10.0.0.1" || addresses[1].Gateway != "" {
+		t.Errorf("delegated gateways = %q, %q, want the gateway on the first address only", addresses[0].Gateway, addresses[1].Gateway)
+	}
+}
+
+func TestPluginVersionInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "Default", list: "", want: version.All.SupportedVersions()},
+		{name: "Pinned", list: "1.0.0", want: []string{"1.0.0"}},
+		{name: "List", list: "0.4.0, 1.0.0", want: []string{"0.4.0", "1.0.0"}},
+		{name: "Unknown", list: "9.9.9", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := pluginVersionInfo(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pluginVersionInfo(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := info.SupportedVersions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SupportedVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPluginVersionNegotiation runs the skel entry point with a restricted
+// version set and checks that a config for an unadvertised version is
+// refused before cmdAdd runs.
+func TestPluginVersionNegotiation(t *testing.T) {
+	info, err := pluginVersionInfo("1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("CNI_CONTAINERID", "ctr-version")
+	t.Setenv("CNI_NETNS", "/proc/1/ns/net")
+	t.Setenv("CNI_IFNAME", "eth0")
+	t.Setenv("CNI_PATH", t.TempDir())
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(`{"cniVersion":"0.4.0","name":"test-net","type":"openstack-port"}`))
+	_ = w.Close()
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	called := false
+	add := func(*skel.CmdArgs) error {
+		called = true
+		return nil
+	}
+	cniErr := skel.PluginMainFuncsWithError(skel.CNIFuncs{Add: add, Check: cmdCheck, Del: cmdDel}, info, pluginName)
+	if cniErr == nil || cniErr.Code != types.ErrIncompatibleCNIVersion {
+		t.Fatalf("error = %v, want code %d", cniErr, types.ErrIncompatibleCNIVersion)
+	}
+	if called {
+		t.Error("cmdAdd ran for an unadvertised CNI version")
+	}
+}
+
+func TestCmdAddRouterRoutesCleanup(t *testing.T) {
+	oldDelay := delegateAddRetryDelay
+	delegateAddRetryDelay = 0
+	t.Cleanup(func() { delegateAddRetryDelay = oldDelay })
+
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotAdd api.AddRequest
+	var gotDel api.DelRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotAdd)
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotDel)
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath, _ := setupFlakyDelegatePlugin(t, 5)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["delegate_add_attempts"] = 1
+	conf["router_id"] = "router-uuid"
+	conf["router_route_destinations"] = []string{"192.168.100.0/24"}
+	stdinData, _ := json.Marshal(conf)
+
+	err = cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-router",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if gotAdd.RouterID != "router-uuid" || !reflect.DeepEqual(gotAdd.RouterRouteDestinations, []string{"192.168.100.0/24"}) {
+		t.Errorf("forwarded router_id/destinations = %q/%v, want router-uuid/[192.168.100.0/24]", gotAdd.RouterID, gotAdd.RouterRouteDestinations)
+	}
+	if gotDel.RouterID != "router-uuid" {
+		t.Errorf("cleanup router_id = %q, want router-uuid", gotDel.RouterID)
+	}
+}
+
+func TestCmdPortNaming(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	got := map[string]string{}
+	record := func(path string, r *http.Request) {
+		var body struct {
+			PortNaming string `json:"port_naming"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		got[path] = body.PortNaming
+		mu.Unlock()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		record("/add", r)
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		record("/del", r)
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		record("/check", r)
+		_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath := setupFakeDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	stdin := func(naming string) []byte {
+		var conf map[string]interface{}
+		_ = json.Unmarshal(makeStdinData(sock), &conf)
+		conf["port_naming"] = naming
+		data, _ := json.Marshal(conf)
+		return data
+	}
+	args := &skel.CmdArgs{
+		ContainerID: "ctr-naming",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdin("hashed"),
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+	err = cmdAdd(args)
+	_ = w.Close()
+	os.Stdout = oldStdout
+	if err != nil {
+		t.Fatalf("cmdAdd: %v", err)
+	}
+	if err := cmdCheck(args); err != nil {
+		t.Fatalf("cmdCheck: %v", err)
+	}
+	if err := cmdDel(args); err != nil {
+		t.Fatalf("cmdDel: %v", err)
+	}
+	want := map[string]string{"/add": "hashed", "/check": "hashed", "/del": "hashed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("forwarded port_naming = %v, want %v", got, want)
+	}
+
+	args.StdinData = stdin("bogus")
+	if err := cmdAdd(args); err == nil || !strings.Contains(err.Error(), "port_naming") {
+		t.Errorf("cmdAdd with unknown port_naming error = %v, want it rejected", err)
+	}
+}
+
+// TestCmdShortContainerID checks that container IDs shorter than a docker
+// short ID are forwarded unchanged; only the daemon's namer truncates them.
+func TestCmdShortContainerID(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	got := map[string]string{}
+	record := func(path string, r *http.Request) {
+		var body struct {
+			ContainerID string `json:"container_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		got[path] = body.ContainerID
+		mu.Unlock()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		record("/add", r)
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		record("/del", r)
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		record("/check", r)
+		_ = json.NewEncoder(w).Encode(api.CheckResponse{Exists: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	cniPath := setupFakeDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	for _, id := range []string{"a", "ctr-short"} {
+		t.Run(id, func(t *testing.T) {
+			args := &skel.CmdArgs{
+				ContainerID: id,
+				Netns:       "/proc/1/ns/net",
+				IfName:      "eth0",
+				StdinData:   makeStdinData(sock),
+			}
+			oldStdout := os.Stdout
+			r, w, _ := os.Pipe()
+			defer r.Close()
+			os.Stdout = w
+			err := cmdAdd(args)
+			_ = w.Close()
+			os.Stdout = oldStdout
+			if err != nil {
+				t.Fatalf("cmdAdd: %v", err)
+			}
+			if err := cmdCheck(args); err != nil {
+				t.Fatalf("cmdCheck: %v", err)
+			}
+			if err := cmdDel(args); err != nil {
+				t.Fatalf("cmdDel: %v", err)
+			}
+			want := map[string]string{"/add": id, "/check": id, "/del": id}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("forwarded container_id = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDelegatePassthrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "absent", raw: "", wantNil: true},
+		{name: "null", raw: "null", wantNil: true},
+		{name: "object", raw: `{"runtimeConfig":{"sysctls":{"net.ipv4.conf.eth0.rp_filter":"0"}}}`},
+		{name: "array", raw: `["x"]`, wantErr: true},
+		{name: "string", raw: `"x"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &PluginConf{DelegatePassthrough: json.RawMessage(tt.raw)}
+			got, err := conf.delegatePassthrough()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("delegatePassthrough() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && (got == nil) != tt.wantNil {
+				t.Errorf("delegatePassthrough() = %v, wantNil %t", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestMergePassthroughRejectsGeneratedKeys(t *testing.T) {
+	confMap := map[string]interface{}{"ipam": map[string]interface{}{"type": "static"}}
+	if err := mergePassthrough(confMap, map[string]interface{}{"ipam": nil}); err == nil {
+		t.Error("expected error overriding ipam")
+	}
+	if err := mergePassthrough(confMap, map[string]interface{}{"sysctls": map[string]interface{}{}}); err != nil {
+		t.Errorf("mergePassthrough() error = %v", err)
+	}
+	if _, ok := confMap["sysctls"]; !ok {
+		t.Error("sysctls not merged")
+	}
+}
+
+func TestCmdAddDelegatePassthrough(t *testing.T) {
+	sock := setupMockDaemon(t)
+	cniPath, captured := setupCapturingDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["delegate_passthrough"] = map[string]interface{}{
+		"runtimeConfig": map[string]interface{}{
+			"sysctls": map[string]interface{}{"net.ipv6.conf.eth0.accept_ra": "0"},
+		},
+		"interface_flags": []interface{}{"promisc"},
+	}
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-passthrough",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delegated struct {
+		RuntimeConfig struct {
+			Sysctls map[string]string `json:"sysctls"`
+		} `json:"runtimeConfig"`
+		InterfaceFlags []string `json:"interface_flags"`
+		IPAM           struct {
+			Type string `json:"type"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(data, &delegated); err != nil {
+		t.Fatalf("failed to decode delegated config: %v", err)
+	}
+	if got := delegated.RuntimeConfig.Sysctls["net.ipv6.conf.eth0.accept_ra"]; got != "0" {
+		t.Errorf("delegated sysctl = %q, want 0", got)
+	}
+	if !reflect.DeepEqual(delegated.InterfaceFlags, []string{"promisc"}) {
+		t.Errorf("delegated interface_flags = %v, want [promisc]", delegated.InterfaceFlags)
+	}
+	if delegated.IPAM.Type != "static" {
+		t.Errorf("delegated ipam type = %q, want static", delegated.IPAM.Type)
+	}
+}
+
+func TestCmdAddDelegatePassthroughNotObject(t *testing.T) {
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "unused.sock")), &conf)
+	conf["delegate_passthrough"] = []interface{}{"sysctls"}
+	stdinData, _ := json.Marshal(conf)
+
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-passthrough", StdinData: stdinData})
+	if err == nil || !strings.Contains(err.Error(), "delegate_passthrough must be a JSON object") {
+		t.Errorf("cmdAdd error = %v, want delegate_passthrough object error", err)
+	}
+}
+
+func TestCmdAddInvalidPortSpec(t *testing.T) {
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(filepath.Join(t.TempDir(), "unused.sock")), &conf)
+	conf["bandwidth"] = map[string]interface{}{"max_burst_kbps": 100}
+	stdinData, _ := json.Marshal(conf)
+
+	err := cmdAdd(&skel.CmdArgs{ContainerID: "ctr-spec", StdinData: stdinData})
+	if err == nil || !strings.Contains(err.Error(), "bandwidth needs min_kbps or max_kbps") {
+		t.Errorf("cmdAdd error = %v, want a bandwidth error before contacting the daemon", err)
+	}
+}
+
+func TestCmdAddObservesDelegate(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observed := make(chan api.ObserveRequest, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.5",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/observe", func(w http.ResponseWriter, r *http.Request) {
+		var req api.ObserveRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		observed <- req
+		_ = json.NewEncoder(w).Encode(api.ObserveResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err = cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-observe",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData(sock),
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	select {
+	case req := <-observed:
+		if req.Phase != api.PhaseDelegate || req.Seconds <= 0 {
+			t.Errorf("observation = %+v, want a positive delegate duration", req)
+		}
+	default:
+		t.Fatal("cmdAdd did not report the delegate duration")
+	}
+}
+
+func TestCmdAddReportPortID(t *testing.T) {
+	sock := setupMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["report_port_id"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-report",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatalf("failed to decode result %q: %v", out, err)
+	}
+	if result[api.ResultPortIDKey] != "port-123" {
+		t.Errorf("result[%s] = %v, want port-123", api.ResultPortIDKey, result[api.ResultPortIDKey])
+	}
+	if _, ok := result["ips"]; !ok {
+		t.Errorf("result lost the delegate's ips: %s", out)
+	}
+}
+
+func TestCmdAddPrintsDaemonWarnings(t *testing.T) {
+	d := newMockDaemon(t)
+	d.respond("/add", http.StatusOK, api.AddResponse{
+		PortID:       "port-123",
+		MACAddress:   "fa:16:3e:aa:bb:cc",
+		IPAddress:    "10.0.0.5",
+		PrefixLength: "24",
+		GatewayIP:    "10.0.0.1",
+		Warnings:     []string{"network net-uuid reports no MTU, the net-mtu extension may be unavailable; leaving the MTU to the delegate"},
+	})
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	stdoutR, stdoutW, _ := os.Pipe()
+	defer stdoutR.Close()
+	stderrR, stderrW, _ := os.Pipe()
+	os.Stdout, os.Stderr = stdoutW, stderrW
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-warnings",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData(d.sock),
+	})
+
+	_ = stdoutW.Close()
+	_ = stderrW.Close()
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+	stderr, err := io.ReadAll(stderrR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "warning: daemon: network net-uuid reports no MTU"; !strings.Contains(string(stderr), want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+}
+
+func TestPrintResultWithPortIDKeepsResult(t *testing.T) {
+	result, err := version.NewResult("0.4.0", []byte(`{"cniVersion":"0.4.0","interfaces":[{"name":"eth0"}],"ips":[{"version":"4","address":"10.0.0.5/24"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := printResultWithPortID(&out, result, "port-123"); err != nil {
+		t.Fatalf("printResultWithPortID() error = %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["cniVersion"] != "0.4.0" || got[api.ResultPortIDKey] != "port-123" {
+		t.Errorf("result = %v, want cniVersion 0.4.0 and the port ID", got)
+	}
+}
+
+func TestCheckResultIP(t *testing.T) {
+	result, err := version.NewResult("0.4.0", []byte(`{"cniVersion":"0.4.0","ips":[{"version":"4","address":"10.0.0.5/24"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkResultIP(result, "10.0.0.5"); err != nil {
+		t.Errorf("checkResultIP(matching) error = %v", err)
+	}
+	if err := checkResultIP(result, "10.0.0.6"); err == nil {
+		t.Error("checkResultIP(mismatching) expected error, got nil")
+	}
+}
+
+func TestCmdAddStrictIPCheckMismatch(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := make(chan api.DelRequest, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		// The fake delegate configures 10.0.0.5.
+		_ = json.NewEncoder(w).Encode(api.AddResponse{
+			PortID:       "port-123",
+			MACAddress:   "fa:16:3e:aa:bb:cc",
+			IPAddress:    "10.0.0.9",
+			PrefixLength: "24",
+			GatewayIP:    "10.0.0.1",
+		})
+	})
+	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		var req api.DelRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		deleted <- req
+		_ = json.NewEncoder(w).Encode(api.DelResponse{OK: true})
+	})
+	srv := &http.Server{Handler: mux}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["strict_ip_check"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	err = cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-strict-ip",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.9") {
+		t.Fatalf("cmdAdd error = %v, want an IP mismatch error", err)
+	}
+	select {
+	case req := <-deleted:
+		if req.ContainerID != "ctr-strict-ip" {
+			t.Errorf("cleanup container_id = %q, want ctr-strict-ip", req.ContainerID)
+		}
+	default:
+		t.Error("expected the neutron port to be cleaned up")
+	}
+}
+
+func TestCmdAddStrictIPCheckMatch(t *testing.T) {
+	sock := setupMockDaemon(t)
+	t.Setenv("CNI_PATH", setupFakeDelegatePlugin(t))
+
+	var conf map[string]interface{}
+	_ = json.Unmarshal(makeStdinData(sock), &conf)
+	conf["strict_ip_check"] = true
+	stdinData, _ := json.Marshal(conf)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	os.Stdout = w
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-strict-ip",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   stdinData,
+	})
+
+	_ = w.Close()
+	os.Stdout = oldStdout
+
+	if err != nil {
+		t.Fatalf("cmdAdd returned error: %v", err)
+	}
+}
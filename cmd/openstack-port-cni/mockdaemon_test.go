@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"openstack-port/internal/api"
+)
+
+// mockDaemon is a daemon serving HTTP on a unix socket for CNI tests. Each
+// path answers with the status and JSON body programmed with respond, and
+// every request is recorded for received to return. It is safe for
+// concurrent use.
+type mockDaemon struct {
+	sock string
+
+	mu        sync.Mutex
+	responses map[string]mockResponse
+	requests  []mockRequest
+}
+
+type mockResponse struct {
+	status int
+	body   interface{}
+}
+
+// mockRequest is a request received by a mockDaemon.
+type mockRequest struct {
+	Path string
+	Body []byte
+}
+
+// newMockDaemon starts a mockDaemon answering /add with a port on
+// 10.0.0.5/24, /del, /check, /up and /report with success and /observe
+// with an empty body, stopped when the test ends.
+func newMockDaemon(t *testing.T) *mockDaemon {
+	t.Helper()
+	d := &mockDaemon{
+		sock:      filepath.Join(t.TempDir(), "test.sock"),
+		responses: make(map[string]mockResponse),
+	}
+	d.respond("/add", http.StatusOK, api.AddResponse{
+		PortID:       "port-123",
+		MACAddress:   "fa:16:3e:aa:bb:cc",
+		IPAddress:    "10.0.0.5",
+		PrefixLength: "24",
+		GatewayIP:    "10.0.0.1",
+	})
+	d.respond("/del", http.StatusOK, api.DelResponse{OK: true})
+	d.respond("/check", http.StatusOK, api.CheckResponse{Exists: true})
+	d.respond("/up", http.StatusOK, api.UpResponse{OK: true})
+	d.respond("/observe", http.StatusOK, struct{}{})
+	d.respond("/report", http.StatusOK, api.ReportResponse{OK: true})
+
+	listener, err := net.Listen("unix", d.sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(d.serveHTTP)}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return d
+}
+
+// respond makes the daemon answer requests to path with status and body
+// encoded as JSON. An error status should come with an api.ErrorResponse.
+func (d *mockDaemon) respond(path string, status int, body interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.responses[path] = mockResponse{status: status, body: body}
+}
+
+// received returns the requests made to path so far, in order.
+func (d *mockDaemon) received(path string) []mockRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var requests []mockRequest
+	for _, r := range d.requests {
+		if r.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// decodeLast decodes the body of the last request made to path into v,
+// failing the test when there is none.
+func (d *mockDaemon) decodeLast(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	requests := d.received(path)
+	if len(requests) == 0 {
+		t.Fatalf("daemon received no %s request", path)
+	}
+	if err := json.Unmarshal(requests[len(requests)-1].Body, v); err != nil {
+		t.Fatalf("decoding %s request: %v", path, err)
+	}
+}
+
+func (d *mockDaemon) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	d.mu.Lock()
+	d.requests = append(d.requests, mockRequest{Path: r.URL.Path, Body: body})
+	resp, ok := d.responses[r.URL.Path]
+	d.mu.Unlock()
+	if !ok {
+		resp = mockResponse{status: http.StatusNotFound, body: api.ErrorResponse{Error: "unknown path " + r.URL.Path}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	_ = json.NewEncoder(w).Encode(resp.body)
+}
+
+func TestMockDaemonErrorResponse(t *testing.T) {
+	cniPath := setupFakeDelegatePlugin(t)
+	t.Setenv("CNI_PATH", cniPath)
+	d := newMockDaemon(t)
+	d.respond("/add", http.StatusInternalServerError, api.ErrorResponse{Error: "failed to create port: quota exceeded"})
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "ctr-error",
+		Netns:       "/proc/1/ns/net",
+		IfName:      "eth0",
+		StdinData:   makeStdinData(d.sock),
+	})
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("cmdAdd error = %v, want the daemon's error", err)
+	}
+	var req api.AddRequest
+	d.decodeLast(t, "/add", &req)
+	if req.ContainerID != "ctr-error" || req.NetworkID != "net-uuid" {
+		t.Errorf("add request = %+v, want container ctr-error on net-uuid", req)
+	}
+	if n := len(d.received("/del")); n != 0 {
+		t.Errorf("daemon received %d /del request(s) after a failed /add, want none", n)
+	}
+}
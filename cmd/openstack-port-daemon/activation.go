@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation,
+// as in sd_listen_fds(3).
+const listenFDsStart = 3
+
+// activatedListeners returns the Unix socket listeners passed to the daemon
+// with the socket activation protocol (LISTEN_FDS, and LISTEN_PID when set),
+// by systemd or by a predecessor handing over its sockets, keyed by socket
+// path. Their file descriptors start at start. The variables are unset so
+// that they are not passed on. No LISTEN_FDS, or a LISTEN_PID naming
+// another process, returns no listeners.
+func activatedListeners(start int) (map[string]*net.UnixListener, error) {
+	fds, pid := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID")
+	for _, name := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS=%q (want a positive integer)", fds)
+	}
+	listeners := make(map[string]*net.UnixListener, n)
+	for fd := start; fd < start+n; fd++ {
+		l, err := fdListener(fd)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners[l.Addr().String()] = l
+	}
+	return listeners, nil
+}
+
+// fdListener returns the Unix socket listener of the inherited fd, which it
+// takes over.
+func fdListener(fd int) (*net.UnixListener, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited fd %d is not a listening socket: %v", fd, err)
+	}
+	unixListener, ok := l.(*net.UnixListener)
+	if !ok {
+		_ = l.Close()
+		return nil, fmt.Errorf("inherited fd %d is not a Unix socket", fd)
+	}
+	return unixListener, nil
+}
+
+// adoptOrListenSocket serves the activated listener of path when there is
+// one, removing it from activated, or else listens on path as listenSocket
+// does. It reports whether the listener was adopted. An adopted socket keeps
+// the owner and mode its creator gave it.
+func adoptOrListenSocket(path string, cfg daemonConfig, activated map[string]*net.UnixListener) (*peerCredListener, bool, error) {
+	if l, ok := activated[path]; ok {
+		delete(activated, path)
+		return guardListener(l, cfg), true, nil
+	}
+	l, err := listenSocket(path, cfg)
+	return l, false, err
+}
+
+// closeUnusedListeners closes the activated listeners left after the
+// daemon adopted its own, those of sockets it does not serve.
+func closeUnusedListeners(activated map[string]*net.UnixListener) {
+	for path, l := range activated {
+		log.Printf("WARNING closing inherited socket %s, which the daemon does not serve", path)
+		_ = l.Close()
+	}
+}
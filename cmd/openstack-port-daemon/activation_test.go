@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// activationFDsStart is where tests place the fds they pass as activated,
+// far above those the test process has open.
+const activationFDsStart = 200
+
+// passListeners opens a listening Unix socket at each of paths and places
+// them, in order, at consecutive fds from activationFDsStart, as socket
+// activation passes them.
+func passListeners(t *testing.T, paths ...string) {
+	t.Helper()
+	for i, path := range paths {
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := l.File()
+		// The passed fd keeps listening on the socket file.
+		l.SetUnlinkOnClose(false)
+		_ = l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Dup3(int(f.Fd()), activationFDsStart+i, unix.O_CLOEXEC); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+	}
+}
+
+func TestActivatedListeners(t *testing.T) {
+	dir := t.TempDir()
+	httpPath, grpcPath := filepath.Join(dir, "cni.sock"), filepath.Join(dir, "cni-grpc.sock")
+	passListeners(t, httpPath, grpcPath)
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDNAMES", "cni:cni-grpc")
+
+	activated, err := activatedListeners(activationFDsStart)
+	if err != nil {
+		t.Fatalf("activatedListeners() error = %v", err)
+	}
+	if len(activated) != 2 || activated[httpPath] == nil || activated[grpcPath] == nil {
+		t.Fatalf("activatedListeners() = %v, want listeners on %s and %s", activated, httpPath, grpcPath)
+	}
+	for _, name := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s=%q left set", name, v)
+		}
+	}
+
+	cfg := defaultDaemonConfig()
+	listener, adopted, err := adoptOrListenSocket(httpPath, cfg, activated)
+	if err != nil || !adopted {
+		t.Fatalf("adoptOrListenSocket() = %v, %v, want the inherited listener", adopted, err)
+	}
+	defer listener.Close()
+	if _, ok := activated[httpPath]; ok {
+		t.Error("adopted listener left among the activated ones")
+	}
+	go func() {
+		if conn, err := listener.UnixListener.Accept(); err == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", httpPath)
+	if err != nil {
+		t.Fatalf("dialing the adopted socket: %v", err)
+	}
+	_ = conn.Close()
+
+	closeUnusedListeners(activated)
+	if _, err := net.Dial("unix", grpcPath); err == nil {
+		t.Error("unused inherited socket still accepts connections")
+	}
+}
+
+func TestActivatedListenersNone(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	if activated, err := activatedListeners(activationFDsStart); err != nil || activated != nil {
+		t.Errorf("activatedListeners() = %v, %v without LISTEN_FDS, want none", activated, err)
+	}
+
+	// Variables meant for another process, e.g. the daemon's parent, are
+	// ignored.
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	if activated, err := activatedListeners(activationFDsStart); err != nil || activated != nil {
+		t.Errorf("activatedListeners() = %v, %v for another LISTEN_PID, want none", activated, err)
+	}
+}
+
+func TestActivatedListenersInvalid(t *testing.T) {
+	for _, v := range []string{"0", "two"} {
+		t.Setenv("LISTEN_FDS", v)
+		if _, err := activatedListeners(activationFDsStart); err == nil {
+			t.Errorf("activatedListeners() accepted LISTEN_FDS=%q", v)
+		}
+	}
+
+	// An inherited fd that is not a Unix socket listener is refused.
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unix.Dup3(int(f.Fd()), activationFDsStart, unix.O_CLOEXEC)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	if _, err := activatedListeners(activationFDsStart); err == nil {
+		t.Error("activatedListeners() accepted a file that is not a socket")
+	}
+}
+
+func TestAdoptOrListenSocketListens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cni.sock")
+	cfg := defaultDaemonConfig()
+	listener, adopted, err := adoptOrListenSocket(path, cfg, nil)
+	if err != nil || adopted {
+		t.Fatalf("adoptOrListenSocket() = %v, %v, want a new listener", adopted, err)
+	}
+	defer listener.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("socket not created: %v", err)
+	}
+}
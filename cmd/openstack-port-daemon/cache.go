@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// lookupCache is a bounded cache of Neutron lookup results, such as subnets
+// read on every ADD. Entries expire after ttl, and beyond size entries the
+// least recently used one is evicted. Hits and misses are counted for
+// /metrics. A nil *lookupCache caches nothing.
+type lookupCache struct {
+	name string
+	size int
+	ttl  time.Duration
+	// now returns the current time; nil uses time.Now.
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List // of *cacheEntry, most recently used first
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// newLookupCache returns a cache labelled name in metrics, or nil when size
+// is not positive.
+func newLookupCache(name string, size int, ttl time.Duration) *lookupCache {
+	if size <= 0 {
+		return nil
+	}
+	return &lookupCache{
+		name:    name,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// lookupKey is the cache key of the lookup of id through client. It holds
+// the Neutron endpoint client calls, so that requests overriding the
+// endpoint neither read nor fill the entries of another Neutron.
+func lookupKey(client *gophercloud.ServiceClient, id string) string {
+	return client.ResourceBaseURL() + " " + id
+}
+
+func (c *lookupCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// get returns the unexpired value cached for key.
+func (c *lookupCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if ok && c.clock().Before(elem.Value.(*cacheEntry).expiresAt) {
+		c.order.MoveToFront(elem)
+		c.hits++
+		return elem.Value.(*cacheEntry).value, true
+	}
+	if ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+	c.misses++
+	return nil, false
+}
+
+// put caches value for key, evicting the least recently used entry when the
+// cache is full.
+func (c *lookupCache) put(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := c.clock().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+// writeCachePrometheus writes the counters of the non-nil caches in the
+// Prometheus text format.
+func writeCachePrometheus(w io.Writer, caches ...*lookupCache) {
+	var live []*lookupCache
+	for _, c := range caches {
+		if c != nil {
+			live = append(live, c)
+		}
+	}
+	if len(live) == 0 {
+		return
+	}
+	metrics := []struct {
+		name, kind, help string
+		value            func(c *lookupCache) uint64
+	}{
+		{"openstack_cni_lookup_cache_hits_total", "counter", "Neutron lookups answered from the cache.", func(c *lookupCache) uint64 { return c.hits }},
+		{"openstack_cni_lookup_cache_misses_total", "counter", "Neutron lookups not found in the cache.", func(c *lookupCache) uint64 { return c.misses }},
+		{"openstack_cni_lookup_cache_evictions_total", "counter", "Cache entries evicted to stay within the size.", func(c *lookupCache) uint64 { return c.evictions }},
+		{"openstack_cni_lookup_cache_entries", "gauge", "Entries currently cached.", func(c *lookupCache) uint64 { return uint64(c.order.Len()) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for _, c := range live {
+			c.mu.Lock()
+			fmt.Fprintf(w, "%s{cache=%q} %d\n", m.name, c.name, m.value(c))
+			c.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func TestLookupCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLookupCache("subnet", 2, time.Minute)
+	c.put("a", 1)
+	c.put("b", 2)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a missing before eviction")
+	}
+	c.put("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b still cached, want it evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s evicted, want it cached", key)
+		}
+	}
+	if c.evictions != 1 {
+		t.Errorf("evictions = %d, want 1", c.evictions)
+	}
+}
+
+func TestLookupCacheCountsHitsAndMisses(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newLookupCache("subnet", 8, time.Minute)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.get("a"); ok {
+		t.Fatal("empty cache hit")
+	}
+	c.put("a", 1)
+	if v, ok := c.get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("get(a) = %v, %t, want 1, true", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry hit")
+	}
+	if c.hits != 1 || c.misses != 2 {
+		t.Errorf("hits, misses = %d, %d, want 1, 2", c.hits, c.misses)
+	}
+}
+
+func TestLookupCacheDisabled(t *testing.T) {
+	c := newLookupCache("subnet", 0, time.Minute)
+	if c != nil {
+		t.Fatal("newLookupCache with size 0 returned a cache")
+	}
+	c.put("a", 1)
+	if _, ok := c.get("a"); ok {
+		t.Error("nil cache hit")
+	}
+}
+
+func TestWriteCachePrometheus(t *testing.T) {
+	c := newLookupCache("subnet", 8, time.Minute)
+	c.get("a")
+	c.put("a", 1)
+	c.get("a")
+
+	var buf bytes.Buffer
+	writeCachePrometheus(&buf, c, nil)
+	out := buf.String()
+	for _, want := range []string{
+		`openstack_cni_lookup_cache_hits_total{cache="subnet"} 1`,
+		`openstack_cni_lookup_cache_misses_total{cache="subnet"} 1`,
+		`openstack_cni_lookup_cache_entries{cache="subnet"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAddUsesSubnetCache(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+	}))
+	subnetGets := 0
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		subnetGets++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	cfg := defaultDaemonConfig()
+	cfg.LookupCacheSize = 8
+	handler := newHandler(thclient.ServiceClient(), cfg)
+	for _, containerID := range []string{"abcdef1234567890", "0123456789abcdef"} {
+		body := bytes.NewBufferString(`{"container_id":"` + containerID + `","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400}`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+	if subnetGets != 1 {
+		t.Errorf("subnet GETs = %d, want 1", subnetGets)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := `openstack_cni_lookup_cache_hits_total{cache="subnet"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, rec.Body.String())
+	}
+}
+
+func TestAddSubnetCachePerEndpoint(t *testing.T) {
+	serve := func(mux *http.ServeMux, prefix string, subnetGets *int) {
+		mux.HandleFunc(prefix+"/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+		}))
+		mux.HandleFunc(prefix+"/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			*subnetGets++
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+	}
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	catalogGets, canaryGets := 0, 0
+	serve(th.Mux, "", &catalogGets)
+	canary := http.NewServeMux()
+	serve(canary, "/v2.0", &canaryGets)
+	server := httptest.NewServer(canary)
+	defer server.Close()
+
+	cfg := defaultDaemonConfig()
+	cfg.LookupCacheSize = 8
+	cfg.AllowEndpointOverride = true
+	handler := newHandler(thclient.ServiceClient(), cfg)
+	for _, body := range []string{
+		`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400}`,
+		`{"container_id":"0123456789abcdef","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400,"endpoint_override":"` + server.URL + `"}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+	if catalogGets != 1 || canaryGets != 1 {
+		t.Errorf("subnet GETs = %d on the catalog endpoint, %d on the override, want 1 each", catalogGets, canaryGets)
+	}
+}
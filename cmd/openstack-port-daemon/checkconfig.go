@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gophercloud/gophercloud"
+
+	"openstack-port/internal/api"
+)
+
+// checkedConfig is the part of a CNI network config that --check-config
+// looks at. Plugins is set for a conflist.
+type checkedConfig struct {
+	api.ValidateRequest
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	DelegatePlugin string            `json:"delegate_plugin"`
+	Plugins        []json.RawMessage `json:"plugins"`
+}
+
+// checkConfigFile validates the CNI network config at path against Neutron
+// like POST /validate, and checks that it names a delegate plugin. A
+// conflist has each plugin carrying a network_id checked. It writes a
+// PASS or FAIL report to out and reports whether every check passed; only
+// unreadable files and unexpected Neutron failures are returned as an
+// error.
+func checkConfigFile(neutronClient *gophercloud.ServiceClient, path string, out io.Writer) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config: %w", err)
+	}
+	var conf checkedConfig
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return false, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	confs := []checkedConfig{conf}
+	if conf.Plugins != nil {
+		confs = nil
+		for i, raw := range conf.Plugins {
+			var plugin checkedConfig
+			if err := json.Unmarshal(raw, &plugin); err != nil {
+				return false, fmt.Errorf("failed to parse plugin %d of %s: %w", i, path, err)
+			}
+			if plugin.NetworkID != "" {
+				plugin.Name = conf.Name
+				confs = append(confs, plugin)
+			}
+		}
+		if len(confs) == 0 {
+			fmt.Fprintf(out, "FAIL %s: no plugin sets network_id\n", path)
+			return false, nil
+		}
+	}
+
+	ok := true
+	for _, c := range confs {
+		errs, err := checkConfig(neutronClient, c)
+		if err != nil {
+			return false, err
+		}
+		if len(errs) > 0 {
+			ok = false
+			fmt.Fprintf(out, "FAIL %s: network %q type %s\n", path, c.Name, c.Type)
+			for _, e := range errs {
+				fmt.Fprintf(out, "  - %s\n", e)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "PASS %s: network %q type %s network_id=%s subnet_id=%s delegate_plugin=%s\n", path, c.Name, c.Type, c.NetworkID, c.SubnetID, c.DelegatePlugin)
+	}
+	return ok, nil
+}
+
+// checkConfig returns the reasons conf would fail an ADD.
+func checkConfig(neutronClient *gophercloud.ServiceClient, conf checkedConfig) ([]string, error) {
+	var errs []string
+	if conf.DelegatePlugin == "" {
+		errs = append(errs, "delegate_plugin is required")
+	}
+	if conf.NetworkID == "" || (conf.SubnetID == "" && conf.SegmentID == "") {
+		return append(errs, "network_id and subnet_id (or segment_id) are required"), nil
+	}
+	resp, err := validateConfig(neutronClient, conf.ValidateRequest)
+	if err != nil {
+		return nil, err
+	}
+	return append(errs, resp.Errors...), nil
+}
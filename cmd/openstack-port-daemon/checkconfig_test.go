@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nad.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func handleCheckConfigSubnet(t *testing.T) {
+	t.Helper()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "cidr": "10.0.0.0/24"}}`))
+	})
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+		handleValidateNetwork(t)
+		handleCheckConfigSubnet(t)
+
+		path := writeConfigFile(t, `{
+			"cniVersion": "0.4.0",
+			"name": "tenant",
+			"type": "openstack-port-cni",
+			"network_id": "net-uuid",
+			"subnet_id": "subnet-uuid",
+			"delegate_plugin": "ovs"
+		}`)
+		var out bytes.Buffer
+		ok, err := checkConfigFile(thclient.ServiceClient(), path, &out)
+		if err != nil {
+			t.Fatalf("checkConfigFile() error = %v", err)
+		}
+		if !ok || !strings.HasPrefix(out.String(), "PASS ") {
+			t.Errorf("checkConfigFile() = %t, report:\n%s\nwant a pass", ok, out.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+		handleValidateNetwork(t)
+		th.Mux.HandleFunc("/subnets/missing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		path := writeConfigFile(t, `{
+			"cniVersion": "0.4.0",
+			"name": "tenant",
+			"type": "openstack-port-cni",
+			"network_id": "net-uuid",
+			"subnet_id": "missing"
+		}`)
+		var out bytes.Buffer
+		ok, err := checkConfigFile(thclient.ServiceClient(), path, &out)
+		if err != nil {
+			t.Fatalf("checkConfigFile() error = %v", err)
+		}
+		report := out.String()
+		if ok || !strings.HasPrefix(report, "FAIL ") {
+			t.Errorf("checkConfigFile() = %t, report:\n%s\nwant a failure", ok, report)
+		}
+		for _, want := range []string{"delegate_plugin is required", "subnet missing not found"} {
+			if !strings.Contains(report, want) {
+				t.Errorf("report does not mention %q:\n%s", want, report)
+			}
+		}
+	})
+
+	t.Run("Conflist", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+		handleValidateNetwork(t)
+		handleCheckConfigSubnet(t)
+
+		path := writeConfigFile(t, `{
+			"cniVersion": "0.4.0",
+			"name": "tenant",
+			"plugins": [
+				{"type": "openstack-port-cni", "network_id": "net-uuid", "subnet_id": "subnet-uuid", "delegate_plugin": "ovs"},
+				{"type": "tuning"}
+			]
+		}`)
+		var out bytes.Buffer
+		ok, err := checkConfigFile(thclient.ServiceClient(), path, &out)
+		if err != nil {
+			t.Fatalf("checkConfigFile() error = %v", err)
+		}
+		if !ok || strings.Count(out.String(), "PASS ") != 1 {
+			t.Errorf("checkConfigFile() = %t, report:\n%s\nwant one pass", ok, out.String())
+		}
+	})
+
+	t.Run("Unparsable", func(t *testing.T) {
+		path := writeConfigFile(t, `{"network_id": `)
+		if _, err := checkConfigFile(nil, path, &bytes.Buffer{}); err == nil {
+			t.Error("checkConfigFile() error = nil, want a parse error")
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+
+	"openstack-port/internal/api"
+)
+
+var (
+	// errInvalidCleanupToken is returned for tokens this daemon did not
+	// issue, that were tampered with or that expired.
+	errInvalidCleanupToken = errors.New("invalid cleanup token")
+	// errCleanupTokenRedeemed is returned for a token already used.
+	errCleanupTokenRedeemed = errors.New("cleanup token already redeemed")
+	// errCleanupTokenRevoked is returned for a token issued before a DEL of
+	// its container released the port.
+	errCleanupTokenRevoked = errors.New("cleanup token revoked by a DEL of its container")
+)
+
+// cleanupClaims is what a cleanup token authorizes: deleting PortID on
+// NetworkID while it is still the port of ContainerID, named Name with
+// DeviceID. QoSPolicy records that the ADD created a bandwidth QoS policy for
+// it. Nonce makes every token distinct.
+type cleanupClaims struct {
+	PortID      string `json:"port_id"`
+	NetworkID   string `json:"network_id"`
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name"`
+	DeviceID    string `json:"device_id,omitempty"`
+	QoSPolicy   bool   `json:"qos_policy,omitempty"`
+	Nonce       string `json:"nonce"`
+	// IssuedAt and ExpiresAt are Unix times in nanoseconds.
+	IssuedAt  int64 `json:"issued_at"`
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// cleanupTokens issues and redeems cleanup tokens. Tokens are the claims
+// signed with a key generated at startup, so tokens issued before a restart
+// are rejected, and expire after ttl. Only redeemed tokens and revoked ports
+// are stored, each for ttl, past which the tokens they concern have expired.
+type cleanupTokens struct {
+	key []byte
+	ttl time.Duration
+
+	mu sync.Mutex
+	// redeemed holds the expiry of each redeemed token by nonce.
+	redeemed map[string]time.Time
+	// revoked holds, by port ID, when a DEL last released the port.
+	revoked   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newCleanupTokens(ttl time.Duration) *cleanupTokens {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &cleanupTokens{key: key, ttl: ttl, redeemed: make(map[string]time.Time), revoked: make(map[string]time.Time)}
+}
+
+func (c *cleanupTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// issue returns a token authorizing the deletion of the port claims names,
+// filling in its nonce and validity.
+func (c *cleanupTokens) issue(claims cleanupClaims) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	now := time.Now()
+	claims.Nonce = hex.EncodeToString(nonce)
+	claims.IssuedAt, claims.ExpiresAt = now.UnixNano(), now.Add(c.ttl).UnixNano()
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload))
+}
+
+// verify returns the claims of token when this daemon issued it and it has
+// not expired. It does not redeem it.
+func (c *cleanupTokens) verify(token string) (cleanupClaims, error) {
+	enc := base64.RawURLEncoding
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return cleanupClaims{}, errInvalidCleanupToken
+	}
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil {
+		return cleanupClaims{}, errInvalidCleanupToken
+	}
+	sig, err := enc.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return cleanupClaims{}, errInvalidCleanupToken
+	}
+	var claims cleanupClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.PortID == "" || claims.Nonce == "" {
+		return cleanupClaims{}, errInvalidCleanupToken
+	}
+	if !time.Now().Before(time.Unix(0, claims.ExpiresAt)) {
+		return cleanupClaims{}, errInvalidCleanupToken
+	}
+	return claims, nil
+}
+
+// redeem marks the verified claims used, unless they already were or a DEL
+// revoked them. A caller that fails to delete the port should release the
+// claims so the token can be presented again.
+func (c *cleanupTokens) redeem(claims cleanupClaims) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	if _, ok := c.redeemed[claims.Nonce]; ok {
+		return errCleanupTokenRedeemed
+	}
+	if at, ok := c.revoked[claims.PortID]; ok && claims.IssuedAt <= at.UnixNano() {
+		return errCleanupTokenRevoked
+	}
+	c.redeemed[claims.Nonce] = time.Unix(0, claims.ExpiresAt)
+	return nil
+}
+
+// release makes a redeemed token usable again.
+func (c *cleanupTokens) release(claims cleanupClaims) {
+	c.mu.Lock()
+	delete(c.redeemed, claims.Nonce)
+	c.mu.Unlock()
+}
+
+// revoke invalidates the tokens issued so far for portID, which a DEL of its
+// container released.
+func (c *cleanupTokens) revoke(portID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	c.revoked[portID] = time.Now()
+}
+
+// prune drops, at most once a minute, the entries of tokens that expired.
+// c.mu must be held.
+func (c *cleanupTokens) prune() {
+	now := time.Now()
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+	c.lastPrune = now
+	for nonce, expires := range c.redeemed {
+		if !now.Before(expires) {
+			delete(c.redeemed, nonce)
+		}
+	}
+	for portID, at := range c.revoked {
+		if now.Sub(at) >= c.ttl {
+			delete(c.revoked, portID)
+		}
+	}
+}
+
+// delByToken serves a /del carrying a cleanup token: it deletes the port the
+// token was issued for, or returns it to the warm pool, as /del would for
+// its container. The port is left alone when it no longer belongs to the
+// container, e.g. after a pool or an adopting pod handed it on.
+func delByToken(w http.ResponseWriter, token string, d tokenDel) {
+	claims, err := d.tokens.verify(token)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	defer d.locks.lock(claims.ContainerID)()
+
+	if err := d.tokens.redeem(claims); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("DEL by cleanup token port_id=%s network_id=%s container_id=%s", claims.PortID, claims.NetworkID, claims.ContainerID)
+
+	port, err := d.portClient.Get(claims.PortID)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			log.Printf("DEL port_id=%s already gone", claims.PortID)
+			d.recent.forget(claims.NetworkID, claims.Name)
+			writeJSON(w, http.StatusOK, api.DelResponse{OK: true})
+			return
+		}
+		d.tokens.release(claims)
+		log.Printf("ERROR getting port %s: %v", claims.PortID, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to get port %s: %v", claims.PortID, err))
+		return
+	}
+	if port.Name != claims.Name || port.DeviceID != claims.DeviceID || port.NetworkID != claims.NetworkID {
+		log.Printf("WARNING cleanup token for port %s of container %s refused: the port is now %q with device_id %q", claims.PortID, claims.ContainerID, port.Name, port.DeviceID)
+		writeError(w, http.StatusConflict, fmt.Sprintf("port %s no longer belongs to container %s", claims.PortID, claims.ContainerID))
+		return
+	}
+
+	resp := api.DelResponse{OK: true}
+	if d.pool.give(*port) {
+		log.Printf("DEL returned port_id=%s to the pool", port.ID)
+		resp.PooledPortIDs = []string{port.ID}
+	} else if err := d.portClient.Delete(port.ID); err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); !ok {
+			d.tokens.release(claims)
+			log.Printf("ERROR deleting port %s: %v", port.ID, err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete port %s: %v", port.ID, err))
+			return
+		}
+		log.Printf("DEL port_id=%s already gone", port.ID)
+	} else {
+		log.Printf("DEL deleted port_id=%s", port.ID)
+		resp.DeletedPortIDs = []string{port.ID}
+		event := PortEvent{Type: portEventDeleted, PortID: port.ID, NetworkID: port.NetworkID, ContainerID: claims.ContainerID}
+		if len(port.FixedIPs) > 0 {
+			event.IPAddress = port.FixedIPs[0].IPAddress
+		}
+		d.events.emit(event)
+	}
+
+	if claims.QoSPolicy {
+		policyIDs, err := deleteQoSPolicies(d.neutronClient, claims.Name)
+		if err != nil {
+			log.Printf("WARNING deleting QoS policies of port %s failed, policies may leak: %v", claims.Name, err)
+		}
+		for _, id := range policyIDs {
+			log.Printf("DEL deleted qos_policy_id=%s", id)
+		}
+	}
+	d.recent.forget(claims.NetworkID, claims.Name)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// tokenDel holds what delByToken shares with the /del handler.
+type tokenDel struct {
+	tokens        *cleanupTokens
+	locks         *containerLocks
+	portClient    NeutronPortClient
+	neutronClient *gophercloud.ServiceClient
+	pool          *warmPool
+	events        *eventQueue
+	recent        *recentCreates
+}
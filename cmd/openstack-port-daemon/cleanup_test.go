@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestCleanupTokens(t *testing.T) {
+	tokens := newCleanupTokens(time.Hour)
+	claims := cleanupClaims{PortID: "port-uuid-1234", NetworkID: "net-uuid", ContainerID: "abcdef1234567890", Name: "k8s-pod-abcdef123456"}
+	token := tokens.issue(claims)
+
+	t.Run("RedeemOnce", func(t *testing.T) {
+		got, err := tokens.verify(token)
+		if err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		if got.PortID != claims.PortID || got.NetworkID != claims.NetworkID || got.ContainerID != claims.ContainerID || got.Name != claims.Name {
+			t.Errorf("claims = %+v, want %+v", got, claims)
+		}
+		if err := tokens.redeem(got); err != nil {
+			t.Fatalf("redeem: %v", err)
+		}
+		if err := tokens.redeem(got); !errors.Is(err, errCleanupTokenRedeemed) {
+			t.Errorf("second redeem error = %v, want %v", err, errCleanupTokenRedeemed)
+		}
+
+		tokens.release(got)
+		if err := tokens.redeem(got); err != nil {
+			t.Errorf("redeem after release: %v", err)
+		}
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		before, _ := tokens.verify(tokens.issue(claims))
+		tokens.revoke(claims.PortID)
+		if err := tokens.redeem(before); !errors.Is(err, errCleanupTokenRevoked) {
+			t.Errorf("redeem of a token issued before the DEL error = %v, want %v", err, errCleanupTokenRevoked)
+		}
+		// The port handed to a later ADD gets a token of its own.
+		time.Sleep(time.Millisecond)
+		after, _ := tokens.verify(tokens.issue(claims))
+		if err := tokens.redeem(after); err != nil {
+			t.Errorf("redeem of a token issued after the DEL: %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		short := newCleanupTokens(time.Millisecond)
+		token := short.issue(claims)
+		time.Sleep(5 * time.Millisecond)
+		if _, err := short.verify(token); !errors.Is(err, errInvalidCleanupToken) {
+			t.Errorf("verify of an expired token error = %v, want %v", err, errInvalidCleanupToken)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		short := newCleanupTokens(time.Millisecond)
+		got, _ := short.verify(short.issue(claims))
+		if err := short.redeem(got); err != nil {
+			t.Fatal(err)
+		}
+		short.revoke(claims.PortID)
+		time.Sleep(5 * time.Millisecond)
+		short.lastPrune = time.Time{}
+		short.revoke("port-other")
+		if len(short.redeemed) != 0 || len(short.revoked) != 1 {
+			t.Errorf("%d redeemed and %d revoked entries left, want only the fresh revocation", len(short.redeemed), len(short.revoked))
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		payload, sig, _ := strings.Cut(tokens.issue(claims), ".")
+		forged, _ := json.Marshal(cleanupClaims{PortID: "port-other", NetworkID: "net-uuid", Nonce: "00", ExpiresAt: time.Now().Add(time.Hour).UnixNano()})
+		for name, tok := range map[string]string{
+			"Empty":         "",
+			"NoSignature":   payload,
+			"BadSignature":  payload + ".c2lnbmF0dXJl",
+			"OtherPayload":  base64URL(forged) + "." + sig,
+			"OtherDaemon":   newCleanupTokens(time.Hour).issue(claims),
+			"NotBase64":     "!!." + sig,
+			"SignedGarbage": base64URL([]byte("garbage")) + "." + base64URL(tokens.sign([]byte("garbage"))),
+		} {
+			t.Run(name, func(t *testing.T) {
+				if _, err := tokens.verify(tok); !errors.Is(err, errInvalidCleanupToken) {
+					t.Errorf("verify error = %v, want %v", err, errInvalidCleanupToken)
+				}
+			})
+		}
+	})
+}
+
+// addWithCleanupToken serves an ADD of containerID asking for a cleanup
+// token and returns the response.
+func addWithCleanupToken(t *testing.T, handler http.Handler, containerID string) api.AddResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+		bytes.NewBufferString(`{"container_id":"`+containerID+`","network_id":"net-uuid","subnet_id":"subnet-uuid","cleanup_token":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var add api.AddResponse
+	if err := json.NewDecoder(rec.Body).Decode(&add); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if add.CleanupToken == "" {
+		t.Fatal("expected a cleanup token")
+	}
+	return add
+}
+
+func TestDelByCleanupToken(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	handleAddPortAndSubnet(t)
+
+	fake := newFakePortClient()
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+	serve := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	t.Run("Deletes", func(t *testing.T) {
+		add := addWithCleanupToken(t, handler, "aaaaaaaaaaaa0001")
+		// No container or network ID: the token alone names the port.
+		body, _ := json.Marshal(api.DelRequest{CleanupToken: add.CleanupToken})
+		rec := serve(string(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("del status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		var del api.DelResponse
+		if err := json.NewDecoder(rec.Body).Decode(&del); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if _, left := fake.ports[add.PortID]; !reflect.DeepEqual(del.DeletedPortIDs, []string{add.PortID}) || left {
+			t.Errorf("DeletedPortIDs = %v (port left %t), want [%s]", del.DeletedPortIDs, left, add.PortID)
+		}
+
+		if rec := serve(string(body)); rec.Code != http.StatusConflict {
+			t.Errorf("reused token status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+		if rec := serve(`{"cleanup_token":"bogus"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("invalid token status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("PortHandedOn", func(t *testing.T) {
+		add := addWithCleanupToken(t, handler, "aaaaaaaaaaaa0002")
+		// The port now serves another pod, as after a pool or an adoption
+		// handed it on.
+		port := fake.ports[add.PortID]
+		port.Name = "k8s-pod-bbbbbbbbbbbb"
+		fake.ports[add.PortID] = port
+
+		body, _ := json.Marshal(api.DelRequest{CleanupToken: add.CleanupToken})
+		if rec := serve(string(body)); rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d, body: %s", rec.Code, http.StatusConflict, rec.Body.String())
+		}
+		if _, ok := fake.ports[add.PortID]; !ok {
+			t.Error("the port of another pod was deleted")
+		}
+	})
+
+	t.Run("RevokedByDel", func(t *testing.T) {
+		add := addWithCleanupToken(t, handler, "aaaaaaaaaaaa0003")
+		if rec := serve(`{"container_id":"aaaaaaaaaaaa0003","network_id":"net-uuid"}`); rec.Code != http.StatusOK {
+			t.Fatalf("del status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		body, _ := json.Marshal(api.DelRequest{CleanupToken: add.CleanupToken})
+		rec := serve(string(body))
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), errCleanupTokenRevoked.Error()) {
+			t.Errorf("status = %d, body: %s, want %d revoked", rec.Code, rec.Body.String(), http.StatusConflict)
+		}
+	})
+}
+
+func TestAddWithoutCleanupToken(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	handleAddPortAndSubnet(t)
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)))
+	if strings.Contains(rec.Body.String(), "cleanup_token") {
+		t.Errorf("body = %s, want no cleanup_token unless requested", rec.Body.String())
+	}
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
+)
+
+// daemonConfig holds the daemon tunables. Values are read from
+// OPENSTACK_CNI_* environment variables alongside the OS_* credentials.
+type daemonConfig struct {
+	// ContainerLock serializes ADD/DEL operations for the same container.
+	ContainerLock bool
+	// MaxConcurrentRequests bounds the requests handled at once; 0 disables
+	// the limit.
+	MaxConcurrentRequests int
+	// MaxQueueDepth bounds the requests waiting for a free slot; beyond it
+	// the daemon answers 429.
+	MaxQueueDepth int
+	// EnvFile is an optional env-format file of OS_* credentials, re-read
+	// every EnvFilePollInterval so rotated credentials take effect.
+	EnvFile             string
+	EnvFilePollInterval time.Duration
+	// WarmPoolSize is the number of spare ports kept pre-created on
+	// WarmPoolSubnetID; 0 disables the warm pool.
+	WarmPoolSize      int
+	WarmPoolNetworkID string
+	WarmPoolSubnetID  string
+	// AllowRouterRoutes lets CNI configs add routes to a Neutron router on
+	// ADD. This needs router admin rights and is off by default.
+	AllowRouterRoutes bool
+	// AllowEndpointOverride lets CNI configs send their Neutron calls to
+	// another endpoint with the daemon's token. Off by default.
+	AllowEndpointOverride bool
+	// NeutronReadTimeout bounds each Neutron subnet and network lookup made
+	// while handling an ADD.
+	NeutronReadTimeout time.Duration
+	// RequestTimeout bounds how long a request may take before it is
+	// answered 504; an ADD past it deletes the port it created.
+	RequestTimeout time.Duration
+	// LookupCacheSize bounds the subnets, and separately the network MTUs,
+	// that ADD keeps cached for LookupCacheTTL; 0 disables the caches.
+	LookupCacheSize int
+	LookupCacheTTL  time.Duration
+	// IPAllocationRetries bounds how many times a port create failing with
+	// no IP address available is retried, IPAllocationRetryInterval apart.
+	// 0 disables the retry.
+	IPAllocationRetries       int
+	IPAllocationRetryInterval time.Duration
+	// UnavailableRetryAfter is the Retry-After hint sent with 503
+	// responses, for conditions that take a while to clear.
+	UnavailableRetryAfter time.Duration
+	// ReauthMinTokenLifetime makes requests re-authenticate first when the
+	// Keystone token expires within it.
+	ReauthMinTokenLifetime time.Duration
+	// CleanupTokenTTL is how long a cleanup token issued on ADD stays valid.
+	CleanupTokenTTL time.Duration
+	// CreateVisibilityGrace is how long after creating a port DEL and CHECK
+	// keep re-listing it when Neutron does not list it yet.
+	CreateVisibilityGrace time.Duration
+	// PortNamer names container ports for requests that do not select a
+	// strategy themselves.
+	PortNamer portname.PortNamer
+	// SocketUID and SocketGID, when not -1, own the daemon socket; peers
+	// running as that UID or GID are accepted besides root.
+	SocketUID int
+	SocketGID int
+	// SocketCheckInterval is how often the daemon checks that its sockets
+	// still exist, listening again on one that was removed.
+	SocketCheckInterval time.Duration
+	// PeerCredPolicy decides what happens to a peer whose SO_PEERCRED
+	// credentials cannot be read: peerCredPolicyFailClosed rejects it,
+	// peerCredPolicyFailOpen accepts it unchecked. Only trusted setups
+	// should fail open.
+	PeerCredPolicy string
+	// PeerExeAllowlist, when not empty, also requires the executable of
+	// a connecting peer, read from /proc/<pid>/exe with the PID of
+	// SO_PEERCRED, to be one of these absolute paths. An executable that
+	// cannot be read is handled like unreadable credentials.
+	PeerExeAllowlist []string
+	// GRPCSocket, when set, is the path of a second Unix socket serving
+	// ADD, DEL and CHECK over gRPC. Empty disables gRPC.
+	GRPCSocket string
+	// UserAgent is prepended to the User-Agent of every Keystone and
+	// Neutron request.
+	UserAgent string
+	// ReuseExistingPort makes an ADD return the container's port when one
+	// already exists on the requested subnet, e.g. created by a concurrent
+	// ADD for the same container, instead of creating a duplicate.
+	ReuseExistingPort bool
+	// StalePortPolicy decides what an ADD creating a port does about ports
+	// with the container's name on another network, left over from an
+	// earlier config: stalePortPolicyError fails the ADD,
+	// stalePortPolicyRecreate deletes them and creates the port, and
+	// stalePortPolicyIgnore, the default, does not look for them: a pod
+	// attached to several networks has a port of that name on each.
+	StalePortPolicy string
+	// StrictJSON rejects request bodies not sent as application/json or
+	// carrying unknown fields, to catch client bugs early. It is off by
+	// default so older clients keep working.
+	StrictJSON bool
+	// PortTags are Neutron tags set on every port the daemon creates,
+	// warm pool spares included, before the tags of the request.
+	PortTags []string
+	// PortDescription, when set, renders the description of the ports the
+	// daemon hands to containers from the pod's metadata.
+	PortDescription *descriptionTemplate
+	// HostID is set as binding:host_id on the ports the daemon creates.
+	// Empty leaves the binding to Neutron.
+	HostID string
+	// NeutronClientPoolSize is the number of Neutron clients, each with its
+	// own token, that requests are spread over in turn. 1 shares a single
+	// client.
+	NeutronClientPoolSize int
+	// AddRateInterval, when not 0, rate limits the ADDs of each pod (or
+	// container, for requests naming no pod) to AddRateBurst in a row, then
+	// one per AddRateInterval, and its DELs alike. Excess requests are
+	// answered 429 with api.ReasonRateLimited.
+	AddRateInterval time.Duration
+	AddRateBurst    int
+	// NamespaceNetworks maps Kubernetes namespaces to the network, and
+	// optionally subnet, of the pods whose request names no network.
+	NamespaceNetworks map[string]namespaceNetwork
+	// EventsURL, when set, is an http(s) URL each port create and delete
+	// event is POSTed to. Empty publishes no events.
+	EventsURL string
+}
+
+// version is the daemon version reported in the default User-Agent. It can
+// be set at build time with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// Host ID sources, as set in OPENSTACK_CNI_HOST_ID_SOURCE.
+const (
+	hostIDSourceHostname = "hostname"
+	hostIDSourceFile     = "file"
+	hostIDSourceFixed    = "fixed"
+	hostIDSourceNone     = "none"
+)
+
+// Peer credential policies, as set in OPENSTACK_CNI_PEERCRED_POLICY.
+const (
+	peerCredPolicyFailClosed = "fail-closed"
+	peerCredPolicyFailOpen   = "fail-open"
+)
+
+// Stale port policies, as set in OPENSTACK_CNI_STALE_PORT_POLICY.
+const (
+	stalePortPolicyError    = "error"
+	stalePortPolicyRecreate = "recreate"
+	stalePortPolicyIgnore   = "ignore"
+)
+
+// defaultDaemonConfig returns the configuration used when no overrides are set.
+func defaultDaemonConfig() daemonConfig {
+	return daemonConfig{
+		ContainerLock:             true,
+		MaxConcurrentRequests:     16,
+		MaxQueueDepth:             64,
+		EnvFilePollInterval:       30 * time.Second,
+		NeutronReadTimeout:        10 * time.Second,
+		RequestTimeout:            60 * time.Second,
+		CreateVisibilityGrace:     2 * time.Second,
+		CleanupTokenTTL:           24 * time.Hour,
+		LookupCacheTTL:            30 * time.Second,
+		UnavailableRetryAfter:     10 * time.Second,
+		IPAllocationRetries:       2,
+		IPAllocationRetryInterval: time.Second,
+		ReauthMinTokenLifetime:    5 * time.Minute,
+		PortNamer:                 portname.DefaultNamer{},
+		SocketUID:                 -1,
+		SocketGID:                 -1,
+		SocketCheckInterval:       10 * time.Second,
+		PeerCredPolicy:            peerCredPolicyFailClosed,
+		StalePortPolicy:           stalePortPolicyIgnore,
+		AddRateBurst:              5,
+		NeutronClientPoolSize:     1,
+		UserAgent:                 "openstack-port-cni/" + version,
+	}
+}
+
+// loadDaemonConfig reads daemon tunables from the environment, falling back
+// to defaultDaemonConfig for unset variables.
+func loadDaemonConfig() (daemonConfig, error) {
+	cfg := defaultDaemonConfig()
+	if err := envBool("OPENSTACK_CNI_CONTAINER_LOCK", &cfg.ContainerLock); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_MAX_CONCURRENT_REQUESTS", &cfg.MaxConcurrentRequests); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_MAX_QUEUE_DEPTH", &cfg.MaxQueueDepth); err != nil {
+		return daemonConfig{}, err
+	}
+	cfg.EnvFile = os.Getenv("OPENSTACK_CNI_ENV_FILE")
+	cfg.GRPCSocket = os.Getenv("OPENSTACK_CNI_GRPC_SOCKET")
+	if err := envDuration("OPENSTACK_CNI_ENV_FILE_POLL_INTERVAL", &cfg.EnvFilePollInterval); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_WARM_POOL_SIZE", &cfg.WarmPoolSize); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envBool("OPENSTACK_CNI_ALLOW_ROUTER_ROUTES", &cfg.AllowRouterRoutes); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envBool("OPENSTACK_CNI_ALLOW_ENDPOINT_OVERRIDE", &cfg.AllowEndpointOverride); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_NEUTRON_READ_TIMEOUT", &cfg.NeutronReadTimeout); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_REQUEST_TIMEOUT", &cfg.RequestTimeout); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_CREATE_VISIBILITY_GRACE", &cfg.CreateVisibilityGrace); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_CLEANUP_TOKEN_TTL", &cfg.CleanupTokenTTL); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_LOOKUP_CACHE_SIZE", &cfg.LookupCacheSize); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_LOOKUP_CACHE_TTL", &cfg.LookupCacheTTL); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_IP_ALLOCATION_RETRIES", &cfg.IPAllocationRetries); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_IP_ALLOCATION_RETRY_INTERVAL", &cfg.IPAllocationRetryInterval); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_UNAVAILABLE_RETRY_AFTER", &cfg.UnavailableRetryAfter); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_REAUTH_MIN_TOKEN_LIFETIME", &cfg.ReauthMinTokenLifetime); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envBool("OPENSTACK_CNI_REUSE_EXISTING_PORT", &cfg.ReuseExistingPort); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envBool("OPENSTACK_CNI_STRICT_JSON", &cfg.StrictJSON); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_SOCKET_UID", &cfg.SocketUID); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_SOCKET_GID", &cfg.SocketGID); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envDuration("OPENSTACK_CNI_SOCKET_CHECK_INTERVAL", &cfg.SocketCheckInterval); err != nil {
+		return daemonConfig{}, err
+	}
+	switch v := os.Getenv("OPENSTACK_CNI_PEERCRED_POLICY"); v {
+	case "":
+	case peerCredPolicyFailClosed, peerCredPolicyFailOpen:
+		cfg.PeerCredPolicy = v
+	default:
+		return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_PEERCRED_POLICY=%q (want %s or %s)", v, peerCredPolicyFailClosed, peerCredPolicyFailOpen)
+	}
+	if v := os.Getenv("OPENSTACK_CNI_PEER_EXE_ALLOWLIST"); v != "" {
+		for _, path := range strings.Split(v, ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_PEER_EXE_ALLOWLIST: %q is not an absolute path", path)
+			}
+			cfg.PeerExeAllowlist = append(cfg.PeerExeAllowlist, filepath.Clean(path))
+		}
+	}
+	switch v := os.Getenv("OPENSTACK_CNI_STALE_PORT_POLICY"); v {
+	case "":
+	case stalePortPolicyError, stalePortPolicyRecreate, stalePortPolicyIgnore:
+		cfg.StalePortPolicy = v
+	default:
+		return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_STALE_PORT_POLICY=%q (want %s, %s or %s)", v, stalePortPolicyError, stalePortPolicyRecreate, stalePortPolicyIgnore)
+	}
+	if v := os.Getenv("OPENSTACK_CNI_PORT_TAGS"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				cfg.PortTags = append(cfg.PortTags, tag)
+			}
+		}
+		if err := api.ValidateTags(cfg.PortTags); err != nil {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_PORT_TAGS: %w", err)
+		}
+	}
+	if v := os.Getenv("OPENSTACK_CNI_PORT_DESCRIPTION_TEMPLATE"); v != "" {
+		// A node whose hostname cannot be read renders {node} as unknown.
+		node, _ := os.Hostname()
+		tmpl, err := parseDescriptionTemplate(v, node)
+		if err != nil {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_PORT_DESCRIPTION_TEMPLATE: %w", err)
+		}
+		cfg.PortDescription = tmpl
+	}
+	if v := os.Getenv("OPENSTACK_CNI_USER_AGENT"); v != "" {
+		cfg.UserAgent = v
+	}
+	if err := envDuration("OPENSTACK_CNI_ADD_RATE_INTERVAL", &cfg.AddRateInterval); err != nil {
+		return daemonConfig{}, err
+	}
+	if err := envInt("OPENSTACK_CNI_ADD_RATE_BURST", &cfg.AddRateBurst); err != nil {
+		return daemonConfig{}, err
+	}
+	if cfg.AddRateBurst < 1 {
+		return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_ADD_RATE_BURST=%d (want at least 1)", cfg.AddRateBurst)
+	}
+	if err := envInt("OPENSTACK_CNI_NEUTRON_CLIENT_POOL_SIZE", &cfg.NeutronClientPoolSize); err != nil {
+		return daemonConfig{}, err
+	}
+	if cfg.NeutronClientPoolSize < 1 {
+		return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_NEUTRON_CLIENT_POOL_SIZE=%d (want at least 1)", cfg.NeutronClientPoolSize)
+	}
+	inline, file := os.Getenv("OPENSTACK_CNI_NAMESPACE_NETWORKS"), os.Getenv("OPENSTACK_CNI_NAMESPACE_NETWORKS_FILE")
+	switch {
+	case inline != "" && file != "":
+		return daemonConfig{}, fmt.Errorf("OPENSTACK_CNI_NAMESPACE_NETWORKS and OPENSTACK_CNI_NAMESPACE_NETWORKS_FILE cannot both be set")
+	case inline != "":
+		networks, err := parseNamespaceNetworks(inline)
+		if err != nil {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_NAMESPACE_NETWORKS: %w", err)
+		}
+		cfg.NamespaceNetworks = networks
+	case file != "":
+		networks, err := loadNamespaceNetworksFile(file)
+		if err != nil {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_NAMESPACE_NETWORKS_FILE: %w", err)
+		}
+		cfg.NamespaceNetworks = networks
+	}
+	if v := os.Getenv("OPENSTACK_CNI_EVENTS_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_EVENTS_URL=%q (want an http or https URL)", v)
+		}
+		cfg.EventsURL = v
+	}
+	if v := os.Getenv("OPENSTACK_CNI_PORT_NAMING"); v != "" {
+		namer, err := portname.New(v)
+		if err != nil {
+			return daemonConfig{}, fmt.Errorf("invalid OPENSTACK_CNI_PORT_NAMING: %w", err)
+		}
+		cfg.PortNamer = namer
+	}
+	hostID, err := resolveHostID(os.Getenv("OPENSTACK_CNI_HOST_ID_SOURCE"), os.Getenv("OPENSTACK_CNI_HOST_ID_FILE"), os.Getenv("OPENSTACK_CNI_HOST_ID"))
+	if err != nil {
+		return daemonConfig{}, err
+	}
+	cfg.HostID = hostID
+	cfg.WarmPoolNetworkID = os.Getenv("OPENSTACK_CNI_WARM_POOL_NETWORK_ID")
+	cfg.WarmPoolSubnetID = os.Getenv("OPENSTACK_CNI_WARM_POOL_SUBNET_ID")
+	if cfg.WarmPoolSize > 0 && (cfg.WarmPoolNetworkID == "" || cfg.WarmPoolSubnetID == "") {
+		return daemonConfig{}, fmt.Errorf("OPENSTACK_CNI_WARM_POOL_SIZE requires OPENSTACK_CNI_WARM_POOL_NETWORK_ID and OPENSTACK_CNI_WARM_POOL_SUBNET_ID")
+	}
+	return cfg, nil
+}
+
+// resolveHostID returns the binding:host_id selected by source: the node's
+// hostname (the default), the trimmed content of file, the fixed value, or
+// nothing for "none".
+func resolveHostID(source, file, value string) (string, error) {
+	switch source {
+	case "", hostIDSourceHostname:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get hostname for binding:host_id: %w", err)
+		}
+		return hostname, nil
+	case hostIDSourceFile:
+		if file == "" {
+			return "", fmt.Errorf("OPENSTACK_CNI_HOST_ID_SOURCE=file requires OPENSTACK_CNI_HOST_ID_FILE")
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read host ID file: %w", err)
+		}
+		hostID := strings.TrimSpace(string(data))
+		if hostID == "" || strings.ContainsAny(hostID, " \t\n") {
+			return "", fmt.Errorf("host ID file %s must hold a single non-empty host ID", file)
+		}
+		return hostID, nil
+	case hostIDSourceFixed:
+		if value == "" {
+			return "", fmt.Errorf("OPENSTACK_CNI_HOST_ID_SOURCE=fixed requires OPENSTACK_CNI_HOST_ID")
+		}
+		return value, nil
+	case hostIDSourceNone:
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid OPENSTACK_CNI_HOST_ID_SOURCE=%q (want %s, %s, %s or %s)", source, hostIDSourceHostname, hostIDSourceFile, hostIDSourceFixed, hostIDSourceNone)
+	}
+}
+
+// envBool parses the named environment variable into dst if it is set.
+func envBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s=%q: %w", name, v, err)
+	}
+	*dst = b
+	return nil
+}
+
+// envInt parses the named environment variable into dst if it is set. Negative
+// values are rejected.
+func envInt(name string, dst *int) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s=%q: %w", name, v, err)
+	}
+	if n < 0 {
+		return fmt.Errorf("invalid %s=%q: must not be negative", name, v)
+	}
+	*dst = n
+	return nil
+}
+
+// envDuration parses the named environment variable (e.g. "30s") into dst if
+// it is set. Non-positive durations are rejected.
+func envDuration(name string, dst *time.Duration) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s=%q: %w", name, v, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("invalid %s=%q: must be positive", name, v)
+	}
+	*dst = d
+	return nil
+}
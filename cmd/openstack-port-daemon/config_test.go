@@ -0,0 +1,645 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"openstack-port/internal/portname"
+)
+
+// clearDaemonEnv unsets every OPENSTACK_CNI_* variable read by
+// loadDaemonConfig so tests start from the defaults.
+func clearDaemonEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"OPENSTACK_CNI_CONTAINER_LOCK",
+		"OPENSTACK_CNI_MAX_CONCURRENT_REQUESTS",
+		"OPENSTACK_CNI_MAX_QUEUE_DEPTH",
+		"OPENSTACK_CNI_ENV_FILE",
+		"OPENSTACK_CNI_ENV_FILE_POLL_INTERVAL",
+		"OPENSTACK_CNI_WARM_POOL_SIZE",
+		"OPENSTACK_CNI_WARM_POOL_NETWORK_ID",
+		"OPENSTACK_CNI_WARM_POOL_SUBNET_ID",
+		"OPENSTACK_CNI_ALLOW_ROUTER_ROUTES",
+		"OPENSTACK_CNI_ALLOW_ENDPOINT_OVERRIDE",
+		"OPENSTACK_CNI_NEUTRON_READ_TIMEOUT",
+		"OPENSTACK_CNI_REQUEST_TIMEOUT",
+		"OPENSTACK_CNI_CREATE_VISIBILITY_GRACE",
+		"OPENSTACK_CNI_CLEANUP_TOKEN_TTL",
+		"OPENSTACK_CNI_REAUTH_MIN_TOKEN_LIFETIME",
+		"OPENSTACK_CNI_UNAVAILABLE_RETRY_AFTER",
+		"OPENSTACK_CNI_IP_ALLOCATION_RETRIES",
+		"OPENSTACK_CNI_IP_ALLOCATION_RETRY_INTERVAL",
+		"OPENSTACK_CNI_PORT_NAMING",
+		"OPENSTACK_CNI_SOCKET_UID",
+		"OPENSTACK_CNI_SOCKET_GID",
+		"OPENSTACK_CNI_PEERCRED_POLICY",
+		"OPENSTACK_CNI_SOCKET_CHECK_INTERVAL",
+		"OPENSTACK_CNI_PORT_TAGS",
+		"OPENSTACK_CNI_STALE_PORT_POLICY",
+		"OPENSTACK_CNI_PEER_EXE_ALLOWLIST",
+		"OPENSTACK_CNI_GRPC_SOCKET",
+		"OPENSTACK_CNI_USER_AGENT",
+		"OPENSTACK_CNI_STRICT_JSON",
+		"OPENSTACK_CNI_REUSE_EXISTING_PORT",
+		"OPENSTACK_CNI_LOOKUP_CACHE_SIZE",
+		"OPENSTACK_CNI_LOOKUP_CACHE_TTL",
+		"OPENSTACK_CNI_HOST_ID_SOURCE",
+		"OPENSTACK_CNI_HOST_ID_FILE",
+		"OPENSTACK_CNI_HOST_ID",
+		"OPENSTACK_CNI_EVENTS_URL",
+		"OPENSTACK_CNI_PORT_DESCRIPTION_TEMPLATE",
+		"OPENSTACK_CNI_ADD_RATE_INTERVAL",
+		"OPENSTACK_CNI_ADD_RATE_BURST",
+		"OPENSTACK_CNI_NEUTRON_CLIENT_POOL_SIZE",
+		"OPENSTACK_CNI_NAMESPACE_NETWORKS",
+		"OPENSTACK_CNI_NAMESPACE_NETWORKS_FILE",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadDaemonConfigDefaults(t *testing.T) {
+	clearDaemonEnv(t)
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	want := defaultDaemonConfig()
+	want.HostID, _ = os.Hostname()
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("cfg = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadDaemonConfigReuseExistingPort(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_REUSE_EXISTING_PORT", "true")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if !cfg.ReuseExistingPort {
+		t.Error("ReuseExistingPort = false, want true")
+	}
+}
+
+func TestLoadDaemonConfigAllowEndpointOverride(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_ALLOW_ENDPOINT_OVERRIDE", "true")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if !cfg.AllowEndpointOverride {
+		t.Error("AllowEndpointOverride = false, want true")
+	}
+}
+
+func TestLoadDaemonConfigStrictJSON(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_STRICT_JSON", "true")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if !cfg.StrictJSON {
+		t.Error("StrictJSON = false, want true")
+	}
+}
+
+func TestLoadDaemonConfigContainerLock(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_CONTAINER_LOCK", "false")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.ContainerLock {
+		t.Error("ContainerLock = true, want false")
+	}
+}
+
+func TestLoadDaemonConfigInvalidBool(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_CONTAINER_LOCK", "nope")
+
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestLoadDaemonConfigRequestLimits(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_MAX_CONCURRENT_REQUESTS", "4")
+	t.Setenv("OPENSTACK_CNI_MAX_QUEUE_DEPTH", "0")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.MaxConcurrentRequests != 4 {
+		t.Errorf("MaxConcurrentRequests = %d, want 4", cfg.MaxConcurrentRequests)
+	}
+	if cfg.MaxQueueDepth != 0 {
+		t.Errorf("MaxQueueDepth = %d, want 0", cfg.MaxQueueDepth)
+	}
+}
+
+func TestLoadDaemonConfigInvalidInt(t *testing.T) {
+	for _, v := range []string{"many", "-1"} {
+		t.Run(v, func(t *testing.T) {
+			clearDaemonEnv(t)
+			t.Setenv("OPENSTACK_CNI_MAX_QUEUE_DEPTH", v)
+
+			if _, err := loadDaemonConfig(); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadDaemonConfigEnvFile(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_ENV_FILE", "/etc/openstack/creds.env")
+	t.Setenv("OPENSTACK_CNI_ENV_FILE_POLL_INTERVAL", "5s")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.EnvFile != "/etc/openstack/creds.env" {
+		t.Errorf("EnvFile = %q, want %q", cfg.EnvFile, "/etc/openstack/creds.env")
+	}
+	if cfg.EnvFilePollInterval != 5*time.Second {
+		t.Errorf("EnvFilePollInterval = %v, want 5s", cfg.EnvFilePollInterval)
+	}
+}
+
+func TestLoadDaemonConfigInvalidDuration(t *testing.T) {
+	for _, v := range []string{"soon", "0s"} {
+		t.Run(v, func(t *testing.T) {
+			clearDaemonEnv(t)
+			t.Setenv("OPENSTACK_CNI_ENV_FILE_POLL_INTERVAL", v)
+
+			if _, err := loadDaemonConfig(); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadDaemonConfigWarmPool(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_WARM_POOL_SIZE", "5")
+	t.Setenv("OPENSTACK_CNI_WARM_POOL_NETWORK_ID", "net-uuid")
+	t.Setenv("OPENSTACK_CNI_WARM_POOL_SUBNET_ID", "subnet-uuid")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.WarmPoolSize != 5 || cfg.WarmPoolNetworkID != "net-uuid" || cfg.WarmPoolSubnetID != "subnet-uuid" {
+		t.Errorf("warm pool = %d/%q/%q, want 5/net-uuid/subnet-uuid", cfg.WarmPoolSize, cfg.WarmPoolNetworkID, cfg.WarmPoolSubnetID)
+	}
+}
+
+func TestLoadDaemonConfigWarmPoolRequiresSubnet(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_WARM_POOL_SIZE", "5")
+	t.Setenv("OPENSTACK_CNI_WARM_POOL_NETWORK_ID", "net-uuid")
+
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestLoadDaemonConfigNeutronReadTimeout(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_NEUTRON_READ_TIMEOUT", "3s")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.NeutronReadTimeout != 3*time.Second {
+		t.Errorf("NeutronReadTimeout = %v, want 3s", cfg.NeutronReadTimeout)
+	}
+}
+
+func TestLoadDaemonConfigRequestTimeout(t *testing.T) {
+	clearDaemonEnv(t)
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.RequestTimeout != 60*time.Second {
+		t.Errorf("default RequestTimeout = %v, want 60s", cfg.RequestTimeout)
+	}
+
+	t.Setenv("OPENSTACK_CNI_REQUEST_TIMEOUT", "90s")
+	if cfg, err = loadDaemonConfig(); err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.RequestTimeout != 90*time.Second {
+		t.Errorf("RequestTimeout = %v, want 90s", cfg.RequestTimeout)
+	}
+
+	t.Setenv("OPENSTACK_CNI_REQUEST_TIMEOUT", "0s")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted OPENSTACK_CNI_REQUEST_TIMEOUT=0s")
+	}
+}
+
+func TestLoadDaemonConfigCreateVisibilityGrace(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_CREATE_VISIBILITY_GRACE", "500ms")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.CreateVisibilityGrace != 500*time.Millisecond {
+		t.Errorf("CreateVisibilityGrace = %v, want 500ms", cfg.CreateVisibilityGrace)
+	}
+}
+
+func TestLoadDaemonConfigCleanupTokenTTL(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_CLEANUP_TOKEN_TTL", "1h")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.CleanupTokenTTL != time.Hour {
+		t.Errorf("CleanupTokenTTL = %v, want 1h", cfg.CleanupTokenTTL)
+	}
+}
+
+func TestLoadDaemonConfigIPAllocationRetry(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_IP_ALLOCATION_RETRIES", "5")
+	t.Setenv("OPENSTACK_CNI_IP_ALLOCATION_RETRY_INTERVAL", "250ms")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.IPAllocationRetries != 5 || cfg.IPAllocationRetryInterval != 250*time.Millisecond {
+		t.Errorf("IPAllocationRetries, IPAllocationRetryInterval = %d, %v, want 5, 250ms", cfg.IPAllocationRetries, cfg.IPAllocationRetryInterval)
+	}
+}
+
+func TestLoadDaemonConfigUnavailableRetryAfter(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_UNAVAILABLE_RETRY_AFTER", "30s")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.UnavailableRetryAfter != 30*time.Second {
+		t.Errorf("UnavailableRetryAfter = %v, want 30s", cfg.UnavailableRetryAfter)
+	}
+}
+
+func TestLoadDaemonConfigReauthMinTokenLifetime(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_REAUTH_MIN_TOKEN_LIFETIME", "90s")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.ReauthMinTokenLifetime != 90*time.Second {
+		t.Errorf("ReauthMinTokenLifetime = %v, want 90s", cfg.ReauthMinTokenLifetime)
+	}
+}
+
+func TestLoadDaemonConfigSocketCheckInterval(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_SOCKET_CHECK_INTERVAL", "2s")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.SocketCheckInterval != 2*time.Second {
+		t.Errorf("SocketCheckInterval = %v, want 2s", cfg.SocketCheckInterval)
+	}
+}
+
+func TestLoadDaemonConfigPortTags(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_PORT_TAGS", "environment=prod, managed-by=cni,")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if want := []string{"environment=prod", "managed-by=cni"}; !reflect.DeepEqual(cfg.PortTags, want) {
+		t.Errorf("PortTags = %q, want %q", cfg.PortTags, want)
+	}
+
+	t.Setenv("OPENSTACK_CNI_PORT_TAGS", strings.Repeat("t", 256))
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted a tag longer than Neutron allows")
+	}
+}
+
+func TestLoadDaemonConfigPortNaming(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_PORT_NAMING", "hashed")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.PortNamer != (portname.HashedNamer{}) {
+		t.Errorf("PortNamer = %T, want portname.HashedNamer", cfg.PortNamer)
+	}
+
+	t.Setenv("OPENSTACK_CNI_PORT_NAMING", "bogus")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestLoadDaemonConfigSocketOwner(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_SOCKET_GID", "2000")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.SocketUID != -1 || cfg.SocketGID != 2000 {
+		t.Errorf("socket owner = %d:%d, want -1:2000", cfg.SocketUID, cfg.SocketGID)
+	}
+}
+
+func TestLoadDaemonConfigPeerCredPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: peerCredPolicyFailClosed},
+		{value: "fail-closed", want: peerCredPolicyFailClosed},
+		{value: "fail-open", want: peerCredPolicyFailOpen},
+		{value: "open", wantErr: true},
+	} {
+		clearDaemonEnv(t)
+		t.Setenv("OPENSTACK_CNI_PEERCRED_POLICY", tt.value)
+
+		cfg, err := loadDaemonConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OPENSTACK_CNI_PEERCRED_POLICY=%q: loadDaemonConfig() error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.PeerCredPolicy != tt.want {
+			t.Errorf("OPENSTACK_CNI_PEERCRED_POLICY=%q: PeerCredPolicy = %q, want %q", tt.value, cfg.PeerCredPolicy, tt.want)
+		}
+	}
+}
+
+func TestLoadDaemonConfigStalePortPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: stalePortPolicyIgnore},
+		{value: "error", want: stalePortPolicyError},
+		{value: "recreate", want: stalePortPolicyRecreate},
+		{value: "ignore", want: stalePortPolicyIgnore},
+		{value: "delete", wantErr: true},
+	} {
+		clearDaemonEnv(t)
+		t.Setenv("OPENSTACK_CNI_STALE_PORT_POLICY", tt.value)
+
+		cfg, err := loadDaemonConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OPENSTACK_CNI_STALE_PORT_POLICY=%q: loadDaemonConfig() error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.StalePortPolicy != tt.want {
+			t.Errorf("OPENSTACK_CNI_STALE_PORT_POLICY=%q: StalePortPolicy = %q, want %q", tt.value, cfg.StalePortPolicy, tt.want)
+		}
+	}
+}
+
+func TestLoadDaemonConfigPeerExeAllowlist(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_PEER_EXE_ALLOWLIST", "/opt/cni/bin/openstack-port-cni, /usr/libexec/cni//openstack-port-cni,")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if want := []string{"/opt/cni/bin/openstack-port-cni", "/usr/libexec/cni/openstack-port-cni"}; !reflect.DeepEqual(cfg.PeerExeAllowlist, want) {
+		t.Errorf("PeerExeAllowlist = %q, want %q", cfg.PeerExeAllowlist, want)
+	}
+
+	t.Setenv("OPENSTACK_CNI_PEER_EXE_ALLOWLIST", "openstack-port-cni")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted a relative executable path")
+	}
+}
+
+func TestLoadDaemonConfigAddRate(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_ADD_RATE_INTERVAL", "10s")
+	t.Setenv("OPENSTACK_CNI_ADD_RATE_BURST", "3")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.AddRateInterval != 10*time.Second || cfg.AddRateBurst != 3 {
+		t.Errorf("AddRateInterval, AddRateBurst = %s, %d, want 10s, 3", cfg.AddRateInterval, cfg.AddRateBurst)
+	}
+
+	t.Setenv("OPENSTACK_CNI_ADD_RATE_BURST", "0")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted a burst of 0")
+	}
+}
+
+func TestLoadDaemonConfigNeutronClientPoolSize(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_NEUTRON_CLIENT_POOL_SIZE", "4")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.NeutronClientPoolSize != 4 {
+		t.Errorf("NeutronClientPoolSize = %d, want 4", cfg.NeutronClientPoolSize)
+	}
+
+	t.Setenv("OPENSTACK_CNI_NEUTRON_CLIENT_POOL_SIZE", "0")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted a pool of 0 clients")
+	}
+}
+
+func TestLoadDaemonConfigNamespaceNetworks(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_NAMESPACE_NETWORKS", "team-a=net-a/subnet-a")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if want := map[string]namespaceNetwork{"team-a": {NetworkID: "net-a", SubnetID: "subnet-a"}}; !reflect.DeepEqual(cfg.NamespaceNetworks, want) {
+		t.Errorf("NamespaceNetworks = %+v, want %+v", cfg.NamespaceNetworks, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "namespaces.json")
+	if err := os.WriteFile(path, []byte(`{"team-b": {"network_id": "net-b"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENSTACK_CNI_NAMESPACE_NETWORKS_FILE", path)
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted both the inline and the file mapping")
+	}
+
+	t.Setenv("OPENSTACK_CNI_NAMESPACE_NETWORKS", "")
+	cfg, err = loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if want := map[string]namespaceNetwork{"team-b": {NetworkID: "net-b"}}; !reflect.DeepEqual(cfg.NamespaceNetworks, want) {
+		t.Errorf("NamespaceNetworks = %+v, want %+v", cfg.NamespaceNetworks, want)
+	}
+}
+
+func TestLoadDaemonConfigEventsURL(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_EVENTS_URL", "https://events.example.com/topics/ports")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.EventsURL != "https://events.example.com/topics/ports" {
+		t.Errorf("EventsURL = %q, want https://events.example.com/topics/ports", cfg.EventsURL)
+	}
+
+	for _, v := range []string{"amqp://broker:5672", "events.example.com", "http://"} {
+		t.Setenv("OPENSTACK_CNI_EVENTS_URL", v)
+		if _, err := loadDaemonConfig(); err == nil {
+			t.Errorf("loadDaemonConfig() accepted OPENSTACK_CNI_EVENTS_URL=%q", v)
+		}
+	}
+}
+
+func TestLoadDaemonConfigPortDescriptionTemplate(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_PORT_DESCRIPTION_TEMPLATE", "{namespace}/{pod} on {node}")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	hostname, _ := os.Hostname()
+	want := &descriptionTemplate{text: "{namespace}/{pod} on {node}", node: hostname}
+	if !reflect.DeepEqual(cfg.PortDescription, want) {
+		t.Errorf("PortDescription = %+v, want %+v", cfg.PortDescription, want)
+	}
+
+	t.Setenv("OPENSTACK_CNI_PORT_DESCRIPTION_TEMPLATE", "{namespace}/{pod_name}")
+	if _, err := loadDaemonConfig(); err == nil {
+		t.Error("loadDaemonConfig() accepted an unknown template variable")
+	}
+}
+
+func TestLoadDaemonConfigHostID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("os.Hostname() error = %v", err)
+	}
+	dir := t.TempDir()
+	hostIDFile := filepath.Join(dir, "host-id")
+	if err := os.WriteFile(hostIDFile, []byte("node-1.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		file    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", want: hostname},
+		{name: "hostname", source: "hostname", want: hostname},
+		{name: "file", source: "file", file: hostIDFile, want: "node-1.example.com"},
+		{name: "file missing", source: "file", file: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "file empty", source: "file", file: emptyFile, wantErr: true},
+		{name: "file unset", source: "file", wantErr: true},
+		{name: "fixed", source: "fixed", value: "compute-1", want: "compute-1"},
+		{name: "fixed unset", source: "fixed", wantErr: true},
+		{name: "none", source: "none", value: "ignored", want: ""},
+		{name: "unknown", source: "dns", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearDaemonEnv(t)
+			t.Setenv("OPENSTACK_CNI_HOST_ID_SOURCE", tt.source)
+			t.Setenv("OPENSTACK_CNI_HOST_ID_FILE", tt.file)
+			t.Setenv("OPENSTACK_CNI_HOST_ID", tt.value)
+
+			cfg, err := loadDaemonConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadDaemonConfig() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && cfg.HostID != tt.want {
+				t.Errorf("HostID = %q, want %q", cfg.HostID, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDaemonConfigUserAgent(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_USER_AGENT", "cni-node-7")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.UserAgent != "cni-node-7" {
+		t.Errorf("UserAgent = %q, want %q", cfg.UserAgent, "cni-node-7")
+	}
+}
+
+func TestLoadDaemonConfigLookupCache(t *testing.T) {
+	clearDaemonEnv(t)
+	t.Setenv("OPENSTACK_CNI_LOOKUP_CACHE_SIZE", "128")
+	t.Setenv("OPENSTACK_CNI_LOOKUP_CACHE_TTL", "1m")
+
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		t.Fatalf("loadDaemonConfig() error = %v", err)
+	}
+	if cfg.LookupCacheSize != 128 || cfg.LookupCacheTTL != time.Minute {
+		t.Errorf("LookupCacheSize, LookupCacheTTL = %d, %v, want 128, 1m", cfg.LookupCacheSize, cfg.LookupCacheTTL)
+	}
+}
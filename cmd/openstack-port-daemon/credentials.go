@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+)
+
+// errEnvFileChanging is returned by readEnvFile when the file changed while
+// it was being read, e.g. a secret update was still being written.
+var errEnvFileChanging = errors.New("env file changed while being read")
+
+// parseEnvFile parses env-file content: one KEY=VALUE per line, with blank
+// lines and # comments ignored, an optional "export " prefix and optional
+// matching single or double quotes around the value. An unterminated quote,
+// as left by a truncated write, is an error.
+func parseEnvFile(data []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			if len(value) < 2 || value[len(value)-1] != value[0] {
+				return nil, fmt.Errorf("line %d: unterminated quote", lineNo)
+			}
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// readEnvFile reads path in a single read and checks that its size and
+// modification time did not change meanwhile, so a file caught mid-write is
+// reported as errEnvFileChanging rather than returned truncated.
+func readEnvFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, before.Size())
+	if _, err := io.ReadFull(f, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errEnvFileChanging
+		}
+		return nil, err
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !envFileUnchanged(before, after) {
+		return nil, errEnvFileChanging
+	}
+	return data, nil
+}
+
+// envFileUnchanged reports whether two stats of the env file describe the
+// same content.
+func envFileUnchanged(before, after os.FileInfo) bool {
+	return before.Size() == after.Size() && before.ModTime().Equal(after.ModTime())
+}
+
+// envFileWatcher applies an env file (typically OS_* credentials mounted
+// from a Kubernetes secret) to the process environment and re-applies it
+// when the file content changes, e.g. after secret rotation.
+type envFileWatcher struct {
+	path string
+
+	// mu guards digest and applied: load runs from both the watch loop and
+	// re-authentication.
+	mu      sync.Mutex
+	digest  [sha256.Size]byte
+	applied map[string]bool
+}
+
+func newEnvFileWatcher(path string) *envFileWatcher {
+	return &envFileWatcher{path: path, applied: make(map[string]bool)}
+}
+
+// load reads the env file and, if its content differs from the last load,
+// sets its variables in the environment and unsets variables that were
+// removed from it. It reports whether the environment changed. Nothing is
+// applied unless the whole file was read and parsed.
+func (w *envFileWatcher) load() (bool, error) {
+	if w == nil {
+		return false, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, err := readEnvFile(w.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read env file %s: %w", w.path, err)
+	}
+	digest := sha256.Sum256(data)
+	if digest == w.digest {
+		return false, nil
+	}
+	vars, err := parseEnvFile(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse env file %s: %w", w.path, err)
+	}
+	for key := range w.applied {
+		if _, ok := vars[key]; !ok {
+			_ = os.Unsetenv(key)
+			delete(w.applied, key)
+		}
+	}
+	for key, value := range vars {
+		if err := os.Setenv(key, value); err != nil {
+			return false, fmt.Errorf("failed to set %s from env file: %w", key, err)
+		}
+		w.applied[key] = true
+	}
+	w.digest = digest
+	return true, nil
+}
+
+// watch polls the env file every interval until ctx is done, calling
+// onChange after each change has been applied.
+func (w *envFileWatcher) watch(ctx context.Context, interval time.Duration, onChange func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := w.load()
+		if errors.Is(err, errEnvFileChanging) {
+			log.Printf("env file %s is being written, retrying at next poll", w.path)
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR reloading env file: %v", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		log.Printf("env file %s changed, re-authenticating", w.path)
+		if err := onChange(); err != nil {
+			log.Printf("ERROR re-authenticating after env file change: %v", err)
+		}
+	}
+}
+
+// Authenticator builds an authenticated Neutron client and returns it with
+// its token's expiry (zero when unknown). It is called at startup and on
+// every re-authentication, once per pooled client, so an implementation
+// fetching credentials from elsewhere (e.g. a vault or a token exchange)
+// should fetch them anew each time.
+type Authenticator interface {
+	Authenticate() (*gophercloud.ServiceClient, time.Time, error)
+}
+
+// envAuthenticator is the default Authenticator: it authenticates from the
+// OS_* environment, re-applying the env file first.
+type envAuthenticator struct {
+	envWatcher *envFileWatcher
+	userAgent  string
+}
+
+// Authenticate implements Authenticator.
+func (a envAuthenticator) Authenticate() (*gophercloud.ServiceClient, time.Time, error) {
+	return authenticateNeutron(a.envWatcher, a.userAgent)
+}
+
+// authenticateNeutron re-applies the env file (a nil envWatcher is skipped),
+// then authenticates from the OS_* environment and builds a Neutron client
+// sending userAgent. It returns the client and its token's expiry.
+func authenticateNeutron(envWatcher *envFileWatcher, userAgent string) (*gophercloud.ServiceClient, time.Time, error) {
+	if _, err := envWatcher.load(); err != nil {
+		return nil, time.Time{}, err
+	}
+	opts, err := buildAuthOpts()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read OS_* env vars: %w", err)
+	}
+	start := time.Now()
+	provider, err := newProviderClient(opts.IdentityEndpoint, userAgent)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to create OpenStack client: %w", err)
+	}
+	if err := openstack.Authenticate(provider, opts); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to authenticate with OpenStack: %w", err)
+	}
+	client, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to create Neutron client: %w", err)
+	}
+	allocationLatency.since(phaseAuth, start)
+	return client, tokenExpiry(provider), nil
+}
+
+// newProviderClient returns an unauthenticated provider client for the
+// Keystone endpoint whose requests carry userAgent ahead of gophercloud's
+// own, so Neutron and Keystone logs attribute the load to the daemon. Each
+// request is traced.
+func newProviderClient(endpoint, userAgent string) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient.Transport = tracingTransport{base: provider.HTTPClient.Transport}
+	if userAgent != "" {
+		provider.UserAgent.Prepend(userAgent)
+	}
+	return provider, nil
+}
+
+// tokenExpiry returns the expiry of provider's Keystone v3 token, or the
+// zero time when it is not known.
+func tokenExpiry(provider *gophercloud.ProviderClient) time.Time {
+	result, ok := provider.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return time.Time{}
+	}
+	token, err := result.ExtractToken()
+	if err != nil {
+		return time.Time{}
+	}
+	return token.ExpiresAt
+}
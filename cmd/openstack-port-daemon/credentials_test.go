@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	data := []byte(`# OpenStack credentials
+OS_AUTH_URL=http://keystone.example.com/v3
+export OS_USERNAME=admin
+OS_PASSWORD="s3cr=t"
+OS_PROJECT_NAME='demo'
+
+OS_REGION_NAME =  RegionOne
+`)
+	got, err := parseEnvFile(data)
+	if err != nil {
+		t.Fatalf("parseEnvFile() error = %v", err)
+	}
+	want := map[string]string{
+		"OS_AUTH_URL":     "http://keystone.example.com/v3",
+		"OS_USERNAME":     "admin",
+		"OS_PASSWORD":     "s3cr=t",
+		"OS_PROJECT_NAME": "demo",
+		"OS_REGION_NAME":  "RegionOne",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEnvFile() = %v, want %v", got, want)
+	}
+}
+
+func TestParseEnvFileInvalidLine(t *testing.T) {
+	for _, data := range []string{"OS_USERNAME", "=value"} {
+		if _, err := parseEnvFile([]byte(data)); err == nil {
+			t.Errorf("parseEnvFile(%q) expected error, got nil", data)
+		}
+	}
+}
+
+func TestEnvFileWatcherLoad(t *testing.T) {
+	t.Setenv("OS_USERNAME", "")
+	t.Setenv("OS_PASSWORD", "")
+	path := filepath.Join(t.TempDir(), "creds.env")
+	if err := os.WriteFile(path, []byte("OS_USERNAME=admin\nOS_PASSWORD=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newEnvFileWatcher(path)
+	changed, err := w.load()
+	if err != nil || !changed {
+		t.Fatalf("first load() = %v, %v; want true, nil", changed, err)
+	}
+	if got := os.Getenv("OS_PASSWORD"); got != "old" {
+		t.Errorf("OS_PASSWORD = %q, want %q", got, "old")
+	}
+
+	changed, err = w.load()
+	if err != nil || changed {
+		t.Fatalf("unchanged load() = %v, %v; want false, nil", changed, err)
+	}
+
+	// Rotation drops OS_USERNAME and changes OS_PASSWORD.
+	if err := os.WriteFile(path, []byte("OS_PASSWORD=new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = w.load()
+	if err != nil || !changed {
+		t.Fatalf("rotated load() = %v, %v; want true, nil", changed, err)
+	}
+	if got := os.Getenv("OS_PASSWORD"); got != "new" {
+		t.Errorf("OS_PASSWORD = %q, want %q", got, "new")
+	}
+	if _, ok := os.LookupEnv("OS_USERNAME"); ok {
+		t.Error("OS_USERNAME still set after being removed from the env file")
+	}
+}
+
+func TestParseEnvFileTruncatedQuote(t *testing.T) {
+	for _, data := range []string{"OS_PASSWORD=\"s3cr", "OS_PASSWORD='", "OS_PASSWORD=\""} {
+		if _, err := parseEnvFile([]byte(data)); err == nil {
+			t.Errorf("parseEnvFile(%q) expected error, got nil", data)
+		}
+	}
+}
+
+func TestEnvFileWatcherLoadPartialFile(t *testing.T) {
+	t.Setenv("OS_USERNAME", "")
+	t.Setenv("OS_PASSWORD", "")
+	path := filepath.Join(t.TempDir(), "creds.env")
+	if err := os.WriteFile(path, []byte("OS_USERNAME=admin\nOS_PASSWORD=\"old\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w := newEnvFileWatcher(path)
+	if _, err := w.load(); err != nil {
+		t.Fatalf("first load() error = %v", err)
+	}
+
+	// A rotation caught half-written: the new password's closing quote
+	// and the next line have not landed yet.
+	if err := os.WriteFile(path, []byte("OS_USERNAME=rotated\nOS_PASSWORD=\"ne"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := w.load()
+	if err == nil || changed {
+		t.Fatalf("partial load() = %v, %v; want false, error", changed, err)
+	}
+	if got := os.Getenv("OS_USERNAME"); got != "admin" {
+		t.Errorf("OS_USERNAME = %q after partial file, want %q", got, "admin")
+	}
+	if got := os.Getenv("OS_PASSWORD"); got != "old" {
+		t.Errorf("OS_PASSWORD = %q after partial file, want %q", got, "old")
+	}
+
+	// Once the write completes the new content is applied.
+	if err := os.WriteFile(path, []byte("OS_USERNAME=rotated\nOS_PASSWORD=\"new\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = w.load()
+	if err != nil || !changed {
+		t.Fatalf("completed load() = %v, %v; want true, nil", changed, err)
+	}
+	if got := os.Getenv("OS_PASSWORD"); got != "new" {
+		t.Errorf("OS_PASSWORD = %q, want %q", got, "new")
+	}
+}
+
+func TestEnvFileUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.env")
+	if err := os.WriteFile(path, []byte("OS_PASSWORD=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envFileUnchanged(before, before) {
+		t.Error("envFileUnchanged() = false for identical stats")
+	}
+
+	if err := os.WriteFile(path, []byte("OS_PASSWORD=old\nOS_USERNAME=ad"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envFileUnchanged(before, after) {
+		t.Error("envFileUnchanged() = true after the file grew")
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.env")
+	if err := os.WriteFile(path, []byte("OS_PASSWORD=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readEnvFile(path)
+	if err != nil {
+		t.Fatalf("readEnvFile() error = %v", err)
+	}
+	if string(data) != "OS_PASSWORD=old\n" {
+		t.Errorf("readEnvFile() = %q, want %q", data, "OS_PASSWORD=old\n")
+	}
+}
+
+func TestEnvFileWatcherLoadMissingFile(t *testing.T) {
+	w := newEnvFileWatcher(filepath.Join(t.TempDir(), "missing.env"))
+	if _, err := w.load(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+// fakeKeystone serves Keystone v3 password authentication, with a catalog
+// listing a network endpoint under /network/, and records the passwords it
+// was given. Tokens expire at expiresAt.
+type fakeKeystone struct {
+	*httptest.Server
+	expiresAt time.Time
+	mu        sync.Mutex
+	passwords []string
+}
+
+func newFakeKeystone(t *testing.T) *fakeKeystone {
+	t.Helper()
+	k := &fakeKeystone{expiresAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+	k.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/auth/tokens" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		k.mu.Lock()
+		k.passwords = append(k.passwords, body.Auth.Identity.Password.User.Password)
+		k.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Subject-Token", "token-"+body.Auth.Identity.Password.User.Password)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token": map[string]interface{}{
+				"expires_at": k.expiresAt.Format(time.RFC3339),
+				"catalog": []interface{}{
+					map[string]interface{}{
+						"type": "network",
+						"name": "neutron",
+						"endpoints": []interface{}{
+							map[string]interface{}{
+								"id":        "network-public",
+								"interface": "public",
+								"region":    "RegionOne",
+								"region_id": "RegionOne",
+								"url":       k.URL + "/network/",
+							},
+						},
+					},
+				},
+			},
+		})
+	}))
+	t.Cleanup(k.Close)
+	return k
+}
+
+func (k *fakeKeystone) seen() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.passwords...)
+}
+
+// clearOSEnv unsets the OS_* variables gophercloud reads so tests only see
+// the credentials they set.
+func clearOSEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"OS_AUTH_URL", "OS_USERNAME", "OS_PASSWORD", "OS_PROJECT_NAME", "OS_DOMAIN_NAME",
+		"OS_USER_DOMAIN_NAME", "OS_PROJECT_DOMAIN_NAME", "OS_TOKEN", "OS_TENANT_ID",
+		"OS_TENANT_NAME", "OS_PROJECT_ID", "OS_DOMAIN_ID", "OS_USER_DOMAIN_ID",
+		"OS_PROJECT_DOMAIN_ID", "OS_APPLICATION_CREDENTIAL_ID",
+		"OS_APPLICATION_CREDENTIAL_NAME", "OS_APPLICATION_CREDENTIAL_SECRET", "OS_AUTH_TYPE",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+// writeCreds writes an env file authenticating against keystone.
+func writeCreds(t *testing.T, keystone *fakeKeystone, path, password string) {
+	t.Helper()
+	content := "OS_AUTH_URL=" + keystone.URL + "/v3\n" +
+		"OS_USERNAME=admin\n" +
+		"OS_PASSWORD=" + password + "\n" +
+		"OS_PROJECT_NAME=demo\n" +
+		"OS_DOMAIN_NAME=Default\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticateNeutron(t *testing.T) {
+	keystone := newFakeKeystone(t)
+	clearOSEnv(t)
+	path := filepath.Join(t.TempDir(), "creds.env")
+	writeCreds(t, keystone, path, "secret")
+
+	client, expiresAt, err := authenticateNeutron(newEnvFileWatcher(path), "")
+	if err != nil {
+		t.Fatalf("authenticateNeutron() error = %v", err)
+	}
+	if want := keystone.URL + "/network/v2.0/"; client.ResourceBaseURL() != want {
+		t.Errorf("client endpoint = %q, want %q", client.ResourceBaseURL(), want)
+	}
+	if !expiresAt.Equal(keystone.expiresAt) {
+		t.Errorf("expiresAt = %v, want %v", expiresAt, keystone.expiresAt)
+	}
+	if got := client.ProviderClient.Token(); got != "token-secret" {
+		t.Errorf("token = %q, want token-secret", got)
+	}
+}
+
+func TestNewProviderClientUserAgent(t *testing.T) {
+	provider, err := newProviderClient("http://keystone.example:5000/v3", "openstack-port-cni/1.2.3")
+	if err != nil {
+		t.Fatalf("newProviderClient() error = %v", err)
+	}
+	if got := provider.UserAgent.Join(); !strings.HasPrefix(got, "openstack-port-cni/1.2.3 ") {
+		t.Errorf("UserAgent = %q, want it to start with openstack-port-cni/1.2.3", got)
+	}
+}
+
+func TestEnvFileRotationTriggersReauth(t *testing.T) {
+	keystone := newFakeKeystone(t)
+	clearOSEnv(t)
+
+	path := filepath.Join(t.TempDir(), "creds.env")
+	writeCreds(t, keystone, path, "old-password")
+
+	w := newEnvFileWatcher(path)
+	rebuild := func() (*gophercloud.ServiceClient, time.Time, error) {
+		return authenticateNeutron(w, "")
+	}
+	initial, _, err := rebuild()
+	if err != nil {
+		t.Fatalf("authenticateNeutron() error = %v", err)
+	}
+	clients := newNeutronClientRef(initial, rebuild)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rotated := make(chan error, 1)
+	go w.watch(ctx, 10*time.Millisecond, func() error {
+		_, err := clients.reload()
+		rotated <- err
+		return err
+	})
+
+	writeCreds(t, keystone, path, "new-password")
+	select {
+	case err := <-rotated:
+		if err != nil {
+			t.Fatalf("reload() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("env file change did not trigger re-authentication")
+	}
+
+	seen := keystone.seen()
+	if len(seen) == 0 || seen[len(seen)-1] != "new-password" {
+		t.Errorf("keystone passwords = %v, want last to be new-password", seen)
+	}
+	provider := clients.get().ProviderClient
+	if got := provider.Token(); got != "token-new-password" {
+		t.Errorf("provider token = %q, want %q", got, "token-new-password")
+	}
+
+	// Later automatic renewals keep using the rotated credentials.
+	if err := provider.Reauthenticate(provider.Token()); err != nil {
+		t.Fatalf("Reauthenticate() error = %v", err)
+	}
+	seen = keystone.seen()
+	if seen[len(seen)-1] != "new-password" {
+		t.Errorf("renewal used password %q, want new-password", seen[len(seen)-1])
+	}
+}
+
+// fakeAuthenticator hands out clients in order, failing with err when set,
+// and counts its calls.
+type fakeAuthenticator struct {
+	clients   []*gophercloud.ServiceClient
+	expiresAt time.Time
+	err       error
+	calls     int
+}
+
+func (a *fakeAuthenticator) Authenticate() (*gophercloud.ServiceClient, time.Time, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, time.Time{}, a.err
+	}
+	client := a.clients[0]
+	if len(a.clients) > 1 {
+		a.clients = a.clients[1:]
+	}
+	return client, a.expiresAt, nil
+}
+
+func TestNewHandlerWithAuthenticator(t *testing.T) {
+	t.Run("ReauthCallsAuthenticator", func(t *testing.T) {
+		first := &gophercloud.ServiceClient{Endpoint: "http://first/"}
+		second := &gophercloud.ServiceClient{Endpoint: "http://second/"}
+		auth := &fakeAuthenticator{
+			clients:   []*gophercloud.ServiceClient{first, second},
+			expiresAt: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		handler, err := newHandlerWithAuthenticator(auth, defaultDaemonConfig())
+		if err != nil {
+			t.Fatalf("newHandlerWithAuthenticator() error = %v", err)
+		}
+		if auth.calls != 1 {
+			t.Fatalf("Authenticate calls = %d, want 1", auth.calls)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reauth", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if auth.calls != 2 {
+			t.Errorf("Authenticate calls = %d, want 2", auth.calls)
+		}
+		if !strings.Contains(rec.Body.String(), "2030-01-02T03:04:05Z") {
+			t.Errorf("body = %s, want expires_at 2030-01-02T03:04:05Z", rec.Body.String())
+		}
+	})
+
+	t.Run("InitialFailure", func(t *testing.T) {
+		auth := &fakeAuthenticator{err: errors.New("vault sealed")}
+		if _, err := newHandlerWithAuthenticator(auth, defaultDaemonConfig()); err == nil || !strings.Contains(err.Error(), "vault sealed") {
+			t.Errorf("newHandlerWithAuthenticator() error = %v, want vault sealed", err)
+		}
+	})
+}
+
+func TestNewAuthenticatedClientRef(t *testing.T) {
+	first := &gophercloud.ServiceClient{Endpoint: "http://first/"}
+	second := &gophercloud.ServiceClient{Endpoint: "http://second/"}
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	auth := &fakeAuthenticator{clients: []*gophercloud.ServiceClient{first, second}, expiresAt: now.Add(time.Minute)}
+
+	clients, err := newAuthenticatedClientRef(auth, 1)
+	if err != nil {
+		t.Fatalf("newAuthenticatedClientRef() error = %v", err)
+	}
+	if clients.get() != first {
+		t.Fatal("client is not the authenticator's")
+	}
+
+	// The initial token's expiry is recorded, so a token about to expire is
+	// refreshed through the authenticator.
+	clients.now = func() time.Time { return now }
+	refreshed, err := clients.refreshIfExpiring(5 * time.Minute)
+	if err != nil || !refreshed {
+		t.Fatalf("refreshIfExpiring() = %v, %v, want true, nil", refreshed, err)
+	}
+	if clients.get() != second {
+		t.Error("client was not rebuilt by the authenticator")
+	}
+
+	auth.err = errors.New("vault sealed")
+	if _, err := clients.reload(); err == nil {
+		t.Fatal("reload() error = nil, want the authenticator's")
+	}
+	if clients.get() != second {
+		t.Error("client was dropped after a failed reload")
+	}
+}
+
+func TestNewAuthenticatedClientRefPool(t *testing.T) {
+	a1 := &gophercloud.ServiceClient{Endpoint: "http://a1/"}
+	a2 := &gophercloud.ServiceClient{Endpoint: "http://a2/"}
+	b1 := &gophercloud.ServiceClient{Endpoint: "http://b1/"}
+	b2 := &gophercloud.ServiceClient{Endpoint: "http://b2/"}
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	auth := &fakeAuthenticator{clients: []*gophercloud.ServiceClient{a1, a2, b1, b2}, expiresAt: expiresAt}
+
+	clients, err := newAuthenticatedClientRef(auth, 2)
+	if err != nil {
+		t.Fatalf("newAuthenticatedClientRef() error = %v", err)
+	}
+	if auth.calls != 2 {
+		t.Errorf("Authenticate calls = %d, want one per pooled client", auth.calls)
+	}
+	for i, want := range []*gophercloud.ServiceClient{a1, a2, a1, a2} {
+		if got := clients.get(); got != want {
+			t.Errorf("get() %d = %s, want %s", i+1, got.Endpoint, want.Endpoint)
+		}
+	}
+
+	// A reload rebuilds the whole pool.
+	got, err := clients.reload()
+	if err != nil || !got.Equal(expiresAt) {
+		t.Fatalf("reload() = %v, %v, want %v, nil", got, err, expiresAt)
+	}
+	seen := map[*gophercloud.ServiceClient]bool{clients.get(): true, clients.get(): true}
+	if !seen[b1] || !seen[b2] {
+		t.Errorf("pool after reload = %v, want b1 and b2", seen)
+	}
+}
+
+func TestNeutronClientPoolSpreadsRequests(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	var mu sync.Mutex
+	tokens := make(map[string]int)
+	record := func(r *http.Request) {
+		mu.Lock()
+		tokens[r.Header.Get("X-Auth-Token")]++
+		mu.Unlock()
+	}
+	created := 0
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		mu.Lock()
+		created++
+		id := created
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"port": {"id": "port-%d", "mac_address": "fa:16:3e:00:00:%02x", "network_id": "net-uuid",
+			"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.%d"}]}}`, id, id, 10+id)
+	}))
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+	th.Mux.HandleFunc("/networks/net-uuid", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"network": {"id": "net-uuid", "mtu": 1450}}`))
+	})
+
+	clientWithToken := func(token string) *gophercloud.ServiceClient {
+		client := thclient.ServiceClient()
+		client.TokenID = token
+		return client
+	}
+	auth := &fakeAuthenticator{clients: []*gophercloud.ServiceClient{clientWithToken("token-a"), clientWithToken("token-b")}}
+	cfg := defaultDaemonConfig()
+	cfg.NeutronClientPoolSize = 2
+	handler, err := newHandlerWithAuthenticator(auth, cfg)
+	if err != nil {
+		t.Fatalf("newHandlerWithAuthenticator() error = %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"container_id":"abcdef123456000%d","network_id":"net-uuid","subnet_id":"subnet-uuid"}`, i)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ADD %d status = %d, body: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	if len(tokens) != 2 || tokens["token-a"] == 0 || tokens["token-b"] == 0 {
+		t.Errorf("Neutron calls by token = %v, want both pooled clients used", tokens)
+	}
+}
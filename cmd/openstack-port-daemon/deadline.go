@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// neutronDeadlineKey carries, in a request context, a context expiring at
+// the request deadline but not cancelled with the request.
+type neutronDeadlineKey struct{}
+
+// detachedDeadline has the values of a request context, is not cancelled
+// when the caller goes away, and expires with expiry.
+type detachedDeadline struct {
+	context.Context
+	expiry context.Context
+}
+
+func (c detachedDeadline) Deadline() (time.Time, bool) { return c.expiry.Deadline() }
+func (c detachedDeadline) Done() <-chan struct{}       { return c.expiry.Done() }
+func (c detachedDeadline) Err() error                  { return c.expiry.Err() }
+
+// neutronContext returns the context of the Neutron calls of the request
+// ctx belongs to. A caller going away does not cut them midway, but they
+// stop at the deadline guardDeadline set.
+func neutronContext(ctx context.Context) context.Context {
+	detached := context.WithoutCancel(ctx)
+	if expiry, ok := ctx.Value(neutronDeadlineKey{}).(context.Context); ok {
+		return detachedDeadline{Context: detached, expiry: expiry}
+	}
+	return detached
+}
+
+// deadlineWriter buffers the response of a request run by guardDeadline,
+// which sends it once the handler returns, or answers 504 instead when the
+// deadline passes first.
+type deadlineWriter struct {
+	mu        sync.Mutex
+	buf       responseBuffer
+	committed bool
+	timedOut  bool
+	finished  bool
+}
+
+// Header returns the buffered header, which the handler alone sets.
+func (w *deadlineWriter) Header() http.Header { return w.buf.header }
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.buf.Write(p)
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.timedOut {
+		w.buf.WriteHeader(status)
+	}
+}
+
+// commit reports whether the deadline has not passed yet, and if so keeps
+// it from answering 504 once it does.
+func (w *deadlineWriter) commit() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return false
+	}
+	w.committed = true
+	return true
+}
+
+// expire drops the handler's answer, unless it was committed or the
+// handler returned, and reports whether it did.
+func (w *deadlineWriter) expire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed || w.finished {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
+
+// finish records that the handler returned and reports whether its answer
+// was dropped by then.
+func (w *deadlineWriter) finish() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished = true
+	return w.timedOut
+}
+
+// commitResponse reports whether a handler that did work needing undoing on
+// failure, such as creating a port, may still answer: false means the
+// request deadline passed and the caller was answered 504, so the handler
+// must undo its work. Once it reports true the handler's answer is sent
+// even if the deadline passes while it is written.
+func commitResponse(w http.ResponseWriter) bool {
+	if dw, ok := w.(*deadlineWriter); ok {
+		return dw.commit()
+	}
+	return true
+}
+
+// guardDeadline runs next with its request context expiring after timeout
+// and answers 504 Gateway Timeout when next has not answered by then. next
+// keeps running to the end, undoing its work, and its late answer is
+// dropped; it should hold any resource it bounds, such as a request limiter
+// slot, until then. The Neutron calls of next, which do not follow the
+// request's cancellation, stop at the deadline too (see neutronContext). A
+// request cancelled by its caller is left to finish as without the guard.
+func guardDeadline(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		expiry, cancelExpiry := context.WithDeadline(context.Background(), deadline)
+		ctx = context.WithValue(ctx, neutronDeadlineKey{}, expiry)
+		dw := &deadlineWriter{buf: responseBuffer{header: make(http.Header)}}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer close(done)
+			defer cancelExpiry()
+			defer func() {
+				p := recover()
+				// Past the deadline nobody waits for the handler, so its
+				// panic is logged rather than raised.
+				if dw.finish() {
+					if p != nil {
+						log.Printf("ERROR %s %s panicked after its deadline: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+					}
+					return
+				}
+				if p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(dw, r.WithContext(ctx))
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			select {
+			case <-done:
+			default:
+				if ctx.Err() == context.DeadlineExceeded && dw.expire() {
+					log.Printf("ERROR %s %s did not complete within %s", r.Method, r.URL.Path, timeout)
+					writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("request did not complete within %s", timeout))
+					return
+				}
+				<-done
+			}
+		}
+		select {
+		case p := <-panicked:
+			panic(p)
+		default:
+		}
+
+		for key, values := range dw.buf.header {
+			w.Header()[key] = values
+		}
+		if dw.buf.status != 0 {
+			w.WriteHeader(dw.buf.status)
+		}
+		_, _ = w.Write(dw.buf.body.Bytes())
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func TestGuardDeadline(t *testing.T) {
+	t.Run("InTime", func(t *testing.T) {
+		handler := guardDeadline(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); !ok {
+				t.Error("request context has no deadline")
+			}
+			w.Header().Set("Retry-After", "3")
+			writeError(w, http.StatusTooManyRequests, "busy")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", nil))
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3" || rec.Body.Len() == 0 {
+			t.Errorf("status = %d, headers = %v, body: %s, want the handler's answer", rec.Code, rec.Header(), rec.Body.String())
+		}
+	})
+
+	t.Run("Exceeded", func(t *testing.T) {
+		finished := make(chan error, 1)
+		handler := guardDeadline(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			time.Sleep(10 * time.Millisecond)
+			_, err := w.Write([]byte("late"))
+			finished <- err
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", nil))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+		}
+		if err := <-finished; err != http.ErrHandlerTimeout {
+			t.Errorf("late write error = %v, want %v", err, http.ErrHandlerTimeout)
+		}
+	})
+
+	t.Run("Committed", func(t *testing.T) {
+		handler := guardDeadline(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !commitResponse(w) {
+				t.Error("commitResponse() = false before the deadline")
+			}
+			<-r.Context().Done()
+			writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want the committed answer %d", rec.Code, http.StatusOK)
+		}
+	})
+}
+
+// syncBuffer is a buffer safe to read while the log package writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestGuardDeadlinePanicAfterDeadline(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	handler := guardDeadline(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		panic("late failure")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "panicked after its deadline: late failure") {
+		if time.Now().After(deadline) {
+			t.Fatalf("panic after the deadline not logged, logs: %s", logs.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNeutronContext(t *testing.T) {
+	t.Run("Deadline", func(t *testing.T) {
+		errs := make(chan error, 1)
+		handler := guardDeadline(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := neutronContext(r.Context())
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("Neutron context has no deadline")
+			}
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			case <-time.After(time.Second):
+				errs <- nil
+			}
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/add", nil))
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Errorf("Neutron context error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("CallerGone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		handler := guardDeadline(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			if err := neutronContext(r.Context()).Err(); err != nil {
+				t.Errorf("Neutron context error = %v once the caller went away, want none", err)
+			}
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/add", nil).WithContext(ctx))
+	})
+}
+
+func TestAddDeadlineExceededKeepsLimiterSlot(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	fake.createDelay = 300 * time.Millisecond
+	cfg := defaultDaemonConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	cfg.MaxConcurrentRequests, cfg.MaxQueueDepth = 1, 0
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+	add := func(containerID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+			bytes.NewBufferString(`{"container_id":"`+containerID+`","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)))
+		return rec
+	}
+	if rec := add("aaaaaaaaaaaa0001"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("slow ADD status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	// The timed-out ADD still runs, so it still holds the only slot.
+	if rec := add("aaaaaaaaaaaa0002"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("ADD during the timed-out one status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAddDeadlineExceededCleansUp(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	// A cached subnet takes the lookup, which would fail past the deadline,
+	// out of the way, so the ADD reaches its answer with the port created.
+	cfg.LookupCacheSize = 8
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+	add := func(containerID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+			bytes.NewBufferString(`{"container_id":"`+containerID+`","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)))
+		return rec
+	}
+	if rec := add("aaaaaaaaaaaa0001"); rec.Code != http.StatusOK {
+		t.Fatalf("ADD status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	fake.mu.Lock()
+	fake.createDelay = 100 * time.Millisecond
+	fake.mu.Unlock()
+	if rec := add("aaaaaaaaaaaa0002"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("slow ADD status = %d, want %d, body: %s", rec.Code, http.StatusGatewayTimeout, rec.Body.String())
+	}
+
+	// The handler finishes in the background and deletes the port it made.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		created, left := fake.created, len(fake.ports)
+		fake.mu.Unlock()
+		if created == 2 && left == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d ports created, %d left, want the slow ADD's port deleted", created, left)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
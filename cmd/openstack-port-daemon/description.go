@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Port description template variables, written {name} in the template.
+const (
+	descriptionVarNamespace   = "namespace"
+	descriptionVarPod         = "pod"
+	descriptionVarNode        = "node"
+	descriptionVarContainerID = "container_id"
+	descriptionVarNetworkID   = "network_id"
+)
+
+// descriptionUnknown replaces a template variable whose value is unknown,
+// e.g. the pod of a request from a runtime passing no CNI_ARGS.
+const descriptionUnknown = "unknown"
+
+// maxDescriptionLength is the longest port description, in characters,
+// Neutron accepts.
+const maxDescriptionLength = 255
+
+// descriptionTemplate renders the description of the ports the daemon
+// creates from pod metadata, e.g. "{namespace}/{pod} on {node}". A nil
+// *descriptionTemplate renders no description.
+type descriptionTemplate struct {
+	text string
+	// node is the daemon's hostname, substituted for {node}.
+	node string
+}
+
+// parseDescriptionTemplate checks that every {variable} of text is known and
+// every brace closed, and returns its template rendering {node} as node.
+func parseDescriptionTemplate(text, node string) (*descriptionTemplate, error) {
+	rest := text
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("unexpected } in %q", text)
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] == '{' {
+			return nil, fmt.Errorf("unclosed { in %q", text)
+		}
+		switch name := rest[open+1 : open+1+end]; name {
+		case descriptionVarNamespace, descriptionVarPod, descriptionVarNode, descriptionVarContainerID, descriptionVarNetworkID:
+		default:
+			return nil, fmt.Errorf("unknown variable {%s} in %q (want {%s}, {%s}, {%s}, {%s} or {%s})", name, text,
+				descriptionVarNamespace, descriptionVarPod, descriptionVarNode, descriptionVarContainerID, descriptionVarNetworkID)
+		}
+		rest = rest[open+1+end+1:]
+	}
+	return &descriptionTemplate{text: text, node: node}, nil
+}
+
+// render returns the description of the port of a request, with unknown
+// values rendered as "unknown" and cut to the length Neutron accepts.
+func (t *descriptionTemplate) render(namespace, pod, containerID, networkID string) string {
+	if t == nil {
+		return ""
+	}
+	value := func(v string) string {
+		if v == "" {
+			return descriptionUnknown
+		}
+		return v
+	}
+	description := strings.NewReplacer(
+		"{"+descriptionVarNamespace+"}", value(namespace),
+		"{"+descriptionVarPod+"}", value(pod),
+		"{"+descriptionVarNode+"}", value(t.node),
+		"{"+descriptionVarContainerID+"}", value(containerID),
+		"{"+descriptionVarNetworkID+"}", value(networkID),
+	).Replace(t.text)
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		description = string(runes[:maxDescriptionLength])
+	}
+	return description
+}
+
+// String returns the template text, for the startup log.
+func (t *descriptionTemplate) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.text
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestParseDescriptionTemplate(t *testing.T) {
+	for _, text := range []string{
+		"{namespace}/{pod} on {node}",
+		"container {container_id} on network {network_id}",
+		"managed by openstack-port-cni",
+	} {
+		if _, err := parseDescriptionTemplate(text, "node-1"); err != nil {
+			t.Errorf("parseDescriptionTemplate(%q) error = %v", text, err)
+		}
+	}
+	for _, text := range []string{
+		"{namespace}/{pod_name}",
+		"{namespace",
+		"namespace}",
+		"{{pod}}",
+		"{}",
+	} {
+		if _, err := parseDescriptionTemplate(text, "node-1"); err == nil {
+			t.Errorf("parseDescriptionTemplate(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestDescriptionTemplateRender(t *testing.T) {
+	tmpl, err := parseDescriptionTemplate("{namespace}/{pod} on {node} ({container_id}, {network_id})", "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		node      string
+		namespace string
+		pod       string
+		want      string
+	}{
+		{"all metadata", "node-1", "default", "web-0", "default/web-0 on node-1 (abcdef1234567890, net-uuid)"},
+		{"no pod metadata", "node-1", "", "", "unknown/unknown on node-1 (abcdef1234567890, net-uuid)"},
+		{"no hostname", "", "default", "web-0", "default/web-0 on unknown (abcdef1234567890, net-uuid)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl.node = tt.node
+			if got := tmpl.render(tt.namespace, tt.pod, "abcdef1234567890", "net-uuid"); got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long, err := parseDescriptionTemplate(strings.Repeat("é", 250)+"{pod}", "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []rune(long.render("default", "web-0123456789", "abcdef1234567890", "net-uuid")); len(got) != maxDescriptionLength {
+		t.Errorf("rendered %d characters, want %d", len(got), maxDescriptionLength)
+	}
+
+	var none *descriptionTemplate
+	if got := none.render("default", "web-0", "abcdef1234567890", "net-uuid"); got != "" {
+		t.Errorf("nil template rendered %q, want no description", got)
+	}
+}
+
+func TestAddPortDescription(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.PortDescription, _ = parseDescriptionTemplate("{namespace}/{pod} on {node}", "node-1")
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+
+	for body, want := range map[string]string{
+		`{"container_id":"aaaaaaaaaaaa0001","network_id":"net-uuid","subnet_id":"subnet-uuid","pod_namespace":"default","pod_name":"web-0"}`: "default/web-0 on node-1",
+		`{"container_id":"aaaaaaaaaaaa0002","network_id":"net-uuid","subnet_id":"subnet-uuid"}`:                                              "unknown/unknown on node-1",
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ADD status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := fake.ports[resp.PortID].Description; got != want {
+			t.Errorf("description of port %s = %q, want %q", resp.PortID, got, want)
+		}
+	}
+}
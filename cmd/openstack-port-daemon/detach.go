@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"openstack-port/internal/api"
+)
+
+// detachedPortName is the name of ports a detach-only DEL kept. They hold
+// their addresses until an ADD asking for one of them adopts the port.
+const detachedPortName = "k8s-detached"
+
+// portUpdateOpts wraps ports.UpdateOpts and sends a non-nil HostID as
+// binding:host_id, an empty one clearing the binding, a non-nil
+// QoSPolicyID as qos_policy_id, an empty one clearing the policy, and a
+// non-nil BindingProfile as for portCreateOpts.
+type portUpdateOpts struct {
+	ports.UpdateOpts
+	HostID         *string
+	QoSPolicyID    *string
+	BindingProfile *api.BindingProfile
+}
+
+// ToPortUpdateMap implements ports.UpdateOptsBuilder.
+func (opts portUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	body, err := opts.UpdateOpts.ToPortUpdateMap()
+	if err != nil {
+		return nil, err
+	}
+	port := body["port"].(map[string]interface{})
+	if opts.HostID != nil {
+		port["binding:host_id"] = *opts.HostID
+	}
+	if opts.QoSPolicyID != nil {
+		if *opts.QoSPolicyID == "" {
+			port["qos_policy_id"] = nil
+		} else {
+			port["qos_policy_id"] = *opts.QoSPolicyID
+		}
+	}
+	if opts.BindingProfile != nil {
+		port["binding:vnic_type"] = remoteManagedVNICType
+		port["binding:profile"] = bindingProfileMap(opts.BindingProfile)
+	}
+	return body, nil
+}
+
+// detachPort unbinds port instead of deleting it: it is renamed to
+// detachedPortName, set down and its binding:host_id and device_id are
+// cleared, so Neutron keeps its addresses. With clearQoS its QoS policy,
+// named after the port being released, is cleared so that it can be
+// deleted.
+func detachPort(portClient NeutronPortClient, port ports.Port, clearQoS bool) error {
+	name, deviceID, hostID, adminStateUp := detachedPortName, "", "", false
+	opts := portUpdateOpts{
+		UpdateOpts: ports.UpdateOpts{Name: &name, DeviceID: &deviceID, AdminStateUp: &adminStateUp},
+		HostID:     &hostID,
+	}
+	if clearQoS {
+		cleared := ""
+		opts.QoSPolicyID = &cleared
+	}
+	_, err := portClient.Update(port.ID, opts)
+	return err
+}
+
+// findDetachedPort returns the detached port on networkID holding
+// ipAddress, or nil when there is none.
+func findDetachedPort(portClient NeutronPortClient, networkID, ipAddress string) (*ports.Port, error) {
+	detached, err := portClient.List(ports.ListOpts{
+		Name:      detachedPortName,
+		NetworkID: networkID,
+		FixedIPs:  []ports.FixedIPOpts{{IPAddress: ipAddress}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range detached {
+		for _, ip := range p.FixedIPs {
+			if ip.IPAddress == ipAddress {
+				return &p, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// adoptPort binds a detached port to containerID as the ADD would have
+// created it with opts: it takes the name, description, security groups,
+// binding, QoS policy, admin state and tags of opts, and its device_id is
+// set as for a pool port. The tags are replaced first, so that a failure
+// leaves the port detached. Security groups and binding profile are kept
+// when opts sets none, as updating the latter needs admin rights.
+func adoptPort(neutronClient *gophercloud.ServiceClient, portClient NeutronPortClient, port *ports.Port, containerID string, opts portCreateOpts) (*ports.Port, error) {
+	if len(opts.Tags) > 0 || len(port.Tags) > 0 {
+		if _, err := attributestags.ReplaceAll(neutronClient, "ports", port.ID, attributestags.ReplaceAllOpts{Tags: opts.Tags}).Extract(); err != nil {
+			return nil, err
+		}
+	}
+	adminStateUp := opts.AdminStateUp == nil || *opts.AdminStateUp
+	updateOpts := portUpdateOpts{
+		UpdateOpts: ports.UpdateOpts{
+			Name:           &opts.Name,
+			DeviceID:       &containerID,
+			AdminStateUp:   &adminStateUp,
+			SecurityGroups: opts.SecurityGroups,
+		},
+		BindingProfile: opts.BindingProfile,
+	}
+	if opts.Description != "" {
+		updateOpts.Description = &opts.Description
+	}
+	if opts.HostID != "" {
+		updateOpts.HostID = &opts.HostID
+	}
+	if opts.QoSPolicyID != "" {
+		updateOpts.QoSPolicyID = &opts.QoSPolicyID
+	}
+	return portClient.Update(port.ID, updateOpts)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestPortUpdateOptsHostID(t *testing.T) {
+	name, empty := "k8s-pod-abcdef123456", ""
+	tests := []struct {
+		hostID *string
+		want   interface{}
+		set    bool
+	}{
+		{hostID: nil},
+		{hostID: &empty, want: "", set: true},
+	}
+	for _, tt := range tests {
+		body, err := portUpdateOpts{UpdateOpts: ports.UpdateOpts{Name: &name}, HostID: tt.hostID}.ToPortUpdateMap()
+		if err != nil {
+			t.Fatalf("ToPortUpdateMap() error = %v", err)
+		}
+		got, set := body["port"].(map[string]interface{})["binding:host_id"]
+		if set != tt.set || got != tt.want {
+			t.Errorf("binding:host_id = %v (set %t), want %v (set %t)", got, set, tt.want, tt.set)
+		}
+	}
+}
+
+func TestDelDetachOnly(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": [{"id": "port-a", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+	})
+	var update map[string]interface{}
+	th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected %s on port-a, want only a PUT", r.Method)
+		}
+		var body struct {
+			Port map[string]interface{} `json:"port"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode update body: %v", err)
+		}
+		update = body.Port
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-a", "name": "k8s-detached", "network_id": "net-uuid"}}`))
+	})
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","detach_only":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	want := map[string]interface{}{
+		"name":            detachedPortName,
+		"admin_state_up":  false,
+		"device_id":       "",
+		"binding:host_id": "",
+	}
+	if !reflect.DeepEqual(update, want) {
+		t.Errorf("update body = %v, want %v", update, want)
+	}
+	var resp api.DelResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(resp.DetachedPortIDs, []string{"port-a"}) || len(resp.DeletedPortIDs) != 0 {
+		t.Errorf("resp = %+v, want port-a detached and nothing deleted", resp)
+	}
+}
+
+func TestDelDetachOnlyStrictFailure(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": [{"id": "port-a", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"},
+			{"id": "port-b", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"},
+			{"id": "port-c", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+	})
+	for _, id := range []string{"port-a", "port-b"} {
+		th.Mux.HandleFunc("/ports/"+id, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+	}
+	th.Mux.HandleFunc("/ports/port-c", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-c", "name": "k8s-detached", "network_id": "net-uuid"}}`))
+	})
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","detach_only":true,"strict":true}`)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusInternalServerError, rec.Body.String())
+	}
+	var resp api.DelResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if want := "failed to detach 2 of 3 ports, first port-a"; !strings.HasPrefix(resp.Error, want) {
+		t.Errorf("error = %q, want it to start with %q", resp.Error, want)
+	}
+}
+
+func TestAddAdoptsDetachedPort(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1"}}`))
+	})
+
+	fake := newFakePortClient(ports.Port{
+		ID:        "port-kept",
+		Name:      detachedPortName,
+		NetworkID: "net-uuid",
+		FixedIPs:  []ports.IP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.7"}},
+	})
+	fake.createErr = gophercloud.ErrDefault409{}
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+
+	body := `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.7","mtu":1400}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp api.AddResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.PortID != "port-kept" || resp.IPAddress != "10.0.0.7" || resp.Created {
+		t.Errorf("resp = %+v, want port-kept adopted with 10.0.0.7", resp)
+	}
+	if p := fake.ports["port-kept"]; p.Name != "k8s-pod-abcdef123456" || !p.AdminStateUp {
+		t.Errorf("adopted port = %+v, want it renamed and up", p)
+	}
+
+	// Without a detached port holding the address, the conflict stands.
+	body = `{"container_id":"0123456789abcdef","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.8","mtu":1400}`
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestDelDetachOnlyClearsQoSPolicy(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	qos := handleQoS(t)
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": [{"id": "port-a", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+	})
+	var update map[string]interface{}
+	th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Port map[string]interface{} `json:"port"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode update body: %v", err)
+		}
+		update = body.Port
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-a", "name": "k8s-detached", "network_id": "net-uuid"}}`))
+	})
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","detach_only":true,"qos_policy":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	// The policy is named after the released port; the pod adopting it
+	// gets its own.
+	if v, ok := update["qos_policy_id"]; !ok || v != nil {
+		t.Errorf("update qos_policy_id = %v (set %t), want null", v, ok)
+	}
+	if !reflect.DeepEqual(qos.deleted, []string{"policy-1"}) {
+		t.Errorf("deleted policies = %v, want [policy-1]", qos.deleted)
+	}
+}
+
+func TestAddAdoptAppliesRequest(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	handleQoS(t)
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"ports": [{"id": "port-kept", "name": "k8s-detached", "network_id": "net-uuid", "tags": ["old-pod"],
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.7"}]}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"NeutronError": {"type": "IpAddressAlreadyAllocated", "message": "in use"}}`))
+	})
+	var tags map[string]interface{}
+	th.Mux.HandleFunc("/ports/port-kept/tags", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+			t.Errorf("decode tags body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"tags": ["team-a"]}`))
+	})
+	var update map[string]interface{}
+	th.Mux.HandleFunc("/ports/port-kept", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Port map[string]interface{} `json:"port"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode update body: %v", err)
+		}
+		update = body.Port
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-kept", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+			"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.7"}]}}`))
+	})
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1"}}`))
+	})
+
+	cfg := defaultDaemonConfig()
+	cfg.HostID = "node-1"
+	handler := newHandler(thclient.ServiceClient(), cfg)
+	body := `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.7","mtu":1400,
+		"security_group_ids":["sg-new"],"tags":["team-a"],"bandwidth":{"max_kbps":1000}}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if want := map[string]interface{}{"tags": []interface{}{"team-a"}}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags body = %v, want %v", tags, want)
+	}
+	for key, want := range map[string]interface{}{
+		"name":            "k8s-pod-abcdef123456",
+		"device_id":       "abcdef1234567890",
+		"admin_state_up":  true,
+		"security_groups": []interface{}{"sg-new"},
+		"binding:host_id": "node-1",
+		"qos_policy_id":   "policy-1",
+	} {
+		if !reflect.DeepEqual(update[key], want) {
+			t.Errorf("update %s = %v, want %v", key, update[key], want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
+)
+
+// hostPortListOpts adds the binding:host_id filter, which gophercloud's
+// ports.ListOpts does not expose, to a port list query.
+type hostPortListOpts struct {
+	ports.ListOpts
+	HostID string
+}
+
+// ToPortListQuery implements ports.ListOptsBuilder.
+func (opts hostPortListOpts) ToPortListQuery() (string, error) {
+	q, err := opts.ListOpts.ToPortListQuery()
+	if err != nil {
+		return "", err
+	}
+	host := url.Values{"binding:host_id": []string{opts.HostID}}.Encode()
+	if q == "" {
+		return "?" + host, nil
+	}
+	return q + "&" + host, nil
+}
+
+// isManagedPortName reports whether name is one the daemon gives to the
+// ports it creates: container ports, warm pool spares and ports kept by a
+// detach-only DEL.
+func isManagedPortName(name string) bool {
+	return strings.HasPrefix(name, portname.Prefix) || name == poolPortName || name == detachedPortName
+}
+
+// drainNode serves /drain-node: it deletes the daemon-managed ports bound to
+// req.HostID, or only lists them on a dry run. Other ports on the host, such
+// as those of Nova instances, are left alone. Deleted ports are dropped from
+// pool, and the bandwidth QoS policies of deleted container ports are
+// deleted with them when neutronClient is set.
+func drainNode(w http.ResponseWriter, req api.DrainNodeRequest, portClient NeutronPortClient, neutronClient *gophercloud.ServiceClient, pool *warmPool) {
+	allPorts, err := portClient.List(hostPortListOpts{HostID: req.HostID})
+	if err != nil {
+		log.Printf("ERROR listing ports on host %s: %v", req.HostID, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports on host %s: %v", req.HostID, err))
+		return
+	}
+
+	resp := api.DrainNodeResponse{OK: true, DryRun: req.DryRun}
+	for _, p := range allPorts {
+		if !isManagedPortName(p.Name) {
+			continue
+		}
+		if req.DryRun {
+			resp.PortIDs = append(resp.PortIDs, p.ID)
+			continue
+		}
+		if err := portClient.Delete(p.ID); err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				log.Printf("DRAIN port_id=%s already gone", p.ID)
+				continue
+			}
+			log.Printf("ERROR deleting port %s: %v", p.ID, err)
+			resp.FailedPorts = append(resp.FailedPorts, api.PortFailure{PortID: p.ID, Error: err.Error()})
+			continue
+		}
+		log.Printf("DRAIN deleted port_id=%s", p.ID)
+		resp.PortIDs = append(resp.PortIDs, p.ID)
+		pool.drop(p.ID)
+		// A cloud without the QoS extension answers the policy list 404.
+		if neutronClient != nil && strings.HasPrefix(p.Name, portname.Prefix) {
+			policyIDs, err := deleteQoSPolicies(neutronClient, p.Name)
+			if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+				log.Printf("WARNING deleting QoS policies of port %s failed, policies may leak: %v", p.Name, err)
+			}
+			for _, id := range policyIDs {
+				log.Printf("DRAIN deleted qos_policy_id=%s", id)
+			}
+		}
+	}
+
+	log.Printf("DRAIN host_id=%s dry_run=%t ports=%d failed=%d", req.HostID, req.DryRun, len(resp.PortIDs), len(resp.FailedPorts))
+	if len(resp.FailedPorts) > 0 {
+		resp.OK = false
+		resp.Error = fmt.Sprintf("failed to delete %d port(s) on host %s", len(resp.FailedPorts), req.HostID)
+		writeJSON(w, http.StatusInternalServerError, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+// handleTwoHosts mocks Neutron with ports on node-a and node-b, filtering
+// port lists by binding:host_id. It returns the IDs deleted so far.
+func handleTwoHosts(t *testing.T) func() []string {
+	t.Helper()
+	hostPorts := map[string][]map[string]string{
+		"node-a": {
+			{"id": "port-a1", "name": "k8s-pod-aaaaaaaaaaaa"},
+			{"id": "port-a2", "name": poolPortName},
+			{"id": "port-a3", "name": "nova-instance-port"},
+			{"id": "port-a4", "name": detachedPortName},
+		},
+		"node-b": {
+			{"id": "port-b1", "name": "k8s-pod-bbbbbbbbbbbb"},
+		},
+	}
+	var mu sync.Mutex
+	var deleted []string
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ports": hostPorts[r.URL.Query().Get("binding:host_id")]})
+	})
+	for _, hostList := range hostPorts {
+		for _, p := range hostList {
+			id := p["id"]
+			th.Mux.HandleFunc("/ports/"+id, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					t.Errorf("unexpected %s on port %s", r.Method, id)
+				}
+				mu.Lock()
+				deleted = append(deleted, id)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			})
+		}
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := append([]string(nil), deleted...)
+		sort.Strings(out)
+		return out
+	}
+}
+
+func postDrain(t *testing.T, body string) (int, api.DrainNodeResponse) {
+	t.Helper()
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain-node", bytes.NewBufferString(body)))
+	var resp api.DrainNodeResponse
+	if rec.Code != http.StatusBadRequest {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestDrainNodeEndpoint(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry_run=%t", dryRun), func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			deleted := handleTwoHosts(t)
+
+			code, resp := postDrain(t, fmt.Sprintf(`{"host_id":"node-a","dry_run":%t}`, dryRun))
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", code, http.StatusOK)
+			}
+			got := append([]string(nil), resp.PortIDs...)
+			sort.Strings(got)
+			if want := []string{"port-a1", "port-a2", "port-a4"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("PortIDs = %v, want %v", got, want)
+			}
+			if resp.DryRun != dryRun {
+				t.Errorf("DryRun = %t, want %t", resp.DryRun, dryRun)
+			}
+			wantDeleted := []string{"port-a1", "port-a2", "port-a4"}
+			if dryRun {
+				wantDeleted = nil
+			}
+			if got := deleted(); !reflect.DeepEqual(got, wantDeleted) {
+				t.Errorf("deleted = %v, want %v", got, wantDeleted)
+			}
+		})
+	}
+}
+
+func TestDrainNodeDropsPoolPortsAndQoSPolicies(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	deleted := handleTwoHosts(t)
+	var listed, deletedPolicies []string
+	th.Mux.HandleFunc("/qos/policies", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		listed = append(listed, name)
+		w.Header().Set("Content-Type", "application/json")
+		if name != "k8s-pod-aaaaaaaaaaaa" {
+			_, _ = w.Write([]byte(`{"policies": []}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"policies": [{"id": "policy-a1", "name": "k8s-pod-aaaaaaaaaaaa"}]}`))
+	})
+	th.Mux.HandleFunc("/qos/policies/policy-a1", func(w http.ResponseWriter, r *http.Request) {
+		deletedPolicies = append(deletedPolicies, "policy-a1")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	pool := &warmPool{
+		spares:    []ports.Port{{ID: "port-a2"}, {ID: "port-spare"}},
+		handedOut: map[string]bool{"port-a1": true},
+	}
+	client := thclient.ServiceClient()
+	rec := httptest.NewRecorder()
+	drainNode(rec, api.DrainNodeRequest{HostID: "node-a"}, gophercloudPortClient{clients: newNeutronClientRef(client, nil)}, client, pool)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if want := []string{"port-a1", "port-a2", "port-a4"}; !reflect.DeepEqual(deleted(), want) {
+		t.Errorf("deleted = %v, want %v", deleted(), want)
+	}
+	if len(pool.spares) != 1 || pool.spares[0].ID != "port-spare" || len(pool.handedOut) != 0 {
+		t.Errorf("pool spares = %v, handed out = %v, want only port-spare left", pool.spares, pool.handedOut)
+	}
+	// Only container ports have QoS policies.
+	if want := []string{"k8s-pod-aaaaaaaaaaaa"}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("policy lists = %v, want %v", listed, want)
+	}
+	if want := []string{"policy-a1"}; !reflect.DeepEqual(deletedPolicies, want) {
+		t.Errorf("deleted policies = %v, want %v", deletedPolicies, want)
+	}
+}
+
+func TestDrainNodeRequiresHost(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	if code, _ := postDrain(t, `{}`); code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestHostPortListOpts(t *testing.T) {
+	q, err := hostPortListOpts{ListOpts: ports.ListOpts{NetworkID: "net-uuid"}, HostID: "node-a"}.ToPortListQuery()
+	if err != nil {
+		t.Fatalf("ToPortListQuery() error = %v", err)
+	}
+	if !strings.Contains(q, "network_id=net-uuid") || !strings.Contains(q, "binding%3Ahost_id=node-a") {
+		t.Errorf("query = %q, want network_id and binding:host_id filters", q)
+	}
+}
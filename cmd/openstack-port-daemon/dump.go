@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"openstack-port/internal/api"
+)
+
+// dumpPorts writes an api.PortManifest of every daemon-managed port
+// visible to neutronClient to out, as indented JSON sorted by port name.
+// Other ports, such as those of Nova instances, are left out.
+func dumpPorts(neutronClient *gophercloud.ServiceClient, out io.Writer) error {
+	pages, err := ports.List(neutronClient, ports.ListOpts{}).AllPages()
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %w", err)
+	}
+	var allPorts []struct {
+		ports.Port
+		portsbinding.PortsBindingExt
+	}
+	if err := ports.ExtractPortsInto(pages, &allPorts); err != nil {
+		return fmt.Errorf("failed to list ports: %w", err)
+	}
+
+	manifest := api.PortManifest{Ports: []api.ManifestPort{}}
+	for _, p := range allPorts {
+		if !isManagedPortName(p.Name) {
+			continue
+		}
+		port := api.ManifestPort{
+			PortID:     p.ID,
+			Name:       p.Name,
+			NetworkID:  p.NetworkID,
+			MACAddress: p.MACAddress,
+			DeviceID:   p.DeviceID,
+			HostID:     p.HostID,
+			Status:     p.Status,
+			Pooled:     p.Name == poolPortName,
+			Detached:   p.Name == detachedPortName,
+		}
+		if !port.Pooled && !port.Detached {
+			port.ContainerID = p.DeviceID
+		}
+		for _, tag := range p.Tags {
+			if pod, ok := strings.CutPrefix(tag, podTagPrefix); ok {
+				port.Pod = pod
+			}
+		}
+		for _, ip := range p.FixedIPs {
+			port.FixedIPs = append(port.FixedIPs, api.FixedIP{SubnetID: ip.SubnetID, IPAddress: ip.IPAddress})
+		}
+		manifest.Ports = append(manifest.Ports, port)
+	}
+	sort.Slice(manifest.Ports, func(i, j int) bool {
+		if manifest.Ports[i].Name != manifest.Ports[j].Name {
+			return manifest.Ports[i].Name < manifest.Ports[j].Name
+		}
+		return manifest.Ports[i].PortID < manifest.Ports[j].PortID
+	})
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestDumpPorts(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": [
+			{
+				"id": "port-b",
+				"name": "k8s-pod-bbbbbbbbbbbb",
+				"network_id": "net-uuid",
+				"mac_address": "fa:16:3e:00:00:02",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.6"}],
+				"device_id": "bbbbbbbbbbbbbbbb",
+				"binding:host_id": "node-1",
+				"status": "ACTIVE",
+				"tags": ["environment=prod", "k8s-pod=default/web-0"]
+			},
+			{
+				"id": "port-vm",
+				"name": "vm-port",
+				"network_id": "net-uuid",
+				"mac_address": "fa:16:3e:00:00:09",
+				"device_id": "instance-uuid",
+				"binding:host_id": "compute-1"
+			},
+			{
+				"id": "port-a",
+				"name": "k8s-pod-aaaaaaaaaaaa",
+				"network_id": "net-uuid",
+				"mac_address": "fa:16:3e:00:00:01",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}],
+				"binding:host_id": "node-2",
+				"status": "DOWN"
+			},
+			{
+				"id": "port-kept",
+				"name": "k8s-detached",
+				"network_id": "net-uuid",
+				"mac_address": "fa:16:3e:00:00:04",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.8"}],
+				"status": "DOWN"
+			},
+			{
+				"id": "port-spare",
+				"name": "k8s-pool-spare",
+				"network_id": "net-uuid",
+				"mac_address": "fa:16:3e:00:00:03",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.7"}]
+			}
+		]}`))
+	})
+
+	var out bytes.Buffer
+	if err := dumpPorts(thclient.ServiceClient(), &out); err != nil {
+		t.Fatalf("dumpPorts() error = %v", err)
+	}
+	var manifest api.PortManifest
+	if err := json.Unmarshal(out.Bytes(), &manifest); err != nil {
+		t.Fatalf("decoding manifest %q: %v", out.String(), err)
+	}
+	want := []api.ManifestPort{
+		{
+			PortID: "port-kept", Name: "k8s-detached", NetworkID: "net-uuid", MACAddress: "fa:16:3e:00:00:04",
+			FixedIPs: []api.FixedIP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.8"}},
+			Status:   "DOWN", Detached: true,
+		},
+		{
+			PortID: "port-a", Name: "k8s-pod-aaaaaaaaaaaa", NetworkID: "net-uuid", MACAddress: "fa:16:3e:00:00:01",
+			FixedIPs: []api.FixedIP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.5"}},
+			HostID:   "node-2", Status: "DOWN",
+		},
+		{
+			PortID: "port-b", Name: "k8s-pod-bbbbbbbbbbbb", NetworkID: "net-uuid", MACAddress: "fa:16:3e:00:00:02",
+			FixedIPs: []api.FixedIP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.6"}},
+			DeviceID: "bbbbbbbbbbbbbbbb", HostID: "node-1", Status: "ACTIVE",
+			ContainerID: "bbbbbbbbbbbbbbbb", Pod: "default/web-0",
+		},
+		{
+			PortID: "port-spare", Name: "k8s-pool-spare", NetworkID: "net-uuid", MACAddress: "fa:16:3e:00:00:03",
+			FixedIPs: []api.FixedIP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.7"}},
+			Pooled:   true,
+		},
+	}
+	if !reflect.DeepEqual(manifest.Ports, want) {
+		t.Errorf("manifest ports = %+v, want %+v", manifest.Ports, want)
+	}
+}
+
+func TestDumpPortsEmpty(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": []}`))
+	})
+
+	var out bytes.Buffer
+	if err := dumpPorts(thclient.ServiceClient(), &out); err != nil {
+		t.Fatalf("dumpPorts() error = %v", err)
+	}
+	if got := out.String(); got != "{\n  \"ports\": []\n}\n" {
+		t.Errorf("dumpPorts() wrote %q, want an empty ports list", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Port event types, as set in PortEvent.Type.
+const (
+	portEventCreated = "port.created"
+	portEventDeleted = "port.deleted"
+)
+
+// eventQueueSize bounds the events waiting to be published; beyond it new
+// events are dropped rather than slowing ADD and DEL down.
+const eventQueueSize = 256
+
+// eventPublishTimeout bounds each publish of the webhook publisher.
+const eventPublishTimeout = 5 * time.Second
+
+// PortEvent tells external systems that the daemon created or deleted the
+// Neutron port of a container.
+type PortEvent struct {
+	Type        string `json:"type"`
+	PortID      string `json:"port_id"`
+	NetworkID   string `json:"network_id"`
+	ContainerID string `json:"container_id"`
+	// Pod is the pod's namespace/name, when the runtime passed them.
+	Pod       string    `json:"pod,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// EventPublisher emits port events to an external system, e.g. a message
+// queue. Events are published one at a time, in order, off the request
+// path. A build publishing over a broker protocol (e.g. AMQP or Kafka)
+// provides its own implementation, keeping its client library out of the
+// default build.
+type EventPublisher interface {
+	Publish(ctx context.Context, event PortEvent) error
+}
+
+// noopPublisher is the default EventPublisher: it drops every event.
+type noopPublisher struct{}
+
+// Publish implements EventPublisher.
+func (noopPublisher) Publish(context.Context, PortEvent) error { return nil }
+
+// webhookPublisher is an EventPublisher POSTing each event as JSON to url,
+// e.g. a Kafka REST proxy or a bridge into a message queue.
+type webhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// Publish implements EventPublisher. Any non-2xx answer is an error.
+func (p webhookPublisher) Publish(ctx context.Context, event PortEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+// newEventPublisher returns the EventPublisher configured by cfg: a
+// webhookPublisher when an events URL is set, else a noopPublisher.
+func newEventPublisher(cfg daemonConfig) EventPublisher {
+	if cfg.EventsURL == "" {
+		return noopPublisher{}
+	}
+	return webhookPublisher{url: cfg.EventsURL, client: &http.Client{Timeout: eventPublishTimeout}}
+}
+
+// podRef returns the namespace/name of a pod, or "" when either is unknown.
+func podRef(namespace, name string) string {
+	if namespace == "" || name == "" {
+		return ""
+	}
+	return namespace + "/" + name
+}
+
+// eventQueue hands events to a publisher from a single goroutine, so they
+// are published in the order they happened without blocking the request
+// that emits them. A nil *eventQueue drops every event.
+type eventQueue struct {
+	publisher EventPublisher
+	events    chan PortEvent
+}
+
+// newEventQueue starts publishing to publisher, or returns nil when
+// publisher is a noopPublisher.
+func newEventQueue(publisher EventPublisher) *eventQueue {
+	if _, ok := publisher.(noopPublisher); ok {
+		return nil
+	}
+	q := &eventQueue{publisher: publisher, events: make(chan PortEvent, eventQueueSize)}
+	go q.run()
+	return q
+}
+
+// emit queues event, stamped with the current time. A full queue drops it
+// with a warning.
+func (q *eventQueue) emit(event PortEvent) {
+	if q == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	select {
+	case q.events <- event:
+	default:
+		log.Printf("WARNING event queue full, dropping %s event for port %s", event.Type, event.PortID)
+	}
+}
+
+func (q *eventQueue) run() {
+	for event := range q.events {
+		if err := q.publisher.Publish(context.Background(), event); err != nil {
+			log.Printf("WARNING publishing %s event for port %s failed: %v", event.Type, event.PortID, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+// fakePublisher is an EventPublisher sending every event it is given to
+// events.
+type fakePublisher struct {
+	events chan PortEvent
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{events: make(chan PortEvent, 16)}
+}
+
+func (p *fakePublisher) Publish(_ context.Context, event PortEvent) error {
+	p.events <- event
+	return nil
+}
+
+// next returns the next published event, failing t when none comes.
+func (p *fakePublisher) next(t *testing.T) PortEvent {
+	t.Helper()
+	select {
+	case event := <-p.events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event published")
+		return PortEvent{}
+	}
+}
+
+func TestPortEvents(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	publisher := newFakePublisher()
+	handler := newHandlerWithEvents(newNeutronClientRef(thclient.ServiceClient(), nil), newFakePortClient(), publisher, defaultDaemonConfig())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+		bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","pod_namespace":"default","pod_name":"web-0"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ADD status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	created := publisher.next(t)
+	if created.Type != portEventCreated || created.PortID != "port-1" || created.NetworkID != "net-uuid" ||
+		created.ContainerID != "abcdef1234567890" || created.Pod != "default/web-0" || created.IPAddress != "10.0.0.11" || created.Time.IsZero() {
+		t.Errorf("created event = %+v", created)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del",
+		bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DEL status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	deleted := publisher.next(t)
+	if deleted.Type != portEventDeleted || deleted.PortID != "port-1" || deleted.NetworkID != "net-uuid" ||
+		deleted.ContainerID != "abcdef1234567890" || deleted.Pod != "" || deleted.IPAddress != "10.0.0.11" {
+		t.Errorf("deleted event = %+v", deleted)
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var got PortEvent
+	status := http.StatusAccepted
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	cfg := defaultDaemonConfig()
+	cfg.EventsURL = srv.URL
+	publisher := newEventPublisher(cfg)
+	event := PortEvent{Type: portEventCreated, PortID: "port-1", ContainerID: "abcdef1234567890", Pod: "default/web-0"}
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got != event {
+		t.Errorf("published %+v, want %+v", got, event)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := publisher.Publish(context.Background(), event); err == nil {
+		t.Error("Publish() accepted a 503 answer")
+	}
+}
+
+func TestNewEventQueueNoop(t *testing.T) {
+	if q := newEventQueue(newEventPublisher(defaultDaemonConfig())); q != nil {
+		t.Errorf("newEventQueue() = %+v without an events URL, want nil", q)
+	}
+	// A nil queue drops events.
+	var q *eventQueue
+	q.emit(PortEvent{Type: portEventCreated, PortID: "port-1"})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+// fallbackRange returns the block of the subnet's addresses the CNI's
+// fallback IPAM allocates from: the largest CIDR block clear of the
+// subnet's allocation pools, its gateway and its network address (and
+// broadcast address on IPv4). Neutron only hands out pool addresses, so
+// host-local never allocates one that another port holds.
+func fallbackRange(subnet *subnets.Subnet) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %v", subnet.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	first, last := addrInt(prefix.Addr()), addrInt(lastAddr(prefix))
+
+	type interval struct{ start, end *big.Int }
+	excluded := []interval{{first, first}}
+	if prefix.Addr().Is4() {
+		excluded = append(excluded, interval{last, last})
+	}
+	if gateway, err := netip.ParseAddr(subnet.GatewayIP); err == nil {
+		excluded = append(excluded, interval{addrInt(gateway), addrInt(gateway)})
+	}
+	for _, pool := range subnet.AllocationPools {
+		start, err := netip.ParseAddr(pool.Start)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid allocation pool start %q: %v", pool.Start, err)
+		}
+		end, err := netip.ParseAddr(pool.End)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid allocation pool end %q: %v", pool.End, err)
+		}
+		excluded = append(excluded, interval{addrInt(start), addrInt(end)})
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].start.Cmp(excluded[j].start) < 0 })
+
+	one := big.NewInt(1)
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	var best *big.Int
+	bestBits := -1
+	consider := func(start, end *big.Int) {
+		if start.Cmp(end) > 0 {
+			return
+		}
+		if s, bits := largestBlock(start, end, hostBits); bits > bestBits {
+			best, bestBits = s, bits
+		}
+	}
+	next := first
+	for _, ex := range excluded {
+		consider(next, new(big.Int).Sub(ex.start, one))
+		if after := new(big.Int).Add(ex.end, one); after.Cmp(next) > 0 {
+			next = after
+		}
+	}
+	consider(next, last)
+	if best == nil {
+		return netip.Prefix{}, fmt.Errorf("no address of %s outside its allocation pools", subnet.CIDR)
+	}
+	return netip.PrefixFrom(intAddr(best, prefix.Addr().Is4()), prefix.Addr().BitLen()-bestBits), nil
+}
+
+// largestBlock returns the start and host bits of the largest aligned
+// block of at most maxBits host bits within [start, end].
+func largestBlock(start, end *big.Int, maxBits int) (*big.Int, int) {
+	for bits := maxBits; bits > 0; bits-- {
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		// Round start up to a multiple of size.
+		s := new(big.Int).Add(start, new(big.Int).Sub(size, big.NewInt(1)))
+		s.Div(s, size).Mul(s, size)
+		if blockEnd := new(big.Int).Add(s, size); blockEnd.Sub(blockEnd, big.NewInt(1)).Cmp(end) <= 0 {
+			return s, bits
+		}
+	}
+	return start, 0
+}
+
+// lastAddr returns the last address of prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	a := prefix.Masked().Addr().As16()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	for i := 15; hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		a[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	if prefix.Addr().Is4() {
+		return netip.AddrFrom16(a).Unmap()
+	}
+	return netip.AddrFrom16(a)
+}
+
+func addrInt(a netip.Addr) *big.Int {
+	b := a.As16()
+	return new(big.Int).SetBytes(b[:])
+}
+
+func intAddr(n *big.Int, is4 bool) netip.Addr {
+	var b [16]byte
+	n.FillBytes(b[:])
+	if is4 {
+		return netip.AddrFrom16(b).Unmap()
+	}
+	return netip.AddrFrom16(b)
+}
+
+// allowFallbackRange lets the port send from the addresses of block, which
+// fallback IPAM allocates without Neutron knowing, so that port security
+// does not drop the pod's traffic. A port without port security needs no
+// address pair, and Neutron refuses one.
+func allowFallbackRange(portClient NeutronPortClient, port *ports.Port, block netip.Prefix) error {
+	pairs := []ports.AddressPair{{IPAddress: block.String()}}
+	_, err := portClient.Update(port.ID, ports.UpdateOpts{AllowedAddressPairs: &pairs})
+	if neutronErrorType(err) == neutronAddressPairNeedsPortSecurity {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+func TestFallbackRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  subnets.Subnet
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "AfterPool",
+			subnet: subnets.Subnet{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1", AllocationPools: []subnets.AllocationPool{{Start: "10.0.0.2", End: "10.0.0.127"}}},
+			want:   "10.0.0.128/26",
+		},
+		{
+			name: "BetweenPools",
+			subnet: subnets.Subnet{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1", AllocationPools: []subnets.AllocationPool{
+				{Start: "10.0.0.200", End: "10.0.0.254"}, {Start: "10.0.0.2", End: "10.0.0.99"},
+			}},
+			want: "10.0.0.128/26",
+		},
+		{
+			name:   "WithoutPools",
+			subnet: subnets.Subnet{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1"},
+			want:   "10.0.0.64/26",
+		},
+		{
+			name:   "GatewayInGap",
+			subnet: subnets.Subnet{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.129", AllocationPools: []subnets.AllocationPool{{Start: "10.0.0.1", End: "10.0.0.127"}}},
+			want:   "10.0.0.160/27",
+		},
+		{
+			name:   "IPv6",
+			subnet: subnets.Subnet{CIDR: "2001:db8::/64", GatewayIP: "2001:db8::1", AllocationPools: []subnets.AllocationPool{{Start: "2001:db8::2", End: "2001:db8::ffff"}}},
+			want:   "2001:db8:0:0:8000::/65",
+		},
+		{
+			name:    "PoolCoversSubnet",
+			subnet:  subnets.Subnet{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1", AllocationPools: []subnets.AllocationPool{{Start: "10.0.0.2", End: "10.0.0.254"}}},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidCIDR",
+			subnet:  subnets.Subnet{CIDR: "bogus"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fallbackRange(&tt.subnet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("fallbackRange() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fallbackRange() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("fallbackRange() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
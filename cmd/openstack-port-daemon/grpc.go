@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/apipb"
+)
+
+// grpcServer serves ADD, DEL and CHECK over gRPC by replaying each call on
+// the HTTP handler, so both transports share its locks, pool and limits.
+type grpcServer struct {
+	apipb.UnimplementedDaemonServer
+	handler http.Handler
+}
+
+func newGRPCServer(handler http.Handler) *grpc.Server {
+	srv := grpc.NewServer()
+	apipb.RegisterDaemonServer(srv, &grpcServer{handler: handler})
+	return srv
+}
+
+// responseBuffer is a minimal http.ResponseWriter keeping the response in
+// memory.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// grpcCode maps an HTTP status of the handler to the matching gRPC code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+// retryAfterKey is the trailer carrying the handler's Retry-After hint.
+const retryAfterKey = "retry-after"
+
+// reasonKey is the trailer carrying the ErrorResponse.Reason of an error.
+const reasonKey = "reason"
+
+// call POSTs req as JSON to path on the handler and decodes the response
+// into resp, turning an error response into a gRPC status. A Retry-After
+// hint is passed on in the retryAfterKey trailer, an error reason in the
+// reasonKey trailer.
+func (s *grpcServer) call(ctx context.Context, path string, req, resp interface{}) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to encode request: %v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://grpc"+path, bytes.NewReader(data))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// The caller's span, if any, parents the handler's.
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range traceContext.Fields() {
+			if v := md.Get(key); len(v) > 0 {
+				httpReq.Header.Set(key, v[0])
+			}
+		}
+	}
+	buf := &responseBuffer{header: make(http.Header)}
+	s.handler.ServeHTTP(buf, httpReq)
+	if buf.status == 0 {
+		buf.status = http.StatusOK
+	}
+
+	if v := buf.header.Get("Retry-After"); v != "" {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(retryAfterKey, v))
+	}
+	if buf.status < 200 || buf.status >= 300 {
+		var errResp api.ErrorResponse
+		if json.Unmarshal(buf.body.Bytes(), &errResp) != nil || errResp.Error == "" {
+			errResp.Error = http.StatusText(buf.status)
+		}
+		if errResp.Reason != "" {
+			_ = grpc.SetTrailer(ctx, metadata.Pairs(reasonKey, errResp.Reason))
+		}
+		return status.Error(grpcCode(buf.status), errResp.Error)
+	}
+	if err := json.Unmarshal(buf.body.Bytes(), resp); err != nil {
+		return status.Errorf(codes.Internal, "failed to decode response: %v", err)
+	}
+	return nil
+}
+
+func (s *grpcServer) Add(ctx context.Context, req *apipb.AddRequest) (*apipb.AddResponse, error) {
+	var resp api.AddResponse
+	if err := s.call(ctx, "/add", req.ToAPI(), &resp); err != nil {
+		return nil, err
+	}
+	return apipb.FromAddResponse(resp), nil
+}
+
+func (s *grpcServer) Del(ctx context.Context, req *apipb.DelRequest) (*apipb.DelResponse, error) {
+	var resp api.DelResponse
+	if err := s.call(ctx, "/del", req.ToAPI(), &resp); err != nil {
+		return nil, err
+	}
+	return apipb.FromDelResponse(resp), nil
+}
+
+func (s *grpcServer) Check(ctx context.Context, req *apipb.CheckRequest) (*apipb.CheckResponse, error) {
+	var resp api.CheckResponse
+	if err := s.call(ctx, "/check", req.ToAPI(), &resp); err != nil {
+		return nil, err
+	}
+	return apipb.FromCheckResponse(resp), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/apipb"
+)
+
+// startGRPC serves the fake-backed handler over gRPC on a temporary Unix
+// socket and returns a client for it.
+func startGRPC(t *testing.T, fake *fakePortClient) apipb.DaemonClient {
+	t.Helper()
+	return startGRPCHandler(t, newHandlerWithPortClient(nil, fake, defaultDaemonConfig()))
+}
+
+// startGRPCHandler serves handler over gRPC on a temporary Unix socket and
+// returns a client for it.
+func startGRPCHandler(t *testing.T, handler http.Handler) apipb.DaemonClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "grpc.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := newGRPCServer(handler)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return apipb.NewDaemonClient(conn)
+}
+
+func TestGRPCCheckAndDel(t *testing.T) {
+	fake := newFakePortClient(
+		ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid", Status: "ACTIVE"},
+	)
+	client := startGRPC(t, fake)
+	ctx := context.Background()
+
+	check, err := client.Check(ctx, &apipb.CheckRequest{ContainerId: "abcdef1234567890", NetworkId: "net-uuid"})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !check.GetExists() || check.GetPortId() != "port-a" {
+		t.Errorf("Check = %v, want port-a to exist", check)
+	}
+
+	del, err := client.Del(ctx, &apipb.DelRequest{ContainerId: "abcdef1234567890", NetworkId: "net-uuid"})
+	if err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+	if !del.GetOk() || !reflect.DeepEqual(del.GetDeletedPortIds(), []string{"port-a"}) {
+		t.Errorf("Del = %v, want port-a deleted", del)
+	}
+	if _, ok := fake.ports["port-a"]; ok {
+		t.Error("port-a was not deleted")
+	}
+
+	check, err = client.Check(ctx, &apipb.CheckRequest{ContainerId: "abcdef1234567890", NetworkId: "net-uuid"})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if check.GetExists() || check.GetFilter().GetName() != "k8s-pod-abcdef123456" {
+		t.Errorf("Check after Del = %v, want no port and the filter echoed", check)
+	}
+}
+
+func TestGRPCAddError(t *testing.T) {
+	client := startGRPC(t, newFakePortClient())
+
+	_, err := client.Add(context.Background(), &apipb.AddRequest{ContainerId: "abcdef1234567890"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Add error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCRetryAfterTrailer(t *testing.T) {
+	client := startGRPCHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeUnavailable(w, 10*time.Second, "subnet has no delegated IPv6 prefix yet")
+	}))
+
+	var trailer metadata.MD
+	_, err := client.Add(context.Background(), &apipb.AddRequest{ContainerId: "abcdef1234567890"}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Add error = %v, want Unavailable", err)
+	}
+	if got := trailer.Get(retryAfterKey); len(got) != 1 || got[0] != "10" {
+		t.Errorf("%s trailer = %v, want [10]", retryAfterKey, got)
+	}
+}
+
+func TestGRPCReasonTrailer(t *testing.T) {
+	client := startGRPCHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeRateLimited(w, 10*time.Second, "too many ADDs for pod default/web-0")
+	}))
+
+	var trailer metadata.MD
+	_, err := client.Add(context.Background(), &apipb.AddRequest{ContainerId: "abcdef1234567890"}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Add error = %v, want ResourceExhausted", err)
+	}
+	if got := trailer.Get(reasonKey); len(got) != 1 || got[0] != api.ReasonRateLimited {
+		t.Errorf("%s trailer = %v, want [%s]", reasonKey, got, api.ReasonRateLimited)
+	}
+}
+
+func TestGRPCCode(t *testing.T) {
+	tests := map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusForbidden:           codes.PermissionDenied,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusConflict:            codes.AlreadyExists,
+		http.StatusTooManyRequests:     codes.ResourceExhausted,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+		http.StatusInternalServerError: codes.Internal,
+	}
+	for httpStatus, want := range tests {
+		if got := grpcCode(httpStatus); got != want {
+			t.Errorf("grpcCode(%d) = %v, want %v", httpStatus, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// busyRetryAfterSeconds is the Retry-After hint sent with 429 responses.
+const busyRetryAfterSeconds = 1
+
+// requestLimiter bounds the number of requests handled concurrently and the
+// number allowed to wait for a free slot. Requests beyond the queue depth are
+// rejected instead of queueing without bound. A nil *requestLimiter admits
+// everything.
+type requestLimiter struct {
+	slots    chan struct{}
+	maxQueue int
+
+	mu      sync.Mutex
+	waiting int
+}
+
+// newRequestLimiter returns a limiter allowing maxConcurrent in-flight
+// requests with up to maxQueue more waiting, or nil when maxConcurrent is 0.
+func newRequestLimiter(maxConcurrent, maxQueue int) *requestLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &requestLimiter{
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: maxQueue,
+	}
+}
+
+// acquire takes a slot, waiting in the queue if none is free. It returns
+// false without waiting when the queue is full, or once ctx is done.
+func (l *requestLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= l.maxQueue {
+		l.mu.Unlock()
+		return false
+	}
+	l.waiting++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire.
+func (l *requestLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// limitRequests sheds load with 429 Too Many Requests once the limiter's
+// queue is full. Health checks, metrics scrapes and the CNI's in-memory
+// phase observations bypass the limiter.
+func limitRequests(l *requestLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health", "/metrics", "/observe":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r.Context()) {
+			w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfterSeconds))
+			writeError(w, http.StatusTooManyRequests, "daemon busy, retry later")
+			return
+		}
+		defer l.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter is a token bucket per key: a key may be allowed burst times
+// in a row, then once per interval. A nil *rateLimiter allows everything.
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// tokenBucket holds the tokens of a key as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter refilling one token per interval up to
+// burst, or nil when interval is 0.
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: interval, burst: burst, now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of key. When none is left it returns
+// false and how long until one is.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(l.burst), b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have refilled completely, which behave like
+// new ones, at most once per refill period so keys seen once do not pile
+// up.
+func (l *rateLimiter) prune(now time.Time) {
+	full := l.interval * time.Duration(l.burst)
+	if now.Sub(l.pruned) < full {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// podRateKey is the key an ADD or DEL is rate limited by: its pod, which
+// keeps its namespace and name across sandbox restarts, or else its
+// container.
+func podRateKey(namespace, name, containerID string) string {
+	if pod := podRef(namespace, name); pod != "" {
+		return "pod " + pod
+	}
+	return "container " + containerID
+}
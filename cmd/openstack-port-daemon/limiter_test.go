@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestRequestLimiterNilAdmitsAll(t *testing.T) {
+	l := newRequestLimiter(0, 0)
+	if l != nil {
+		t.Fatal("expected nil limiter when maxConcurrent is 0")
+	}
+	if !l.acquire(context.Background()) {
+		t.Error("nil limiter rejected a request")
+	}
+	l.release()
+}
+
+func TestRequestLimiterQueueFull(t *testing.T) {
+	l := newRequestLimiter(1, 1)
+	if !l.acquire(context.Background()) {
+		t.Fatal("first acquire failed")
+	}
+
+	// The second request waits in the queue.
+	queued := make(chan bool)
+	go func() { queued <- l.acquire(context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.mu.Lock()
+		waiting := l.waiting
+		l.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second request never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The third exceeds the queue depth and is rejected immediately.
+	if l.acquire(context.Background()) {
+		t.Fatal("expected acquire to fail when the queue is full")
+	}
+
+	l.release()
+	if !<-queued {
+		t.Fatal("queued request was not admitted after release")
+	}
+	l.release()
+}
+
+func TestRequestLimiterContextDone(t *testing.T) {
+	l := newRequestLimiter(1, 1)
+	_ = l.acquire(context.Background())
+	defer l.release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if l.acquire(ctx) {
+		t.Fatal("expected acquire to fail once the context is done")
+	}
+}
+
+func TestLimitRequestsReturns429WithRetryAfter(t *testing.T) {
+	l := newRequestLimiter(1, 0)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := limitRequests(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/add" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/add", nil))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// Health checks are never shed.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(10*time.Second, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("pod a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("pod a")
+	if ok || wait != 10*time.Second {
+		t.Errorf("allow() past the burst = %t, %s, want false, 10s", ok, wait)
+	}
+	if ok, _ := l.allow("pod b"); !ok {
+		t.Error("another key refused")
+	}
+
+	now = now.Add(4 * time.Second)
+	if ok, wait := l.allow("pod a"); ok || wait != 6*time.Second {
+		t.Errorf("allow() before a refill = %t, %s, want false, 6s", ok, wait)
+	}
+	now = now.Add(6 * time.Second)
+	if ok, _ := l.allow("pod a"); !ok {
+		t.Error("allow() after a refill refused")
+	}
+}
+
+func TestRateLimiterPrunesFullBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(time.Second, 2)
+	l.now = func() time.Time { return now }
+
+	l.allow("pod a")
+	now = now.Add(2 * time.Second)
+	l.allow("pod b")
+	now = now.Add(time.Second)
+	l.allow("pod c")
+	if _, ok := l.buckets["pod a"]; ok {
+		t.Error("refilled bucket of pod a kept")
+	}
+	if _, ok := l.buckets["pod b"]; !ok {
+		t.Error("bucket of pod b, not refilled yet, dropped")
+	}
+}
+
+func TestRateLimiterNilAllowsAll(t *testing.T) {
+	l := newRateLimiter(0, 5)
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("pod a"); !ok {
+			t.Fatal("nil rate limiter refused a request")
+		}
+	}
+}
+
+func TestPodRateKey(t *testing.T) {
+	if got := podRateKey("default", "web-0", "abc"); got != "pod default/web-0" {
+		t.Errorf("podRateKey() = %q, want pod default/web-0", got)
+	}
+	if got := podRateKey("", "", "abc"); got != "container abc" {
+		t.Errorf("podRateKey() = %q, want container abc", got)
+	}
+}
+
+func TestAddRateLimitPerPod(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.AddRateInterval = time.Minute
+	cfg.AddRateBurst = 2
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+	add := func(containerID, pod string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"container_id":%q,"network_id":"net-uuid","subnet_id":"subnet-uuid","pod_namespace":"default","pod_name":%q}`, containerID, pod)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	// A crash-looping pod gets a new sandbox, and container ID, each time.
+	for i, containerID := range []string{"aaaaaaaaaaaa0001", "aaaaaaaaaaaa0002"} {
+		if rec := add(containerID, "crashing"); rec.Code != http.StatusOK {
+			t.Fatalf("ADD %d status = %d, body: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := add("aaaaaaaaaaaa0003", "crashing")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("ADD past the burst status = %d, want %d, body: %s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	var errResp api.ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&errResp); err != nil || errResp.Reason != api.ReasonRateLimited {
+		t.Errorf("error response = %+v (%v), want reason %s", errResp, err, api.ReasonRateLimited)
+	}
+	if fake.created != 2 {
+		t.Errorf("created = %d ports, want 2", fake.created)
+	}
+
+	if rec := add("bbbbbbbbbbbb0001", "healthy"); rec.Code != http.StatusOK {
+		t.Errorf("ADD of another pod status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDelRateLimitPerPod(t *testing.T) {
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.AddRateInterval = time.Minute
+	cfg.AddRateBurst = 2
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+	del := func(containerID, pod string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"container_id":%q,"network_id":"net-uuid","pod_namespace":"default","pod_name":%q}`, containerID, pod)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	for i, containerID := range []string{"aaaaaaaaaaaa0001", "aaaaaaaaaaaa0002"} {
+		if rec := del(containerID, "crashing"); rec.Code != http.StatusOK {
+			t.Fatalf("DEL %d status = %d, body: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := del("aaaaaaaaaaaa0003", "crashing")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("DEL past the burst status = %d, want %d, body: %s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if fake.lists != 2 {
+		t.Errorf("lists = %d, want 2", fake.lists)
+	}
+
+	if rec := del("bbbbbbbbbbbb0001", "healthy"); rec.Code != http.StatusOK {
+		t.Errorf("DEL of another pod status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+}
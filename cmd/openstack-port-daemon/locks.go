@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// containerLocks hands out one mutex per container ID so that operations on
+// the same container are serialized while different containers proceed in
+// parallel. Entries are reference counted and dropped once unused. A nil
+// *containerLocks performs no locking.
+type containerLocks struct {
+	mu    sync.Mutex
+	locks map[string]*containerLock
+}
+
+type containerLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newContainerLocks() *containerLocks {
+	return &containerLocks{locks: make(map[string]*containerLock)}
+}
+
+// lock acquires the mutex for containerID and returns the function that
+// releases it.
+func (l *containerLocks) lock(containerID string) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	cl, ok := l.locks[containerID]
+	if !ok {
+		cl = &containerLock{}
+		l.locks[containerID] = cl
+	}
+	cl.refs++
+	l.mu.Unlock()
+
+	cl.mu.Lock()
+	return func() {
+		cl.mu.Unlock()
+		l.mu.Lock()
+		cl.refs--
+		if cl.refs == 0 {
+			delete(l.locks, containerID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContainerLocksSameContainerSerialized(t *testing.T) {
+	locks := newContainerLocks()
+	unlock := locks.lock("ctr-1")
+
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		locks.lock("ctr-1")()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock on the same container acquired while the first is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second lock not acquired after release")
+	}
+}
+
+func TestContainerLocksDifferentContainersParallel(t *testing.T) {
+	locks := newContainerLocks()
+	unlock := locks.lock("ctr-1")
+	defer unlock()
+
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		locks.lock("ctr-2")()
+	}()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("lock on a different container blocked")
+	}
+}
+
+func TestContainerLocksReleasedEntriesDropped(t *testing.T) {
+	locks := newContainerLocks()
+	locks.lock("ctr-1")()
+	if n := len(locks.locks); n != 0 {
+		t.Errorf("len(locks) = %d after release, want 0", n)
+	}
+}
+
+func TestContainerLocksNil(t *testing.T) {
+	var locks *containerLocks
+	locks.lock("ctr-1")()
+}
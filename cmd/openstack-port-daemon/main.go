@@ -1,40 +1,436 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/mtu"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
 
 	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
 )
 
-// portName returns the deterministic Neutron port name for a container.
-func portName(containerID string) string {
-	id := containerID
-	if len(id) > 12 {
-		id = id[:12]
+// namerFor returns the port namer selected by a request's port_naming, or
+// fallback when the request does not select one.
+func namerFor(strategy string, fallback portname.PortNamer) (portname.PortNamer, error) {
+	if strategy == "" {
+		return fallback, nil
 	}
-	return fmt.Sprintf("k8s-pod-%s", id)
+	return portname.New(strategy)
+}
+
+// portCreateOpts wraps ports.CreateOpts and merges Extra into the request
+// body so that less-common port attributes can be passed through. A
+// non-empty HostID is sent as binding:host_id, a BindingProfile makes the
+// port a remote-managed (Smart-NIC) port and a non-empty QoSPolicyID is sent
+// as qos_policy_id. Non-empty Tags are sent as tags.
+type portCreateOpts struct {
+	ports.CreateOpts
+	HostID         string
+	Extra          map[string]interface{}
+	BindingProfile *api.BindingProfile
+	QoSPolicyID    string
+	Tags           []string
+}
+
+// ToPortCreateMap implements ports.CreateOptsBuilder.
+func (opts portCreateOpts) ToPortCreateMap() (map[string]interface{}, error) {
+	body, err := opts.CreateOpts.ToPortCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	port, ok := body["port"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected port create body")
+	}
+	for key, value := range opts.Extra {
+		port[key] = value
+	}
+	if opts.HostID != "" {
+		port["binding:host_id"] = opts.HostID
+	}
+	if opts.BindingProfile != nil {
+		port["binding:vnic_type"] = remoteManagedVNICType
+		port["binding:profile"] = bindingProfileMap(opts.BindingProfile)
+	}
+	if opts.QoSPolicyID != "" {
+		port["qos_policy_id"] = opts.QoSPolicyID
+	}
+	if len(opts.Tags) > 0 {
+		port["tags"] = opts.Tags
+	}
+	return body, nil
+}
+
+// mergeTags returns the static tags followed by those of tags not among
+// them, each once.
+func mergeTags(static, tags []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{static, tags} {
+		for _, tag := range list {
+			if !seen[tag] {
+				seen[tag] = true
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+// podTagPrefix starts the tag naming the pod, as namespace/name, on the
+// ports the daemon creates for it.
+const podTagPrefix = "k8s-pod="
+
+// podTag returns the tag naming the pod namespace/name, or "" when the pod
+// is unknown or its tag would be too long for Neutron.
+func podTag(namespace, name string) string {
+	pod := podRef(namespace, name)
+	if pod == "" || len(podTagPrefix+pod) > api.MaxTagLength {
+		return ""
+	}
+	return podTagPrefix + pod
+}
+
+// specCreateOpts translates spec into the create request of the port
+// name on networkID, bound to hostID when set. The caller fills in the
+// fixed IP's subnet and the QoS policy.
+func specCreateOpts(spec api.PortSpec, name, networkID, hostID string) portCreateOpts {
+	opts := portCreateOpts{
+		CreateOpts: ports.CreateOpts{
+			Name:      name,
+			NetworkID: networkID,
+		},
+		HostID:         hostID,
+		Extra:          spec.ExtraCreateOpts,
+		BindingProfile: spec.BindingProfile,
+	}
+	if len(spec.SecurityGroupIDs) > 0 {
+		securityGroupIDs := spec.SecurityGroupIDs
+		opts.SecurityGroups = &securityGroupIDs
+	}
+	if spec.AdminStateDown {
+		adminStateUp := false
+		opts.AdminStateUp = &adminStateUp
+	}
+	return opts
+}
+
+// fixedIPs returns the fixed_ips asking for count addresses on subnetID,
+// the first of them ipAddress when set. A count below two asks for one.
+func fixedIPs(subnetID, ipAddress string, count int) []ports.IP {
+	ips := []ports.IP{{SubnetID: subnetID, IPAddress: ipAddress}}
+	for len(ips) < count {
+		ips = append(ips, ports.IP{SubnetID: subnetID})
+	}
+	return ips
+}
+
+// subnetCandidates returns the subnets an ADD may allocate on, in order:
+// subnetID, if set, followed by the further candidates without duplicates.
+func subnetCandidates(subnetID string, more []string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, id := range append([]string{subnetID}, more...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			candidates = append(candidates, id)
+		}
+	}
+	return candidates
+}
+
+// reusablePort returns the first of existing with an address on one of
+// candidates, and ipAddress when set, along with that subnet. Such a port
+// was created for the same ADD by a concurrent request.
+func reusablePort(existing []ports.Port, candidates []string, ipAddress string) (*ports.Port, string) {
+	for i := range existing {
+		for _, subnetID := range candidates {
+			for _, ip := range existing[i].FixedIPs {
+				if ip.SubnetID == subnetID && (ipAddress == "" || ip.IPAddress == ipAddress) {
+					return &existing[i], subnetID
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+// portsOnOtherNetworks returns the ports of existing not on networkID.
+func portsOnOtherNetworks(existing []ports.Port, networkID string) []ports.Port {
+	var other []ports.Port
+	for _, p := range existing {
+		if p.NetworkID != networkID {
+			other = append(other, p)
+		}
+	}
+	return other
+}
+
+// segmentSubnetListOpts adds the segment_id filter, which gophercloud's
+// subnets.ListOpts does not expose, to a subnet list query.
+type segmentSubnetListOpts struct {
+	subnets.ListOpts
+	SegmentID string
+}
+
+// ToSubnetListQuery implements subnets.ListOptsBuilder.
+func (opts segmentSubnetListOpts) ToSubnetListQuery() (string, error) {
+	q, err := opts.ListOpts.ToSubnetListQuery()
+	if err != nil {
+		return "", err
+	}
+	segment := url.Values{"segment_id": []string{opts.SegmentID}}.Encode()
+	if q == "" {
+		return "?" + segment, nil
+	}
+	return q + "&" + segment, nil
+}
+
+// segmentSubnetIDs returns the IDs of the subnets of networkID that belong
+// to segmentID.
+func segmentSubnetIDs(neutronClient *gophercloud.ServiceClient, networkID, segmentID string) ([]string, error) {
+	allPages, err := subnets.List(neutronClient, segmentSubnetListOpts{
+		ListOpts:  subnets.ListOpts{NetworkID: networkID},
+		SegmentID: segmentID,
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allSubnets, err := subnets.ExtractSubnets(allPages)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(allSubnets))
+	for _, s := range allSubnets {
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+// namedSubnets returns the subnets of networkID called name.
+func namedSubnets(neutronClient *gophercloud.ServiceClient, networkID, name string) ([]subnets.Subnet, error) {
+	allPages, err := subnets.List(neutronClient, subnets.ListOpts{NetworkID: networkID, Name: name}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return subnets.ExtractSubnets(allPages)
+}
+
+// pickSubnet returns the ID of the subnet of matches, the subnets called
+// name or, when name is empty, all subnets of the network, that the match
+// strategy selects (see api.SubnetMatchError).
+func pickSubnet(matches []subnets.Subnet, name, match string) (string, error) {
+	named := ""
+	if name != "" {
+		named = fmt.Sprintf(" named %q", name)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no subnet%s on the network", named)
+	}
+	switch match {
+	case api.SubnetMatchFirst:
+		return matches[0].ID, nil
+	case api.SubnetMatchIPv4, api.SubnetMatchIPv6:
+		ipVersion := 4
+		if match == api.SubnetMatchIPv6 {
+			ipVersion = 6
+		}
+		var kept []subnets.Subnet
+		for _, s := range matches {
+			if s.IPVersion == ipVersion {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			return "", fmt.Errorf("no IPv%d subnet%s on the network", ipVersion, named)
+		}
+		matches = kept
+	}
+	if len(matches) > 1 {
+		ids := make([]string, 0, len(matches))
+		for _, s := range matches {
+			ids = append(ids, s.ID)
+		}
+		return "", fmt.Errorf("%d subnets%s on the network (%s); set subnet_match to pick one", len(matches), named, strings.Join(ids, ", "))
+	}
+	return matches[0].ID, nil
+}
+
+const (
+	// minMTU and maxMTU bound an MTU override; minIPv6MTU applies on IPv6
+	// subnets.
+	minMTU     = 68
+	minIPv6MTU = 1280
+	maxMTU     = 9216
+)
+
+// validateMTU checks an MTU override against the sane range for ipVersion.
+func validateMTU(m, ipVersion int) error {
+	lower := minMTU
+	if ipVersion == 6 {
+		lower = minIPv6MTU
+	}
+	if m < lower || m > maxMTU {
+		return fmt.Errorf("mtu %d out of range [%d, %d]", m, lower, maxMTU)
+	}
+	return nil
+}
+
+// networkMTU returns the MTU Neutron advertises for networkID.
+func networkMTU(neutronClient *gophercloud.ServiceClient, networkID string) (int, error) {
+	var network struct {
+		networks.Network
+		mtu.NetworkMTUExt
+	}
+	if err := networks.Get(neutronClient, networkID).ExtractInto(&network); err != nil {
+		return 0, err
+	}
+	return network.MTU, nil
+}
+
+const (
+	// prefixDelegationSubnetPool is the subnetpool_id Neutron reports for
+	// IPv6 prefix delegation subnets.
+	prefixDelegationSubnetPool = "prefix_delegation"
+	// pendingDelegationCIDR is the placeholder CIDR of a PD subnet whose
+	// prefix has not been delegated yet.
+	pendingDelegationCIDR = "::/64"
+)
+
+// isPrefixDelegationSubnet reports whether subnet gets its prefix through
+// IPv6 prefix delegation.
+func isPrefixDelegationSubnet(subnet *subnets.Subnet) bool {
+	return subnet.IPVersion == 6 && subnet.SubnetPoolID == prefixDelegationSubnetPool
+}
+
+// isSLAACSubnet reports whether hosts on subnet autoconfigure their IPv6
+// address from the EUI-64 of their MAC, Neutron computing the port's fixed
+// IP the same way.
+func isSLAACSubnet(subnet *subnets.Subnet) bool {
+	return subnet.IPVersion == 6 && (subnet.IPv6AddressMode == "slaac" || subnet.IPv6AddressMode == "dhcpv6-stateless")
+}
+
+// subnetPrefixLength returns the prefix length of a subnet CIDR, e.g. "64"
+// for 2001:db8::/64.
+func subnetPrefixLength(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet CIDR %q: %w", cidr, err)
+	}
+	ones, _ := network.Mask.Size()
+	return strconv.Itoa(ones), nil
+}
+
+// validatePrefixLength checks that a user-supplied prefix length fits the
+// address family of the subnet cidr: at most 32 for IPv4, 128 for IPv6.
+func validatePrefixLength(prefixLength int, cidr string) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid subnet CIDR %q: %w", cidr, err)
+	}
+	_, bits := network.Mask.Size()
+	if prefixLength < 0 || prefixLength > bits {
+		family := "IPv4"
+		if bits == 8*net.IPv6len {
+			family = "IPv6"
+		}
+		return fmt.Errorf("prefix_length %d out of range [0, %d] for %s subnet %s", prefixLength, bits, family, cidr)
+	}
+	return nil
+}
+
+// validateGatewayOverride checks that a user-supplied gateway is an IP of the
+// subnet's family and lies inside the subnet, unless onLink explicitly
+// allows an off-subnet gateway. An IPv6 link-local gateway is always
+// reachable on the link, so it needs no onLink.
+func validateGatewayOverride(gatewayIP, cidr string, onLink bool) error {
+	gw := net.ParseIP(gatewayIP)
+	if gw == nil {
+		return fmt.Errorf("invalid gateway_ip %q", gatewayIP)
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid subnet CIDR %q: %w", cidr, err)
+	}
+	if (gw.To4() == nil) != (network.IP.To4() == nil) {
+		return fmt.Errorf("gateway_ip %s does not match the address family of subnet %s", gatewayIP, cidr)
+	}
+	if !network.Contains(gw) && !onLink && !(gw.To4() == nil && gw.IsLinkLocalUnicast()) {
+		return fmt.Errorf("gateway_ip %s is outside subnet %s; set on_link to use an off-subnet gateway", gatewayIP, cidr)
+	}
+	return nil
 }
 
 // peerCredListener wraps a net.UnixListener and verifies that connecting
-// peers are root (UID 0) using SO_PEERCRED.
+// peers are root (UID 0), or run as allowedUID or allowedGID when those are
+// not -1, using SO_PEERCRED. When allowedExes is set, the peer's executable
+// must also be one of them. When failOpen is set, a peer whose credentials
+// or executable cannot be read is accepted unchecked instead of rejected.
 type peerCredListener struct {
 	*net.UnixListener
+	allowedUID int
+	allowedGID int
+	failOpen   bool
+	// allowedExes are the executable paths accepted for peers; empty
+	// skips the check.
+	allowedExes []string
+	// peerCred reads the credentials of a peer; nil uses SO_PEERCRED.
+	peerCred func(*net.UnixConn) (*unix.Ucred, error)
+	// peerExe reads the executable path of a process; nil uses
+	// /proc/<pid>/exe.
+	peerExe func(pid int32) (string, error)
+}
+
+// exeAllowed reports whether a peer running exe may connect.
+func (l *peerCredListener) exeAllowed(exe string) bool {
+	return len(l.allowedExes) == 0 || slices.Contains(l.allowedExes, exe)
+}
+
+// procExe reads the executable path of process pid from /proc. It is best
+// effort: the process may have exited, or its binary been replaced, in
+// which case the path ends in " (deleted)" and matches no allowed path.
+func procExe(pid int32) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+}
+
+// peerAllowed reports whether a peer running as uid and gid may connect.
+func (l *peerCredListener) peerAllowed(uid, gid uint32) bool {
+	return uid == 0 ||
+		(l.allowedUID >= 0 && uid == uint32(l.allowedUID)) ||
+		(l.allowedGID >= 0 && gid == uint32(l.allowedGID))
+}
+
+// chownSocket gives the socket at path to uid and gid; -1 leaves either
+// unchanged.
+func chownSocket(path string, uid, gid int) error {
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
 }
 
 func (l *peerCredListener) Accept() (net.Conn, error) {
@@ -42,9 +438,51 @@ func (l *peerCredListener) Accept() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	raw, err := conn.SyscallConn()
+	peerCred := l.peerCred
+	if peerCred == nil {
+		peerCred = peerCredentials
+	}
+	ucred, err := peerCred(conn)
 	if err != nil {
+		if l.failOpen {
+			log.Printf("WARNING accepting peer without a credentials check: %v", err)
+			return conn, nil
+		}
+		_ = conn.Close()
+		return nil, err
+	}
+	if !l.peerAllowed(ucred.Uid, ucred.Gid) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("rejected peer uid=%d gid=%d", ucred.Uid, ucred.Gid)
+	}
+	if len(l.allowedExes) == 0 {
+		return conn, nil
+	}
+	peerExe := l.peerExe
+	if peerExe == nil {
+		peerExe = procExe
+	}
+	exe, err := peerExe(ucred.Pid)
+	if err != nil {
+		if l.failOpen {
+			log.Printf("WARNING accepting peer pid=%d without an executable check: %v", ucred.Pid, err)
+			return conn, nil
+		}
 		_ = conn.Close()
+		return nil, fmt.Errorf("reading executable of peer pid=%d: %w", ucred.Pid, err)
+	}
+	if !l.exeAllowed(exe) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("rejected peer pid=%d exe=%s", ucred.Pid, exe)
+	}
+	return conn, nil
+}
+
+// peerCredentials reads the credentials of the peer of conn with
+// SO_PEERCRED.
+func peerCredentials(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
 		return nil, fmt.Errorf("failed to get raw conn: %w", err)
 	}
 	var ucred *unix.Ucred
@@ -53,18 +491,50 @@ func (l *peerCredListener) Accept() (net.Conn, error) {
 		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
 	})
 	if err != nil {
-		_ = conn.Close()
 		return nil, fmt.Errorf("raw control: %w", err)
 	}
 	if credErr != nil {
-		_ = conn.Close()
 		return nil, fmt.Errorf("getsockopt peercred: %w", credErr)
 	}
-	if ucred.Uid != 0 {
-		_ = conn.Close()
-		return nil, fmt.Errorf("rejected non-root peer uid=%d", ucred.Uid)
+	return ucred, nil
+}
+
+// listenSocket listens on a fresh Unix socket at path, owned and guarded as
+// set in cfg.
+func listenSocket(path string, cfg daemonConfig) (*peerCredListener, error) {
+	socketDir := filepath.Dir(path)
+	if err := os.MkdirAll(socketDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket dir %s: %v", socketDir, err)
+	}
+	// Remove stale socket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	unixListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		_ = unixListener.Close()
+		return nil, fmt.Errorf("failed to chmod socket: %v", err)
+	}
+	if err := chownSocket(path, cfg.SocketUID, cfg.SocketGID); err != nil {
+		_ = unixListener.Close()
+		return nil, fmt.Errorf("failed to chown socket: %v", err)
+	}
+	return guardListener(unixListener, cfg), nil
+}
+
+// guardListener checks the peers of unixListener as set in cfg.
+func guardListener(unixListener *net.UnixListener, cfg daemonConfig) *peerCredListener {
+	return &peerCredListener{
+		UnixListener: unixListener,
+		allowedUID:   cfg.SocketUID,
+		allowedGID:   cfg.SocketGID,
+		failOpen:     cfg.PeerCredPolicy == peerCredPolicyFailOpen,
+		allowedExes:  cfg.PeerExeAllowlist,
 	}
-	return conn, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
@@ -77,88 +547,870 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, api.ErrorResponse{Error: msg})
 }
 
-// newHandler creates the HTTP handler with all API routes.
-func newHandler(neutronClient *gophercloud.ServiceClient) http.Handler {
+// writeErrorReason is writeError with a machine-readable reason code.
+func writeErrorReason(w http.ResponseWriter, status int, reason, msg string) {
+	writeJSON(w, status, api.ErrorResponse{Error: msg, Reason: reason})
+}
+
+// decodeRequest decodes r's JSON body into v, answering 400 Bad Request when
+// it cannot. In strict mode the body must be sent as application/json (415
+// Unsupported Media Type otherwise) and must not carry unknown fields. It
+// reports whether v was decoded.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}, strict bool) bool {
+	dec := json.NewDecoder(r.Body)
+	if strict {
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return false
+		}
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// overrideEndpoint returns r with its Neutron calls sent to endpoint, a
+// request's endpoint_override, when set. It answers 403 Forbidden unless
+// allow is set and 400 for a malformed endpoint, and then reports false.
+func overrideEndpoint(w http.ResponseWriter, r *http.Request, endpoint string, allow bool) (*http.Request, bool) {
+	if endpoint == "" {
+		return r, true
+	}
+	if !allow {
+		writeError(w, http.StatusForbidden, "endpoint overrides are disabled on this daemon (OPENSTACK_CNI_ALLOW_ENDPOINT_OVERRIDE)")
+		return nil, false
+	}
+	if err := api.ValidateEndpointOverride(endpoint); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return r.WithContext(withEndpointOverride(r.Context(), endpoint)), true
+}
+
+// writeUnavailable answers 503 Service Unavailable with a Retry-After hint of
+// retryAfter, rounded up to whole seconds, so that callers back off instead
+// of retrying at once. A non-positive retryAfter sends no hint.
+func writeUnavailable(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	setRetryAfter(w, retryAfter)
+	writeError(w, http.StatusServiceUnavailable, msg)
+}
+
+// writeRateLimited answers 429 Too Many Requests with the
+// api.ReasonRateLimited reason and a Retry-After header of wait.
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, msg string) {
+	setRetryAfter(w, wait)
+	writeErrorReason(w, http.StatusTooManyRequests, api.ReasonRateLimited, msg)
+}
+
+// setRetryAfter sets the Retry-After header to d rounded up to whole
+// seconds, unless d is 0.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		seconds := (d + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+}
+
+// logRequests logs the method, path and Host header of every request so
+// that callers using a custom daemon_host can be correlated in the logs.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("request method=%s path=%s host=%s", r.Method, r.URL.Path, r.Host)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHandler creates the HTTP handler with all API routes, using gophercloud
+// for port operations. /reauth is unavailable.
+func newHandler(neutronClient *gophercloud.ServiceClient, cfg daemonConfig) http.Handler {
+	clients := newNeutronClientRef(neutronClient, nil)
+	return newHandlerWithPortClient(clients, gophercloudPortClient{clients: clients}, cfg)
+}
+
+// newHandlerWithAuthenticator creates the HTTP handler with a Neutron client
+// from auth, which /reauth calls again to rebuild it. It fails when the
+// initial authentication does.
+func newHandlerWithAuthenticator(auth Authenticator, cfg daemonConfig) (http.Handler, error) {
+	clients, err := newAuthenticatedClientRef(auth, cfg.NeutronClientPoolSize)
+	if err != nil {
+		return nil, err
+	}
+	return newHandlerWithPortClient(clients, gophercloudPortClient{clients: clients}, cfg), nil
+}
+
+// newHandlerWithPortClient is newHandler with the port operations routed
+// through portClient. Subnet lookups and /validate use the client held by
+// clients, which /reauth rebuilds.
+func newHandlerWithPortClient(clients *neutronClientRef, portClient NeutronPortClient, cfg daemonConfig) http.Handler {
+	return newHandlerWithEvents(clients, portClient, newEventPublisher(cfg), cfg)
+}
+
+// newHandlerWithEvents is newHandlerWithPortClient with port events
+// published through publisher instead of the one cfg configures.
+func newHandlerWithEvents(clients *neutronClientRef, portClient NeutronPortClient, publisher EventPublisher, cfg daemonConfig) http.Handler {
 	mux := http.NewServeMux()
 
+	// ipLocks serializes static IP requests for the same address so that
+	// concurrent pods do not race each other into Neutron.
+	var locks, ipLocks *containerLocks
+	if cfg.ContainerLock {
+		locks = newContainerLocks()
+		ipLocks = newContainerLocks()
+	}
+	// delLocks serializes DELs of the same container even with the container
+	// lock off, so that a runtime retrying a DEL while the first is still
+	// running does not delete the ports twice: the later DEL finds them gone.
+	delLocks := locks
+	if delLocks == nil {
+		delLocks = newContainerLocks()
+	}
+
+	pool := newWarmPool(portClient, cfg)
+	if pool != nil {
+		go pool.replenish()
+	}
+	events := newEventQueue(publisher)
+	// ADDs and DELs are counted apart, so that a pod throttled on ADD can
+	// still release its ports.
+	addLimiter := newRateLimiter(cfg.AddRateInterval, cfg.AddRateBurst)
+	delLimiter := newRateLimiter(cfg.AddRateInterval, cfg.AddRateBurst)
+
+	started := time.Now()
+	var activity neutronActivity
+	tokens := newCleanupTokens(cfg.CleanupTokenTTL)
+	recent := newRecentCreates(cfg.CreateVisibilityGrace)
+
+	// requestClient returns the current client with its calls traced under
+	// r's span, and not cancelled with r but stopping at its deadline.
+	requestClient := func(r *http.Request) *gophercloud.ServiceClient {
+		return withContext(neutronContext(r.Context()), clients.get())
+	}
+
+	// subnetCache and mtuCache spare ADD its Neutron reads for subnets and
+	// networks seen recently on the same endpoint.
+	subnetCache := newLookupCache("subnet", cfg.LookupCacheSize, cfg.LookupCacheTTL)
+	mtuCache := newLookupCache("network_mtu", cfg.LookupCacheSize, cfg.LookupCacheTTL)
+	getSubnet := func(client *gophercloud.ServiceClient, subnetID string) (*subnets.Subnet, error) {
+		key := lookupKey(client, subnetID)
+		if v, ok := subnetCache.get(key); ok {
+			return v.(*subnets.Subnet), nil
+		}
+		start := time.Now()
+		subnet, err := subnets.Get(client, subnetID).Extract()
+		allocationLatency.since(phaseSubnetGet, start)
+		// A PD subnet's CIDR changes once its prefix is delegated, so it
+		// is always read afresh.
+		if err == nil && !isPrefixDelegationSubnet(subnet) {
+			subnetCache.put(key, subnet)
+		}
+		return subnet, err
+	}
+	getNetworkMTU := func(client *gophercloud.ServiceClient, networkID string) (int, error) {
+		key := lookupKey(client, networkID)
+		if v, ok := mtuCache.get(key); ok {
+			return v.(int), nil
+		}
+		m, err := networkMTU(client, networkID)
+		if err == nil {
+			mtuCache.put(key, m)
+		}
+		return m, err
+	}
+
+	// refreshToken re-authenticates ahead of the token's expiry. A failure
+	// only delays it: the current token is still valid for a while.
+	refreshToken := func() {
+		refreshed, err := clients.refreshIfExpiring(cfg.ReauthMinTokenLifetime)
+		if err != nil {
+			log.Printf("WARNING re-authenticating before token expiry failed, keeping the current client: %v", err)
+		} else if refreshed {
+			log.Printf("re-authenticated, token expired within %s", cfg.ReauthMinTokenLifetime)
+		}
+	}
+
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-	})
-
-	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-			return
+		resp := api.HealthResponse{
+			Status:        "ok",
+			UptimeSeconds: int64(time.Since(started).Seconds()),
+		}
+		if last := activity.lastSuccess(); !last.IsZero() {
+			resp.LastNeutronSuccess = last.UTC().Format(time.RFC3339)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		allocationLatency.writePrometheus(w)
+		writeCachePrometheus(w, subnetCache, mtuCache)
+	})
+
+	mux.HandleFunc("/observe", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.ObserveRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		// Only phases timed outside the daemon are accepted.
+		if req.Phase != phaseDelegate {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown phase %q", req.Phase))
+			return
+		}
+		if req.Seconds < 0 {
+			writeError(w, http.StatusBadRequest, "seconds must not be negative")
+			return
+		}
+		d := time.Duration(req.Seconds * float64(time.Second))
+		allocationLatency.observe(req.Phase, d)
+		recordDelegateSpan(r.Context(), d)
+		writeJSON(w, http.StatusOK, api.ObserveResponse{OK: true})
+	})
+
+	mux.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.ReportRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		annotateSpan(r.Context(), req.ContainerID, req.NetworkID)
+		if req.ContainerID == "" || req.PortID == "" || len(req.Result) == 0 {
+			writeError(w, http.StatusBadRequest, "container_id, port_id and result are required")
+			return
+		}
+		var result bytes.Buffer
+		if err := json.Compact(&result, req.Result); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid result: %v", err))
+			return
+		}
+		log.Printf("REPORT container_id=%s network_id=%s port_id=%s result=%s", req.ContainerID, req.NetworkID, req.PortID, result.String())
+		writeJSON(w, http.StatusOK, api.ReportResponse{OK: true})
+	})
+
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.AddRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		// A request naming no network takes the network, and the subnet
+		// unless it names one, mapped to the pod's namespace.
+		if req.NetworkID == "" {
+			network := cfg.NamespaceNetworks[req.PodNamespace]
+			req.NetworkID = network.NetworkID
+			if req.SubnetID == "" && req.SubnetName == "" && req.SegmentID == "" && len(req.SubnetIDs) == 0 {
+				req.SubnetID = network.SubnetID
+			}
+		}
+		annotateSpan(r.Context(), req.ContainerID, req.NetworkID)
+		r, ok := overrideEndpoint(w, r, req.EndpointOverride, cfg.AllowEndpointOverride)
+		if !ok {
+			return
+		}
+		portClient := portClientWithContext(r.Context(), portClient)
+		if req.ContainerID == "" || req.NetworkID == "" {
+			writeError(w, http.StatusBadRequest, "container_id and network_id are required")
+			return
+		}
+		if req.SubnetName != "" && (req.SubnetID != "" || req.SegmentID != "" || len(req.SubnetIDs) > 0) {
+			writeError(w, http.StatusBadRequest, "subnet_name cannot be combined with subnet_id, segment_id or subnet_ids")
+			return
+		}
+		if err := api.ValidateSubnetMatch(req.SubnetMatch); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(req.SubnetIDs) > 0 && (req.SegmentID != "" || req.IPAddress != "") {
+			writeError(w, http.StatusBadRequest, "subnet_ids cannot be combined with segment_id or ip_address")
+			return
+		}
+		namer, err := namerFor(req.PortNaming, cfg.PortNamer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := req.PortSpec.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.GatewayIP != "" && net.ParseIP(req.GatewayIP) == nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid gateway_ip %q", req.GatewayIP))
+			return
+		}
+		if req.MTU != 0 {
+			if err := validateMTU(req.MTU, 4); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.RouterID != "" {
+			if !cfg.AllowRouterRoutes {
+				writeError(w, http.StatusForbidden, "router routes are disabled on this daemon (OPENSTACK_CNI_ALLOW_ROUTER_ROUTES)")
+				return
+			}
+			if err := validateRouteDestinations(req.RouterRouteDestinations); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.IPAddress != "" && net.ParseIP(req.IPAddress) == nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ip_address %q", req.IPAddress))
+			return
+		}
+		logMsg := fmt.Sprintf("ADD container_id=%s network_id=%s subnet_id=%s", req.ContainerID, req.NetworkID, req.SubnetID)
+		if req.SubnetName != "" {
+			logMsg += fmt.Sprintf(" subnet_name=%s", req.SubnetName)
+		}
+		if req.SegmentID != "" {
+			logMsg += fmt.Sprintf(" segment_id=%s", req.SegmentID)
+		}
+		if len(req.SubnetIDs) > 0 {
+			logMsg += fmt.Sprintf(" subnet_ids=%v", req.SubnetIDs)
+		}
+		if len(req.SecurityGroupIDs) > 0 {
+			logMsg += fmt.Sprintf(" security_group_ids=%v", req.SecurityGroupIDs)
+		}
+		if req.IPAddress != "" {
+			logMsg += fmt.Sprintf(" ip_address=%s", req.IPAddress)
+		}
+		if req.IPCount > 1 {
+			logMsg += fmt.Sprintf(" ip_count=%d", req.IPCount)
+		}
+		if req.EndpointOverride != "" {
+			logMsg += fmt.Sprintf(" endpoint_override=%s", req.EndpointOverride)
+		}
+		if req.AdminStateDown {
+			logMsg += " admin_state_down=true"
+		}
+		if req.MTU != 0 {
+			logMsg += fmt.Sprintf(" mtu=%d", req.MTU)
+		}
+		if req.RouterID != "" {
+			logMsg += fmt.Sprintf(" router_id=%s router_route_destinations=%v", req.RouterID, req.RouterRouteDestinations)
+		}
+		if len(req.ExtraCreateOpts) > 0 {
+			logMsg += fmt.Sprintf(" extra_create_opts=%v", req.ExtraCreateOpts)
+		}
+		if req.BindingProfile != nil {
+			logMsg += fmt.Sprintf(" binding_profile_pci_slot=%s", req.BindingProfile.PCISlot)
+		}
+		log.Print(logMsg)
+
+		rateKey := podRateKey(req.PodNamespace, req.PodName, req.ContainerID)
+		if ok, wait := addLimiter.allow(rateKey); !ok {
+			log.Printf("WARNING ADD container_id=%s throttled, too many ADDs for %s", req.ContainerID, rateKey)
+			writeRateLimited(w, wait, fmt.Sprintf("too many ADDs for %s, retry in %s", rateKey, wait.Round(time.Millisecond)))
+			return
+		}
+
+		refreshToken()
+		defer locks.lock(req.ContainerID)()
+		if req.IPAddress != "" {
+			defer ipLocks.lock(req.NetworkID + "/" + req.IPAddress)()
+		}
+
+		neutronClient := requestClient(r)
+		// cleanupClient undoes the ADD's work even past the request
+		// deadline, as the port client deletes its port.
+		cleanupClient := withContext(context.WithoutCancel(r.Context()), neutronClient)
+
+		// On routed networks, restrict the allocation to the requested
+		// segment's subnet so the IP is local to the node.
+		subnetID := req.SubnetID
+		// subnet_name, or else nothing naming a subnet at all, has the
+		// subnet picked among the network's.
+		if subnetID == "" && req.SegmentID == "" && len(req.SubnetIDs) == 0 {
+			listed := fmt.Sprintf("subnets named %q", req.SubnetName)
+			if req.SubnetName == "" {
+				listed = "subnets of network " + req.NetworkID
+			}
+			readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+			matches, err := namedSubnets(readClient, req.NetworkID, req.SubnetName)
+			cancel()
+			if isTimeout(err) {
+				log.Printf("ERROR listing %s timed out after %s: %v", listed, cfg.NeutronReadTimeout, err)
+				writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("timed out after %s listing %s", cfg.NeutronReadTimeout, listed))
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR listing %s: %v", listed, err)
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list %s: %v", listed, err))
+				return
+			}
+			subnetID, err = pickSubnet(matches, req.SubnetName, req.SubnetMatch)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.SegmentID != "" {
+			readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+			ids, err := segmentSubnetIDs(readClient, req.NetworkID, req.SegmentID)
+			cancel()
+			if isTimeout(err) {
+				log.Printf("ERROR listing subnets for segment %s timed out after %s: %v", req.SegmentID, cfg.NeutronReadTimeout, err)
+				writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("timed out after %s listing subnets for segment %s", cfg.NeutronReadTimeout, req.SegmentID))
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR listing subnets for segment %s: %v", req.SegmentID, err)
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list subnets for segment %s: %v", req.SegmentID, err))
+				return
+			}
+			switch {
+			case len(ids) == 0:
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("no subnet on network %s belongs to segment %s", req.NetworkID, req.SegmentID))
+				return
+			case subnetID == "":
+				subnetID = ids[0]
+			default:
+				found := false
+				for _, id := range ids {
+					if id == subnetID {
+						found = true
+						break
+					}
+				}
+				if !found {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("subnet %s does not belong to segment %s", subnetID, req.SegmentID))
+					return
+				}
+			}
+		}
+
+		name := namer.PortName(req.ContainerID)
+
+		// A concurrent ADD for the container may have created the port
+		// while this one waited for the container lock; return that port
+		// rather than a duplicate.
+		var port *ports.Port
+		reused := false
+		if cfg.ReuseExistingPort {
+			existing, err := listPorts(portClient, ports.ListOpts{Name: name, NetworkID: req.NetworkID}, recent)
+			if err != nil {
+				log.Printf("ERROR listing ports: %v", err)
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports: %v", err))
+				return
+			}
+			if p, onSubnet := reusablePort(existing, subnetCandidates(subnetID, req.SubnetIDs), req.IPAddress); p != nil {
+				port, subnetID, reused = p, onSubnet, true
+				log.Printf("ADD reusing existing port_id=%s", port.ID)
+			} else if len(existing) > 0 {
+				log.Printf("WARNING %d port(s) named %s do not match the request, creating another", len(existing), name)
+			}
+		}
+		// A port with the container's name on another network is likely
+		// left over from an earlier network config.
+		if !reused && cfg.StalePortPolicy != stalePortPolicyIgnore {
+			named, err := portClient.List(ports.ListOpts{Name: name})
+			if err != nil {
+				log.Printf("ERROR listing ports: %v", err)
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports: %v", err))
+				return
+			}
+			if stale := portsOnOtherNetworks(named, req.NetworkID); len(stale) > 0 {
+				if cfg.StalePortPolicy != stalePortPolicyRecreate {
+					log.Printf("ERROR port %s named %s is on network %s, not %s", stale[0].ID, name, stale[0].NetworkID, req.NetworkID)
+					writeError(w, http.StatusConflict, fmt.Sprintf("port %s named %s already exists on network %s, not network_id %s", stale[0].ID, name, stale[0].NetworkID, req.NetworkID))
+					return
+				}
+				for _, p := range stale {
+					if err := portClient.Delete(p.ID); err != nil {
+						if _, ok := err.(gophercloud.ErrDefault404); !ok {
+							log.Printf("ERROR deleting stale port %s: %v", p.ID, err)
+							writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete stale port %s: %v", p.ID, err))
+							return
+						}
+					}
+					log.Printf("ADD deleted stale port_id=%s on network %s", p.ID, p.NetworkID)
+				}
+			}
+		}
+		// discardPort deletes the port of a failed ADD, unless it was
+		// reused and so belongs to the concurrent ADD that created it.
+		discardPort := func() {
+			if !reused {
+				portClient.Delete(port.ID)
+			}
 		}
-		var req api.AddRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
-			return
+
+		createOpts := specCreateOpts(req.PortSpec, name, req.NetworkID, cfg.HostID)
+		createOpts.Tags = mergeTags(cfg.PortTags, req.Tags)
+		if tag := podTag(req.PodNamespace, req.PodName); tag != "" {
+			createOpts.Tags = mergeTags(createOpts.Tags, []string{tag})
 		}
-		if req.ContainerID == "" || req.NetworkID == "" || req.SubnetID == "" {
-			writeError(w, http.StatusBadRequest, "container_id, network_id, and subnet_id are required")
-			return
+		createOpts.DeviceID = req.ContainerID
+		createOpts.Description = cfg.PortDescription.render(req.PodNamespace, req.PodName, req.ContainerID, req.NetworkID)
+		// The bandwidth QoS policy is deleted again unless the ADD succeeds;
+		// failure paths delete the port using it first.
+		var qosPolicyID string
+		added := false
+		if req.Bandwidth != nil && !reused {
+			qosPolicyID, err = createQoSPolicy(neutronClient, name, req.Bandwidth)
+			if err != nil {
+				log.Printf("ERROR %v", err)
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			log.Printf("ADD created qos_policy_id=%s min_kbps=%d max_kbps=%d", qosPolicyID, req.Bandwidth.MinKbps, req.Bandwidth.MaxKbps)
+			defer func() {
+				if !added {
+					deleteQoSPolicy(cleanupClient, qosPolicyID)
+				}
+			}()
 		}
-		logMsg := fmt.Sprintf("ADD container_id=%s network_id=%s subnet_id=%s", req.ContainerID, req.NetworkID, req.SubnetID)
-		if len(req.SecurityGroupIDs) > 0 {
-			logMsg += fmt.Sprintf(" security_group_ids=%v", req.SecurityGroupIDs)
+		pooled := false
+		if !reused && pool.serves(req, subnetID) {
+			port, pooled = pool.take(req.ContainerID, name, createOpts.Description)
 		}
-		log.Print(logMsg)
-
-		name := portName(req.ContainerID)
-		createOpts := ports.CreateOpts{
-			Name:      name,
-			NetworkID: req.NetworkID,
-			FixedIPs: []ports.IP{
-				{SubnetID: req.SubnetID},
-			},
+		if pooled {
+			log.Printf("ADD using pool port_id=%s", port.ID)
+		} else if !reused {
+			// Candidate subnets are tried in order; only running out of
+			// addresses (IpAddressGenerationFailure) moves on to the next
+			// one: other conflicts, such as the port quota being hit,
+			// would fail alike on every subnet. When every
+			// candidate reports no address available, which can clear
+			// while DHCP agents churn, the sweep is retried a few times.
+			candidates := subnetCandidates(subnetID, req.SubnetIDs)
+			for retry := 0; ; retry++ {
+				for i, candidate := range candidates {
+					createOpts.FixedIPs = fixedIPs(candidate, req.IPAddress, req.IPCount)
+					createOpts.QoSPolicyID = qosPolicyID
+					start := time.Now()
+					port, err = portClient.Create(createOpts)
+					allocationLatency.since(phasePortCreate, start)
+					if err == nil {
+						subnetID = candidate
+						break
+					}
+					if !isIPUnavailable(err) || i == len(candidates)-1 {
+						break
+					}
+					log.Printf("WARNING creating port on subnet %s failed, trying subnet %s: %v", candidate, candidates[i+1], err)
+				}
+				if !isIPUnavailable(err) || retry >= cfg.IPAllocationRetries {
+					break
+				}
+				log.Printf("WARNING no IP address available, retrying in %s (%d/%d): %v", cfg.IPAllocationRetryInterval, retry+1, cfg.IPAllocationRetries, err)
+				// A request past its deadline or given up by its caller
+				// keeps the last error rather than waiting out the retries.
+				timer := time.NewTimer(cfg.IPAllocationRetryInterval)
+				select {
+				case <-r.Context().Done():
+					timer.Stop()
+				case <-timer.C:
+				}
+				if r.Context().Err() != nil {
+					break
+				}
+			}
 		}
-		if len(req.SecurityGroupIDs) > 0 {
-			createOpts.SecurityGroups = &req.SecurityGroupIDs
+		// A requested address held by a port a detach-only DEL kept is
+		// handed over with that port.
+		adopted := false
+		if _, ok := err.(gophercloud.ErrDefault409); ok && req.IPAddress != "" {
+			detached, findErr := findDetachedPort(portClient, req.NetworkID, req.IPAddress)
+			if findErr != nil {
+				log.Printf("WARNING looking up a detached port holding %s failed: %v", req.IPAddress, findErr)
+			}
+			if detached != nil {
+				createOpts.QoSPolicyID = qosPolicyID
+				port, err = adoptPort(neutronClient, portClient, detached, req.ContainerID, createOpts)
+				if err != nil {
+					log.Printf("ERROR adopting detached port %s: %v", detached.ID, err)
+					writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to adopt detached port %s: %v", detached.ID, err))
+					return
+				}
+				for _, ip := range detached.FixedIPs {
+					if ip.IPAddress == req.IPAddress {
+						subnetID = ip.SubnetID
+					}
+				}
+				adopted = true
+				log.Printf("ADD adopted detached port_id=%s ip=%s", port.ID, req.IPAddress)
+			}
 		}
-		port, err := ports.Create(neutronClient, createOpts).Extract()
 		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault409); ok && req.IPAddress != "" {
+				log.Printf("ERROR creating port: ip_address %s already allocated: %v", req.IPAddress, err)
+				writeError(w, http.StatusConflict, fmt.Sprintf("ip_address %s is already allocated on network %s", req.IPAddress, req.NetworkID))
+				return
+			}
 			log.Printf("ERROR creating port: %v", err)
 			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create port: %v", err))
 			return
 		}
 
 		// Get subnet details for CIDR and gateway
-		subnet, err := subnets.Get(neutronClient, req.SubnetID).Extract()
+		readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+		subnet, err := getSubnet(readClient, subnetID)
+		cancel()
+		if isTimeout(err) {
+			log.Printf("ERROR getting subnet %s timed out after %s, cleaning up port %s: %v", subnetID, cfg.NeutronReadTimeout, port.ID, err)
+			discardPort()
+			writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("timed out after %s getting subnet %s", cfg.NeutronReadTimeout, subnetID))
+			return
+		}
 		if err != nil {
 			log.Printf("ERROR getting subnet, cleaning up port %s: %v", port.ID, err)
-			ports.Delete(neutronClient, port.ID)
+			discardPort()
 			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to get subnet: %v", err))
 			return
 		}
+		activity.record()
 
-		// Extract prefix length from CIDR
-		prefixLength := ""
-		if parts := strings.SplitN(subnet.CIDR, "/", 2); len(parts) == 2 {
-			prefixLength = parts[1]
+		// A subnet on another network means stale or mixed-up IDs; its
+		// addressing would not route on the pod's network.
+		if subnet.NetworkID != req.NetworkID {
+			log.Printf("ERROR subnet %s is on network %s, not %s, cleaning up port %s", subnet.ID, subnet.NetworkID, req.NetworkID, port.ID)
+			discardPort()
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("subnet %s belongs to network %s, not network_id %s", subnet.ID, subnet.NetworkID, req.NetworkID))
+			return
 		}
 
-		// Find the IP on the requested subnet
-		ipAddress := ""
+		// PD subnets carry a placeholder CIDR until the router has obtained
+		// a prefix; addresses allocated before that are not routable.
+		delegatedPrefix := ""
+		if isPrefixDelegationSubnet(subnet) {
+			if subnet.CIDR == pendingDelegationCIDR {
+				log.Printf("ERROR subnet %s prefix not yet delegated, cleaning up port %s", subnet.ID, port.ID)
+				discardPort()
+				writeUnavailable(w, cfg.UnavailableRetryAfter, fmt.Sprintf("subnet %s has no delegated IPv6 prefix yet", subnet.ID))
+				return
+			}
+			delegatedPrefix = subnet.CIDR
+		}
+
+		gatewayIP := subnet.GatewayIP
+		if req.GatewayIP != "" {
+			if err := validateGatewayOverride(req.GatewayIP, subnet.CIDR, req.OnLink); err != nil {
+				log.Printf("ERROR invalid gateway override, cleaning up port %s: %v", port.ID, err)
+				discardPort()
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			gatewayIP = req.GatewayIP
+		}
+
+		// warn logs a condition that does not fail the ADD and reports it
+		// to the CNI in the response.
+		var warnings []string
+		warn := func(format string, args ...interface{}) {
+			msg := fmt.Sprintf(format, args...)
+			log.Printf("WARNING %s", msg)
+			warnings = append(warnings, msg)
+		}
+
+		// An explicit MTU takes precedence over the network's; a failed
+		// lookup, or a network without an MTU when the net-mtu extension
+		// is unavailable, only leaves the MTU to the delegate's default.
+		portMTU := req.MTU
+		if portMTU != 0 {
+			if err := validateMTU(portMTU, subnet.IPVersion); err != nil {
+				log.Printf("ERROR invalid mtu override, cleaning up port %s: %v", port.ID, err)
+				discardPort()
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		} else {
+			readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+			m, err := getNetworkMTU(readClient, req.NetworkID)
+			cancel()
+			switch {
+			case err != nil:
+				warn("getting MTU of network %s: %v", req.NetworkID, err)
+			case m == 0:
+				warn("network %s reports no MTU, the net-mtu extension may be unavailable; leaving the MTU to the delegate", req.NetworkID)
+			default:
+				portMTU = m
+			}
+		}
+
+		prefixLength, err := subnetPrefixLength(subnet.CIDR)
+		if err != nil {
+			log.Printf("ERROR subnet %s, cleaning up port %s: %v", subnet.ID, port.ID, err)
+			discardPort()
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("subnet %s: %v", subnet.ID, err))
+			return
+		}
+		if req.PrefixLength != nil {
+			if err := validatePrefixLength(*req.PrefixLength, subnet.CIDR); err != nil {
+				log.Printf("ERROR invalid prefix_length override, cleaning up port %s: %v", port.ID, err)
+				discardPort()
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			prefixLength = strconv.Itoa(*req.PrefixLength)
+		}
+
+		// A port without any fixed IP is reported as such rather than as
+		// missing an IP on the subnet, unless fallback IPAM takes over.
+		if len(port.FixedIPs) == 0 && !req.FallbackIPAM {
+			log.Printf("ERROR port %s has no fixed IP, cleaning up", port.ID)
+			discardPort()
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("neutron assigned no IP to port %s", port.ID))
+			return
+		}
+
+		// Find the IPs on the requested subnet
+		var ipAddresses []string
 		for _, ip := range port.FixedIPs {
-			if ip.SubnetID == req.SubnetID {
-				ipAddress = ip.IPAddress
-				break
+			if ip.SubnetID == subnetID {
+				ipAddresses = append(ipAddresses, ip.IPAddress)
+			}
+		}
+		ipAddress := ""
+		if len(ipAddresses) > 0 {
+			ipAddress = ipAddresses[0]
+		}
+		var fallbackStart, fallbackEnd string
+		if ipAddress == "" {
+			if !req.FallbackIPAM {
+				// The port got addresses, only elsewhere: the subnet and
+				// network Neutron used are not the ones asked for.
+				var got []string
+				for _, ip := range port.FixedIPs {
+					got = append(got, ip.IPAddress+" on "+ip.SubnetID)
+				}
+				log.Printf("ERROR port %s has no IP on subnet %s, only %s, cleaning up", port.ID, subnetID, strings.Join(got, ", "))
+				discardPort()
+				writeErrorReason(w, http.StatusInternalServerError, api.AddReasonNoIPOnSubnet, fmt.Sprintf(
+					"port %s created on network %s but no address on requested subnet %s (got %s); check how the subnet and network are associated in Neutron",
+					port.ID, req.NetworkID, subnetID, strings.Join(got, ", ")))
+				return
+			}
+			log.Printf("WARNING port %s has no IP on subnet %s, leaving allocation to fallback IPAM", port.ID, subnetID)
+			block, err := fallbackRange(subnet)
+			if err != nil {
+				log.Printf("ERROR no fallback IPAM range on subnet %s, cleaning up port %s: %v", subnetID, port.ID, err)
+				discardPort()
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("no fallback IPAM range on subnet %s: %v", subnetID, err))
+				return
+			}
+			if err := allowFallbackRange(portClient, port, block); err != nil {
+				log.Printf("ERROR allowing fallback IPAM range %s on port %s, cleaning up: %v", block, port.ID, err)
+				discardPort()
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to allow fallback IPAM range %s on port %s: %v", block, port.ID, err))
+				return
 			}
+			fallbackStart, fallbackEnd = block.Addr().String(), lastAddr(block).String()
 		}
 
-		log.Printf("ADD success port_id=%s mac=%s ip=%s", port.ID, port.MACAddress, ipAddress)
-		writeJSON(w, http.StatusOK, api.AddResponse{
-			PortID:       port.ID,
-			MACAddress:   port.MACAddress,
-			IPAddress:    ipAddress,
-			PrefixLength: prefixLength,
-			GatewayIP:    subnet.GatewayIP,
-		})
+		if req.RouterID != "" {
+			if ipAddress == "" {
+				log.Printf("ERROR port %s has no IP to route to, cleaning up", port.ID)
+				discardPort()
+				writeError(w, http.StatusBadRequest, "router routes need a Neutron-assigned IP")
+				return
+			}
+			if err := addRouterRoutes(neutronClient, req.RouterID, req.RouterRouteDestinations, ipAddress); err != nil {
+				log.Printf("ERROR adding routes on router %s, cleaning up port %s: %v", req.RouterID, port.ID, err)
+				discardPort()
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to add routes on router %s: %v", req.RouterID, err))
+				return
+			}
+			log.Printf("ADD routed %v via %s on router %s", req.RouterRouteDestinations, ipAddress, req.RouterID)
+		}
+
+		log.Printf("ADD success port_id=%s mac=%s ip=%s subnet_id=%s security_groups=%v", port.ID, port.MACAddress, ipAddress, subnetID, port.SecurityGroups)
+		resp := api.AddResponse{
+			PortID:          port.ID,
+			MACAddress:      port.MACAddress,
+			IPAddress:       ipAddress,
+			PrefixLength:    prefixLength,
+			GatewayIP:       gatewayIP,
+			SubnetID:        subnetID,
+			DelegatedPrefix: delegatedPrefix,
+			SubnetCIDR:      subnet.CIDR,
+			FallbackStart:   fallbackStart,
+			FallbackEnd:     fallbackEnd,
+			IPVersion:       subnet.IPVersion,
+			ProjectID:       port.ProjectID,
+			NetworkID:       req.NetworkID,
+			SecurityGroups:  port.SecurityGroups,
+			Created:         !pooled && !adopted && !reused,
+			MTU:             portMTU,
+		}
+		if len(ipAddresses) > 1 {
+			resp.IPAddresses = ipAddresses
+		}
+		if resp.ProjectID == "" {
+			resp.ProjectID = port.TenantID
+		}
+		for _, route := range subnet.HostRoutes {
+			resp.HostRoutes = append(resp.HostRoutes, api.Route{Dst: route.DestinationCIDR, GW: route.NextHop})
+		}
+		for _, ip := range port.FixedIPs {
+			resp.FixedIPs = append(resp.FixedIPs, api.FixedIP{SubnetID: ip.SubnetID, IPAddress: ip.IPAddress})
+		}
+		if isSLAACSubnet(subnet) {
+			if id, err := api.EUI64InterfaceID(port.MACAddress); err != nil {
+				warn("computing the EUI-64 interface ID of port %s: %v", port.ID, err)
+			} else {
+				resp.InterfaceID = id
+			}
+			if ipAddress != "" && !api.MatchesEUI64(ipAddress, port.MACAddress) {
+				warn("port %s ip %s on SLAAC subnet %s does not end with the EUI-64 of mac %s", port.ID, ipAddress, subnetID, port.MACAddress)
+			}
+		}
+		if req.BindingProfile != nil {
+			readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+			binding, err := portBinding(readClient, port.ID)
+			cancel()
+			if err != nil {
+				warn("getting binding:vif_type of port %s: %v", port.ID, err)
+			} else {
+				resp.VIFType = binding.VIFType
+			}
+		}
+		// Past the request deadline the caller was answered 504, so the port
+		// is undone instead of being left to a container that never got it.
+		if !commitResponse(w) {
+			log.Printf("ERROR request deadline of %s exceeded, cleaning up port %s", cfg.RequestTimeout, port.ID)
+			if req.RouterID != "" {
+				if _, err := removeRouterRoutes(cleanupClient, req.RouterID, []string{ipAddress}); err != nil {
+					log.Printf("WARNING removing routes via %s from router %s: %v", ipAddress, req.RouterID, err)
+				}
+			}
+			discardPort()
+			return
+		}
+		if req.CleanupToken {
+			resp.CleanupToken = tokens.issue(cleanupClaims{
+				PortID: port.ID, NetworkID: req.NetworkID, ContainerID: req.ContainerID,
+				Name: port.Name, DeviceID: port.DeviceID, QoSPolicy: req.Bandwidth != nil,
+			})
+		}
+		resp.Warnings = warnings
+		if resp.Created {
+			events.emit(PortEvent{
+				Type: portEventCreated, PortID: port.ID, NetworkID: req.NetworkID, ContainerID: req.ContainerID,
+				Pod: podRef(req.PodNamespace, req.PodName), IPAddress: ipAddress,
+			})
+		}
+		recent.record(req.NetworkID, name)
+		added = true
+		writeJSON(w, http.StatusOK, resp)
 	})
 
 	mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
@@ -167,47 +1419,224 @@ func newHandler(neutronClient *gophercloud.ServiceClient) http.Handler {
 			return
 		}
 		var req api.DelRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		if req.NetworkID == "" {
+			req.NetworkID = cfg.NamespaceNetworks[req.PodNamespace].NetworkID
+		}
+		annotateSpan(r.Context(), req.ContainerID, req.NetworkID)
+		r, ok := overrideEndpoint(w, r, req.EndpointOverride, cfg.AllowEndpointOverride)
+		if !ok {
+			return
+		}
+		portClient := portClientWithContext(r.Context(), portClient)
+		if req.CleanupToken != "" {
+			delByToken(w, req.CleanupToken, tokenDel{
+				tokens: tokens, locks: delLocks, portClient: portClient, neutronClient: requestClient(r),
+				pool: pool, events: events, recent: recent,
+			})
 			return
 		}
 		if req.ContainerID == "" || req.NetworkID == "" {
 			writeError(w, http.StatusBadRequest, "container_id and network_id are required")
 			return
 		}
-		log.Printf("DEL container_id=%s network_id=%s", req.ContainerID, req.NetworkID)
+		namer, err := namerFor(req.PortNaming, cfg.PortNamer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("DEL container_id=%s network_id=%s strict=%t detach_only=%t", req.ContainerID, req.NetworkID, req.Strict, req.DetachOnly)
+
+		rateKey := podRateKey(req.PodNamespace, req.PodName, req.ContainerID)
+		if ok, wait := delLimiter.allow(rateKey); !ok {
+			log.Printf("WARNING DEL container_id=%s throttled, too many DELs for %s", req.ContainerID, rateKey)
+			writeRateLimited(w, wait, fmt.Sprintf("too many DELs for %s, retry in %s", rateKey, wait.Round(time.Millisecond)))
+			return
+		}
+
+		refreshToken()
 
-		name := portName(req.ContainerID)
+		defer delLocks.lock(req.ContainerID)()
+
+		name := namer.PortName(req.ContainerID)
 		listOpts := ports.ListOpts{
 			Name:      name,
 			NetworkID: req.NetworkID,
 		}
-		allPages, err := ports.List(neutronClient, listOpts).AllPages()
+		allPorts, err := listPorts(portClient, listOpts, recent)
 		if err != nil {
 			log.Printf("ERROR listing ports: %v", err)
 			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports: %v", err))
 			return
 		}
-		allPorts, err := ports.ExtractPorts(allPages)
-		if err != nil {
-			log.Printf("ERROR extracting ports: %v", err)
-			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to extract ports: %v", err))
-			return
+		activity.record()
+
+		// Drop the pod's router routes before its address can be reused.
+		if req.RouterID != "" && len(allPorts) > 0 {
+			if !cfg.AllowRouterRoutes {
+				log.Printf("WARNING router routes are disabled, not removing routes on router %s", req.RouterID)
+			} else {
+				var nexthops []string
+				for _, p := range allPorts {
+					for _, ip := range p.FixedIPs {
+						nexthops = append(nexthops, ip.IPAddress)
+					}
+				}
+				removed, err := removeRouterRoutes(requestClient(r), req.RouterID, nexthops)
+				if err != nil {
+					if req.Strict {
+						log.Printf("ERROR removing routes on router %s: %v", req.RouterID, err)
+						writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to remove routes on router %s: %v", req.RouterID, err))
+						return
+					}
+					log.Printf("WARNING removing routes on router %s failed, routes may leak: %v", req.RouterID, err)
+				} else if len(removed) > 0 {
+					log.Printf("DEL removed %d route(s) on router %s", len(removed), req.RouterID)
+				}
+			}
 		}
 
+		// Every port is attempted even after a failure, so the response
+		// tells exactly which ports are gone and which are left. Cleanup
+		// tokens issued for them no longer apply once the DEL releases them.
+		var deleted, pooled, detached []string
+		var failed []api.PortFailure
+		attempted := 0
 		for _, p := range allPorts {
-			if err := ports.Delete(neutronClient, p.ID).ExtractErr(); err != nil {
+			tokens.revoke(p.ID)
+			if req.DetachOnly {
+				attempted++
+				if err := detachPort(portClient, p, req.QoSPolicy); err != nil {
+					if _, ok := err.(gophercloud.ErrDefault404); ok {
+						log.Printf("DEL port_id=%s already gone", p.ID)
+						continue
+					}
+					log.Printf("WARNING detaching port %s failed: %v", p.ID, err)
+					failed = append(failed, api.PortFailure{PortID: p.ID, Error: err.Error()})
+					continue
+				}
+				log.Printf("DEL detached port_id=%s", p.ID)
+				detached = append(detached, p.ID)
+				continue
+			}
+			if pool.give(p) {
+				log.Printf("DEL returned port_id=%s to the pool", p.ID)
+				pooled = append(pooled, p.ID)
+				continue
+			}
+			attempted++
+			if err := portClient.Delete(p.ID); err != nil {
 				// Don't error if port is already gone (404)
-				if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				if _, ok := err.(gophercloud.ErrDefault404); ok {
+					log.Printf("DEL port_id=%s already gone", p.ID)
+					continue
+				}
+				if req.Strict {
 					log.Printf("ERROR deleting port %s: %v", p.ID, err)
-					writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete port %s: %v", p.ID, err))
-					return
+				} else {
+					log.Printf("WARNING deleting port %s failed, port may leak: %v", p.ID, err)
 				}
+				failed = append(failed, api.PortFailure{PortID: p.ID, Error: err.Error()})
+				continue
 			}
 			log.Printf("DEL deleted port_id=%s", p.ID)
+			deleted = append(deleted, p.ID)
+			event := PortEvent{
+				Type: portEventDeleted, PortID: p.ID, NetworkID: p.NetworkID, ContainerID: req.ContainerID,
+				Pod: podRef(req.PodNamespace, req.PodName),
+			}
+			if len(p.FixedIPs) > 0 {
+				event.IPAddress = p.FixedIPs[0].IPAddress
+			}
+			events.emit(event)
+		}
+
+		// Detached ports no longer use the policies; the pod adopting one
+		// gets a policy of its own.
+		if req.QoSPolicy && len(failed) == 0 {
+			policyIDs, err := deleteQoSPolicies(requestClient(r), name)
+			if err != nil {
+				log.Printf("WARNING deleting QoS policies of port %s failed, policies may leak: %v", name, err)
+			}
+			for _, id := range policyIDs {
+				log.Printf("DEL deleted qos_policy_id=%s", id)
+			}
+		}
+
+		resp := api.DelResponse{OK: true, DeletedPortIDs: deleted, PooledPortIDs: pooled, DetachedPortIDs: detached, FailedPorts: failed}
+		if req.Strict && len(failed) > 0 {
+			verb := "delete"
+			if req.DetachOnly {
+				verb = "detach"
+			}
+			resp.OK = false
+			resp.Error = fmt.Sprintf("failed to %s port %s: %s", verb, failed[0].PortID, failed[0].Error)
+			if len(failed) > 1 {
+				resp.Error = fmt.Sprintf("failed to %s %d of %d ports, first %s: %s", verb, len(failed), attempted, failed[0].PortID, failed[0].Error)
+			}
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		recent.forget(req.NetworkID, name)
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/up", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.UpRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		annotateSpan(r.Context(), req.ContainerID, req.NetworkID)
+		r, ok := overrideEndpoint(w, r, req.EndpointOverride, cfg.AllowEndpointOverride)
+		if !ok {
+			return
+		}
+		portClient := portClientWithContext(r.Context(), portClient)
+		if req.ContainerID == "" || req.NetworkID == "" || req.PortID == "" {
+			writeError(w, http.StatusBadRequest, "container_id, network_id, and port_id are required")
+			return
+		}
+		namer, err := namerFor(req.PortNaming, cfg.PortNamer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("UP container_id=%s network_id=%s port_id=%s", req.ContainerID, req.NetworkID, req.PortID)
+		refreshToken()
+
+		defer locks.lock(req.ContainerID)()
+
+		// Only touch ports this daemon created for the container.
+		port, err := portClient.Get(req.PortID)
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("port %s not found", req.PortID))
+				return
+			}
+			log.Printf("ERROR getting port %s: %v", req.PortID, err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to get port %s: %v", req.PortID, err))
+			return
+		}
+		if port.Name != namer.PortName(req.ContainerID) || port.NetworkID != req.NetworkID {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("port %s does not belong to container %s", req.PortID, req.ContainerID))
+			return
 		}
 
-		writeJSON(w, http.StatusOK, api.DelResponse{OK: true})
+		adminStateUp := true
+		if _, err := portClient.Update(req.PortID, ports.UpdateOpts{AdminStateUp: &adminStateUp}); err != nil {
+			log.Printf("ERROR setting port %s admin state up: %v", req.PortID, err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to set port %s admin state up: %v", req.PortID, err))
+			return
+		}
+		activity.record()
+		log.Printf("UP success port_id=%s", req.PortID)
+		writeJSON(w, http.StatusOK, api.UpResponse{OK: true})
 	})
 
 	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
@@ -216,40 +1645,226 @@ func newHandler(neutronClient *gophercloud.ServiceClient) http.Handler {
 			return
 		}
 		var req api.CheckRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		if req.NetworkID == "" {
+			req.NetworkID = cfg.NamespaceNetworks[req.PodNamespace].NetworkID
+		}
+		annotateSpan(r.Context(), req.ContainerID, req.NetworkID)
+		r, ok := overrideEndpoint(w, r, req.EndpointOverride, cfg.AllowEndpointOverride)
+		if !ok {
 			return
 		}
+		portClient := portClientWithContext(r.Context(), portClient)
 		if req.ContainerID == "" || req.NetworkID == "" {
 			writeError(w, http.StatusBadRequest, "container_id and network_id are required")
 			return
 		}
+		namer, err := namerFor(req.PortNaming, cfg.PortNamer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		log.Printf("CHECK container_id=%s network_id=%s", req.ContainerID, req.NetworkID)
+		refreshToken()
 
-		name := portName(req.ContainerID)
+		name := namer.PortName(req.ContainerID)
 		listOpts := ports.ListOpts{
 			Name:      name,
 			NetworkID: req.NetworkID,
 		}
-		allPages, err := ports.List(neutronClient, listOpts).AllPages()
+		allPorts, err := listPorts(portClient, listOpts, recent)
+		if err != nil {
+			log.Printf("ERROR listing ports: %v", err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports: %v", err))
+			return
+		}
+		activity.record()
+
+		resp := api.CheckResponse{Exists: len(allPorts) > 0}
+		if resp.Exists {
+			p := allPorts[0]
+			resp.PortID = p.ID
+			resp.NetworkID = p.NetworkID
+			resp.MatchCount = len(allPorts)
+			resp.ProjectID = p.ProjectID
+			if resp.ProjectID == "" {
+				resp.ProjectID = p.TenantID
+			}
+			resp.RevisionNumber = p.RevisionNumber
+			resp.Status = p.Status
+			if !p.CreatedAt.IsZero() {
+				resp.CreatedAt = p.CreatedAt.UTC().Format(time.RFC3339)
+			}
+			resp.DeviceID = p.DeviceID
+			// Listed ports lack the binding attributes; a failed lookup
+			// only leaves them out.
+			if neutronClient := requestClient(r); neutronClient != nil {
+				readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+				binding, err := portBinding(readClient, p.ID)
+				cancel()
+				if err != nil {
+					log.Printf("WARNING getting binding of port %s: %v", p.ID, err)
+				} else {
+					resp.HostID = binding.HostID
+					resp.VIFType = binding.VIFType
+				}
+			}
+		} else {
+			resp.Reason = api.CheckReasonNoMatchingPort
+			resp.Detail = fmt.Sprintf("no ports matched name %s on network %s", name, req.NetworkID)
+			resp.Filter = &api.CheckFilter{Name: name, NetworkID: req.NetworkID}
+		}
+		log.Printf("CHECK result exists=%v port_id=%s network_id=%s matches=%d revision_number=%d status=%s host_id=%s vif_type=%s device_id=%s reason=%s", resp.Exists, resp.PortID, resp.NetworkID, resp.MatchCount, resp.RevisionNumber, resp.Status, resp.HostID, resp.VIFType, resp.DeviceID, resp.Reason)
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/port", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		containerID, networkID := query.Get("container_id"), query.Get("network_id")
+		if networkID == "" {
+			networkID = cfg.NamespaceNetworks[query.Get("pod_namespace")].NetworkID
+		}
+		annotateSpan(r.Context(), containerID, networkID)
+		portClient := portClientWithContext(r.Context(), portClient)
+		if containerID == "" || networkID == "" {
+			writeError(w, http.StatusBadRequest, "container_id and network_id are required")
+			return
+		}
+		namer, err := namerFor(query.Get("port_naming"), cfg.PortNamer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		refreshToken()
+
+		name := namer.PortName(containerID)
+		allPorts, err := listPorts(portClient, ports.ListOpts{Name: name, NetworkID: networkID}, recent)
 		if err != nil {
 			log.Printf("ERROR listing ports: %v", err)
 			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to list ports: %v", err))
 			return
 		}
-		allPorts, err := ports.ExtractPorts(allPages)
+		activity.record()
+		if len(allPorts) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("no ports matched name %s on network %s", name, networkID))
+			return
+		}
+
+		p := allPorts[0]
+		resp := api.PortResponse{
+			PortID:         p.ID,
+			Name:           p.Name,
+			NetworkID:      p.NetworkID,
+			MACAddress:     p.MACAddress,
+			Status:         p.Status,
+			AdminStateUp:   p.AdminStateUp,
+			DeviceID:       p.DeviceID,
+			DeviceOwner:    p.DeviceOwner,
+			ProjectID:      p.ProjectID,
+			RevisionNumber: p.RevisionNumber,
+			MatchCount:     len(allPorts),
+		}
+		if resp.ProjectID == "" {
+			resp.ProjectID = p.TenantID
+		}
+		if !p.CreatedAt.IsZero() {
+			resp.CreatedAt = p.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		for _, ip := range p.FixedIPs {
+			resp.FixedIPs = append(resp.FixedIPs, api.FixedIP{SubnetID: ip.SubnetID, IPAddress: ip.IPAddress})
+		}
+		// Listed ports lack the binding attributes; a failed lookup only
+		// leaves them out.
+		if neutronClient := requestClient(r); neutronClient != nil {
+			readClient, cancel := withTimeout(r.Context(), neutronClient, cfg.NeutronReadTimeout)
+			binding, err := portBinding(readClient, p.ID)
+			cancel()
+			if err != nil {
+				log.Printf("WARNING getting binding of port %s: %v", p.ID, err)
+			} else {
+				resp.HostID = binding.HostID
+				resp.VIFType = binding.VIFType
+				resp.VNICType = binding.VNICType
+				resp.BindingProfile = binding.Profile
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/reauth", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		log.Print("REAUTH rebuilding Neutron client")
+		expiresAt, err := clients.reload()
 		if err != nil {
-			log.Printf("ERROR extracting ports: %v", err)
-			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to extract ports: %v", err))
+			log.Printf("ERROR re-authenticating, keeping the current client: %v", err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to re-authenticate: %v", err))
 			return
 		}
+		resp := api.ReauthResponse{OK: true}
+		if !expiresAt.IsZero() {
+			resp.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		}
+		log.Printf("REAUTH success expires_at=%s", resp.ExpiresAt)
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/drain-node", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.DrainNodeRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		portClient := portClientWithContext(r.Context(), portClient)
+		if req.HostID == "" {
+			writeError(w, http.StatusBadRequest, "host_id is required")
+			return
+		}
+		log.Printf("DRAIN host_id=%s dry_run=%t", req.HostID, req.DryRun)
+		refreshToken()
+		drainNode(w, req, portClient, requestClient(r), pool)
+	})
+
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req api.ValidateRequest
+		if !decodeRequest(w, r, &req, cfg.StrictJSON) {
+			return
+		}
+		if req.NetworkID == "" || (req.SubnetID == "" && req.SegmentID == "") {
+			writeError(w, http.StatusBadRequest, "network_id and subnet_id (or segment_id) are required")
+			return
+		}
+		log.Printf("VALIDATE network_id=%s subnet_id=%s", req.NetworkID, req.SubnetID)
 
-		exists := len(allPorts) > 0
-		log.Printf("CHECK result exists=%v", exists)
-		writeJSON(w, http.StatusOK, api.CheckResponse{Exists: exists})
+		resp, err := validateConfig(requestClient(r), req)
+		if err != nil {
+			log.Printf("ERROR validating config: %v", err)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		activity.record()
+		log.Printf("VALIDATE result valid=%v errors=%v", resp.Valid, resp.Errors)
+		writeJSON(w, http.StatusOK, resp)
 	})
 
-	return mux
+	// The limiter is inside the deadline guard so that a request answered
+	// 504 keeps its slot until its handler returns.
+	return traceRequests(logRequests(guardDeadline(cfg.RequestTimeout, limitRequests(newRequestLimiter(cfg.MaxConcurrentRequests, cfg.MaxQueueDepth), mux))))
 }
 
 // buildAuthOpts reads OpenStack auth options from OS_* environment variables
@@ -268,44 +1883,133 @@ func main() {
 	log.SetPrefix("[openstack-port-daemon] ")
 	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
 
-	// --- OpenStack authentication from environment ---
-	log.Println("authenticating with OpenStack from OS_* environment variables")
-	authOpts, err := buildAuthOpts()
+	checkConfigPath := flag.String("check-config", "", "validate the CNI network config at this path against the cloud, print a report and exit")
+	dumpPortsFlag := flag.Bool("dump-ports", false, "print the daemon-managed ports as a JSON manifest and exit")
+	flag.Parse()
+
+	cfg, err := loadDaemonConfig()
 	if err != nil {
-		log.Fatalf("failed to read OS_* env vars: %v", err)
+		log.Fatalf("failed to read daemon config: %v", err)
+	}
+
+	// --- Optional credentials env file (e.g. a mounted Kubernetes secret) ---
+	var envWatcher *envFileWatcher
+	if cfg.EnvFile != "" {
+		log.Printf("loading OpenStack credentials from env file %s", cfg.EnvFile)
+		envWatcher = newEnvFileWatcher(cfg.EnvFile)
+		if _, err := envWatcher.load(); err != nil {
+			log.Fatalf("failed to load env file: %v", err)
+		}
 	}
-	provider, err := openstack.AuthenticatedClient(authOpts)
+
+	logStartupConfig(log.Default(), cfg, api.SocketPath)
+
+	// --- Optional OpenTelemetry tracing, exported over OTLP ---
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatalf("failed to authenticate with OpenStack: %v", err)
+		log.Fatalf("failed to set up tracing: %v", err)
 	}
-	neutronClient, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{})
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	// --- OpenStack authentication from environment ---
+	log.Println("authenticating with OpenStack from OS_* environment variables")
+	var auth Authenticator = envAuthenticator{envWatcher: envWatcher, userAgent: cfg.UserAgent}
+	clients, err := newAuthenticatedClientRef(auth, cfg.NeutronClientPoolSize)
 	if err != nil {
-		log.Fatalf("failed to create Neutron client: %v", err)
+		log.Fatalf("%v", err)
 	}
 	log.Println("OpenStack authentication successful, Neutron client ready")
 
-	// --- Prepare Unix domain socket ---
-	socketDir := filepath.Dir(api.SocketPath)
-	if err := os.MkdirAll(socketDir, 0755); err != nil {
-		log.Fatalf("failed to create socket dir %s: %v", socketDir, err)
+	// --check-config exits with 1 when the config fails a check.
+	if *checkConfigPath != "" {
+		ok, err := checkConfigFile(clients.get(), *checkConfigPath, os.Stdout)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
 	}
-	// Remove stale socket
-	if err := os.Remove(api.SocketPath); err != nil && !os.IsNotExist(err) {
-		log.Fatalf("failed to remove stale socket: %v", err)
+
+	if *dumpPortsFlag {
+		if err := dumpPorts(clients.get(), os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A rotated env file is handled like POST /reauth: the client is rebuilt
+	// from the new credentials and swapped in.
+	if envWatcher != nil {
+		go envWatcher.watch(ctx, cfg.EnvFilePollInterval, func() error {
+			_, err := clients.reload()
+			return err
+		})
 	}
 
-	unixListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: api.SocketPath, Net: "unix"})
+	// --- Prepare Unix domain socket ---
+	// Sockets passed by socket activation are served as they are, so they
+	// stay open, queueing requests, while the daemon restarts.
+	activated, err := activatedListeners(listenFDsStart)
 	if err != nil {
-		log.Fatalf("failed to listen on %s: %v", api.SocketPath, err)
+		log.Fatalf("%v", err)
 	}
-	if err := os.Chmod(api.SocketPath, 0660); err != nil {
-		log.Fatalf("failed to chmod socket: %v", err)
+	listener, adopted, err := adoptOrListenSocket(api.SocketPath, cfg, activated)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if adopted {
+		log.Printf("serving inherited socket %s", api.SocketPath)
+	} else {
+		log.Printf("listening on %s", api.SocketPath)
 	}
-	listener := &peerCredListener{UnixListener: unixListener}
-	log.Printf("listening on %s", api.SocketPath)
 
 	// --- Server with graceful shutdown ---
-	srv := &http.Server{Handler: newHandler(neutronClient)}
+	handler := newHandlerWithPortClient(clients, gophercloudPortClient{clients: clients}, cfg)
+	srv := &http.Server{Handler: handler}
+
+	// The optional gRPC socket shares the handler, and so its locks and
+	// warm pool, with the HTTP socket.
+	var grpcSrv *grpc.Server
+	grpcAdopted := false
+	if cfg.GRPCSocket != "" {
+		var grpcListener *peerCredListener
+		grpcListener, grpcAdopted, err = adoptOrListenSocket(cfg.GRPCSocket, cfg, activated)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("serving gRPC on %s (inherited=%t)", cfg.GRPCSocket, grpcAdopted)
+		grpcSrv = newGRPCServer(handler)
+		go func() {
+			if err := grpcSrv.Serve(grpcListener); err != nil {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
+	closeUnusedListeners(activated)
+
+	// A removed socket is listened on again; the servers keep serving
+	// their previous listeners too, until shutdown closes them all.
+	go watchSocket(ctx, api.SocketPath, cfg, cfg.SocketCheckInterval, func(l net.Listener) {
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				log.Printf("ERROR server error on re-created socket: %v", err)
+			}
+		}()
+	})
+	if grpcSrv != nil {
+		go watchSocket(ctx, cfg.GRPCSocket, cfg, cfg.SocketCheckInterval, func(l net.Listener) {
+			go func() {
+				if err := grpcSrv.Serve(l); err != nil {
+					log.Printf("ERROR gRPC server error on re-created socket: %v", err)
+				}
+			}()
+		})
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
@@ -313,6 +2017,10 @@ func main() {
 	go func() {
 		sig := <-sigCh
 		log.Printf("received signal %v, shutting down", sig)
+		cancel()
+		if grpcSrv != nil {
+			grpcSrv.GracefulStop()
+		}
 		_ = srv.Shutdown(context.Background())
 	}()
 
@@ -321,7 +2029,13 @@ func main() {
 		log.Fatalf("server error: %v", err)
 	}
 
-	// Clean up socket
-	_ = os.Remove(api.SocketPath)
+	// Clean up sockets, except inherited ones: their creator, e.g.
+	// systemd, keeps them for the next daemon.
+	if !adopted {
+		_ = os.Remove(api.SocketPath)
+	}
+	if cfg.GRPCSocket != "" && !grpcAdopted {
+		_ = os.Remove(cfg.GRPCSocket)
+	}
 	log.Println("daemon stopped")
 }
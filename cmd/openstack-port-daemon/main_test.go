@@ -3,14 +3,30 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	th "github.com/gophercloud/gophercloud/testhelper"
 	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"golang.org/x/sys/unix"
 
 	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
 )
 
 // ---------------------------------------------------------------------------
@@ -55,25 +71,30 @@ func TestBuildAuthOptsAllowReauth(t *testing.T) {
 }
 
 // ---------------------------------------------------------------------------
-// TestPortName
+// TestNamerFor
 // ---------------------------------------------------------------------------
 
-func TestPortName(t *testing.T) {
+func TestNamerFor(t *testing.T) {
 	tests := []struct {
-		name        string
-		containerID string
-		want        string
+		strategy string
+		want     string
+		wantErr  bool
 	}{
-		{"long ID truncated", "abcdef1234567890abcdef", "k8s-pod-abcdef123456"},
-		{"exactly 12 chars", "abcdef123456", "k8s-pod-abcdef123456"},
-		{"short ID unchanged", "abc", "k8s-pod-abc"},
-		{"empty string", "", "k8s-pod-"},
+		{strategy: "", want: "k8s-pod-abcdef123456"},
+		{strategy: portname.StrategyFullID, want: "k8s-pod-abcdef1234567890"},
+		{strategy: "bogus", wantErr: true},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := portName(tt.containerID)
-			if got != tt.want {
-				t.Errorf("portName(%q) = %q, want %q", tt.containerID, got, tt.want)
+		t.Run(tt.strategy, func(t *testing.T) {
+			namer, err := namerFor(tt.strategy, portname.DefaultNamer{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("namerFor(%q) error = %v, wantErr %t", tt.strategy, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := namer.PortName("abcdef1234567890"); got != tt.want {
+				t.Errorf("PortName() = %q, want %q", got, tt.want)
 			}
 		})
 	}
@@ -122,6 +143,437 @@ func TestWriteError(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// TestWriteUnavailable
+// ---------------------------------------------------------------------------
+
+func TestWriteUnavailable(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{10 * time.Second, "10"},
+		{1500 * time.Millisecond, "2"},
+		{0, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeUnavailable(rec, tt.retryAfter, "try later")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("writeUnavailable(%v) Retry-After = %q, want %q", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestStrictJSON
+// ---------------------------------------------------------------------------
+
+func TestStrictJSON(t *testing.T) {
+	const unknownField = `{"container_id":"abcdef1234567890","network_id":"net-uuid","netwrok_id":"typo"}`
+	tests := []struct {
+		name        string
+		strict      bool
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"LenientIgnoresUnknownField", false, "application/json", unknownField, http.StatusOK},
+		{"LenientAcceptsMissingContentType", false, "", unknownField, http.StatusOK},
+		{"StrictRejectsUnknownField", true, "application/json", unknownField, http.StatusBadRequest},
+		{"StrictRejectsMissingContentType", true, "", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`, http.StatusUnsupportedMediaType},
+		{"StrictRejectsOtherContentType", true, "text/plain", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`, http.StatusUnsupportedMediaType},
+		{"StrictAcceptsKnownFields", true, "application/json; charset=utf-8", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultDaemonConfig()
+			cfg.StrictJSON = tt.strict
+			handler := newHandlerWithPortClient(nil, newFakePortClient(), cfg)
+			req := httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestSpecCreateOpts
+// ---------------------------------------------------------------------------
+
+func TestSpecCreateOpts(t *testing.T) {
+	vfNum := 2
+	tests := []struct {
+		name   string
+		spec   api.PortSpec
+		hostID string
+		want   map[string]interface{}
+		absent []string
+	}{
+		{
+			name:   "plain",
+			want:   map[string]interface{}{"name": "k8s-pod-abcdef123456", "network_id": "net-uuid"},
+			absent: []string{"security_groups", "admin_state_up", "binding:host_id", "binding:profile", "qos_policy_id"},
+		},
+		{
+			name: "security groups and admin state",
+			spec: api.PortSpec{SecurityGroupIDs: []string{"sg-1", "sg-2"}, AdminStateDown: true},
+			want: map[string]interface{}{"security_groups": []interface{}{"sg-1", "sg-2"}, "admin_state_up": false},
+		},
+		{
+			name:   "binding",
+			spec:   api.PortSpec{BindingProfile: &api.BindingProfile{PCISlot: "0000:03:00.5", CardSerialNumber: "MT2113X00000", PFMACAddress: "00:53:00:00:00:42", VFNum: &vfNum}},
+			hostID: "node-1",
+			want: map[string]interface{}{
+				"binding:host_id":   "node-1",
+				"binding:vnic_type": "remote-managed",
+				"binding:profile":   map[string]interface{}{"pci_slot": "0000:03:00.5", "card_serial_number": "MT2113X00000", "pf_mac_address": "00:53:00:00:00:42", "vf_num": float64(2)},
+			},
+		},
+		{
+			name: "extra create opts",
+			spec: api.PortSpec{ExtraCreateOpts: map[string]interface{}{"propagate_uplink_status": true, "dns_name": "pod-a"}},
+			want: map[string]interface{}{"propagate_uplink_status": true, "dns_name": "pod-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := specCreateOpts(tt.spec, "k8s-pod-abcdef123456", "net-uuid", tt.hostID)
+			body, err := opts.ToPortCreateMap()
+			if err != nil {
+				t.Fatalf("ToPortCreateMap() error = %v", err)
+			}
+			// Round-trip through JSON so the values compare as Neutron
+			// receives them.
+			data, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sent struct {
+				Port map[string]interface{} `json:"port"`
+			}
+			if err := json.Unmarshal(data, &sent); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := sent.Port[key]; !reflect.DeepEqual(got, want) {
+					t.Errorf("port[%q] = %#v, want %#v", key, got, want)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := sent.Port[key]; ok {
+					t.Errorf("port[%q] = %#v, want it unset", key, sent.Port[key])
+				}
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestPortCreateOptsHostID
+// ---------------------------------------------------------------------------
+
+func TestPortCreateOptsHostID(t *testing.T) {
+	opts := portCreateOpts{
+		CreateOpts: ports.CreateOpts{Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+		HostID:     "node-1",
+	}
+	body, err := opts.ToPortCreateMap()
+	if err != nil {
+		t.Fatalf("ToPortCreateMap() error = %v", err)
+	}
+	if got := body["port"].(map[string]interface{})["binding:host_id"]; got != "node-1" {
+		t.Errorf("binding:host_id = %v, want node-1", got)
+	}
+
+	opts.HostID = ""
+	body, err = opts.ToPortCreateMap()
+	if err != nil {
+		t.Fatalf("ToPortCreateMap() error = %v", err)
+	}
+	if _, ok := body["port"].(map[string]interface{})["binding:host_id"]; ok {
+		t.Error("binding:host_id set without a host ID")
+	}
+}
+
+func TestPickSubnet(t *testing.T) {
+	v4a := subnets.Subnet{ID: "v4-a", IPVersion: 4}
+	v4b := subnets.Subnet{ID: "v4-b", IPVersion: 4}
+	v6 := subnets.Subnet{ID: "v6", IPVersion: 6}
+	tests := []struct {
+		name    string
+		unnamed bool // no subnet_name, all subnets of the network
+		matches []subnets.Subnet
+		match   string
+		want    string
+		wantErr string
+	}{
+		{name: "single", matches: []subnets.Subnet{v6}, want: "v6"},
+		{name: "none", matches: nil, wantErr: `no subnet named "pods"`},
+		{name: "several default", matches: []subnets.Subnet{v4a, v6}, wantErr: "2 subnets named"},
+		{name: "several error", matches: []subnets.Subnet{v4a, v6}, match: api.SubnetMatchError, wantErr: "set subnet_match"},
+		{name: "first", matches: []subnets.Subnet{v6, v4a}, match: api.SubnetMatchFirst, want: "v6"},
+		{name: "ipv4", matches: []subnets.Subnet{v6, v4a}, match: api.SubnetMatchIPv4, want: "v4-a"},
+		{name: "ipv6", matches: []subnets.Subnet{v4a, v6}, match: api.SubnetMatchIPv6, want: "v6"},
+		{name: "ipv4 still several", matches: []subnets.Subnet{v4a, v4b, v6}, match: api.SubnetMatchIPv4, wantErr: "2 subnets named"},
+		{name: "ipv6 none", matches: []subnets.Subnet{v4a, v4b}, match: api.SubnetMatchIPv6, wantErr: "no IPv6 subnet"},
+		{name: "unnamed single", unnamed: true, matches: []subnets.Subnet{v4a}, want: "v4-a"},
+		{name: "unnamed none", unnamed: true, matches: nil, wantErr: "no subnet on the network"},
+		{name: "unnamed several", unnamed: true, matches: []subnets.Subnet{v4a, v6}, wantErr: "2 subnets on the network"},
+		{name: "unnamed ipv6", unnamed: true, matches: []subnets.Subnet{v4a, v6}, match: api.SubnetMatchIPv6, want: "v6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "pods"
+			if tt.unnamed {
+				name = ""
+			}
+			got, err := pickSubnet(tt.matches, name, tt.match)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("pickSubnet() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pickSubnet() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("pickSubnet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		static, tags, want []string
+	}{
+		{},
+		{static: []string{"a", "b"}, want: []string{"a", "b"}},
+		{tags: []string{"c"}, want: []string{"c"}},
+		{static: []string{"a", "b"}, tags: []string{"c", "a", "c"}, want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		if got := mergeTags(tt.static, tt.tags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeTags(%v, %v) = %v, want %v", tt.static, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestPodTag(t *testing.T) {
+	tests := []struct {
+		namespace, name, want string
+	}{
+		{namespace: "default", name: "web-0", want: "k8s-pod=default/web-0"},
+		{name: "web-0"},
+		{namespace: "default", name: strings.Repeat("a", api.MaxTagLength)},
+	}
+	for _, tt := range tests {
+		if got := podTag(tt.namespace, tt.name); got != tt.want {
+			t.Errorf("podTag(%q, %q) = %q, want %q", tt.namespace, tt.name, got, tt.want)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestPeerAllowed
+// ---------------------------------------------------------------------------
+
+func TestPeerAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		uid, gid int
+		peerUID  uint32
+		peerGID  uint32
+		want     bool
+	}{
+		{name: "root", uid: -1, gid: -1, peerUID: 0, peerGID: 0, want: true},
+		{name: "non-root", uid: -1, gid: -1, peerUID: 1000, peerGID: 1000, want: false},
+		{name: "allowed uid", uid: 1000, gid: -1, peerUID: 1000, peerGID: 5, want: true},
+		{name: "allowed gid", uid: -1, gid: 2000, peerUID: 1000, peerGID: 2000, want: true},
+		{name: "other gid", uid: 1001, gid: 2000, peerUID: 1000, peerGID: 2001, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &peerCredListener{allowedUID: tt.uid, allowedGID: tt.gid}
+			if got := l.peerAllowed(tt.peerUID, tt.peerGID); got != tt.want {
+				t.Errorf("peerAllowed(%d, %d) = %t, want %t", tt.peerUID, tt.peerGID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerCredListenerPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOpen bool
+		credErr  error
+		uid      uint32
+		wantErr  bool
+	}{
+		{name: "fail closed unreadable", credErr: errors.New("no peer credentials"), wantErr: true},
+		{name: "fail open unreadable", failOpen: true, credErr: errors.New("no peer credentials")},
+		{name: "fail closed root", uid: 0},
+		{name: "fail open non-root", failOpen: true, uid: 1000, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cni.sock")
+			unixListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unixListener.Close()
+			l := &peerCredListener{
+				UnixListener: unixListener,
+				allowedUID:   -1,
+				allowedGID:   -1,
+				failOpen:     tt.failOpen,
+				peerCred: func(*net.UnixConn) (*unix.Ucred, error) {
+					if tt.credErr != nil {
+						return nil, tt.credErr
+					}
+					return &unix.Ucred{Uid: tt.uid, Gid: tt.uid}, nil
+				},
+			}
+
+			client, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := l.Accept()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Accept() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if conn != nil {
+				_ = conn.Close()
+			}
+		})
+	}
+}
+
+func TestPeerCredListenerExeAllowlist(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		failOpen bool
+		exe      string
+		exeErr   error
+		wantErr  bool
+	}{
+		{name: "no allowlist", exe: "/usr/bin/curl"},
+		{name: "allowed", allowed: []string{"/opt/cni/bin/openstack-port-cni"}, exe: "/opt/cni/bin/openstack-port-cni"},
+		{name: "denied", allowed: []string{"/opt/cni/bin/openstack-port-cni"}, exe: "/usr/bin/curl", wantErr: true},
+		{name: "replaced binary", allowed: []string{"/opt/cni/bin/openstack-port-cni"}, exe: "/opt/cni/bin/openstack-port-cni (deleted)", wantErr: true},
+		{name: "fail closed unreadable", allowed: []string{"/opt/cni/bin/openstack-port-cni"}, exeErr: os.ErrNotExist, wantErr: true},
+		{name: "fail open unreadable", allowed: []string{"/opt/cni/bin/openstack-port-cni"}, failOpen: true, exeErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cni.sock")
+			unixListener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unixListener.Close()
+			l := &peerCredListener{
+				UnixListener: unixListener,
+				allowedUID:   -1,
+				allowedGID:   -1,
+				failOpen:     tt.failOpen,
+				allowedExes:  tt.allowed,
+				peerCred: func(*net.UnixConn) (*unix.Ucred, error) {
+					return &unix.Ucred{Pid: 4242}, nil
+				},
+				peerExe: func(pid int32) (string, error) {
+					if pid != 4242 {
+						t.Errorf("peerExe(%d), want the SO_PEERCRED pid 4242", pid)
+					}
+					return tt.exe, tt.exeErr
+				},
+			}
+
+			client, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := l.Accept()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Accept() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if conn != nil {
+				_ = conn.Close()
+			}
+		})
+	}
+}
+
+func TestProcExe(t *testing.T) {
+	want, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	got, err := procExe(int32(os.Getpid()))
+	if err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+	if got != want {
+		t.Errorf("procExe(self) = %q, want %q", got, want)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestChownSocket
+// ---------------------------------------------------------------------------
+
+func TestChownSocket(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+	path := filepath.Join(t.TempDir(), "cni.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := chownSocket(path, 1234, 5678); err != nil {
+		t.Fatalf("chownSocket() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("owner = %d:%d, want 1234:5678", st.Uid, st.Gid)
+	}
+
+	// -1 leaves the current owner in place.
+	if err := chownSocket(path, -1, 42); err != nil {
+		t.Fatalf("chownSocket() error = %v", err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	st = info.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 42 {
+		t.Errorf("owner = %d:%d, want 1234:42", st.Uid, st.Gid)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestHealthEndpoint
 // ---------------------------------------------------------------------------
@@ -130,7 +582,7 @@ func TestHealthEndpoint(t *testing.T) {
 	th.SetupHTTP()
 	defer th.TeardownHTTP()
 
-	handler := newHandler(thclient.ServiceClient())
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 
 	t.Run("Success", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/health", nil)
@@ -140,12 +592,54 @@ func TestHealthEndpoint(t *testing.T) {
 		if rec.Code != http.StatusOK {
 			t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
 		}
-		var body map[string]string
+		var body api.HealthResponse
 		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
 			t.Fatalf("decode: %v", err)
 		}
-		if body["status"] != "ok" {
-			t.Errorf("body = %v, want {status:ok}", body)
+		if body.Status != "ok" {
+			t.Errorf("status = %q, want ok", body.Status)
+		}
+		if body.UptimeSeconds < 0 {
+			t.Errorf("uptime_seconds = %d, want >= 0", body.UptimeSeconds)
+		}
+		if body.LastNeutronSuccess != "" {
+			t.Errorf("last_neutron_success = %q before any Neutron call, want empty", body.LastNeutronSuccess)
+		}
+	})
+
+	t.Run("LastNeutronSuccessUpdates", func(t *testing.T) {
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ports": []}`))
+		})
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		health := func() api.HealthResponse {
+			t.Helper()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			var body api.HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			return body
+		}
+
+		if got := health().LastNeutronSuccess; got != "" {
+			t.Fatalf("last_neutron_success = %q before any Neutron call, want empty", got)
+		}
+		before := time.Now().Add(-time.Second)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/check", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("check status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+
+		last, err := time.Parse(time.RFC3339, health().LastNeutronSuccess)
+		if err != nil {
+			t.Fatalf("last_neutron_success: %v", err)
+		}
+		if last.Before(before) {
+			t.Errorf("last_neutron_success = %s, want after %s", last, before)
 		}
 	})
 
@@ -160,6 +654,152 @@ func TestHealthEndpoint(t *testing.T) {
 	})
 }
 
+// listNoPorts answers the port lists an ADD makes before creating its port
+// with no ports, and passes the other requests to create.
+func listNoPorts(create http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ports": []}`))
+			return
+		}
+		create(w, r)
+	}
+}
+
+// handleAddPortAndSubnet registers the standard port create, port delete and
+// subnet get mocks used by /add tests. The returned flag is set once the
+// created port is deleted.
+func handleAddPortAndSubnet(t *testing.T) *bool {
+	t.Helper()
+	deleted := new(bool)
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{
+			"port": {
+				"id": "port-uuid-1234",
+				"name": "k8s-pod-abcdef123456",
+				"mac_address": "fa:16:3e:aa:bb:cc",
+				"network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}],
+				"status": "ACTIVE"
+			}
+		}`))
+	}))
+	th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			*deleted = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{
+			"subnet": {
+				"id": "subnet-uuid",
+				"cidr": "10.0.0.0/24",
+				"gateway_ip": "10.0.0.1",
+				"network_id": "net-uuid"
+			}
+		}`))
+	})
+	return deleted
+}
+
+// handlePrefixDelegationAdd registers /add mocks for an IPv6 prefix
+// delegation subnet whose current CIDR is cidr. The returned flag is set once
+// the created port is deleted.
+func handlePrefixDelegationAdd(t *testing.T, cidr string) *bool {
+	t.Helper()
+	deleted := new(bool)
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{
+			"port": {
+				"id": "port-uuid-pd",
+				"name": "k8s-pod-abcdef123456",
+				"mac_address": "fa:16:3e:aa:bb:cc",
+				"network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "pd-subnet-uuid", "ip_address": "2001:db8:1::5"}]
+			}
+		}`))
+	}))
+	th.Mux.HandleFunc("/ports/port-uuid-pd", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			*deleted = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/subnets/pd-subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, `{
+			"subnet": {
+				"id": "pd-subnet-uuid",
+				"ip_version": 6,
+				"cidr": %q,
+				"gateway_ip": "2001:db8:1::1",
+				"subnetpool_id": "prefix_delegation",
+				"network_id": "net-uuid"
+			}
+		}`, cidr)
+	})
+	return deleted
+}
+
+// handleAddPortWithoutIP registers /add mocks where Neutron creates the port
+// without any fixed IP, on a subnet allocating 10.0.0.2-10.0.0.127. The
+// returned flag is set once the port is deleted, the returned pairs once
+// its allowed address pairs are updated.
+func handleAddPortWithoutIP(t *testing.T) (*bool, *[]ports.AddressPair) {
+	t.Helper()
+	deleted := new(bool)
+	pairs := new([]ports.AddressPair)
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{
+			"port": {
+				"id": "port-uuid-noip",
+				"name": "k8s-pod-abcdef123456",
+				"mac_address": "fa:16:3e:aa:bb:cc",
+				"network_id": "net-uuid",
+				"fixed_ips": []
+			}
+		}`))
+	}))
+	th.Mux.HandleFunc("/ports/port-uuid-noip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var body struct {
+				Port struct {
+					AllowedAddressPairs []ports.AddressPair `json:"allowed_address_pairs"`
+				} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decode update body: %v", err)
+			}
+			*pairs = body.Port.AllowedAddressPairs
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-noip", "network_id": "net-uuid"}}`))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			*deleted = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid",
+			"allocation_pools": [{"start": "10.0.0.2", "end": "10.0.0.127"}]}}`))
+	})
+	return deleted, pairs
+}
+
 // ---------------------------------------------------------------------------
 // TestAddEndpoint
 // ---------------------------------------------------------------------------
@@ -170,7 +810,7 @@ func TestAddEndpoint(t *testing.T) {
 		defer th.TeardownHTTP()
 
 		// Mock port create
-		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodPost {
 				t.Errorf("unexpected method %s on /ports", r.Method)
 			}
@@ -186,7 +826,7 @@ func TestAddEndpoint(t *testing.T) {
 					"status": "ACTIVE"
 				}
 			}`))
-		})
+		}))
 
 		// Mock subnet get
 		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
@@ -195,6 +835,7 @@ func TestAddEndpoint(t *testing.T) {
 			_, _ = w.Write([]byte(`{
 				"subnet": {
 					"id": "subnet-uuid",
+					"ip_version": 4,
 					"cidr": "10.0.0.0/24",
 					"gateway_ip": "10.0.0.1",
 					"network_id": "net-uuid"
@@ -202,7 +843,7 @@ func TestAddEndpoint(t *testing.T) {
 			}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
 		req := httptest.NewRequest(http.MethodPost, "/add", body)
 		rec := httptest.NewRecorder()
@@ -231,13 +872,16 @@ func TestAddEndpoint(t *testing.T) {
 		if resp.GatewayIP != "10.0.0.1" {
 			t.Errorf("GatewayIP = %q, want %q", resp.GatewayIP, "10.0.0.1")
 		}
+		if resp.IPVersion != 4 {
+			t.Errorf("IPVersion = %d, want 4", resp.IPVersion)
+		}
 	})
 
 	t.Run("MissingFields", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{}`)
 		req := httptest.NewRequest(http.MethodPost, "/add", body)
 		rec := httptest.NewRecorder()
@@ -252,7 +896,7 @@ func TestAddEndpoint(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{not json}`)
 		req := httptest.NewRequest(http.MethodPost, "/add", body)
 		rec := httptest.NewRecorder()
@@ -267,7 +911,7 @@ func TestAddEndpoint(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		req := httptest.NewRequest(http.MethodGet, "/add", nil)
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
@@ -286,7 +930,7 @@ func TestAddEndpoint(t *testing.T) {
 			_, _ = w.Write([]byte(`{"error": "boom"}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
 		req := httptest.NewRequest(http.MethodPost, "/add", body)
 		rec := httptest.NewRecorder()
@@ -308,7 +952,7 @@ func TestAddEndpoint(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodPost {
 				t.Errorf("unexpected method %s on /ports", r.Method)
 			}
@@ -339,10 +983,11 @@ func TestAddEndpoint(t *testing.T) {
 					"mac_address": "fa:16:3e:aa:bb:cc",
 					"network_id": "net-uuid",
 					"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}],
+					"security_groups": ["sg-id-1", "sg-id-2"],
 					"status": "ACTIVE"
 				}
 			}`))
-		})
+		}))
 
 		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
@@ -357,7 +1002,7 @@ func TestAddEndpoint(t *testing.T) {
 			}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","security_group_ids":["sg-id-1","sg-id-2"]}`)
 		req := httptest.NewRequest(http.MethodPost, "/add", body)
 		rec := httptest.NewRecorder()
@@ -373,56 +1018,1442 @@ func TestAddEndpoint(t *testing.T) {
 		if resp.PortID != "port-uuid-sg" {
 			t.Errorf("PortID = %q, want %q", resp.PortID, "port-uuid-sg")
 		}
+		if want := []string{"sg-id-1", "sg-id-2"}; !reflect.DeepEqual(resp.SecurityGroups, want) {
+			t.Errorf("SecurityGroups = %v, want %v", resp.SecurityGroups, want)
+		}
 	})
-}
-
-// ---------------------------------------------------------------------------
-// TestDelEndpoint
-// ---------------------------------------------------------------------------
 
-func TestDelEndpoint(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
+	t.Run("DefaultSecurityGroup", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodGet {
-				t.Errorf("unexpected method %s on /ports", r.Method)
-			}
+		// Neutron applies the project's default group to a port created
+		// without security_groups.
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{
-				"ports": [
-					{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc"}
-				]
-			}`))
-		})
-
-		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodDelete {
-				t.Errorf("unexpected method %s on port delete", r.Method)
-			}
-			w.WriteHeader(http.StatusNoContent)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}], "security_groups": ["sg-default"]}}`))
+		}))
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
-		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
-		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
 		rec := httptest.NewRecorder()
-		handler.ServeHTTP(rec, req)
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
 
 		if rec.Code != http.StatusOK {
 			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
 		}
-		var resp api.DelResponse
+		var resp api.AddResponse
 		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
 			t.Fatalf("decode: %v", err)
 		}
-		if !resp.OK {
-			t.Error("expected OK=true")
+		if want := []string{"sg-default"}; !reflect.DeepEqual(resp.SecurityGroups, want) {
+			t.Errorf("SecurityGroups = %v, want %v", resp.SecurityGroups, want)
 		}
 	})
-
+	t.Run("WithExtraCreateOpts", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			var reqBody struct {
+				Port map[string]interface{} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				t.Errorf("failed to decode request body: %v", err)
+			}
+			if got, ok := reqBody.Port["propagate_uplink_status"].(bool); !ok || !got {
+				t.Errorf("propagate_uplink_status = %v, want true", reqBody.Port["propagate_uplink_status"])
+			}
+			if got := reqBody.Port["network_id"]; got != "net-uuid" {
+				t.Errorf("network_id = %v, want %q", got, "net-uuid")
+			}
+			if got := reqBody.Port["name"]; got != "k8s-pod-abcdef123456" {
+				t.Errorf("name = %v, want %q", got, "k8s-pod-abcdef123456")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-extra",
+					"name": "k8s-pod-abcdef123456",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}],
+					"status": "ACTIVE"
+				}
+			}`))
+		}))
+
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"subnet": {
+					"id": "subnet-uuid",
+					"cidr": "10.0.0.0/24",
+					"gateway_ip": "10.0.0.1",
+					"network_id": "net-uuid"
+				}
+			}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","extra_create_opts":{"propagate_uplink_status":true}}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	})
+
+	t.Run("WithTags", func(t *testing.T) {
+		for _, tt := range []struct {
+			name    string
+			reqTags string
+			want    []interface{}
+		}{
+			{name: "StaticOnly", want: []interface{}{"environment=prod", "managed-by=cni"}},
+			{name: "Merged", reqTags: `,"tags":["team=payments","managed-by=cni"]`, want: []interface{}{"environment=prod", "managed-by=cni", "team=payments"}},
+			// The pod is tagged, so that a dump of the ports can tell it.
+			{name: "Pod", reqTags: `,"pod_namespace":"default","pod_name":"web-0"`, want: []interface{}{"environment=prod", "managed-by=cni", "k8s-pod=default/web-0"}},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				th.SetupHTTP()
+				defer th.TeardownHTTP()
+
+				var tags, deviceID interface{}
+				th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+					var reqBody struct {
+						Port map[string]interface{} `json:"port"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+						t.Errorf("failed to decode request body: %v", err)
+					}
+					tags, deviceID = reqBody.Port["tags"], reqBody.Port["device_id"]
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					_, _ = w.Write([]byte(`{
+						"port": {
+							"id": "port-uuid-1234",
+							"name": "k8s-pod-abcdef123456",
+							"mac_address": "fa:16:3e:aa:bb:cc",
+							"network_id": "net-uuid",
+							"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]
+						}
+					}`))
+				}))
+
+				th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "ip_version": 4}}`))
+				})
+
+				cfg := defaultDaemonConfig()
+				cfg.PortTags = []string{"environment=prod", "managed-by=cni"}
+				handler := newHandler(thclient.ServiceClient(), cfg)
+				body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"` + tt.reqTags + `}`)
+				req := httptest.NewRequest(http.MethodPost, "/add", body)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+				}
+				if !reflect.DeepEqual(tags, tt.want) {
+					t.Errorf("port create tags = %v, want %v", tags, tt.want)
+				}
+				if deviceID != "abcdef1234567890" {
+					t.Errorf("port create device_id = %v, want the container ID", deviceID)
+				}
+			})
+		}
+	})
+
+	t.Run("ExtraCreateOptsOverrideManagedField", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			t.Error("port create should not be called when extra_create_opts is rejected")
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","extra_create_opts":{"network_id":"other-net"}}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("SegmentScoped", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("segment_id"); got != "segment-uuid" {
+				t.Errorf("segment_id query = %q, want %q", got, "segment-uuid")
+			}
+			if got := r.URL.Query().Get("network_id"); got != "net-uuid" {
+				t.Errorf("network_id query = %q, want %q", got, "net-uuid")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": [{"id": "segment-subnet-uuid", "network_id": "net-uuid", "cidr": "10.1.0.0/24"}]}`))
+		})
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			var reqBody struct {
+				Port struct {
+					FixedIPs []struct {
+						SubnetID string `json:"subnet_id"`
+					} `json:"fixed_ips"`
+				} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				t.Errorf("failed to decode request body: %v", err)
+			}
+			if len(reqBody.Port.FixedIPs) != 1 || reqBody.Port.FixedIPs[0].SubnetID != "segment-subnet-uuid" {
+				t.Errorf("fixed_ips = %+v, want subnet_id segment-subnet-uuid", reqBody.Port.FixedIPs)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-seg",
+					"name": "k8s-pod-abcdef123456",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"fixed_ips": [{"subnet_id": "segment-subnet-uuid", "ip_address": "10.1.0.5"}]
+				}
+			}`))
+		}))
+
+		th.Mux.HandleFunc("/subnets/segment-subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"subnet": {
+					"id": "segment-subnet-uuid",
+					"cidr": "10.1.0.0/24",
+					"gateway_ip": "10.1.0.1",
+					"network_id": "net-uuid"
+				}
+			}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","segment_id":"segment-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.IPAddress != "10.1.0.5" {
+			t.Errorf("IPAddress = %q, want %q", resp.IPAddress, "10.1.0.5")
+		}
+		if resp.GatewayIP != "10.1.0.1" {
+			t.Errorf("GatewayIP = %q, want %q", resp.GatewayIP, "10.1.0.1")
+		}
+	})
+
+	t.Run("SubnetNotInSegment", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": [{"id": "segment-subnet-uuid", "network_id": "net-uuid"}]}`))
+		})
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			t.Error("port create should not be called when the subnet is outside the segment")
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","segment_id":"segment-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("SegmentWithoutSubnets", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": []}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","segment_id":"segment-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("SubnetName", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("name"); got != "pods" {
+				t.Errorf("subnet list name = %q, want pods", got)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": [
+				{"id": "subnet-v6-uuid", "name": "pods", "network_id": "net-uuid", "ip_version": 6},
+				{"id": "subnet-uuid", "name": "pods", "network_id": "net-uuid", "ip_version": 4}
+			]}`))
+		})
+
+		for _, tt := range []struct {
+			match      string
+			wantStatus int
+		}{
+			{match: "", wantStatus: http.StatusBadRequest},
+			{match: "error", wantStatus: http.StatusBadRequest},
+			{match: "ipv4", wantStatus: http.StatusOK},
+			{match: "bogus", wantStatus: http.StatusBadRequest},
+		} {
+			handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+			body := bytes.NewBufferString(fmt.Sprintf(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_name":"pods","subnet_match":%q}`, tt.match))
+			req := httptest.NewRequest(http.MethodPost, "/add", body)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("subnet_match %q: status = %d, want %d, body: %s", tt.match, rec.Code, tt.wantStatus, rec.Body.String())
+				continue
+			}
+			if rec.Code != http.StatusOK {
+				continue
+			}
+			var resp api.AddResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.SubnetID != "subnet-uuid" {
+				t.Errorf("subnet_match %q: SubnetID = %q, want subnet-uuid", tt.match, resp.SubnetID)
+			}
+		}
+	})
+
+	t.Run("SubnetNameWithSubnetID", func(t *testing.T) {
+		handler := newHandler(nil, defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","subnet_name":"pods"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("NetworkOnlySingleSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("network_id"); got != "net-uuid" {
+				t.Errorf("subnet list network_id = %q, want net-uuid", got)
+			}
+			if r.URL.Query().Has("name") {
+				t.Errorf("subnet list filtered by name %q", r.URL.Query().Get("name"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": [
+				{"id": "subnet-uuid", "name": "pods", "network_id": "net-uuid", "ip_version": 4}
+			]}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.SubnetID != "subnet-uuid" {
+			t.Errorf("SubnetID = %q, want subnet-uuid", resp.SubnetID)
+		}
+	})
+
+	t.Run("NetworkOnlyAmbiguous", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/subnets", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnets": [
+				{"id": "subnet-a", "name": "pods-a", "network_id": "net-uuid", "ip_version": 4},
+				{"id": "subnet-b", "name": "pods-b", "network_id": "net-uuid", "ip_version": 4}
+			]}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "2 subnets on the network (subnet-a, subnet-b)") {
+			t.Errorf("body = %q, want the ambiguous subnets listed", rec.Body.String())
+		}
+	})
+
+	t.Run("GatewayOverride", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","gateway_ip":"10.0.0.254"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.GatewayIP != "10.0.0.254" {
+			t.Errorf("GatewayIP = %q, want %q", resp.GatewayIP, "10.0.0.254")
+		}
+	})
+
+	t.Run("GatewayOverrideOutsideSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := handleAddPortAndSubnet(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","gateway_ip":"192.168.1.1"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if !*deleted {
+			t.Error("expected the created port to be cleaned up")
+		}
+	})
+
+	t.Run("GatewayOverrideOnLink", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","gateway_ip":"192.168.1.1","on_link":true}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.GatewayIP != "192.168.1.1" {
+			t.Errorf("GatewayIP = %q, want %q", resp.GatewayIP, "192.168.1.1")
+		}
+	})
+
+	t.Run("InvalidGatewayOverride", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","gateway_ip":"not-an-ip"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("PrefixDelegationSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handlePrefixDelegationAdd(t, "2001:db8:1::/64")
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"pd-subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.DelegatedPrefix != "2001:db8:1::/64" {
+			t.Errorf("DelegatedPrefix = %q, want %q", resp.DelegatedPrefix, "2001:db8:1::/64")
+		}
+		if resp.IPVersion != 6 {
+			t.Errorf("IPVersion = %d, want 6", resp.IPVersion)
+		}
+		if resp.PrefixLength != "64" {
+			t.Errorf("PrefixLength = %q, want %q", resp.PrefixLength, "64")
+		}
+		if resp.InterfaceID != "" {
+			t.Errorf("InterfaceID = %q on a subnet without SLAAC, want none", resp.InterfaceID)
+		}
+	})
+
+	t.Run("PrefixDelegationPending", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := handlePrefixDelegationAdd(t, "::/64")
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"pd-subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "10" {
+			t.Errorf("Retry-After = %q, want %q", got, "10")
+		}
+		if !*deleted {
+			t.Error("expected the created port to be cleaned up")
+		}
+	})
+
+	t.Run("SLAACSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-slaac",
+					"name": "k8s-pod-abcdef123456",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"fixed_ips": [{"subnet_id": "slaac-subnet-uuid", "ip_address": "2001:db8:2::f816:3eff:feaa:bbcc"}]
+				}
+			}`))
+		}))
+		th.Mux.HandleFunc("/subnets/slaac-subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"subnet": {
+					"id": "slaac-subnet-uuid",
+					"ip_version": 6,
+					"cidr": "2001:db8:2::/64",
+					"gateway_ip": "2001:db8:2::1",
+					"ipv6_address_mode": "slaac",
+					"ipv6_ra_mode": "slaac",
+					"network_id": "net-uuid"
+				}
+			}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"slaac-subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.InterfaceID != "f816:3eff:feaa:bbcc" {
+			t.Errorf("InterfaceID = %q, want %q", resp.InterfaceID, "f816:3eff:feaa:bbcc")
+		}
+	})
+
+	t.Run("IPv6Subnet", func(t *testing.T) {
+		for _, gateway := range []string{"2001:db8::1", "fe80::1"} {
+			t.Run(gateway, func(t *testing.T) {
+				th.SetupHTTP()
+				defer th.TeardownHTTP()
+
+				th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-v6", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+						"fixed_ips": [{"subnet_id": "v6-subnet-uuid", "ip_address": "2001:db8::5"}]}}`))
+				}))
+				th.Mux.HandleFunc("/subnets/v6-subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = fmt.Fprintf(w, `{"subnet": {"id": "v6-subnet-uuid", "ip_version": 6, "cidr": "2001:db8::/64", "gateway_ip": %q, "network_id": "net-uuid"}}`, gateway)
+				})
+
+				handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+				body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"v6-subnet-uuid"}`)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+				}
+				var resp api.AddResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if resp.IPAddress != "2001:db8::5" || resp.PrefixLength != "64" || resp.GatewayIP != gateway || resp.IPVersion != 6 {
+					t.Errorf("ip, prefix, gateway, version = %s, %s, %s, %d, want 2001:db8::5, 64, %s, 6",
+						resp.IPAddress, resp.PrefixLength, resp.GatewayIP, resp.IPVersion, gateway)
+				}
+			})
+		}
+	})
+
+	t.Run("TwoIPsOnOneSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		var fixedIPs []ports.IP
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Port struct {
+					FixedIPs []ports.IP `json:"fixed_ips"`
+				} `json:"port"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			fixedIPs = body.Port.FixedIPs
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}, {"subnet_id": "subnet-uuid", "ip_address": "10.0.0.6"}]}}`))
+		}))
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_count":2}`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if want := []ports.IP{{SubnetID: "subnet-uuid"}, {SubnetID: "subnet-uuid"}}; !reflect.DeepEqual(fixedIPs, want) {
+			t.Errorf("requested fixed_ips = %+v, want %+v", fixedIPs, want)
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.IPAddress != "10.0.0.5" || !reflect.DeepEqual(resp.IPAddresses, []string{"10.0.0.5", "10.0.0.6"}) {
+			t.Errorf("ip_address = %q, ip_addresses = %v, want 10.0.0.5 and both addresses", resp.IPAddress, resp.IPAddresses)
+		}
+	})
+
+	t.Run("FixedIPsOnOtherSubnets", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-v6", "ip_address": "fd00::5"}, {"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}, {"subnet_id": "subnet-other", "ip_address": "10.1.0.5"}]}}`))
+		}))
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.IPAddress != "10.0.0.5" || resp.IPAddresses != nil {
+			t.Errorf("ip_address = %q, ip_addresses = %v, want only 10.0.0.5", resp.IPAddress, resp.IPAddresses)
+		}
+		want := []api.FixedIP{
+			{SubnetID: "subnet-v6", IPAddress: "fd00::5"},
+			{SubnetID: "subnet-uuid", IPAddress: "10.0.0.5"},
+			{SubnetID: "subnet-other", IPAddress: "10.1.0.5"},
+		}
+		if !reflect.DeepEqual(resp.FixedIPs, want) {
+			t.Errorf("fixed_ips = %+v, want %+v", resp.FixedIPs, want)
+		}
+	})
+
+	t.Run("ProjectID", func(t *testing.T) {
+		for _, tt := range []struct {
+			name  string
+			owner string
+		}{
+			{name: "ProjectID", owner: `"project_id": "project-uuid", "tenant_id": "project-uuid"`},
+			{name: "TenantIDOnly", owner: `"tenant_id": "project-uuid"`},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				th.SetupHTTP()
+				defer th.TeardownHTTP()
+
+				th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", ` + tt.owner + `,
+						"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+				}))
+				th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+				})
+
+				handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+				body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+				}
+				var resp api.AddResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.ProjectID != "project-uuid" {
+					t.Errorf("project_id = %q, want %q", resp.ProjectID, "project-uuid")
+				}
+			})
+		}
+	})
+
+	t.Run("SubnetHostRoutes", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+		}))
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid",
+				"host_routes": [{"destination": "172.16.0.0/16", "nexthop": "10.0.0.254"}]}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if want := []api.Route{{Dst: "172.16.0.0/16", GW: "10.0.0.254"}}; !reflect.DeepEqual(resp.HostRoutes, want) {
+			t.Errorf("host_routes = %+v, want %+v", resp.HostRoutes, want)
+		}
+	})
+
+	t.Run("IPCountTooLarge", func(t *testing.T) {
+		fake := newFakePortClient()
+		rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_count":17}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if fake.created != 0 {
+			t.Errorf("created %d port(s), want none", fake.created)
+		}
+	})
+
+	t.Run("SubnetOnOtherNetwork", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := handleAddPortAndSubnet(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"stale-net-uuid","subnet_id":"subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if want := "subnet subnet-uuid belongs to network net-uuid, not network_id stale-net-uuid"; !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("body = %s, want it to contain %q", rec.Body.String(), want)
+		}
+		if !*deleted {
+			t.Error("expected the created port to be cleaned up")
+		}
+	})
+
+	t.Run("NoIPAssigned", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted, _ := handleAddPortWithoutIP(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+		if want := "neutron assigned no IP to port port-uuid-noip"; !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("body = %s, want an error containing %q", rec.Body.String(), want)
+		}
+		if !*deleted {
+			t.Error("expected the created port to be cleaned up")
+		}
+	})
+
+	t.Run("IPOnOtherSubnetOnly", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := false
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+				"fixed_ips": [{"subnet_id": "subnet-other", "ip_address": "10.1.0.5"}]}}`))
+		}))
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deleted = true
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+		var errResp api.ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&errResp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if errResp.Reason != api.AddReasonNoIPOnSubnet {
+			t.Errorf("reason = %q, want %q", errResp.Reason, api.AddReasonNoIPOnSubnet)
+		}
+		if want := "port port-uuid-1234 created on network net-uuid but no address on requested subnet subnet-uuid (got 10.1.0.5 on subnet-other)"; !strings.Contains(errResp.Error, want) {
+			t.Errorf("error = %q, want one containing %q", errResp.Error, want)
+		}
+		if !deleted {
+			t.Error("expected the created port to be cleaned up")
+		}
+	})
+
+	t.Run("NoIPAssignedFallbackIPAM", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted, pairs := handleAddPortWithoutIP(t)
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","fallback_ipam":true}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.IPAddress != "" {
+			t.Errorf("IPAddress = %q, want empty", resp.IPAddress)
+		}
+		if resp.SubnetCIDR != "10.0.0.0/24" {
+			t.Errorf("SubnetCIDR = %q, want %q", resp.SubnetCIDR, "10.0.0.0/24")
+		}
+		if resp.PortID != "port-uuid-noip" {
+			t.Errorf("PortID = %q, want %q", resp.PortID, "port-uuid-noip")
+		}
+		// The pool ends at .127: the largest block past it without the
+		// broadcast address.
+		if resp.FallbackStart != "10.0.0.128" || resp.FallbackEnd != "10.0.0.191" {
+			t.Errorf("fallback range = %s-%s, want 10.0.0.128-10.0.0.191", resp.FallbackStart, resp.FallbackEnd)
+		}
+		if want := []ports.AddressPair{{IPAddress: "10.0.0.128/26"}}; !reflect.DeepEqual(*pairs, want) {
+			t.Errorf("allowed address pairs = %+v, want %+v", *pairs, want)
+		}
+		if *deleted {
+			t.Error("port should be kept for fallback IPAM")
+		}
+	})
+
+	t.Run("StaticIPConflict", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"NeutronError": {"type": "IpAddressAlreadyAllocated", "message": "IP address 10.0.0.5 already allocated in subnet subnet-uuid"}}`))
+		}))
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.5"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+		var errResp api.ErrorResponse
+		_ = json.NewDecoder(rec.Body).Decode(&errResp)
+		if !strings.Contains(errResp.Error, "10.0.0.5") {
+			t.Errorf("error = %q, want it to name the address", errResp.Error)
+		}
+	})
+
+	t.Run("InvalidIPAddress", func(t *testing.T) {
+		handler := newHandler(nil, defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abc","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"not-an-ip"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("ConcurrentSameIP", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		var mu sync.Mutex
+		allocated := false
+		inFlight, maxInFlight := 0, 0
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			// The loser looks for a detached port holding the address.
+			if r.Method == http.MethodGet {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"ports": []}`))
+				return
+			}
+			var body struct {
+				Port struct {
+					FixedIPs []map[string]string `json:"fixed_ips"`
+				} `json:"port"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if len(body.Port.FixedIPs) != 1 || body.Port.FixedIPs[0]["ip_address"] != "10.0.0.5" {
+				t.Errorf("unexpected fixed_ips %v", body.Port.FixedIPs)
+			}
+
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			taken := allocated
+			allocated = true
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			if taken {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"NeutronError": {"type": "IpAddressAlreadyAllocated", "message": "IP address 10.0.0.5 already allocated"}}`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-123",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]
+				}
+			}`))
+		})
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		codes := make([]int, 2)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := bytes.NewBufferString(fmt.Sprintf(`{"container_id":"container-%d","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.5"}`, i))
+				req := httptest.NewRequest(http.MethodPost, "/add", body)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				codes[i] = rec.Code
+			}(i)
+		}
+		wg.Wait()
+
+		if maxInFlight != 1 {
+			t.Errorf("max concurrent creates for the same IP = %d, want 1", maxInFlight)
+		}
+		ok, conflict := 0, 0
+		for _, code := range codes {
+			switch code {
+			case http.StatusOK:
+				ok++
+			case http.StatusConflict:
+				conflict++
+			}
+		}
+		if ok != 1 || conflict != 1 {
+			t.Errorf("status codes = %v, want one %d and one %d", codes, http.StatusOK, http.StatusConflict)
+		}
+	})
+
+	t.Run("AdminStateDown", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Port map[string]interface{} `json:"port"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if up, ok := body.Port["admin_state_up"]; !ok || up != false {
+				t.Errorf("admin_state_up = %v, want false", body.Port["admin_state_up"])
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-123",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"admin_state_up": false,
+					"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]
+				}
+			}`))
+		}))
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","admin_state_down":true}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	})
+
+	t.Run("AdminStateDownConflictsWithExtraOpts", func(t *testing.T) {
+		handler := newHandler(nil, defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abc","network_id":"net-uuid","subnet_id":"subnet-uuid","admin_state_down":true,"extra_create_opts":{"admin_state_up":true}}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("MTU", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			mtu        int
+			wantStatus int
+			wantMTU    int
+			wantLookup bool
+		}{
+			{name: "FromNetwork", mtu: 0, wantStatus: http.StatusOK, wantMTU: 1450, wantLookup: true},
+			{name: "OverrideTakesPrecedence", mtu: 1400, wantStatus: http.StatusOK, wantMTU: 1400},
+			{name: "OverrideTooSmall", mtu: 20, wantStatus: http.StatusBadRequest},
+			{name: "OverrideTooLarge", mtu: 70000, wantStatus: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				th.SetupHTTP()
+				defer th.TeardownHTTP()
+
+				handleAddPortAndSubnet(t)
+				looked := false
+				th.Mux.HandleFunc("/networks/net-uuid", func(w http.ResponseWriter, r *http.Request) {
+					looked = true
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte(`{"network": {"id": "net-uuid", "mtu": 1450}}`))
+				})
+
+				handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+				body := bytes.NewBufferString(fmt.Sprintf(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":%d}`, tt.mtu))
+				req := httptest.NewRequest(http.MethodPost, "/add", body)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				if tt.wantStatus != http.StatusOK {
+					return
+				}
+				var resp api.AddResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if resp.MTU != tt.wantMTU {
+					t.Errorf("MTU = %d, want %d", resp.MTU, tt.wantMTU)
+				}
+				if looked != tt.wantLookup {
+					t.Errorf("network MTU lookup = %t, want %t", looked, tt.wantLookup)
+				}
+				if len(resp.Warnings) != 0 {
+					t.Errorf("Warnings = %q, want none", resp.Warnings)
+				}
+			})
+		}
+	})
+
+	t.Run("MTUExtensionUnavailableWarns", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+		th.Mux.HandleFunc("/networks/net-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"network": {"id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.MTU != 0 {
+			t.Errorf("MTU = %d, want 0", resp.MTU)
+		}
+		if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "net-mtu extension may be unavailable") {
+			t.Errorf("Warnings = %q, want one about the net-mtu extension", resp.Warnings)
+		}
+	})
+
+	t.Run("RouterRoutes", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleAddPortAndSubnet(t)
+		got := handleRouterWithRoutes(t, `[]`, "add_extraroutes")
+
+		cfg := defaultDaemonConfig()
+		cfg.AllowRouterRoutes = true
+		handler := newHandler(thclient.ServiceClient(), cfg)
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","router_id":"router-uuid","router_route_destinations":["192.168.100.0/24"]}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if !strings.Contains(*got, `"nexthop":"10.0.0.5"`) {
+			t.Errorf("add_extraroutes body = %s, want the pod IP as next hop", *got)
+		}
+	})
+
+	t.Run("RouterRoutesFailCleansUp", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := handleAddPortAndSubnet(t)
+		th.Mux.HandleFunc("/routers/router-uuid/add_extraroutes", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		cfg := defaultDaemonConfig()
+		cfg.AllowRouterRoutes = true
+		handler := newHandler(thclient.ServiceClient(), cfg)
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","router_id":"router-uuid","router_route_destinations":["192.168.100.0/24"]}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusInternalServerError, rec.Body.String())
+		}
+		if !*deleted {
+			t.Error("expected the port to be cleaned up")
+		}
+	})
+
+	t.Run("RouterRoutesRejected", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			allow      bool
+			body       string
+			wantStatus int
+		}{
+			{name: "Disabled", body: `"router_route_destinations":["192.168.100.0/24"]`, wantStatus: http.StatusForbidden},
+			{name: "NoDestinations", allow: true, body: `"router_route_destinations":[]`, wantStatus: http.StatusBadRequest},
+			{name: "InvalidDestination", allow: true, body: `"router_route_destinations":["192.168.100.1"]`, wantStatus: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cfg := defaultDaemonConfig()
+				cfg.AllowRouterRoutes = tt.allow
+				handler := newHandler(nil, cfg)
+				body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","router_id":"router-uuid",` + tt.body + `}`)
+				req := httptest.NewRequest(http.MethodPost, "/add", body)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("SubnetLookupTimesOut", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		deleted := new(bool)
+		th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+		}))
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				*deleted = true
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		})
+
+		cfg := defaultDaemonConfig()
+		cfg.NeutronReadTimeout = 20 * time.Millisecond
+		handler := newHandler(thclient.ServiceClient(), cfg)
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/add", body)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("ADD took %s, want it bounded by the read timeout", elapsed)
+		}
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusGatewayTimeout, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "timed out") {
+			t.Errorf("body = %s, want a timeout message", rec.Body.String())
+		}
+		if !*deleted {
+			t.Error("expected the port to be cleaned up")
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// TestDelEndpoint
+// ---------------------------------------------------------------------------
+
+func TestDelEndpoint(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Errorf("unexpected method %s on /ports", r.Method)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"ports": [
+					{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc"}
+				]
+			}`))
+		})
+
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete {
+				t.Errorf("unexpected method %s on port delete", r.Method)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.DelResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !resp.OK {
+			t.Error("expected OK=true")
+		}
+		if !reflect.DeepEqual(resp.DeletedPortIDs, []string{"port-uuid-1234"}) {
+			t.Errorf("DeletedPortIDs = %v, want [port-uuid-1234]", resp.DeletedPortIDs)
+		}
+	})
+
+	t.Run("MultipleMatches", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"ports": [
+					{"id": "port-uuid-1", "name": "k8s-pod-abcdef123456"},
+					{"id": "port-uuid-2", "name": "k8s-pod-abcdef123456"},
+					{"id": "port-uuid-gone", "name": "k8s-pod-abcdef123456"}
+				]
+			}`))
+		})
+		for _, id := range []string{"port-uuid-1", "port-uuid-2"} {
+			th.Mux.HandleFunc("/ports/"+id, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		}
+		th.Mux.HandleFunc("/ports/port-uuid-gone", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.DelResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		want := []string{"port-uuid-1", "port-uuid-2"}
+		if !reflect.DeepEqual(resp.DeletedPortIDs, want) {
+			t.Errorf("DeletedPortIDs = %v, want %v", resp.DeletedPortIDs, want)
+		}
+	})
+
 	t.Run("NoPortsFound", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
@@ -430,79 +2461,439 @@ func TestDelEndpoint(t *testing.T) {
 		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"ports": []}`))
+			_, _ = w.Write([]byte(`{"ports": []}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp api.DelResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !resp.OK {
+			t.Error("expected OK=true")
+		}
+		if len(resp.DeletedPortIDs) != 0 {
+			t.Errorf("DeletedPortIDs = %v, want none", resp.DeletedPortIDs)
+		}
+	})
+
+	t.Run("MissingFields", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{}`)
+		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("WrongMethod", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodGet, "/del", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		strict     bool
+		wantStatus int
+	}{
+		{name: "DeleteFailsLenient", strict: false, wantStatus: http.StatusOK},
+		{name: "DeleteFailsStrict", strict: true, wantStatus: http.StatusInternalServerError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+
+			th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"ports": [{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456"}]}`))
+			})
+			th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+
+			handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+			body := bytes.NewBufferString(fmt.Sprintf(`{"container_id":"abcdef1234567890","network_id":"net-uuid","strict":%t}`, tt.strict))
+			req := httptest.NewRequest(http.MethodPost, "/del", body)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.strict {
+				return
+			}
+			var resp api.DelResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if len(resp.DeletedPortIDs) != 0 {
+				t.Errorf("DeletedPortIDs = %v, want none", resp.DeletedPortIDs)
+			}
+		})
+	}
+
+	t.Run("RemovesRouterRoutes", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"ports": [{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}]}`))
+		})
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		got := handleRouterWithRoutes(t, `[
+			{"destination": "192.168.100.0/24", "nexthop": "10.0.0.5"},
+			{"destination": "192.168.200.0/24", "nexthop": "10.0.0.9"}
+		]`, "remove_extraroutes")
+
+		cfg := defaultDaemonConfig()
+		cfg.AllowRouterRoutes = true
+		handler := newHandler(thclient.ServiceClient(), cfg)
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","router_id":"router-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		want := `{"router":{"routes":[{"destination":"192.168.100.0/24","nexthop":"10.0.0.5"}]}}`
+		if strings.TrimSpace(*got) != want {
+			t.Errorf("remove_extraroutes body = %s, want %s", *got, want)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// TestUpEndpoint
+// ---------------------------------------------------------------------------
+
+func TestUpEndpoint(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		updated := false
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.Method {
+			case http.MethodGet:
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid", "admin_state_up": false}}`))
+			case http.MethodPut:
+				var body struct {
+					Port map[string]interface{} `json:"port"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if body.Port["admin_state_up"] != true {
+					t.Errorf("admin_state_up = %v, want true", body.Port["admin_state_up"])
+				}
+				updated = true
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid", "admin_state_up": true}}`))
+			default:
+				t.Errorf("unexpected method %s", r.Method)
+			}
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","port_id":"port-uuid-1234"}`)
+		req := httptest.NewRequest(http.MethodPost, "/up", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if !updated {
+			t.Error("expected the port to be updated")
+		}
+	})
+
+	t.Run("OtherContainersPort", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Errorf("unexpected method %s", r.Method)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "name": "k8s-pod-other0000000", "network_id": "net-uuid"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","port_id":"port-uuid-1234"}`)
+		req := httptest.NewRequest(http.MethodPost, "/up", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("MissingFields", func(t *testing.T) {
+		handler := newHandler(nil, defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abc","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/up", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// TestCheckEndpoint
+// ---------------------------------------------------------------------------
+
+func TestPortEndpoint(t *testing.T) {
+	t.Run("Found", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("name"); got != "k8s-pod-abcdef123456" {
+				t.Errorf("name filter = %q, want k8s-pod-abcdef123456", got)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"ports": [
+					{
+						"id": "port-uuid-1234",
+						"name": "k8s-pod-abcdef123456",
+						"network_id": "net-uuid",
+						"mac_address": "fa:16:3e:aa:bb:cc",
+						"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}],
+						"status": "ACTIVE",
+						"admin_state_up": true,
+						"device_id": "abcdef1234567890",
+						"device_owner": "compute:kuryr",
+						"project_id": "project-uuid",
+						"revision_number": 3,
+						"created_at": "2026-03-01T12:30:00Z"
+					}
+				]
+			}`))
+		})
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "binding:host_id": "node-1", "binding:vif_type": "ovs", "binding:vnic_type": "normal", "binding:profile": {"pci_slot": "0000:3b:00.2"}}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/port?container_id=abcdef1234567890&network_id=net-uuid", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		var resp api.PortResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		want := api.PortResponse{
+			PortID:         "port-uuid-1234",
+			Name:           "k8s-pod-abcdef123456",
+			NetworkID:      "net-uuid",
+			MACAddress:     "fa:16:3e:aa:bb:cc",
+			FixedIPs:       []api.FixedIP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.5"}},
+			Status:         "ACTIVE",
+			AdminStateUp:   true,
+			DeviceID:       "abcdef1234567890",
+			DeviceOwner:    "compute:kuryr",
+			ProjectID:      "project-uuid",
+			RevisionNumber: 3,
+			CreatedAt:      "2026-03-01T12:30:00Z",
+			HostID:         "node-1",
+			VIFType:        "ovs",
+			VNICType:       "normal",
+			BindingProfile: map[string]interface{}{"pci_slot": "0000:3b:00.2"},
+			MatchCount:     1,
+		}
+		if !reflect.DeepEqual(resp, want) {
+			t.Errorf("resp = %+v, want %+v", resp, want)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ports": []}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/port?container_id=abcdef1234567890&network_id=net-uuid", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+		}
+		var resp api.ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !strings.Contains(resp.Error, "k8s-pod-abcdef123456") {
+			t.Errorf("error = %q, want it to name the port looked up", resp.Error)
+		}
+	})
+
+	t.Run("MissingParams", func(t *testing.T) {
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/port?container_id=abcdef1234567890", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	t.Run("Exists", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"ports": [
+					{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc"}
+				]
+			}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
-		req := httptest.NewRequest(http.MethodPost, "/del", body)
+		req := httptest.NewRequest(http.MethodPost, "/check", body)
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
 
 		if rec.Code != http.StatusOK {
 			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
 		}
-		var resp api.DelResponse
+		var resp api.CheckResponse
 		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
 			t.Fatalf("decode: %v", err)
 		}
-		if !resp.OK {
-			t.Error("expected OK=true")
+		if !resp.Exists {
+			t.Error("expected Exists=true")
 		}
-	})
-
-	t.Run("MissingFields", func(t *testing.T) {
-		th.SetupHTTP()
-		defer th.TeardownHTTP()
-
-		handler := newHandler(thclient.ServiceClient())
-		body := bytes.NewBufferString(`{}`)
-		req := httptest.NewRequest(http.MethodPost, "/del", body)
-		rec := httptest.NewRecorder()
-		handler.ServeHTTP(rec, req)
-
-		if rec.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		if resp.PortID != "port-uuid-1234" {
+			t.Errorf("PortID = %q, want %q", resp.PortID, "port-uuid-1234")
 		}
 	})
 
-	t.Run("WrongMethod", func(t *testing.T) {
+	t.Run("ExistsReportsPortDetails", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
-		req := httptest.NewRequest(http.MethodGet, "/del", nil)
+		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{
+				"ports": [
+					{
+						"id": "port-uuid-1234",
+						"name": "k8s-pod-abcdef123456",
+						"network_id": "net-uuid",
+						"project_id": "project-uuid",
+						"tenant_id": "project-uuid",
+						"revision_number": 7,
+						"status": "DOWN",
+						"device_id": "abcdef1234567890",
+						"created_at": "2026-03-01T12:30:00Z"
+					}
+				]
+			}`))
+		})
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "binding:host_id": "node-1", "binding:vif_type": "ovs"}}`))
+		})
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		req := httptest.NewRequest(http.MethodPost, "/check", body)
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
 
-		if rec.Code != http.StatusMethodNotAllowed {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp api.CheckResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		want := api.CheckResponse{
+			Exists:         true,
+			PortID:         "port-uuid-1234",
+			NetworkID:      "net-uuid",
+			MatchCount:     1,
+			ProjectID:      "project-uuid",
+			RevisionNumber: 7,
+			Status:         "DOWN",
+			CreatedAt:      "2026-03-01T12:30:00Z",
+			HostID:         "node-1",
+			VIFType:        "ovs",
+			DeviceID:       "abcdef1234567890",
+		}
+		if resp != want {
+			t.Errorf("resp = %+v, want %+v", resp, want)
 		}
 	})
-}
-
-// ---------------------------------------------------------------------------
-// TestCheckEndpoint
-// ---------------------------------------------------------------------------
 
-func TestCheckEndpoint(t *testing.T) {
-	t.Run("Exists", func(t *testing.T) {
+	t.Run("BindingLookupFailureKeepsResult", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
 		th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{
-				"ports": [
-					{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc"}
-				]
-			}`))
+			_, _ = w.Write([]byte(`{"ports": [{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid", "device_id": "abcdef1234567890"}]}`))
+		})
+		th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
 		})
 
-		handler := newHandler(thclient.ServiceClient())
-		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
-		req := httptest.NewRequest(http.MethodPost, "/check", body)
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodPost, "/check", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`))
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
 
@@ -513,8 +2904,27 @@ func TestCheckEndpoint(t *testing.T) {
 		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
 			t.Fatalf("decode: %v", err)
 		}
-		if !resp.Exists {
-			t.Error("expected Exists=true")
+		if !resp.Exists || resp.DeviceID != "abcdef1234567890" || resp.HostID != "" || resp.VIFType != "" {
+			t.Errorf("resp = %+v, want the port with device_id and no binding", resp)
+		}
+	})
+
+	t.Run("ExistsCountsDuplicateMatches", func(t *testing.T) {
+		fake := newFakePortClient(
+			ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+			ports.Port{ID: "port-b", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+			ports.Port{ID: "port-c", Name: "k8s-pod-abcdef123456", NetworkID: "net-other"},
+		)
+		rec := serveFake(t, fake, "/check", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp api.CheckResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.PortID != "port-a" || resp.NetworkID != "net-uuid" || resp.MatchCount != 2 {
+			t.Errorf("resp = %+v, want port-a on net-uuid with 2 matches", resp)
 		}
 	})
 
@@ -528,7 +2938,7 @@ func TestCheckEndpoint(t *testing.T) {
 			_, _ = w.Write([]byte(`{"ports": []}`))
 		})
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
 		req := httptest.NewRequest(http.MethodPost, "/check", body)
 		rec := httptest.NewRecorder()
@@ -544,13 +2954,26 @@ func TestCheckEndpoint(t *testing.T) {
 		if resp.Exists {
 			t.Error("expected Exists=false")
 		}
+		if resp.PortID != "" {
+			t.Errorf("PortID = %q, want empty", resp.PortID)
+		}
+		if resp.Reason != api.CheckReasonNoMatchingPort {
+			t.Errorf("Reason = %q, want %q", resp.Reason, api.CheckReasonNoMatchingPort)
+		}
+		if resp.Detail == "" {
+			t.Error("expected Detail to be populated")
+		}
+		wantFilter := &api.CheckFilter{Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"}
+		if !reflect.DeepEqual(resp.Filter, wantFilter) {
+			t.Errorf("Filter = %+v, want %+v", resp.Filter, wantFilter)
+		}
 	})
 
 	t.Run("MissingFields", func(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		body := bytes.NewBufferString(`{}`)
 		req := httptest.NewRequest(http.MethodPost, "/check", body)
 		rec := httptest.NewRecorder()
@@ -565,7 +2988,7 @@ func TestCheckEndpoint(t *testing.T) {
 		th.SetupHTTP()
 		defer th.TeardownHTTP()
 
-		handler := newHandler(thclient.ServiceClient())
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
 		req := httptest.NewRequest(http.MethodGet, "/check", nil)
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
@@ -575,3 +2998,412 @@ func TestCheckEndpoint(t *testing.T) {
 		}
 	})
 }
+
+// ---------------------------------------------------------------------------
+// TestContainerLockSerializesAddDel
+// ---------------------------------------------------------------------------
+
+func TestContainerLockSerializesAddDel(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+
+	createStarted := make(chan struct{})
+	releaseCreate := make(chan struct{})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			record("create-start")
+			close(createStarted)
+			<-releaseCreate
+			record("create-end")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"port": {
+					"id": "port-uuid-1234",
+					"name": "k8s-pod-abcdef123456",
+					"mac_address": "fa:16:3e:aa:bb:cc",
+					"network_id": "net-uuid",
+					"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]
+				}
+			}`))
+			return
+		}
+		record("list")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"ports": [{"id": "port-uuid-1234", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+	})
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1"}}`))
+	})
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/add", body))
+	}()
+	<-createStarted
+	go func() {
+		defer wg.Done()
+		body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/del", body))
+	}()
+
+	// Give the DEL a chance to reach Neutron if it were not serialized.
+	time.Sleep(50 * time.Millisecond)
+	close(releaseCreate)
+	wg.Wait()
+
+	want := []string{"create-start", "create-end", "list"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestDelConcurrentDuplicates
+// ---------------------------------------------------------------------------
+
+// slowListPortClient answers port lists slowly, so that unserialized DELs
+// would all find the port before any of them deletes it.
+type slowListPortClient struct {
+	*fakePortClient
+}
+
+func (c slowListPortClient) List(opts ports.ListOptsBuilder) ([]ports.Port, error) {
+	found, err := c.fakePortClient.List(opts)
+	time.Sleep(10 * time.Millisecond)
+	return found, err
+}
+
+func TestDelConcurrentDuplicates(t *testing.T) {
+	for _, containerLock := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ContainerLock=%t", containerLock), func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+			})
+
+			fake := newFakePortClient()
+			cfg := defaultDaemonConfig()
+			cfg.ContainerLock = containerLock
+			handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), slowListPortClient{fake}, cfg)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+				bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("ADD status = %d, body: %s", rec.Code, rec.Body.String())
+			}
+
+			const dels = 8
+			responses := make([]api.DelResponse, dels)
+			var wg sync.WaitGroup
+			for i := range responses {
+				wg.Add(1)
+				go func(resp *api.DelResponse) {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del",
+						bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","strict":true}`)))
+					if rec.Code != http.StatusOK {
+						t.Errorf("DEL status = %d, body: %s", rec.Code, rec.Body.String())
+						return
+					}
+					if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+						t.Errorf("decode: %v", err)
+					}
+				}(&responses[i])
+			}
+			wg.Wait()
+
+			var deleted []string
+			for _, resp := range responses {
+				if !resp.OK || len(resp.FailedPorts) > 0 {
+					t.Errorf("DEL response = %+v, want OK", resp)
+				}
+				deleted = append(deleted, resp.DeletedPortIDs...)
+			}
+			if !reflect.DeepEqual(deleted, []string{"port-1"}) {
+				t.Errorf("ports reported deleted = %v, want port-1 once", deleted)
+			}
+			if fake.deletes != 1 || len(fake.ports) != 0 {
+				t.Errorf("%d deletes, %d ports left, want the port deleted once", fake.deletes, len(fake.ports))
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestSubnetPrefixLength
+// ---------------------------------------------------------------------------
+
+func TestSubnetPrefixLength(t *testing.T) {
+	for cidr, want := range map[string]string{
+		"10.0.0.0/24":     "24",
+		"2001:db8::/64":   "64",
+		"2001:db8:1::/56": "56",
+		"2001:db8::5/128": "128",
+		"fd00:0:0:1::/64": "64",
+	} {
+		if got, err := subnetPrefixLength(cidr); err != nil || got != want {
+			t.Errorf("subnetPrefixLength(%q) = %q, %v, want %q", cidr, got, err, want)
+		}
+	}
+	for _, cidr := range []string{"", "2001:db8::", "2001:db8::/129"} {
+		if _, err := subnetPrefixLength(cidr); err == nil {
+			t.Errorf("subnetPrefixLength(%q) succeeded, want an error", cidr)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestValidatePrefixLength
+// ---------------------------------------------------------------------------
+
+func TestValidatePrefixLength(t *testing.T) {
+	tests := []struct {
+		name         string
+		prefixLength int
+		cidr         string
+		wantErr      bool
+	}{
+		{"ipv4", 24, "10.0.0.0/24", false},
+		{"ipv4 narrower", 28, "10.0.0.0/24", false},
+		{"ipv4 zero", 0, "10.0.0.0/24", false},
+		{"ipv4 host", 32, "10.0.0.0/24", false},
+		{"ipv4 too long", 33, "10.0.0.0/24", true},
+		{"ipv4 ipv6 length", 64, "10.0.0.0/24", true},
+		{"ipv4 negative", -1, "10.0.0.0/24", true},
+		{"ipv6", 64, "2001:db8::/64", false},
+		{"ipv6 host", 128, "2001:db8::/64", false},
+		{"ipv6 too long", 129, "2001:db8::/64", true},
+		{"ipv6 negative", -1, "2001:db8::/64", true},
+		{"invalid cidr", 24, "bogus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePrefixLength(tt.prefixLength, tt.cidr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePrefixLength(%d, %q) error = %v, wantErr %v", tt.prefixLength, tt.cidr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddPrefixLengthOverride(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+	add := func(containerID, prefixLength string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(
+			`{"container_id":"`+containerID+`","network_id":"net-uuid","subnet_id":"subnet-uuid","prefix_length":`+prefixLength+`}`)))
+		return rec
+	}
+
+	rec := add("aaaaaaaaaaaa0001", "28")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var resp api.AddResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.PrefixLength != "28" {
+		t.Errorf("PrefixLength = %q, want the override 28", resp.PrefixLength)
+	}
+
+	for _, prefixLength := range []string{"33", "64", "-1"} {
+		rec := add("aaaaaaaaaaaa0002", prefixLength)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "out of range [0, 32]") {
+			t.Errorf("prefix_length %s: status = %d, body: %s, want a 400 naming the IPv4 range", prefixLength, rec.Code, rec.Body.String())
+		}
+	}
+	if len(fake.ports) != 1 {
+		t.Errorf("%d ports left, want only the valid ADD's", len(fake.ports))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestValidateGatewayOverride
+// ---------------------------------------------------------------------------
+
+func TestValidateGatewayOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway string
+		cidr    string
+		onLink  bool
+		wantErr bool
+	}{
+		{"inside subnet", "10.0.0.254", "10.0.0.0/24", false, false},
+		{"outside subnet", "192.168.1.1", "10.0.0.0/24", false, true},
+		{"outside subnet on link", "192.168.1.1", "10.0.0.0/24", true, false},
+		{"family mismatch", "fd00::1", "10.0.0.0/24", true, true},
+		{"ipv6 inside subnet", "fd00::1", "fd00::/64", false, false},
+		{"ipv6 link-local", "fe80::1", "2001:db8::/64", false, false},
+		{"ipv6 outside subnet", "2001:db8:1::1", "2001:db8::/64", false, true},
+		{"not an IP", "gateway", "10.0.0.0/24", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGatewayOverride(tt.gateway, tt.cidr, tt.onLink)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateGatewayOverride(%q, %q, %v) error = %v, wantErr %v", tt.gateway, tt.cidr, tt.onLink, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestValidateMTU
+// ---------------------------------------------------------------------------
+
+func TestValidateMTU(t *testing.T) {
+	tests := []struct {
+		mtu       int
+		ipVersion int
+		wantErr   bool
+	}{
+		{mtu: 1500, ipVersion: 4},
+		{mtu: 68, ipVersion: 4},
+		{mtu: 67, ipVersion: 4, wantErr: true},
+		{mtu: 9216, ipVersion: 4},
+		{mtu: 9217, ipVersion: 4, wantErr: true},
+		{mtu: 1280, ipVersion: 6},
+		{mtu: 1000, ipVersion: 6, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := validateMTU(tt.mtu, tt.ipVersion)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateMTU(%d, %d) error = %v, wantErr %v", tt.mtu, tt.ipVersion, err, tt.wantErr)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestReauthEndpoint
+// ---------------------------------------------------------------------------
+
+func TestReauthEndpoint(t *testing.T) {
+	serve := func(clients *neutronClientRef) *httptest.ResponseRecorder {
+		handler := newHandlerWithPortClient(clients, gophercloudPortClient{clients: clients}, defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodPost, "/reauth", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("SwapsClient", func(t *testing.T) {
+		oldClient := &gophercloud.ServiceClient{Endpoint: "http://old/"}
+		newClient := &gophercloud.ServiceClient{Endpoint: "http://new/"}
+		expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		clients := newNeutronClientRef(oldClient, func() (*gophercloud.ServiceClient, time.Time, error) {
+			return newClient, expiresAt, nil
+		})
+
+		rec := serve(clients)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.ReauthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !resp.OK || resp.ExpiresAt != "2030-01-02T03:04:05Z" {
+			t.Errorf("response = %+v, want ok with expires_at 2030-01-02T03:04:05Z", resp)
+		}
+		if clients.get() != newClient {
+			t.Error("client was not swapped")
+		}
+	})
+
+	t.Run("FailureKeepsClient", func(t *testing.T) {
+		oldClient := &gophercloud.ServiceClient{Endpoint: "http://old/"}
+		clients := newNeutronClientRef(oldClient, func() (*gophercloud.ServiceClient, time.Time, error) {
+			return nil, time.Time{}, fmt.Errorf("failed to authenticate with OpenStack: bad credentials")
+		})
+
+		rec := serve(clients)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+		if clients.get() != oldClient {
+			t.Error("old client was dropped after a failed reauth")
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodPost, "/reauth", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("WrongMethod", func(t *testing.T) {
+		handler := newHandler(nil, defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodGet, "/reauth", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestReportEndpoint(t *testing.T) {
+	handler := newHandlerWithPortClient(nil, newFakePortClient(), defaultDaemonConfig())
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	body := `{"container_id":"abcdef1234567890","network_id":"net-uuid","port_id":"port-1",
+		"result":{"cniVersion":"1.0.0", "ips":[{"address":"10.0.0.5/24"}]}}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/report", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("report status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	want := `REPORT container_id=abcdef1234567890 network_id=net-uuid port_id=port-1 result={"cniVersion":"1.0.0","ips":[{"address":"10.0.0.5/24"}]}`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want a line containing %q", logs.String(), want)
+	}
+
+	for _, body := range []string{
+		`{"container_id":"abcdef1234567890","network_id":"net-uuid","result":{}}`,
+		`{"container_id":"abcdef1234567890","network_id":"net-uuid","port_id":"port-1"}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/report", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("report %s status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"openstack-port/internal/api"
+)
+
+// Port allocation phases, as reported in the phase label.
+const (
+	// phaseAuth is Keystone authentication and Neutron client creation, at
+	// startup and on every re-authentication.
+	phaseAuth       = "auth"
+	phasePortCreate = "port_create"
+	phaseSubnetGet  = "subnet_get"
+	// phaseDelegate is the delegate ADD, timed by the CNI and reported via
+	// POST /observe.
+	phaseDelegate = api.PhaseDelegate
+)
+
+// phaseBuckets are the upper bounds, in seconds, of the phase histograms.
+var phaseBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram is a cumulative histogram of durations in seconds.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// phaseHistograms holds one histogram per port allocation phase, so
+// operators can see which phase dominates pod setup latency.
+type phaseHistograms struct {
+	mu     sync.Mutex
+	phases map[string]*histogram
+}
+
+func newPhaseHistograms() *phaseHistograms {
+	return &phaseHistograms{phases: make(map[string]*histogram)}
+}
+
+// allocationLatency records the port allocation phases of this daemon.
+var allocationLatency = newPhaseHistograms()
+
+// observe records that phase took d.
+func (p *phaseHistograms) observe(phase string, d time.Duration) {
+	seconds := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.phases[phase]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(phaseBuckets))}
+		p.phases[phase] = h
+	}
+	for i, bound := range phaseBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// since records the time elapsed since start for phase.
+func (p *phaseHistograms) since(phase string, start time.Time) {
+	p.observe(phase, time.Since(start))
+}
+
+// count returns how many durations were recorded for phase.
+func (p *phaseHistograms) count(phase string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.phases[phase]; ok {
+		return h.count
+	}
+	return 0
+}
+
+// writePrometheus writes the histograms in the Prometheus text format.
+func (p *phaseHistograms) writePrometheus(w io.Writer) {
+	const name = "openstack_cni_port_allocation_phase_seconds"
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s Duration of each port allocation phase.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	phases := make([]string, 0, len(p.phases))
+	for phase := range p.phases {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	for _, phase := range phases {
+		h := p.phases[phase]
+		for i, bound := range phaseBuckets {
+			fmt.Fprintf(w, "%s_bucket{phase=%q,le=%q} %d\n", name, phase, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{phase=%q,le=\"+Inf\"} %d\n", name, phase, h.count)
+		fmt.Fprintf(w, "%s_sum{phase=%q} %g\n", name, phase, h.sum)
+		fmt.Fprintf(w, "%s_count{phase=%q} %d\n", name, phase, h.count)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func TestPhaseHistogramsObserve(t *testing.T) {
+	p := newPhaseHistograms()
+	p.observe(phasePortCreate, 20*time.Millisecond)
+	p.observe(phasePortCreate, 3*time.Second)
+
+	if got := p.count(phasePortCreate); got != 2 {
+		t.Errorf("count = %d, want 2", got)
+	}
+	if got := p.count(phaseSubnetGet); got != 0 {
+		t.Errorf("count of unobserved phase = %d, want 0", got)
+	}
+
+	var out bytes.Buffer
+	p.writePrometheus(&out)
+	for _, want := range []string{
+		`# TYPE openstack_cni_port_allocation_phase_seconds histogram`,
+		`openstack_cni_port_allocation_phase_seconds_bucket{phase="port_create",le="0.01"} 0`,
+		`openstack_cni_port_allocation_phase_seconds_bucket{phase="port_create",le="0.025"} 1`,
+		`openstack_cni_port_allocation_phase_seconds_bucket{phase="port_create",le="5"} 2`,
+		`openstack_cni_port_allocation_phase_seconds_bucket{phase="port_create",le="+Inf"} 2`,
+		`openstack_cni_port_allocation_phase_seconds_sum{phase="port_create"} 3.02`,
+		`openstack_cni_port_allocation_phase_seconds_count{phase="port_create"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestAddObservesPhases(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), newFakePortClient(), defaultDaemonConfig())
+
+	creates, gets, delegates := allocationLatency.count(phasePortCreate), allocationLatency.count(phaseSubnetGet), allocationLatency.count(phaseDelegate)
+
+	req := httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ADD status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/observe", bytes.NewBufferString(`{"phase":"delegate","seconds":0.25}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("observe status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if got := allocationLatency.count(phasePortCreate); got != creates+1 {
+		t.Errorf("port_create count = %d, want %d", got, creates+1)
+	}
+	if got := allocationLatency.count(phaseSubnetGet); got != gets+1 {
+		t.Errorf("subnet_get count = %d, want %d", got, gets+1)
+	}
+	if got := allocationLatency.count(phaseDelegate); got != delegates+1 {
+		t.Errorf("delegate count = %d, want %d", got, delegates+1)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, phase := range []string{phasePortCreate, phaseSubnetGet, phaseDelegate} {
+		if !strings.Contains(rec.Body.String(), `_count{phase="`+phase+`"}`) {
+			t.Errorf("metrics output missing phase %s:\n%s", phase, rec.Body.String())
+		}
+	}
+}
+
+func TestObserveRejectsDaemonPhases(t *testing.T) {
+	for _, body := range []string{`{"phase":"port_create","seconds":1}`, `{"phase":"delegate","seconds":-1}`} {
+		rec := serveFake(t, newFakePortClient(), "/observe", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("observe %s status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
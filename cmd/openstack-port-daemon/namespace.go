@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// namespaceNetwork is the network, and optionally the subnet, that the pods
+// of a Kubernetes namespace are attached to when their request names none.
+type namespaceNetwork struct {
+	NetworkID string `json:"network_id"`
+	SubnetID  string `json:"subnet_id,omitempty"`
+}
+
+// parseNamespaceNetworks parses comma-separated namespace=network_id or
+// namespace=network_id/subnet_id entries.
+func parseNamespaceNetworks(v string) (map[string]namespaceNetwork, error) {
+	networks := make(map[string]namespaceNetwork)
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		namespace, target, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not namespace=network_id[/subnet_id]", entry)
+		}
+		networkID, subnetID, _ := strings.Cut(target, "/")
+		if err := addNamespaceNetwork(networks, strings.TrimSpace(namespace), namespaceNetwork{NetworkID: strings.TrimSpace(networkID), SubnetID: strings.TrimSpace(subnetID)}); err != nil {
+			return nil, err
+		}
+	}
+	return networks, nil
+}
+
+// loadNamespaceNetworksFile reads a JSON object mapping namespaces to
+// {"network_id": ..., "subnet_id": ...} from path.
+func loadNamespaceNetworksFile(path string) (map[string]namespaceNetwork, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]namespaceNetwork
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	networks := make(map[string]namespaceNetwork, len(entries))
+	for namespace, network := range entries {
+		if err := addNamespaceNetwork(networks, namespace, network); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return networks, nil
+}
+
+// addNamespaceNetwork adds the network of namespace to networks, checking
+// that both are named and that namespace has no network yet.
+func addNamespaceNetwork(networks map[string]namespaceNetwork, namespace string, network namespaceNetwork) error {
+	if namespace == "" || network.NetworkID == "" {
+		return fmt.Errorf("namespace %q needs a namespace name and a network_id", namespace)
+	}
+	if _, ok := networks[namespace]; ok {
+		return fmt.Errorf("namespace %q is mapped twice", namespace)
+	}
+	networks[namespace] = network
+	return nil
+}
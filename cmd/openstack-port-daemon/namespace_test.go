@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestParseNamespaceNetworks(t *testing.T) {
+	got, err := parseNamespaceNetworks("team-a=net-a/subnet-a, team-b = net-b ,")
+	if err != nil {
+		t.Fatalf("parseNamespaceNetworks() error = %v", err)
+	}
+	want := map[string]namespaceNetwork{
+		"team-a": {NetworkID: "net-a", SubnetID: "subnet-a"},
+		"team-b": {NetworkID: "net-b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNamespaceNetworks() = %+v, want %+v", got, want)
+	}
+
+	for _, v := range []string{"team-a", "team-a=", "=net-a", "team-a=/subnet-a", "team-a=net-a,team-a=net-b"} {
+		if _, err := parseNamespaceNetworks(v); err == nil {
+			t.Errorf("parseNamespaceNetworks(%q) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestLoadNamespaceNetworksFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "namespaces.json")
+	if err := os.WriteFile(path, []byte(`{"team-a": {"network_id": "net-a", "subnet_id": "subnet-a"}, "team-b": {"network_id": "net-b"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadNamespaceNetworksFile(path)
+	if err != nil {
+		t.Fatalf("loadNamespaceNetworksFile() error = %v", err)
+	}
+	want := map[string]namespaceNetwork{
+		"team-a": {NetworkID: "net-a", SubnetID: "subnet-a"},
+		"team-b": {NetworkID: "net-b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadNamespaceNetworksFile() = %+v, want %+v", got, want)
+	}
+
+	for name, content := range map[string]string{
+		"invalid.json":    `{"team-a": "net-a"}`,
+		"no-network.json": `{"team-a": {"subnet_id": "subnet-a"}}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadNamespaceNetworksFile(path); err == nil {
+			t.Errorf("loadNamespaceNetworksFile(%s) succeeded, want an error", name)
+		}
+	}
+	if _, err := loadNamespaceNetworksFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadNamespaceNetworksFile() of a missing file succeeded")
+	}
+}
+
+func TestNamespaceNetworks(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+	th.Mux.HandleFunc("/subnets/subnet-mapped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-mapped", "cidr": "10.1.0.0/24", "gateway_ip": "10.1.0.1", "network_id": "net-mapped"}}`))
+	})
+
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.NamespaceNetworks = map[string]namespaceNetwork{"team-a": {NetworkID: "net-mapped", SubnetID: "subnet-mapped"}}
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+		return rec
+	}
+	decodeAdd := func(t *testing.T, rec *httptest.ResponseRecorder) api.AddResponse {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("Mapped", func(t *testing.T) {
+		resp := decodeAdd(t, serve("/add", `{"container_id":"aaaaaaaaaaaa0001","pod_namespace":"team-a","pod_name":"web-0"}`))
+		if resp.NetworkID != "net-mapped" || resp.SubnetID != "subnet-mapped" {
+			t.Errorf("network, subnet = %s, %s, want net-mapped, subnet-mapped", resp.NetworkID, resp.SubnetID)
+		}
+		if got := fake.ports[resp.PortID].NetworkID; got != "net-mapped" {
+			t.Errorf("port created on network %s, want net-mapped", got)
+		}
+
+		rec := serve("/check", `{"container_id":"aaaaaaaaaaaa0001","pod_namespace":"team-a"}`)
+		var check api.CheckResponse
+		if err := json.NewDecoder(rec.Body).Decode(&check); err != nil || !check.Exists {
+			t.Errorf("CHECK = %+v, %v, want the port found on the mapped network", check, err)
+		}
+		if rec := serve("/del", `{"container_id":"aaaaaaaaaaaa0001","pod_namespace":"team-a","pod_name":"web-0"}`); rec.Code != http.StatusOK {
+			t.Fatalf("DEL status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		if _, ok := fake.ports[resp.PortID]; ok {
+			t.Error("DEL left the port on the mapped network")
+		}
+	})
+
+	t.Run("RequestNetworkWins", func(t *testing.T) {
+		resp := decodeAdd(t, serve("/add", `{"container_id":"aaaaaaaaaaaa0002","network_id":"net-uuid","subnet_id":"subnet-uuid","pod_namespace":"team-a"}`))
+		if resp.NetworkID != "net-uuid" || resp.SubnetID != "subnet-uuid" {
+			t.Errorf("network, subnet = %s, %s, want the request's net-uuid, subnet-uuid", resp.NetworkID, resp.SubnetID)
+		}
+	})
+
+	t.Run("Unmapped", func(t *testing.T) {
+		rec := serve("/add", `{"container_id":"aaaaaaaaaaaa0003","pod_namespace":"team-b"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d, body: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	})
+}
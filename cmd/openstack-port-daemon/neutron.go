@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+// clientBuilder authenticates afresh and returns a new Neutron client along
+// with its token's expiry (zero when unknown).
+type clientBuilder func() (*gophercloud.ServiceClient, time.Time, error)
+
+// errReauthUnavailable is returned by reload when no clientBuilder is set.
+var errReauthUnavailable = errors.New("re-authentication is not configured")
+
+// neutronClientRef holds the Neutron client shared by the handlers so that it
+// can be rebuilt and swapped atomically while requests are in flight. It may
+// hold a pool of clients instead, each authenticated on its own and handed
+// out in turn, so that requests do not all contend for one token refresh.
+// A nil *neutronClientRef holds no client.
+type neutronClientRef struct {
+	current atomic.Pointer[[]*gophercloud.ServiceClient]
+	next    atomic.Uint64
+	// size is the number of clients reload builds.
+	size    int
+	rebuild clientBuilder
+	// now returns the current time; nil uses time.Now.
+	now func() time.Time
+	// mu serializes reloads and guards expiresAt, the current token's
+	// expiry (zero when unknown).
+	mu        sync.Mutex
+	expiresAt time.Time
+}
+
+func newNeutronClientRef(client *gophercloud.ServiceClient, rebuild clientBuilder) *neutronClientRef {
+	r := &neutronClientRef{size: 1, rebuild: rebuild}
+	r.current.Store(&[]*gophercloud.ServiceClient{client})
+	return r
+}
+
+// newAuthenticatedClientRef authenticates with auth size times and returns
+// a neutronClientRef holding the pool of clients, a single client when size
+// is 1, which reload rebuilds with auth.
+func newAuthenticatedClientRef(auth Authenticator, size int) (*neutronClientRef, error) {
+	r := &neutronClientRef{size: max(size, 1), rebuild: auth.Authenticate}
+	if _, err := r.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// get returns the current client, or the next one of the pool in turn.
+func (r *neutronClientRef) get() *gophercloud.ServiceClient {
+	if r == nil {
+		return nil
+	}
+	clients := *r.current.Load()
+	if len(clients) == 1 {
+		return clients[0]
+	}
+	return clients[(r.next.Add(1)-1)%uint64(len(clients))]
+}
+
+// reload builds new clients and swaps them in. On failure the current
+// clients are kept. The expiry returned is the earliest of the new tokens.
+func (r *neutronClientRef) reload() (time.Time, error) {
+	if r == nil || r.rebuild == nil {
+		return time.Time{}, errReauthUnavailable
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *neutronClientRef) reloadLocked() (time.Time, error) {
+	clients := make([]*gophercloud.ServiceClient, 0, r.size)
+	var expiresAt time.Time
+	for range r.size {
+		client, clientExpiresAt, err := r.rebuild()
+		if err != nil {
+			return time.Time{}, err
+		}
+		clients = append(clients, client)
+		if expiresAt.IsZero() || (!clientExpiresAt.IsZero() && clientExpiresAt.Before(expiresAt)) {
+			expiresAt = clientExpiresAt
+		}
+	}
+	r.current.Store(&clients)
+	r.expiresAt = expiresAt
+	return expiresAt, nil
+}
+
+// setExpiry records the expiry of the current client's token.
+func (r *neutronClientRef) setExpiry(expiresAt time.Time) {
+	r.mu.Lock()
+	r.expiresAt = expiresAt
+	r.mu.Unlock()
+}
+
+// refreshIfExpiring reloads the client when its token expires in less than
+// minLifetime, so that a burst of requests near expiry does not fail midway.
+// Concurrent callers reload once. It reports whether a reload happened.
+func (r *neutronClientRef) refreshIfExpiring(minLifetime time.Duration) (bool, error) {
+	if r == nil || r.rebuild == nil || minLifetime <= 0 {
+		return false, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	if r.expiresAt.IsZero() || now().Add(minLifetime).Before(r.expiresAt) {
+		return false, nil
+	}
+	if _, err := r.reloadLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// withTimeout returns a copy of client whose requests are bound to ctx and
+// expire after timeout, and the function releasing that context. The copy
+// shares the original's token and re-authentication.
+func withTimeout(ctx context.Context, client *gophercloud.ServiceClient, timeout time.Duration) (*gophercloud.ServiceClient, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return withContext(ctx, client), cancel
+}
+
+// withContext returns a copy of client whose requests are bound to ctx. The
+// copy shares the original's token and re-authentication. When ctx carries
+// an endpoint override, the copy calls that endpoint.
+func withContext(ctx context.Context, client *gophercloud.ServiceClient) *gophercloud.ServiceClient {
+	if client == nil {
+		return nil
+	}
+	orig := client.ProviderClient
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:      orig.IdentityBase,
+		IdentityEndpoint:  orig.IdentityEndpoint,
+		EndpointLocator:   orig.EndpointLocator,
+		HTTPClient:        orig.HTTPClient,
+		UserAgent:         orig.UserAgent,
+		Throwaway:         orig.IsThrowaway(),
+		Context:           ctx,
+		RetryBackoffFunc:  orig.RetryBackoffFunc,
+		MaxBackoffRetries: orig.MaxBackoffRetries,
+		RetryFunc:         orig.RetryFunc,
+	}
+	provider.UseTokenLock()
+	provider.CopyTokenFrom(orig)
+	if orig.ReauthFunc != nil {
+		// The original's re-authentication refreshes the original only,
+		// so the copy takes up its new token. Passing the copy's stale
+		// token lets copies hitting a 401 together re-authenticate once.
+		provider.ReauthFunc = func() error {
+			if err := orig.Reauthenticate(provider.Token()); err != nil {
+				return err
+			}
+			provider.CopyTokenFrom(orig)
+			return nil
+		}
+	}
+	bound := *client
+	bound.ProviderClient = provider
+	if endpoint, ok := ctx.Value(endpointOverrideKey{}).(string); ok {
+		// As openstack.NewNetworkV2 does for the catalog's endpoint.
+		bound.Endpoint = gophercloud.NormalizeURL(endpoint)
+		bound.ResourceBase = bound.Endpoint + "v2.0/"
+	}
+	return &bound
+}
+
+// endpointOverrideKey is the context key of a request's Neutron endpoint
+// override.
+type endpointOverrideKey struct{}
+
+// withEndpointOverride returns ctx carrying endpoint, which withContext
+// then uses in place of the catalog's Neutron endpoint.
+func withEndpointOverride(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointOverrideKey{}, endpoint)
+}
+
+// isTimeout reports whether err comes from an expired or cancelled request
+// context.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// neutronActivity records when a handler last completed a Neutron call
+// successfully. The zero value has recorded none.
+type neutronActivity struct {
+	last atomic.Int64
+}
+
+func (a *neutronActivity) record() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+// lastSuccess returns the time of the last recorded call, or the zero time.
+func (a *neutronActivity) lastSuccess() time.Time {
+	n := a.last.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// NeutronPortClient is the subset of the Neutron port API the handlers use.
+// It lets tests inject a fake instead of mocking Neutron over HTTP.
+type NeutronPortClient interface {
+	Create(opts ports.CreateOptsBuilder) (*ports.Port, error)
+	Delete(id string) error
+	List(opts ports.ListOptsBuilder) ([]ports.Port, error)
+	Get(id string) (*ports.Port, error)
+	Update(id string, opts ports.UpdateOptsBuilder) (*ports.Port, error)
+}
+
+// gophercloudPortClient implements NeutronPortClient with gophercloud, using
+// whichever client clients currently holds. Requests carry ctx when set.
+type gophercloudPortClient struct {
+	clients *neutronClientRef
+	ctx     context.Context
+}
+
+// client returns the current client, bound to c.ctx when set.
+func (c gophercloudPortClient) client() *gophercloud.ServiceClient {
+	if c.ctx == nil {
+		return c.clients.get()
+	}
+	return withContext(c.ctx, c.clients.get())
+}
+
+// portClientWithContext returns portClient with its Neutron calls carrying
+// the values of ctx, such as the request's span, but not its cancellation:
+// a caller giving up must not abort a create or delete midway.
+func portClientWithContext(ctx context.Context, portClient NeutronPortClient) NeutronPortClient {
+	c, ok := portClient.(gophercloudPortClient)
+	if !ok {
+		return portClient
+	}
+	c.ctx = context.WithoutCancel(ctx)
+	return c
+}
+
+func (c gophercloudPortClient) Create(opts ports.CreateOptsBuilder) (*ports.Port, error) {
+	return ports.Create(c.client(), opts).Extract()
+}
+
+func (c gophercloudPortClient) Delete(id string) error {
+	return ports.Delete(c.client(), id).ExtractErr()
+}
+
+func (c gophercloudPortClient) List(opts ports.ListOptsBuilder) ([]ports.Port, error) {
+	allPages, err := ports.List(c.client(), opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return ports.ExtractPorts(allPages)
+}
+
+func (c gophercloudPortClient) Get(id string) (*ports.Port, error) {
+	return ports.Get(c.client(), id).Extract()
+}
+
+func (c gophercloudPortClient) Update(id string, opts ports.UpdateOptsBuilder) (*ports.Port, error) {
+	return ports.Update(c.client(), id, opts).Extract()
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+	"openstack-port/internal/portname"
+)
+
+// fakePortClient is an in-memory NeutronPortClient. createErr,
+// subnetCreateErrs (keyed by the first fixed IP's subnet) and deleteErrs
+// inject failures; the next hiddenLists calls to List return no ports, as
+// Neutron may right after a create, createDelay slows creates down and
+// deletes counts Delete calls. It is safe for concurrent use.
+type fakePortClient struct {
+	mu               sync.Mutex
+	ports            map[string]ports.Port
+	created          int
+	createErr        error
+	createDelay      time.Duration
+	deletes          int
+	subnetCreateErrs map[string]error
+	deleteErrs       map[string]error
+	hiddenLists      int
+	lists            int
+}
+
+func newFakePortClient(existing ...ports.Port) *fakePortClient {
+	f := &fakePortClient{ports: make(map[string]ports.Port), subnetCreateErrs: make(map[string]error), deleteErrs: make(map[string]error)}
+	for _, p := range existing {
+		f.ports[p.ID] = p
+	}
+	return f
+}
+
+func (f *fakePortClient) Create(opts ports.CreateOptsBuilder) (*ports.Port, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	time.Sleep(f.createDelay)
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	var createOpts ports.CreateOpts
+	switch o := opts.(type) {
+	case ports.CreateOpts:
+		createOpts = o
+	case portCreateOpts:
+		createOpts = o.CreateOpts
+	default:
+		return nil, fmt.Errorf("fakePortClient: unsupported create opts %T", opts)
+	}
+	if ips, ok := createOpts.FixedIPs.([]ports.IP); ok && len(ips) > 0 {
+		if err := f.subnetCreateErrs[ips[0].SubnetID]; err != nil {
+			return nil, err
+		}
+	}
+	f.created++
+	p := ports.Port{
+		ID:          fmt.Sprintf("port-%d", f.created),
+		Name:        createOpts.Name,
+		Description: createOpts.Description,
+		NetworkID:   createOpts.NetworkID,
+		MACAddress:  fmt.Sprintf("fa:16:3e:00:00:%02x", f.created),
+	}
+	if ips, ok := createOpts.FixedIPs.([]ports.IP); ok {
+		for _, ip := range ips {
+			p.FixedIPs = append(p.FixedIPs, ports.IP{SubnetID: ip.SubnetID, IPAddress: fmt.Sprintf("10.0.0.%d", 10+f.created)})
+		}
+	}
+	f.ports[p.ID] = p
+	return &p, nil
+}
+
+func (f *fakePortClient) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes++
+	if err, ok := f.deleteErrs[id]; ok {
+		return err
+	}
+	if _, ok := f.ports[id]; !ok {
+		return gophercloud.ErrDefault404{}
+	}
+	delete(f.ports, id)
+	return nil
+}
+
+func (f *fakePortClient) List(opts ports.ListOptsBuilder) ([]ports.Port, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+	if f.hiddenLists > 0 {
+		f.hiddenLists--
+		return nil, nil
+	}
+	listOpts := opts.(ports.ListOpts)
+	var out []ports.Port
+	for _, p := range f.ports {
+		if p.Name == listOpts.Name && (listOpts.NetworkID == "" || p.NetworkID == listOpts.NetworkID) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (f *fakePortClient) Get(id string) (*ports.Port, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.ports[id]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &p, nil
+}
+
+func (f *fakePortClient) Update(id string, opts ports.UpdateOptsBuilder) (*ports.Port, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.ports[id]
+	if !ok {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	var updateOpts ports.UpdateOpts
+	switch o := opts.(type) {
+	case ports.UpdateOpts:
+		updateOpts = o
+	case portUpdateOpts:
+		updateOpts = o.UpdateOpts
+	default:
+		return nil, fmt.Errorf("fakePortClient: unsupported update opts %T", opts)
+	}
+	if updateOpts.AdminStateUp != nil {
+		p.AdminStateUp = *updateOpts.AdminStateUp
+	}
+	if updateOpts.Name != nil {
+		p.Name = *updateOpts.Name
+	}
+	if updateOpts.DeviceID != nil {
+		p.DeviceID = *updateOpts.DeviceID
+	}
+	if updateOpts.Description != nil {
+		p.Description = *updateOpts.Description
+	}
+	f.ports[id] = p
+	return &p, nil
+}
+
+func serveFake(t *testing.T, fake *fakePortClient, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := newHandlerWithPortClient(nil, fake, defaultDaemonConfig())
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFakeDelSkipsGonePorts(t *testing.T) {
+	fake := newFakePortClient(
+		ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+		ports.Port{ID: "port-b", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+		ports.Port{ID: "port-other", Name: "k8s-pod-other0000000", NetworkID: "net-uuid"},
+	)
+	fake.deleteErrs["port-a"] = gophercloud.ErrDefault404{}
+
+	rec := serveFake(t, fake, "/del", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp api.DelResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(resp.DeletedPortIDs, []string{"port-b"}) {
+		t.Errorf("DeletedPortIDs = %v, want [port-b]", resp.DeletedPortIDs)
+	}
+	if _, ok := fake.ports["port-other"]; !ok {
+		t.Error("port of another container was deleted")
+	}
+}
+
+func TestFakeDelReportsPartialFailure(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			fake := newFakePortClient(
+				ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+				ports.Port{ID: "port-b", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+				ports.Port{ID: "port-c", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+				ports.Port{ID: "port-d", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+			)
+			fake.deleteErrs["port-b"] = gophercloud.ErrDefault500{}
+			fake.deleteErrs["port-c"] = gophercloud.ErrDefault404{}
+
+			rec := serveFake(t, fake, "/del", fmt.Sprintf(`{"container_id":"abcdef1234567890","network_id":"net-uuid","strict":%t}`, strict))
+			wantStatus := http.StatusOK
+			if strict {
+				wantStatus = http.StatusInternalServerError
+			}
+			if rec.Code != wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, wantStatus, rec.Body.String())
+			}
+			var resp api.DelResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			deleted := append([]string(nil), resp.DeletedPortIDs...)
+			sort.Strings(deleted)
+			if !reflect.DeepEqual(deleted, []string{"port-a", "port-d"}) {
+				t.Errorf("DeletedPortIDs = %v, want [port-a port-d]", resp.DeletedPortIDs)
+			}
+			if len(resp.FailedPorts) != 1 || resp.FailedPorts[0].PortID != "port-b" || resp.FailedPorts[0].Error == "" {
+				t.Errorf("FailedPorts = %+v, want port-b with its error", resp.FailedPorts)
+			}
+			if resp.OK == strict {
+				t.Errorf("OK = %t, want %t", resp.OK, !strict)
+			}
+			if strict && !strings.Contains(resp.Error, "port-b") {
+				t.Errorf("Error = %q, want it to name port-b", resp.Error)
+			}
+			if _, ok := fake.ports["port-d"]; ok {
+				t.Error("port-d was not deleted after port-b failed")
+			}
+		})
+	}
+}
+
+func TestFakeCheckReportsPort(t *testing.T) {
+	fake := newFakePortClient(ports.Port{
+		ID:             "port-a",
+		Name:           "k8s-pod-abcdef123456",
+		NetworkID:      "net-uuid",
+		ProjectID:      "project-1",
+		RevisionNumber: 4,
+		Status:         "ACTIVE",
+	})
+
+	rec := serveFake(t, fake, "/check", `{"container_id":"abcdef1234567890","network_id":"net-uuid"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp api.CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := api.CheckResponse{Exists: true, PortID: "port-a", NetworkID: "net-uuid", MatchCount: 1, ProjectID: "project-1", RevisionNumber: 4, Status: "ACTIVE"}
+	if resp != want {
+		t.Errorf("CheckResponse = %+v, want %+v", resp, want)
+	}
+}
+
+func TestFakeUpSetsAdminStateUp(t *testing.T) {
+	fake := newFakePortClient(ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"})
+
+	rec := serveFake(t, fake, "/up", `{"container_id":"abcdef1234567890","network_id":"net-uuid","port_id":"port-a"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if !fake.ports["port-a"].AdminStateUp {
+		t.Error("expected port-a to be admin state up")
+	}
+}
+
+func TestFakeAddCreateConflict(t *testing.T) {
+	fake := newFakePortClient()
+	fake.createErr = gophercloud.ErrDefault409{}
+
+	rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","ip_address":"10.0.0.5"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestFakePortNaming(t *testing.T) {
+	hashed := portname.HashedNamer{}.PortName("abcdef1234567890")
+	fake := newFakePortClient(
+		ports.Port{ID: "port-hashed", Name: hashed, NetworkID: "net-uuid"},
+		ports.Port{ID: "port-default", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"},
+	)
+
+	rec := serveFake(t, fake, "/check", `{"container_id":"abcdef1234567890","network_id":"net-uuid","port_naming":"hashed"}`)
+	var check api.CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&check); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if check.PortID != "port-hashed" {
+		t.Errorf("CHECK port_id = %q, want port-hashed", check.PortID)
+	}
+
+	rec = serveFake(t, fake, "/del", `{"container_id":"abcdef1234567890","network_id":"net-uuid","port_naming":"hashed"}`)
+	var del api.DelResponse
+	if err := json.NewDecoder(rec.Body).Decode(&del); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(del.DeletedPortIDs, []string{"port-hashed"}) {
+		t.Errorf("DeletedPortIDs = %v, want [port-hashed]", del.DeletedPortIDs)
+	}
+
+	rec = serveFake(t, fake, "/check", `{"container_id":"abcdef1234567890","network_id":"net-uuid","port_naming":"bogus"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown port_naming status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestFakePortNamingRoundTrip checks that ADD, CHECK and DEL name ports
+// alike for every strategy, including container IDs shorter than the
+// default namer's 12 characters.
+func TestFakePortNamingRoundTrip(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	for _, strategy := range []string{portname.StrategyDefault, portname.StrategyFullID, portname.StrategyHashed} {
+		for _, id := range []string{"a", "abcdef123456", "abcdef1234567890"} {
+			t.Run(strategy+"/"+id, func(t *testing.T) {
+				fake := newFakePortClient()
+				handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+				serve := func(path string) *httptest.ResponseRecorder {
+					body := fmt.Sprintf(`{"container_id":%q,"network_id":"net-uuid","subnet_id":"subnet-uuid","port_naming":%q}`, id, strategy)
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+					if rec.Code != http.StatusOK {
+						t.Fatalf("%s status = %d, want %d, body: %s", path, rec.Code, http.StatusOK, rec.Body.String())
+					}
+					return rec
+				}
+
+				var add api.AddResponse
+				if err := json.NewDecoder(serve("/add").Body).Decode(&add); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				namer, _ := portname.New(strategy)
+				if got, want := fake.ports[add.PortID].Name, namer.PortName(id); got != want {
+					t.Errorf("port name = %q, want %q", got, want)
+				}
+
+				var check api.CheckResponse
+				if err := json.NewDecoder(serve("/check").Body).Decode(&check); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if !check.Exists || check.PortID != add.PortID {
+					t.Errorf("CHECK = %+v, want port %s", check, add.PortID)
+				}
+
+				var del api.DelResponse
+				if err := json.NewDecoder(serve("/del").Body).Decode(&del); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if !reflect.DeepEqual(del.DeletedPortIDs, []string{add.PortID}) {
+					t.Errorf("DeletedPortIDs = %v, want [%s]", del.DeletedPortIDs, add.PortID)
+				}
+			})
+		}
+	}
+}
+
+func TestFakeAddSubnetFailover(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-b", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-b", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	fake := newFakePortClient()
+	fake.subnetCreateErrs["subnet-a"] = errIPUnavailable
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+
+	body := `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-a","subnet_ids":["subnet-b","subnet-c"]}`
+	req := httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp api.AddResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.SubnetID != "subnet-b" {
+		t.Errorf("SubnetID = %q, want subnet-b", resp.SubnetID)
+	}
+	if resp.IPAddress == "" || resp.PrefixLength != "24" {
+		t.Errorf("address = %q/%q, want an IP on subnet-b", resp.IPAddress, resp.PrefixLength)
+	}
+	if len(fake.ports) != 1 {
+		t.Errorf("ports = %d, want 1", len(fake.ports))
+	}
+}
+
+func TestFakeAddSubnetFailoverOnlyOnConflict(t *testing.T) {
+	fake := newFakePortClient()
+	fake.subnetCreateErrs["subnet-a"] = fmt.Errorf("neutron unavailable")
+
+	rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-a","subnet_ids":["subnet-b"]}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if fake.created != 0 {
+		t.Errorf("created = %d ports, want 0", fake.created)
+	}
+}
+
+func TestFakeAddNoFailoverOnOtherConflict(t *testing.T) {
+	fake := newFakePortClient()
+	fake.subnetCreateErrs["subnet-a"] = conflictError(`{"NeutronError": {"type": "OverQuota", "message": "Quota exceeded for resources: ['port']."}}`)
+
+	rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-a","subnet_ids":["subnet-b"]}`)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("status = %d, want an error, body: %s", rec.Code, rec.Body.String())
+	}
+	if fake.created != 0 {
+		t.Errorf("created = %d ports, want subnet-b not tried", fake.created)
+	}
+}
+
+func TestFakeAddSubnetsExhausted(t *testing.T) {
+	fake := newFakePortClient()
+	fake.subnetCreateErrs["subnet-a"] = errIPUnavailable
+	fake.subnetCreateErrs["subnet-b"] = errIPUnavailable
+
+	rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_ids":["subnet-a","subnet-b"]}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestFakeAddConcurrentReusesPort(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		reuse       bool
+		wantCreated int
+	}{
+		{name: "Reuse", reuse: true, wantCreated: 1},
+		{name: "NoReuse", reuse: false, wantCreated: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+			})
+
+			fake := newFakePortClient()
+			cfg := defaultDaemonConfig()
+			cfg.ReuseExistingPort = tt.reuse
+			handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+
+			// Both ADDs for the container race; the container lock lets one
+			// create the port while the other waits.
+			const body = `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`
+			resps := make([]api.AddResponse, 2)
+			var wg sync.WaitGroup
+			for i := range resps {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+					if rec.Code != http.StatusOK {
+						t.Errorf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+						return
+					}
+					_ = json.NewDecoder(rec.Body).Decode(&resps[i])
+				}()
+			}
+			wg.Wait()
+
+			if fake.created != tt.wantCreated {
+				t.Errorf("created = %d ports, want %d", fake.created, tt.wantCreated)
+			}
+			if !tt.reuse {
+				return
+			}
+			if resps[0].PortID != resps[1].PortID || resps[0].IPAddress != resps[1].IPAddress {
+				t.Errorf("responses = %+v and %+v, want the same port", resps[0], resps[1])
+			}
+			if resps[0].Created == resps[1].Created {
+				t.Errorf("created = %t and %t, want only the winner to report creating the port", resps[0].Created, resps[1].Created)
+			}
+		})
+	}
+}
+
+func TestFakeAddStalePortOnOtherNetwork(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		policy      string
+		wantStatus  int
+		wantCreated int
+		wantKept    bool
+	}{
+		// By default the port may be the pod's on another network.
+		{name: "Default", wantStatus: http.StatusOK, wantCreated: 1, wantKept: true},
+		{name: "Error", policy: stalePortPolicyError, wantStatus: http.StatusConflict, wantKept: true},
+		{name: "Recreate", policy: stalePortPolicyRecreate, wantStatus: http.StatusOK, wantCreated: 1},
+		{name: "Ignore", policy: stalePortPolicyIgnore, wantStatus: http.StatusOK, wantCreated: 1, wantKept: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+			})
+
+			fake := newFakePortClient(ports.Port{ID: "port-stale", Name: "k8s-pod-abcdef123456", NetworkID: "net-old",
+				FixedIPs: []ports.IP{{SubnetID: "subnet-old", IPAddress: "10.9.0.5"}}})
+			cfg := defaultDaemonConfig()
+			if tt.policy != "" {
+				cfg.StalePortPolicy = tt.policy
+			}
+			handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add",
+				bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if fake.created != tt.wantCreated {
+				t.Errorf("created = %d ports, want %d", fake.created, tt.wantCreated)
+			}
+			if _, kept := fake.ports["port-stale"]; kept != tt.wantKept {
+				t.Errorf("stale port kept = %t, want %t", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestReusablePort(t *testing.T) {
+	existing := []ports.Port{
+		{ID: "port-a", FixedIPs: []ports.IP{{SubnetID: "subnet-a", IPAddress: "10.0.0.5"}}},
+		{ID: "port-b", FixedIPs: []ports.IP{{SubnetID: "subnet-b", IPAddress: "10.0.1.5"}}},
+	}
+	tests := []struct {
+		name       string
+		candidates []string
+		ipAddress  string
+		wantPort   string
+		wantSubnet string
+	}{
+		{name: "FirstCandidate", candidates: []string{"subnet-a", "subnet-b"}, wantPort: "port-a", wantSubnet: "subnet-a"},
+		{name: "FallbackCandidate", candidates: []string{"subnet-c", "subnet-b"}, wantPort: "port-b", wantSubnet: "subnet-b"},
+		{name: "MatchingIP", candidates: []string{"subnet-a"}, ipAddress: "10.0.0.5", wantPort: "port-a", wantSubnet: "subnet-a"},
+		{name: "OtherIP", candidates: []string{"subnet-a"}, ipAddress: "10.0.0.6"},
+		{name: "OtherSubnet", candidates: []string{"subnet-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, subnetID := reusablePort(existing, tt.candidates, tt.ipAddress)
+			gotPort := ""
+			if port != nil {
+				gotPort = port.ID
+			}
+			if gotPort != tt.wantPort || subnetID != tt.wantSubnet {
+				t.Errorf("reusablePort() = %q, %q, want %q, %q", gotPort, subnetID, tt.wantPort, tt.wantSubnet)
+			}
+		})
+	}
+}
+
+func TestSubnetCandidates(t *testing.T) {
+	got := subnetCandidates("subnet-a", []string{"subnet-b", "subnet-a", "", "subnet-c"})
+	want := []string{"subnet-a", "subnet-b", "subnet-c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("subnetCandidates() = %v, want %v", got, want)
+	}
+	if got := subnetCandidates("", []string{"subnet-b"}); !reflect.DeepEqual(got, []string{"subnet-b"}) {
+		t.Errorf("subnetCandidates() = %v, want [subnet-b]", got)
+	}
+}
+
+func TestFakeAddSubnetIDsWithIPAddress(t *testing.T) {
+	rec := serveFake(t, newFakePortClient(), "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_ids":["subnet-a"],"ip_address":"10.0.0.5"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRefreshIfExpiring(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	rebuilds := 0
+	rebuild := func() (*gophercloud.ServiceClient, time.Time, error) {
+		rebuilds++
+		return &gophercloud.ServiceClient{}, now.Add(time.Hour), nil
+	}
+	clients := newNeutronClientRef(&gophercloud.ServiceClient{}, rebuild)
+	clients.now = func() time.Time { return now }
+	clients.setExpiry(start.Add(10 * time.Minute))
+
+	// Ten minutes left: well above the threshold.
+	if refreshed, err := clients.refreshIfExpiring(5 * time.Minute); err != nil || refreshed {
+		t.Fatalf("refreshIfExpiring() = %t, %v; want false, nil", refreshed, err)
+	}
+
+	// Four minutes left: re-authenticate.
+	now = start.Add(6 * time.Minute)
+	if refreshed, err := clients.refreshIfExpiring(5 * time.Minute); err != nil || !refreshed {
+		t.Fatalf("near-expiry refreshIfExpiring() = %t, %v; want true, nil", refreshed, err)
+	}
+	if rebuilds != 1 {
+		t.Errorf("rebuilds = %d, want 1", rebuilds)
+	}
+
+	// The new token is valid for another hour.
+	if refreshed, err := clients.refreshIfExpiring(5 * time.Minute); err != nil || refreshed {
+		t.Fatalf("refreshIfExpiring() after reauth = %t, %v; want false, nil", refreshed, err)
+	}
+	if rebuilds != 1 {
+		t.Errorf("rebuilds = %d, want 1", rebuilds)
+	}
+}
+
+func TestRefreshIfExpiringUnknownExpiry(t *testing.T) {
+	rebuilds := 0
+	clients := newNeutronClientRef(&gophercloud.ServiceClient{}, func() (*gophercloud.ServiceClient, time.Time, error) {
+		rebuilds++
+		return &gophercloud.ServiceClient{}, time.Time{}, nil
+	})
+	if refreshed, err := clients.refreshIfExpiring(5 * time.Minute); err != nil || refreshed || rebuilds != 0 {
+		t.Errorf("refreshIfExpiring() = %t, %v with %d rebuilds; want false, nil, 0", refreshed, err, rebuilds)
+	}
+}
+
+func TestRefreshIfExpiringKeepsClientOnFailure(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	initial := &gophercloud.ServiceClient{}
+	clients := newNeutronClientRef(initial, func() (*gophercloud.ServiceClient, time.Time, error) {
+		return nil, time.Time{}, fmt.Errorf("keystone unavailable")
+	})
+	clients.now = func() time.Time { return now }
+	clients.setExpiry(now.Add(time.Minute))
+
+	if _, err := clients.refreshIfExpiring(5 * time.Minute); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if clients.get() != initial {
+		t.Error("client replaced after a failed re-authentication")
+	}
+}
+
+func TestCheckRefreshesExpiringToken(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	rebuilds := 0
+	clients := newNeutronClientRef(&gophercloud.ServiceClient{}, func() (*gophercloud.ServiceClient, time.Time, error) {
+		rebuilds++
+		return &gophercloud.ServiceClient{}, now.Add(time.Hour), nil
+	})
+	clients.now = func() time.Time { return now }
+	clients.setExpiry(now.Add(30 * time.Second))
+
+	handler := newHandlerWithPortClient(clients, newFakePortClient(), defaultDaemonConfig())
+	req := httptest.NewRequest(http.MethodPost, "/check", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rebuilds != 1 {
+		t.Errorf("rebuilds = %d, want 1", rebuilds)
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("catalog endpoint called: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	var mu sync.Mutex
+	var calls []string
+	canary := http.NewServeMux()
+	canary.HandleFunc("/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"ports": [{"id": "port-canary", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"port": {"id": "port-canary", "name": "k8s-pod-abcdef123456", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid",
+			"fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+	})
+	canary.HandleFunc("/v2.0/ports/port-canary", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-canary", "binding:host_id": "node-1"}}`))
+	})
+	canary.HandleFunc("/v2.0/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1"}}`))
+	})
+	canary.HandleFunc("/v2.0/networks/net-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"network": {"id": "net-uuid", "mtu": 1450}}`))
+	})
+	server := httptest.NewServer(canary)
+	defer server.Close()
+
+	cfg := defaultDaemonConfig()
+	cfg.AllowEndpointOverride = true
+	handler := newHandler(thclient.ServiceClient(), cfg)
+	for _, tc := range []struct{ path, body string }{
+		{"/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","endpoint_override":"` + server.URL + `"}`},
+		{"/check", `{"container_id":"abcdef1234567890","network_id":"net-uuid","endpoint_override":"` + server.URL + `"}`},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want %d, body: %s", tc.path, rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+	// The ADD creates its port, the CHECK lists it and reads its binding.
+	if want := []string{"POST /v2.0/ports", "GET /v2.0/ports", "GET /v2.0/ports/port-canary"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("canary port calls = %v, want %v", calls, want)
+	}
+}
+
+func TestWithContextReauthenticates(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	var mu sync.Mutex
+	var tokens []string
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens = append(tokens, r.Header.Get("X-Auth-Token"))
+		mu.Unlock()
+		if r.Header.Get("X-Auth-Token") != "fresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ports": []}`))
+	})
+
+	client := thclient.ServiceClient()
+	provider := client.ProviderClient
+	provider.UseTokenLock()
+	provider.SetToken("revoked-token")
+	reauths := 0
+	provider.ReauthFunc = func() error {
+		reauths++
+		provider.SetToken("fresh-token")
+		return nil
+	}
+
+	if _, err := ports.List(withContext(context.Background(), client), ports.ListOpts{}).AllPages(); err != nil {
+		t.Fatalf("listing ports after a 401: %v", err)
+	}
+	if want := []string{"revoked-token", "fresh-token"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens sent = %v, want %v", tokens, want)
+	}
+	if reauths != 1 || provider.Token() != "fresh-token" {
+		t.Errorf("%d re-authentications, original token %q, want 1 refreshing the original", reauths, provider.Token())
+	}
+}
+
+func TestEndpointOverrideRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      bool
+		endpoint   string
+		wantStatus int
+	}{
+		{"Disabled", false, "https://neutron-canary.example:9696", http.StatusForbidden},
+		{"NotAURL", true, "neutron-canary:9696", http.StatusBadRequest},
+		{"Query", true, "https://neutron-canary.example:9696/?debug=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultDaemonConfig()
+			cfg.AllowEndpointOverride = tt.allow
+			fake := newFakePortClient()
+			handler := newHandlerWithPortClient(nil, fake, cfg)
+			body := `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","endpoint_override":"` + tt.endpoint + `"}`
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if fake.created != 0 {
+				t.Errorf("created %d port(s), want none", fake.created)
+			}
+		})
+	}
+}
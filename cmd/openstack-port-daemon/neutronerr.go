@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// Neutron error types told apart when a port create or update fails with
+// 409.
+const (
+	// neutronIPUnavailable means no address is free right now; it can
+	// clear as DHCP agents release theirs.
+	neutronIPUnavailable = "IpAddressGenerationFailure"
+	// neutronOverQuota means the project hit its port quota; retrying or
+	// another subnet will not help.
+	neutronOverQuota = "OverQuota"
+	// neutronAddressPairNeedsPortSecurity means the port has port
+	// security disabled, so it takes no allowed address pairs.
+	neutronAddressPairNeedsPortSecurity = "AddressPairAndPortSecurityRequired"
+)
+
+// neutronErrorType returns the NeutronError type carried by a 409, or ""
+// for other errors and bodies that are not a NeutronError.
+func neutronErrorType(err error) string {
+	conflict, ok := err.(gophercloud.ErrDefault409)
+	if !ok {
+		return ""
+	}
+	var body struct {
+		NeutronError struct {
+			Type string `json:"type"`
+		} `json:"NeutronError"`
+	}
+	if json.Unmarshal(conflict.Body, &body) != nil {
+		return ""
+	}
+	return body.NeutronError.Type
+}
+
+// isIPUnavailable reports whether err is Neutron running out of addresses.
+func isIPUnavailable(err error) bool {
+	return neutronErrorType(err) == neutronIPUnavailable
+}
+
+// isOverQuota reports whether err is the project's port quota being hit.
+func isOverQuota(err error) bool {
+	return neutronErrorType(err) == neutronOverQuota
+}
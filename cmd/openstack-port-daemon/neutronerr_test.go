@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+// errIPUnavailable is Neutron's answer when a subnet has no address left.
+var errIPUnavailable = conflictError(`{"NeutronError": {"type": "IpAddressGenerationFailure", "message": "No more IP addresses available on network net-uuid."}}`)
+
+func conflictError(body string) error {
+	return gophercloud.ErrDefault409{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusConflict, Body: []byte(body)}}
+}
+
+func TestNeutronErrorType(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		ipUnavailable bool
+		overQuota     bool
+	}{
+		{"ip unavailable", conflictError(`{"NeutronError": {"type": "IpAddressGenerationFailure", "message": "No more IP addresses available on network net-uuid."}}`), true, false},
+		{"over quota", conflictError(`{"NeutronError": {"type": "OverQuota", "message": "Quota exceeded for resources: ['port']."}}`), false, true},
+		{"other conflict", conflictError(`{"NeutronError": {"type": "IpAddressAlreadyAllocated"}}`), false, false},
+		{"not json", conflictError(`conflict`), false, false},
+		{"not a conflict", errors.New("boom"), false, false},
+	}
+	for _, tt := range tests {
+		if got := isIPUnavailable(tt.err); got != tt.ipUnavailable {
+			t.Errorf("%s: isIPUnavailable = %t, want %t", tt.name, got, tt.ipUnavailable)
+		}
+		if got := isOverQuota(tt.err); got != tt.overQuota {
+			t.Errorf("%s: isOverQuota = %t, want %t", tt.name, got, tt.overQuota)
+		}
+	}
+}
+
+func TestAddRetriesIPUnavailable(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		errBody    string
+		wantStatus int
+		wantCreate int
+	}{
+		{
+			name:       "transient unavailability clears",
+			failures:   1,
+			errBody:    `{"NeutronError": {"type": "IpAddressGenerationFailure", "message": "No more IP addresses available on network net-uuid."}}`,
+			wantStatus: http.StatusOK,
+			wantCreate: 2,
+		},
+		{
+			name:       "unavailability outlasts the retries",
+			failures:   10,
+			errBody:    `{"NeutronError": {"type": "IpAddressGenerationFailure", "message": "No more IP addresses available on network net-uuid."}}`,
+			wantStatus: http.StatusInternalServerError,
+			wantCreate: 3,
+		},
+		{
+			name:       "over quota is not retried",
+			failures:   10,
+			errBody:    `{"NeutronError": {"type": "OverQuota", "message": "Quota exceeded for resources: ['port']."}}`,
+			wantStatus: http.StatusInternalServerError,
+			wantCreate: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+
+			var mu sync.Mutex
+			creates := 0
+			th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				creates++
+				n := creates
+				mu.Unlock()
+				w.Header().Set("Content-Type", "application/json")
+				if n <= tt.failures {
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(tt.errBody))
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+			}))
+			th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+			})
+
+			cfg := defaultDaemonConfig()
+			cfg.IPAllocationRetryInterval = time.Millisecond
+			handler := newHandler(thclient.ServiceClient(), cfg)
+			body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400}`)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if creates != tt.wantCreate {
+				t.Errorf("creates = %d, want %d", creates, tt.wantCreate)
+			}
+		})
+	}
+}
+
+func TestAddIPAllocationRetryStopsWithRequest(t *testing.T) {
+	fake := newFakePortClient()
+	fake.createErr = errIPUnavailable
+	cfg := defaultDaemonConfig()
+	cfg.IPAllocationRetries = 100
+	cfg.IPAllocationRetryInterval = time.Hour
+	handler := newHandlerWithPortClient(nil, fake, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`))
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ADD kept waiting to retry after its request was cancelled")
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d, body: %s", rec.Code, http.StatusInternalServerError, rec.Body.String())
+	}
+}
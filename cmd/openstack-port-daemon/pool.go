@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"openstack-port/internal/api"
+)
+
+// poolPortName names the spare ports of the warm pool. It does not start
+// with portname.Prefix, so spares are not matched by DEL or CHECK.
+const poolPortName = "k8s-pool-spare"
+
+// warmPool keeps spare Neutron ports pre-created on one subnet and hands them
+// out on ADD, taking Neutron's create latency off the pod start path. Spares
+// are replenished in the background. A nil *warmPool never hands out ports.
+type warmPool struct {
+	client    NeutronPortClient
+	networkID string
+	subnetID  string
+	size      int
+	hostID    string
+	tags      []string
+
+	mu      sync.Mutex
+	spares  []ports.Port
+	adopted bool
+	filling bool
+	// handedOut holds the IDs of ports taken from the pool; only these are
+	// returned to it on DEL.
+	handedOut map[string]bool
+}
+
+// newWarmPool returns the pool configured by cfg, or nil when the pool is
+// disabled.
+func newWarmPool(client NeutronPortClient, cfg daemonConfig) *warmPool {
+	if cfg.WarmPoolSize <= 0 {
+		return nil
+	}
+	return &warmPool{
+		client:    client,
+		networkID: cfg.WarmPoolNetworkID,
+		subnetID:  cfg.WarmPoolSubnetID,
+		size:      cfg.WarmPoolSize,
+		hostID:    cfg.HostID,
+		tags:      cfg.PortTags,
+		handedOut: make(map[string]bool),
+	}
+}
+
+// serves reports whether an ADD for req on subnetID may use a pooled port.
+// Requests that customize the port are always created directly.
+func (p *warmPool) serves(req api.AddRequest, subnetID string) bool {
+	if p == nil {
+		return false
+	}
+	return req.NetworkID == p.networkID && subnetID == p.subnetID &&
+		req.SegmentID == "" && len(req.SubnetIDs) == 0 && req.PortSpec.IsZero() && req.EndpointOverride == ""
+}
+
+// take hands out a spare port to containerID, renaming it to name, setting
+// its device_id and, when not empty, its description. It reports false when
+// no spare is available.
+func (p *warmPool) take(containerID, name, description string) (*ports.Port, bool) {
+	p.mu.Lock()
+	if len(p.spares) == 0 {
+		p.mu.Unlock()
+		go p.replenish()
+		return nil, false
+	}
+	spare := p.spares[0]
+	p.spares = p.spares[1:]
+	p.mu.Unlock()
+	go p.replenish()
+
+	opts := ports.UpdateOpts{Name: &name, DeviceID: &containerID}
+	if description != "" {
+		opts.Description = &description
+	}
+	port, err := p.client.Update(spare.ID, opts)
+	if err != nil {
+		log.Printf("ERROR handing out pool port %s, deleting it: %v", spare.ID, err)
+		p.client.Delete(spare.ID)
+		return nil, false
+	}
+	p.mu.Lock()
+	p.handedOut[port.ID] = true
+	p.mu.Unlock()
+	return port, true
+}
+
+// give returns a port handed out by the pool to it when the pool has room.
+// It reports false when the caller should delete the port instead.
+func (p *warmPool) give(port ports.Port) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	if !p.handedOut[port.ID] {
+		p.mu.Unlock()
+		return false
+	}
+	delete(p.handedOut, port.ID)
+	full := len(p.spares) >= p.size
+	p.mu.Unlock()
+	if full {
+		return false
+	}
+
+	// take may have described the port after the pod it was handed to.
+	name, deviceID, description := poolPortName, "", ""
+	spare, err := p.client.Update(port.ID, ports.UpdateOpts{Name: &name, DeviceID: &deviceID, Description: &description})
+	if err != nil {
+		log.Printf("WARNING returning port %s to the pool failed: %v", port.ID, err)
+		return false
+	}
+	p.mu.Lock()
+	p.spares = append(p.spares, *spare)
+	p.mu.Unlock()
+	return true
+}
+
+// drop forgets the port portID, a spare or a port handed out, deleted
+// behind the pool's back, e.g. by a node drain. The pool is not refilled
+// until the next take.
+func (p *warmPool) drop(portID string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.handedOut, portID)
+	for i, spare := range p.spares {
+		if spare.ID == portID {
+			p.spares = append(p.spares[:i:i], p.spares[i+1:]...)
+			return
+		}
+	}
+}
+
+// replenish adopts spares left by a previous run, then creates ports until the
+// pool is full. Concurrent calls return immediately while a fill is running.
+func (p *warmPool) replenish() {
+	p.mu.Lock()
+	if p.filling {
+		p.mu.Unlock()
+		return
+	}
+	p.filling = true
+	adopted := p.adopted
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.filling = false
+		p.mu.Unlock()
+	}()
+
+	if !adopted {
+		existing, err := p.client.List(ports.ListOpts{Name: poolPortName, NetworkID: p.networkID})
+		if err != nil {
+			log.Printf("ERROR listing pool ports on network %s: %v", p.networkID, err)
+			return
+		}
+		p.mu.Lock()
+		for _, port := range existing {
+			if len(port.FixedIPs) > 0 && port.FixedIPs[0].SubnetID == p.subnetID {
+				p.spares = append(p.spares, port)
+			}
+		}
+		p.adopted = true
+		p.mu.Unlock()
+	}
+
+	for {
+		p.mu.Lock()
+		missing := p.size - len(p.spares)
+		p.mu.Unlock()
+		if missing <= 0 {
+			return
+		}
+		port, err := p.client.Create(portCreateOpts{
+			CreateOpts: ports.CreateOpts{
+				Name:      poolPortName,
+				NetworkID: p.networkID,
+				FixedIPs:  []ports.IP{{SubnetID: p.subnetID}},
+			},
+			HostID: p.hostID,
+			Tags:   p.tags,
+		})
+		if err != nil {
+			log.Printf("ERROR creating pool port on subnet %s: %v", p.subnetID, err)
+			return
+		}
+		p.mu.Lock()
+		p.spares = append(p.spares, *port)
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func newTestWarmPool(fake *fakePortClient, size int) *warmPool {
+	cfg := defaultDaemonConfig()
+	cfg.WarmPoolSize = size
+	cfg.WarmPoolNetworkID = "net-uuid"
+	cfg.WarmPoolSubnetID = "subnet-uuid"
+	return newWarmPool(fake, cfg)
+}
+
+var errNoCapacity = errors.New("no more IP addresses available on subnet")
+
+func spareCount(p *warmPool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.spares), p.filling
+}
+
+// waitForSpares waits for the background replenishment to finish with n
+// spares in the pool.
+func waitForSpares(t *testing.T, p *warmPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, filling := spareCount(p)
+		if got == n && !filling {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spares = %d (filling %t), want %d", got, filling, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewWarmPoolDisabled(t *testing.T) {
+	if p := newWarmPool(newFakePortClient(), defaultDaemonConfig()); p != nil {
+		t.Errorf("newWarmPool() = %+v, want nil", p)
+	}
+}
+
+func TestWarmPoolReplenishAdoptsAndCreates(t *testing.T) {
+	fake := newFakePortClient(
+		ports.Port{ID: "spare-1", Name: poolPortName, NetworkID: "net-uuid", FixedIPs: []ports.IP{{SubnetID: "subnet-uuid", IPAddress: "10.0.0.3"}}},
+		ports.Port{ID: "spare-other", Name: poolPortName, NetworkID: "net-uuid", FixedIPs: []ports.IP{{SubnetID: "other-subnet", IPAddress: "10.1.0.3"}}},
+	)
+	p := newTestWarmPool(fake, 3)
+
+	p.replenish()
+
+	if got, _ := spareCount(p); got != 3 {
+		t.Fatalf("spares = %d, want 3", got)
+	}
+	if fake.created != 2 {
+		t.Errorf("created = %d, want 2", fake.created)
+	}
+	if p.spares[0].ID != "spare-1" {
+		t.Errorf("first spare = %s, want the adopted spare-1", p.spares[0].ID)
+	}
+}
+
+func TestWarmPoolTakeFromPrefilled(t *testing.T) {
+	fake := newFakePortClient()
+	p := newTestWarmPool(fake, 2)
+	p.replenish()
+	first := p.spares[0]
+
+	port, ok := p.take("abcdef1234567890", "k8s-pod-abcdef123456", "")
+	if !ok {
+		t.Fatal("take() reported no spare")
+	}
+	if port.ID != first.ID {
+		t.Errorf("port = %s, want %s", port.ID, first.ID)
+	}
+	if port.Name != "k8s-pod-abcdef123456" || port.DeviceID != "abcdef1234567890" {
+		t.Errorf("port name/device_id = %q/%q, want k8s-pod-abcdef123456/abcdef1234567890", port.Name, port.DeviceID)
+	}
+	if len(port.FixedIPs) != 1 || port.FixedIPs[0].IPAddress == "" {
+		t.Errorf("port fixed IPs = %v, want the pre-allocated address", port.FixedIPs)
+	}
+
+	waitForSpares(t, p, 2)
+	if fake.created != 3 {
+		t.Errorf("created = %d, want 3", fake.created)
+	}
+}
+
+func TestWarmPoolTakeEmpty(t *testing.T) {
+	fake := newFakePortClient()
+	fake.createErr = errNoCapacity
+	p := newTestWarmPool(fake, 1)
+
+	if _, ok := p.take("abcdef1234567890", "k8s-pod-abcdef123456", ""); ok {
+		t.Error("take() from an empty pool reported a spare")
+	}
+}
+
+func TestWarmPoolGive(t *testing.T) {
+	fake := newFakePortClient()
+	p := newTestWarmPool(fake, 1)
+	p.replenish()
+
+	port, ok := p.take("abcdef1234567890", "k8s-pod-abcdef123456", "default/web-0")
+	if !ok {
+		t.Fatal("take() reported no spare")
+	}
+	waitForSpares(t, p, 1)
+
+	// The pool refilled meanwhile, so the port must be deleted instead.
+	if p.give(*port) {
+		t.Error("give() to a full pool returned true")
+	}
+
+	p.mu.Lock()
+	p.spares = nil
+	p.handedOut[port.ID] = true
+	p.mu.Unlock()
+	if !p.give(*port) {
+		t.Fatal("give() of a handed-out port returned false")
+	}
+	if got := fake.ports[port.ID]; got.Name != poolPortName || got.DeviceID != "" || got.Description != "" {
+		t.Errorf("returned port name/device_id/description = %q/%q/%q, want %q/\"\"/\"\"", got.Name, got.DeviceID, got.Description, poolPortName)
+	}
+
+	if p.give(ports.Port{ID: "port-not-pooled"}) {
+		t.Error("give() of a port not from the pool returned true")
+	}
+}
+
+func TestWarmPoolDrop(t *testing.T) {
+	p := &warmPool{
+		spares:    []ports.Port{{ID: "spare-1"}, {ID: "spare-2"}},
+		handedOut: map[string]bool{"taken-1": true},
+	}
+	p.drop("spare-1")
+	p.drop("taken-1")
+	p.drop("port-not-pooled")
+	if len(p.spares) != 1 || p.spares[0].ID != "spare-2" {
+		t.Errorf("spares = %v, want only spare-2", p.spares)
+	}
+	if len(p.handedOut) != 0 {
+		t.Errorf("handedOut = %v, want it empty", p.handedOut)
+	}
+	// A port dropped while handed out is not taken back on DEL.
+	if p.give(ports.Port{ID: "taken-1"}) {
+		t.Error("give() of a dropped port returned true")
+	}
+
+	var disabled *warmPool
+	disabled.drop("spare-1")
+}
+
+func TestWarmPoolServes(t *testing.T) {
+	p := newTestWarmPool(newFakePortClient(), 1)
+	base := api.AddRequest{ContainerID: "c", NetworkID: "net-uuid", SubnetID: "subnet-uuid"}
+
+	tests := []struct {
+		name     string
+		modify   func(*api.AddRequest)
+		subnetID string
+		want     bool
+	}{
+		{name: "PlainRequest", modify: func(*api.AddRequest) {}, subnetID: "subnet-uuid", want: true},
+		{name: "OtherSubnet", modify: func(*api.AddRequest) {}, subnetID: "other-subnet"},
+		{name: "SecurityGroups", modify: func(r *api.AddRequest) { r.SecurityGroupIDs = []string{"sg"} }, subnetID: "subnet-uuid"},
+		{name: "StaticIP", modify: func(r *api.AddRequest) { r.IPAddress = "10.0.0.5" }, subnetID: "subnet-uuid"},
+		{name: "ExtraCreateOpts", modify: func(r *api.AddRequest) { r.ExtraCreateOpts = map[string]interface{}{"propagate_uplink_status": true} }, subnetID: "subnet-uuid"},
+		{name: "AdminStateDown", modify: func(r *api.AddRequest) { r.AdminStateDown = true }, subnetID: "subnet-uuid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base
+			tt.modify(&req)
+			if got := p.serves(req, tt.subnetID); got != tt.want {
+				t.Errorf("serves() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	var disabled *warmPool
+	if disabled.serves(base, "subnet-uuid") {
+		t.Error("nil pool serves() = true")
+	}
+}
+
+func TestAddReportsCreated(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1"}}`))
+	})
+
+	fake := newFakePortClient()
+	cfg := defaultDaemonConfig()
+	cfg.WarmPoolSize = 1
+	cfg.WarmPoolNetworkID = "net-uuid"
+	cfg.WarmPoolSubnetID = "subnet-uuid"
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, cfg)
+
+	add := func(containerID, extra string) api.AddResponse {
+		t.Helper()
+		body := `{"container_id":"` + containerID + `","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400` + extra + `}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp api.AddResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+
+	// A static IP is never served from the pool.
+	if resp := add("aaaaaaaaaaaa0000", `,"ip_address":"10.0.0.50"`); !resp.Created {
+		t.Error("Created = false for a newly created port, want true")
+	}
+
+	// Once the background replenishment has a spare ready, ADD reuses it.
+	deadline := time.Now().Add(time.Second)
+	for i := 0; ; i++ {
+		resp := add(fmt.Sprintf("bbbbbbbbbbbb%04d", i), "")
+		if !resp.Created {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no ADD reused a pool port")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
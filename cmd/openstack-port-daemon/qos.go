@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/qos/policies"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/qos/rules"
+
+	"openstack-port/internal/api"
+)
+
+// qosPolicyDescription marks the QoS policies the daemon creates for a
+// port's bandwidth, so DEL only deletes those.
+const qosPolicyDescription = "Pod port bandwidth, managed by openstack-port-cni"
+
+// createQoSPolicy creates a QoS policy named name with an egress bandwidth
+// limit rule for MaxKbps and an egress minimum bandwidth rule for MinKbps,
+// and returns its ID. A policy whose rules fail is deleted again.
+func createQoSPolicy(neutronClient *gophercloud.ServiceClient, name string, bandwidth *api.Bandwidth) (string, error) {
+	policy, err := policies.Create(neutronClient, policies.CreateOpts{Name: name, Description: qosPolicyDescription}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to create QoS policy: %w", err)
+	}
+	if bandwidth.MaxKbps > 0 {
+		opts := rules.CreateBandwidthLimitRuleOpts{MaxKBps: bandwidth.MaxKbps, MaxBurstKBps: bandwidth.MaxBurstKbps, Direction: "egress"}
+		if _, err := rules.CreateBandwidthLimitRule(neutronClient, policy.ID, opts).ExtractBandwidthLimitRule(); err != nil {
+			deleteQoSPolicy(neutronClient, policy.ID)
+			return "", fmt.Errorf("failed to create bandwidth limit rule: %w", err)
+		}
+	}
+	if bandwidth.MinKbps > 0 {
+		opts := rules.CreateMinimumBandwidthRuleOpts{MinKBps: bandwidth.MinKbps, Direction: "egress"}
+		if _, err := rules.CreateMinimumBandwidthRule(neutronClient, policy.ID, opts).ExtractMinimumBandwidthRule(); err != nil {
+			deleteQoSPolicy(neutronClient, policy.ID)
+			return "", fmt.Errorf("failed to create minimum bandwidth rule: %w", err)
+		}
+	}
+	return policy.ID, nil
+}
+
+// deleteQoSPolicy deletes the QoS policy policyID, logging failures.
+func deleteQoSPolicy(neutronClient *gophercloud.ServiceClient, policyID string) {
+	if err := policies.Delete(neutronClient, policyID).ExtractErr(); err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); !ok {
+			log.Printf("WARNING deleting QoS policy %s failed, policy may leak: %v", policyID, err)
+		}
+	}
+}
+
+// deleteQoSPolicies deletes the QoS policies the daemon created for the
+// port named name and returns their IDs.
+func deleteQoSPolicies(neutronClient *gophercloud.ServiceClient, name string) ([]string, error) {
+	allPages, err := policies.List(neutronClient, policies.ListOpts{Name: name, Description: qosPolicyDescription}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	found, err := policies.ExtractPolicies(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, policy := range found {
+		if err := policies.Delete(neutronClient, policy.ID).ExtractErr(); err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return deleted, fmt.Errorf("failed to delete QoS policy %s: %w", policy.ID, err)
+			}
+		}
+		deleted = append(deleted, policy.ID)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+// qosRecorder mocks the Neutron QoS API and records the requests made to it.
+type qosRecorder struct {
+	mu       sync.Mutex
+	policy   map[string]interface{}
+	rules    map[string]map[string]interface{}
+	deleted  []string
+	listings int
+}
+
+func handleQoS(t *testing.T) *qosRecorder {
+	t.Helper()
+	rec := &qosRecorder{rules: make(map[string]map[string]interface{})}
+	decode := func(r *http.Request, key string) map[string]interface{} {
+		var body map[string]map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode %s: %v", key, err)
+		}
+		return body[key]
+	}
+	th.Mux.HandleFunc("/qos/policies", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if r.Method == http.MethodGet {
+			rec.listings++
+			if q := r.URL.Query(); q.Get("name") != "k8s-pod-abcdef123456" || q.Get("description") != qosPolicyDescription {
+				t.Errorf("policy list query = %v, want the port name and daemon description", q)
+			}
+			_, _ = w.Write([]byte(`{"policies": [{"id": "policy-1", "name": "k8s-pod-abcdef123456"}]}`))
+			return
+		}
+		rec.policy = decode(r, "policy")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"policy": {"id": "policy-1", "name": "k8s-pod-abcdef123456"}}`))
+	})
+	for _, kind := range []string{"bandwidth_limit_rule", "minimum_bandwidth_rule"} {
+		kind := kind
+		th.Mux.HandleFunc("/qos/policies/policy-1/"+kind+"s", func(w http.ResponseWriter, r *http.Request) {
+			rec.mu.Lock()
+			rec.rules[kind] = decode(r, kind)
+			rec.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"` + kind + `": {"id": "rule-1"}}`))
+		})
+	}
+	th.Mux.HandleFunc("/qos/policies/policy-1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("unexpected %s on policy-1", r.Method)
+		}
+		rec.mu.Lock()
+		rec.deleted = append(rec.deleted, "policy-1")
+		rec.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return rec
+}
+
+func TestAddWithBandwidth(t *testing.T) {
+	for _, subnetOK := range []bool{true, false} {
+		name := "creates policy"
+		if !subnetOK {
+			name = "failed add deletes policy"
+		}
+		t.Run(name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			qos := handleQoS(t)
+
+			var port map[string]interface{}
+			th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Port map[string]interface{} `json:"port"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				port = body.Port
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+			}))
+			portDeleted := false
+			th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+				portDeleted = r.Method == http.MethodDelete
+				w.WriteHeader(http.StatusNoContent)
+			})
+			th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if !subnetOK {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+			})
+
+			handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+			body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400,"bandwidth":{"min_kbps":1000,"max_kbps":10000,"max_burst_kbps":2000}}`)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+
+			if qos.policy["name"] != "k8s-pod-abcdef123456" || qos.policy["description"] != qosPolicyDescription {
+				t.Errorf("policy = %v, want it named after the port", qos.policy)
+			}
+			wantRules := map[string]map[string]interface{}{
+				"bandwidth_limit_rule":   {"max_kbps": float64(10000), "max_burst_kbps": float64(2000), "direction": "egress"},
+				"minimum_bandwidth_rule": {"min_kbps": float64(1000), "direction": "egress"},
+			}
+			if !reflect.DeepEqual(qos.rules, wantRules) {
+				t.Errorf("rules = %v, want %v", qos.rules, wantRules)
+			}
+			if port["qos_policy_id"] != "policy-1" {
+				t.Errorf("port qos_policy_id = %v, want policy-1", port["qos_policy_id"])
+			}
+
+			if subnetOK {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+				}
+				if len(qos.deleted) != 0 {
+					t.Errorf("deleted policies %v after a successful ADD", qos.deleted)
+				}
+				return
+			}
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			if !portDeleted || !reflect.DeepEqual(qos.deleted, []string{"policy-1"}) {
+				t.Errorf("port deleted = %t, policies deleted = %v, want both cleaned up", portDeleted, qos.deleted)
+			}
+		})
+	}
+}
+
+func TestAddRejectsBandwidthWithQoSPolicyID(t *testing.T) {
+	rec := serveFake(t, newFakePortClient(), "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","bandwidth":{"max_kbps":1000},"extra_create_opts":{"qos_policy_id":"policy-x"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDelDeletesQoSPolicy(t *testing.T) {
+	for _, flagged := range []bool{true, false} {
+		t.Run(map[bool]string{true: "qos_policy", false: "no qos_policy"}[flagged], func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+			qos := handleQoS(t)
+			th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"ports": [{"id": "port-a", "name": "k8s-pod-abcdef123456", "network_id": "net-uuid"}]}`))
+			})
+			th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+			body, _ := json.Marshal(api.DelRequest{ContainerID: "abcdef1234567890", NetworkID: "net-uuid", QoSPolicy: flagged})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/del", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+
+			var want []string
+			if flagged {
+				want = []string{"policy-1"}
+			} else if qos.listings != 0 {
+				t.Errorf("listed QoS policies %d time(s) without qos_policy", qos.listings)
+			}
+			if !reflect.DeepEqual(qos.deleted, want) {
+				t.Errorf("deleted policies = %v, want %v", qos.deleted, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/extraroutes"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+)
+
+// validateRouteDestinations checks the destinations of router routes
+// requested for a pod.
+func validateRouteDestinations(destinations []string) error {
+	if len(destinations) == 0 {
+		return fmt.Errorf("router_route_destinations is required with router_id")
+	}
+	for _, d := range destinations {
+		if _, _, err := net.ParseCIDR(d); err != nil {
+			return fmt.Errorf("invalid router route destination %q: %v", d, err)
+		}
+	}
+	return nil
+}
+
+// addRouterRoutes adds a route to each destination via nexthop on routerID.
+// The extraroutes API adds them atomically, so concurrent pods sharing the
+// router do not overwrite each other's routes.
+func addRouterRoutes(neutronClient *gophercloud.ServiceClient, routerID string, destinations []string, nexthop string) error {
+	routes := make([]routers.Route, 0, len(destinations))
+	for _, d := range destinations {
+		routes = append(routes, routers.Route{DestinationCIDR: d, NextHop: nexthop})
+	}
+	_, err := extraroutes.Add(neutronClient, routerID, extraroutes.Opts{Routes: &routes}).Extract()
+	return err
+}
+
+// removeRouterRoutes removes every route on routerID whose next hop is one of
+// nexthops and returns the removed routes.
+func removeRouterRoutes(neutronClient *gophercloud.ServiceClient, routerID string, nexthops []string) ([]routers.Route, error) {
+	router, err := routers.Get(neutronClient, routerID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	hops := make(map[string]bool, len(nexthops))
+	for _, h := range nexthops {
+		hops[h] = true
+	}
+	var routes []routers.Route
+	for _, r := range router.Routes {
+		if hops[r.NextHop] {
+			routes = append(routes, r)
+		}
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	if _, err := extraroutes.Remove(neutronClient, routerID, extraroutes.Opts{Routes: &routes}).Extract(); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
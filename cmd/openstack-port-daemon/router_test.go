@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+)
+
+func TestValidateRouteDestinations(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []string
+		wantErr      bool
+	}{
+		{name: "Valid", destinations: []string{"192.168.100.0/24", "fd00::/64"}},
+		{name: "Empty", wantErr: true},
+		{name: "NotCIDR", destinations: []string{"192.168.100.1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRouteDestinations(tt.destinations); (err != nil) != tt.wantErr {
+				t.Errorf("validateRouteDestinations(%v) error = %v, wantErr %t", tt.destinations, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// handleRouterWithRoutes registers GET /routers/router-uuid returning routes
+// and records the body of the last extraroutes call for action.
+func handleRouterWithRoutes(t *testing.T, routes, action string) *string {
+	t.Helper()
+	got := new(string)
+	th.Mux.HandleFunc("/routers/router-uuid", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method %s on router", r.Method)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"router": {"id": "router-uuid", "routes": ` + routes + `}}`))
+	})
+	th.Mux.HandleFunc("/routers/router-uuid/"+action, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected method %s on %s", r.Method, action)
+		}
+		b, _ := io.ReadAll(r.Body)
+		*got = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"router": {"id": "router-uuid", "routes": []}}`))
+	})
+	return got
+}
+
+func TestAddRouterRoutes(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	got := handleRouterWithRoutes(t, `[]`, "add_extraroutes")
+
+	if err := addRouterRoutes(thclient.ServiceClient(), "router-uuid", []string{"192.168.100.0/24"}, "10.0.0.5"); err != nil {
+		t.Fatalf("addRouterRoutes: %v", err)
+	}
+	want := `{"router":{"routes":[{"destination":"192.168.100.0/24","nexthop":"10.0.0.5"}]}}`
+	if strings.TrimSpace(*got) != want {
+		t.Errorf("add_extraroutes body = %s, want %s", *got, want)
+	}
+}
+
+func TestRemoveRouterRoutes(t *testing.T) {
+	t.Run("OnlyRoutesViaNexthops", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+		got := handleRouterWithRoutes(t, `[
+			{"destination": "192.168.100.0/24", "nexthop": "10.0.0.5"},
+			{"destination": "192.168.200.0/24", "nexthop": "10.0.0.9"}
+		]`, "remove_extraroutes")
+
+		removed, err := removeRouterRoutes(thclient.ServiceClient(), "router-uuid", []string{"10.0.0.5"})
+		if err != nil {
+			t.Fatalf("removeRouterRoutes: %v", err)
+		}
+		wantRemoved := []routers.Route{{DestinationCIDR: "192.168.100.0/24", NextHop: "10.0.0.5"}}
+		if !reflect.DeepEqual(removed, wantRemoved) {
+			t.Errorf("removed = %v, want %v", removed, wantRemoved)
+		}
+		want := `{"router":{"routes":[{"destination":"192.168.100.0/24","nexthop":"10.0.0.5"}]}}`
+		if strings.TrimSpace(*got) != want {
+			t.Errorf("remove_extraroutes body = %s, want %s", *got, want)
+		}
+	})
+
+	t.Run("NoMatchingRoutes", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+		got := handleRouterWithRoutes(t, `[{"destination": "192.168.200.0/24", "nexthop": "10.0.0.9"}]`, "remove_extraroutes")
+
+		removed, err := removeRouterRoutes(thclient.ServiceClient(), "router-uuid", []string{"10.0.0.5"})
+		if err != nil {
+			t.Fatalf("removeRouterRoutes: %v", err)
+		}
+		if len(removed) != 0 || *got != "" {
+			t.Errorf("removed = %v, remove_extraroutes body = %q, want no call", removed, *got)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"openstack-port/internal/api"
+)
+
+// remoteManagedVNICType is the binding:vnic_type of OVN ports plugged
+// through a Smart-NIC.
+const remoteManagedVNICType = "remote-managed"
+
+// bindingProfileMap returns profile as the binding:profile attribute.
+func bindingProfileMap(profile *api.BindingProfile) map[string]interface{} {
+	m := map[string]interface{}{
+		"pci_slot":           profile.PCISlot,
+		"card_serial_number": profile.CardSerialNumber,
+		"pf_mac_address":     profile.PFMACAddress,
+		"vf_num":             *profile.VFNum,
+	}
+	if profile.PCIVendorInfo != "" {
+		m["pci_vendor_info"] = profile.PCIVendorInfo
+	}
+	if profile.PhysicalNetwork != "" {
+		m["physical_network"] = profile.PhysicalNetwork
+	}
+	return m
+}
+
+// portBinding returns the binding attributes of the port portID, which
+// ports.Port does not carry.
+func portBinding(neutronClient *gophercloud.ServiceClient, portID string) (portsbinding.PortsBindingExt, error) {
+	var port struct {
+		ports.Port
+		portsbinding.PortsBindingExt
+	}
+	if err := ports.Get(neutronClient, portID).ExtractInto(&port); err != nil {
+		return portsbinding.PortsBindingExt{}, err
+	}
+	return port.PortsBindingExt, nil
+}
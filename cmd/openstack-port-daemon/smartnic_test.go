@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func TestAddWithBindingProfile(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var created map[string]interface{}
+	th.Mux.HandleFunc("/ports", listNoPorts(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Port map[string]interface{} `json:"port"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode create body: %v", err)
+		}
+		created = body.Port
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "mac_address": "fa:16:3e:aa:bb:cc", "network_id": "net-uuid", "fixed_ips": [{"subnet_id": "subnet-uuid", "ip_address": "10.0.0.5"}]}}`))
+	}))
+	th.Mux.HandleFunc("/ports/port-uuid-1234", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-uuid-1234", "binding:vnic_type": "remote-managed", "binding:vif_type": "ovs"}}`))
+	})
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "ip_version": 4, "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	body := bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","mtu":1400,` +
+		`"binding_profile":{"pci_slot":"0000:03:00.5","card_serial_number":"MT2113X00000","pf_mac_address":"00:53:00:00:00:42","vf_num":3}}`)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/add", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if got := created["binding:vnic_type"]; got != remoteManagedVNICType {
+		t.Errorf("binding:vnic_type = %v, want %q", got, remoteManagedVNICType)
+	}
+	profile, _ := created["binding:profile"].(map[string]interface{})
+	if profile["pci_slot"] != "0000:03:00.5" || profile["vf_num"] != float64(3) {
+		t.Errorf("binding:profile = %v, want pci_slot and vf_num passed through", profile)
+	}
+	if _, ok := profile["physical_network"]; ok {
+		t.Errorf("binding:profile = %v, want unset physical_network omitted", profile)
+	}
+
+	var resp api.AddResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.VIFType != "ovs" {
+		t.Errorf("VIFType = %q, want %q", resp.VIFType, "ovs")
+	}
+}
+
+func TestAddRejectsInvalidBindingProfile(t *testing.T) {
+	fake := newFakePortClient()
+	rec := serveFake(t, fake, "/add", `{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid","binding_profile":{"pci_slot":"0000:03:00.5"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	if fake.created != 0 {
+		t.Errorf("created %d port(s), want none", fake.created)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// watchSocket polls path every interval until ctx is done. When the socket
+// file has vanished, e.g. removed by a cleanup script while the daemon
+// still holds the listener, new clients cannot connect, so it listens on
+// path again like listenSocket and hands the new listener to serve.
+func watchSocket(ctx context.Context, path string, cfg daemonConfig, interval time.Duration, serve func(net.Listener)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			continue
+		}
+		log.Printf("WARNING socket %s was removed, listening on it again", path)
+		listener, err := listenSocket(path, cfg)
+		if err != nil {
+			log.Printf("ERROR re-creating socket %s, retrying at next check: %v", path, err)
+			continue
+		}
+		serve(listener)
+	}
+}
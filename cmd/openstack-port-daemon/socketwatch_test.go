@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// socketTestConfig lets the test process through the peer credential check
+// without running as root.
+func socketTestConfig() daemonConfig {
+	cfg := defaultDaemonConfig()
+	cfg.SocketUID = os.Getuid()
+	return cfg
+}
+
+// getOverSocket sends GET / over the unix socket at path.
+func getOverSocket(path string) error {
+	client := &http.Client{
+		Timeout: time.Second,
+		Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}},
+	}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestWatchSocketRecreatesRemovedSocket(t *testing.T) {
+	cfg := socketTestConfig()
+	path := filepath.Join(t.TempDir(), "cni.sock")
+	listener, err := listenSocket(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go func() { _ = srv.Serve(listener) }()
+	defer func() { _ = srv.Close() }()
+	if err := getOverSocket(path); err != nil {
+		t.Fatalf("request before removal: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var relistened atomic.Int32
+	go watchSocket(ctx, path, cfg, 10*time.Millisecond, func(l net.Listener) {
+		relistened.Add(1)
+		go func() { _ = srv.Serve(l) }()
+	})
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := getOverSocket(path)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request after removal still failing: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := relistened.Load(); got != 1 {
+		t.Errorf("socket listened on again %d times, want 1", got)
+	}
+}
+
+func TestWatchSocketKeepsExistingSocket(t *testing.T) {
+	cfg := socketTestConfig()
+	path := filepath.Join(t.TempDir(), "cni.sock")
+	listener, err := listenSocket(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	watchSocket(ctx, path, cfg, 10*time.Millisecond, func(l net.Listener) {
+		_ = l.Close()
+		t.Error("socket listened on again while it still exists")
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// firstEnv returns the first of the environment variables names that is
+// set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// authMethod names the Keystone method the OS_* environment selects.
+func authMethod() string {
+	switch {
+	case firstEnv("OS_APPLICATION_CREDENTIAL_ID", "OS_APPLICATION_CREDENTIAL_NAME") != "":
+		return "application_credential"
+	case os.Getenv("OS_TOKEN") != "":
+		return "token"
+	default:
+		return "password"
+	}
+}
+
+// logStartupConfig logs the configuration in effect so operators can confirm
+// it. Only identifiers are logged from the OS_* environment: passwords,
+// tokens and application credential secrets never are.
+func logStartupConfig(logger *log.Logger, cfg daemonConfig, socketPath string) {
+	logger.Printf("config socket=%s grpc_socket=%s socket_uid=%d socket_gid=%d peercred_policy=%s peer_exe_allowlist=%v socket_check_interval=%s strict_json=%t tracing=%t events=%t",
+		socketPath, cfg.GRPCSocket, cfg.SocketUID, cfg.SocketGID, cfg.PeerCredPolicy, cfg.PeerExeAllowlist, cfg.SocketCheckInterval, cfg.StrictJSON, tracingEnabled(), cfg.EventsURL != "")
+	logger.Printf("config container_lock=%t max_concurrent_requests=%d max_queue_depth=%d unavailable_retry_after=%s add_rate_interval=%s add_rate_burst=%d",
+		cfg.ContainerLock, cfg.MaxConcurrentRequests, cfg.MaxQueueDepth, cfg.UnavailableRetryAfter, cfg.AddRateInterval, cfg.AddRateBurst)
+	logger.Printf("config neutron_client_pool_size=%d neutron_read_timeout=%s request_timeout=%s lookup_cache_size=%d lookup_cache_ttl=%s create_visibility_grace=%s cleanup_token_ttl=%s ip_allocation_retries=%d ip_allocation_retry_interval=%s reuse_existing_port=%t stale_port_policy=%s",
+		cfg.NeutronClientPoolSize, cfg.NeutronReadTimeout, cfg.RequestTimeout, cfg.LookupCacheSize, cfg.LookupCacheTTL, cfg.CreateVisibilityGrace, cfg.CleanupTokenTTL, cfg.IPAllocationRetries, cfg.IPAllocationRetryInterval, cfg.ReuseExistingPort, cfg.StalePortPolicy)
+	logger.Printf("config warm_pool_size=%d warm_pool_network_id=%s warm_pool_subnet_id=%s allow_router_routes=%t allow_endpoint_override=%t port_naming=%T port_tags=%v port_description_template=%q host_id=%s namespace_networks=%d",
+		cfg.WarmPoolSize, cfg.WarmPoolNetworkID, cfg.WarmPoolSubnetID, cfg.AllowRouterRoutes, cfg.AllowEndpointOverride, cfg.PortNamer, cfg.PortTags, cfg.PortDescription.String(), cfg.HostID, len(cfg.NamespaceNetworks))
+
+	// The Neutron endpoint is the catalog's public one, in any region.
+	logger.Printf("config auth_method=%s auth_url=%s username=%s user_domain=%s project=%s region=any endpoint_type=public env_file=%s env_file_poll_interval=%s reauth_min_token_lifetime=%s user_agent=%q",
+		authMethod(), os.Getenv("OS_AUTH_URL"), firstEnv("OS_USERNAME", "OS_USERID"), firstEnv("OS_USER_DOMAIN_NAME", "OS_USER_DOMAIN_ID", "OS_DOMAIN_NAME", "OS_DOMAIN_ID"),
+		firstEnv("OS_PROJECT_NAME", "OS_TENANT_NAME", "OS_PROJECT_ID", "OS_TENANT_ID"), cfg.EnvFile, cfg.EnvFilePollInterval, cfg.ReauthMinTokenLifetime, cfg.UserAgent)
+}
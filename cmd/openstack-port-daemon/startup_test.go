@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogStartupConfigRedactsSecrets(t *testing.T) {
+	t.Setenv("OS_AUTH_URL", "https://keystone.example.com/v3")
+	t.Setenv("OS_USERNAME", "cni")
+	t.Setenv("OS_PASSWORD", "s3cret-password")
+	t.Setenv("OS_PROJECT_NAME", "k8s")
+	t.Setenv("OS_USER_DOMAIN_NAME", "Default")
+	t.Setenv("OS_APPLICATION_CREDENTIAL_SECRET", "s3cret-app-cred")
+	t.Setenv("OS_TOKEN", "")
+
+	var buf bytes.Buffer
+	logStartupConfig(log.New(&buf, "", 0), defaultDaemonConfig(), "/var/run/openstack-cni/cni.sock")
+	out := buf.String()
+
+	for _, secret := range []string{"s3cret-password", "s3cret-app-cred"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("startup log contains secret %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{
+		"socket=/var/run/openstack-cni/cni.sock",
+		"max_concurrent_requests=16",
+		"lookup_cache_ttl=30s",
+		"auth_method=password",
+		"auth_url=https://keystone.example.com/v3",
+		"username=cni",
+		"project=k8s",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("startup log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLogStartupConfigTokenAuth(t *testing.T) {
+	t.Setenv("OS_AUTH_URL", "https://keystone.example.com/v3")
+	t.Setenv("OS_USERNAME", "")
+	t.Setenv("OS_PASSWORD", "")
+	t.Setenv("OS_TOKEN", "gAAAAAsecret-token")
+
+	var buf bytes.Buffer
+	logStartupConfig(log.New(&buf, "", 0), defaultDaemonConfig(), "/var/run/openstack-cni/cni.sock")
+	out := buf.String()
+
+	if strings.Contains(out, "secret-token") {
+		t.Errorf("startup log contains the token:\n%s", out)
+	}
+	if !strings.Contains(out, "auth_method=token") {
+		t.Errorf("startup log missing auth_method=token:\n%s", out)
+	}
+}
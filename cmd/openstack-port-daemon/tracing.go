@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the daemon's spans.
+const tracerName = "openstack-port-daemon"
+
+// traceContext carries spans across the CNI, the daemon and Neutron as W3C
+// traceparent and tracestate headers.
+var traceContext = propagation.TraceContext{}
+
+// tracer returns the daemon's tracer from the global provider, a no-op one
+// unless setupTracing installed an exporter.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracingEnabled reports whether the environment sets an OTLP endpoint for
+// traces.
+func tracingEnabled() bool {
+	return firstEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+}
+
+// setupTracing exports spans over OTLP/HTTP when tracingEnabled, configured
+// by the standard OTEL_EXPORTER_OTLP_* variables, and otherwise leaves the
+// no-op provider in place. The returned function flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = tracerName
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// statusRecorder records the status a handler answers with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// traceRequests wraps every request in a server span, a child of the
+// caller's span when the request carries a traceparent header.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// annotateSpan tags the request span of ctx with the container and network
+// it is about, so the traces of one pod can be found.
+func annotateSpan(ctx context.Context, containerID, networkID string) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("container_id", containerID),
+		attribute.String("network_id", networkID))
+}
+
+// recordDelegateSpan adds a span for a delegate ADD that the CNI reported
+// to have taken d, ending now.
+func recordDelegateSpan(ctx context.Context, d time.Duration) {
+	end := time.Now()
+	_, span := tracer().Start(ctx, "delegate", trace.WithTimestamp(end.Add(-d)))
+	span.End(trace.WithTimestamp(end))
+}
+
+// tracingTransport wraps each Keystone and Neutron call in a client span,
+// a child of the span of the request's context.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLPath(req.URL.Path)))
+	defer span.End()
+	req = req.Clone(ctx)
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+
+	"openstack-port/internal/apipb"
+)
+
+// recordSpans installs a tracer provider recording ended spans in memory
+// for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// spanNamed returns the recorded span called name.
+func spanNamed(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+		names = append(names, span.Name)
+	}
+	t.Fatalf("no span %q, got %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+func hasAttribute(span tracetest.SpanStub, want attribute.KeyValue) bool {
+	for _, kv := range span.Attributes {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTraceRequests(t *testing.T) {
+	exporter := recordSpans(t)
+	fake := newFakePortClient(ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"})
+	handler := newHandlerWithPortClient(nil, fake, defaultDaemonConfig())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/check", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	span := spanNamed(t, exporter, "POST /check")
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("kind = %v, want server", span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace ID = %s, want the caller's %s", got, traceID)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span ID = %s, want the caller's 00f067aa0ba902b7", got)
+	}
+	for _, want := range []attribute.KeyValue{
+		attribute.String("container_id", "abcdef1234567890"),
+		attribute.String("network_id", "net-uuid"),
+		attribute.Int("http.response.status_code", http.StatusOK),
+	} {
+		if !hasAttribute(span, want) {
+			t.Errorf("span attributes %v lack %v", span.Attributes, want)
+		}
+	}
+}
+
+func TestTraceNeutronCalls(t *testing.T) {
+	exporter := recordSpans(t)
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	var traceparent string
+	th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"port": {"id": "port-a"}}`))
+	})
+
+	client := thclient.ServiceClient()
+	client.ProviderClient.HTTPClient.Transport = tracingTransport{}
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	portClient := portClientWithContext(ctx, gophercloudPortClient{clients: newNeutronClientRef(client, nil)})
+	if _, err := portClient.Get("port-a"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	parent.End()
+
+	span := spanNamed(t, exporter, "HTTP GET")
+	if span.SpanKind != trace.SpanKindClient {
+		t.Errorf("kind = %v, want client", span.SpanKind)
+	}
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("parent span ID = %s, want the request's %s", span.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+	if !hasAttribute(span, attribute.String("url.path", "/ports/port-a")) {
+		t.Errorf("span attributes %v lack the URL path", span.Attributes)
+	}
+	if want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"; traceparent != want {
+		t.Errorf("traceparent sent to Neutron = %q, want %q", traceparent, want)
+	}
+}
+
+func TestTraceObservedDelegate(t *testing.T) {
+	exporter := recordSpans(t)
+	handler := newHandler(nil, defaultDaemonConfig())
+	req := httptest.NewRequest(http.MethodPost, "/observe", bytes.NewBufferString(`{"phase":"delegate","seconds":0.5}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	request := spanNamed(t, exporter, "POST /observe")
+	delegate := spanNamed(t, exporter, "delegate")
+	if delegate.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Error("delegate span is not a child of the /observe request span")
+	}
+	if got := delegate.EndTime.Sub(delegate.StartTime); got != 500*time.Millisecond {
+		t.Errorf("delegate span lasted %s, want 500ms", got)
+	}
+}
+
+func TestSetupTracingDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	previous := otel.GetTracerProvider()
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+	if otel.GetTracerProvider() != previous {
+		t.Error("setupTracing() replaced the tracer provider without an endpoint")
+	}
+}
+
+func TestTraceGRPCRequests(t *testing.T) {
+	exporter := recordSpans(t)
+	fake := newFakePortClient(ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"})
+	client := startGRPCHandler(t, newHandlerWithPortClient(nil, fake, defaultDaemonConfig()))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if _, err := client.Check(ctx, &apipb.CheckRequest{ContainerId: "abcdef1234567890", NetworkId: "net-uuid"}); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := spanNamed(t, exporter, "POST /check").SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace ID = %s, want the caller's %s", got, traceID)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+
+	"openstack-port/internal/api"
+)
+
+// extraCreateOptExtensions maps extra_create_opts keys to the Neutron API
+// extension that must be enabled for Neutron to accept them.
+var extraCreateOptExtensions = map[string]string{
+	"propagate_uplink_status": "uplink-status-propagation",
+}
+
+// requiredExtensions returns the sorted Neutron extension aliases needed to
+// honor req.
+func requiredExtensions(req api.ValidateRequest, securityGroupIDs []string) []string {
+	set := map[string]bool{}
+	if len(securityGroupIDs) > 0 {
+		set["security-group"] = true
+	}
+	if req.SegmentID != "" {
+		set["segment"] = true
+	}
+	if req.RouterID != "" {
+		set["extraroute-atomic"] = true
+	}
+	for key := range req.ExtraCreateOpts {
+		if alias, ok := extraCreateOptExtensions[key]; ok {
+			set[alias] = true
+		}
+	}
+	aliases := make([]string, 0, len(set))
+	for alias := range set {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// validateConfig resolves everything referenced by req against Neutron
+// without modifying anything. Missing resources are reported in the
+// response; only unexpected Neutron failures are returned as an error.
+func validateConfig(neutronClient *gophercloud.ServiceClient, req api.ValidateRequest) (api.ValidateResponse, error) {
+	var resp api.ValidateResponse
+	securityGroupIDs := api.ParseSecurityGroupIDs(req.SecurityGroupIDs)
+
+	if err := api.ValidateExtraCreateOpts(req.ExtraCreateOpts); err != nil {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	if _, err := networks.Get(neutronClient, req.NetworkID).Extract(); err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); !ok {
+			return api.ValidateResponse{}, fmt.Errorf("failed to get network: %w", err)
+		}
+		resp.Errors = append(resp.Errors, fmt.Sprintf("network %s not found", req.NetworkID))
+	} else {
+		resp.NetworkExists = true
+	}
+
+	if req.SubnetID != "" {
+		subnet, err := subnets.Get(neutronClient, req.SubnetID).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return api.ValidateResponse{}, fmt.Errorf("failed to get subnet: %w", err)
+			}
+			resp.Errors = append(resp.Errors, fmt.Sprintf("subnet %s not found", req.SubnetID))
+		} else {
+			resp.SubnetExists = true
+			if subnet.NetworkID != req.NetworkID {
+				resp.Errors = append(resp.Errors, fmt.Sprintf("subnet %s belongs to network %s, not %s", req.SubnetID, subnet.NetworkID, req.NetworkID))
+			}
+		}
+	}
+
+	if req.SegmentID != "" {
+		ids, err := segmentSubnetIDs(neutronClient, req.NetworkID, req.SegmentID)
+		if err != nil {
+			return api.ValidateResponse{}, fmt.Errorf("failed to list subnets for segment %s: %w", req.SegmentID, err)
+		}
+		if len(ids) == 0 {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("no subnet on network %s belongs to segment %s", req.NetworkID, req.SegmentID))
+		} else if req.SubnetID == "" {
+			resp.SubnetExists = true
+		}
+	}
+
+	if required := requiredExtensions(req, securityGroupIDs); len(required) > 0 {
+		allPages, err := extensions.List(neutronClient).AllPages()
+		if err != nil {
+			return api.ValidateResponse{}, fmt.Errorf("failed to list extensions: %w", err)
+		}
+		available, err := extensions.ExtractExtensions(allPages)
+		if err != nil {
+			return api.ValidateResponse{}, fmt.Errorf("failed to extract extensions: %w", err)
+		}
+		enabled := make(map[string]bool, len(available))
+		for _, ext := range available {
+			enabled[ext.Alias] = true
+		}
+		for _, alias := range required {
+			if !enabled[alias] {
+				resp.MissingExtensions = append(resp.MissingExtensions, alias)
+				resp.Errors = append(resp.Errors, fmt.Sprintf("extension %s is not available", alias))
+			}
+		}
+	}
+
+	for _, id := range securityGroupIDs {
+		if _, err := groups.Get(neutronClient, id).Extract(); err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); !ok {
+				return api.ValidateResponse{}, fmt.Errorf("failed to get security group %s: %w", id, err)
+			}
+			resp.MissingSecurityGroups = append(resp.MissingSecurityGroups, id)
+			resp.Errors = append(resp.Errors, fmt.Sprintf("security group %s not found", id))
+		}
+	}
+
+	resp.Valid = len(resp.Errors) == 0
+	return resp, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func handleValidateNetwork(t *testing.T) {
+	t.Helper()
+	th.Mux.HandleFunc("/networks/net-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"network": {"id": "net-uuid", "name": "tenant-net"}}`))
+	})
+}
+
+func handleValidateExtensions(t *testing.T, aliases ...string) {
+	t.Helper()
+	th.Mux.HandleFunc("/extensions", func(w http.ResponseWriter, r *http.Request) {
+		exts := make([]map[string]string, 0, len(aliases))
+		for _, alias := range aliases {
+			exts = append(exts, map[string]string{"alias": alias, "name": alias})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"extensions": exts})
+	})
+}
+
+func postValidate(t *testing.T, body string) api.ValidateResponse {
+	t.Helper()
+	handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp api.ValidateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return resp
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleValidateNetwork(t)
+		handleValidateExtensions(t, "security-group", "uplink-status-propagation")
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid", "cidr": "10.0.0.0/24"}}`))
+		})
+		th.Mux.HandleFunc("/security-groups/sg-id-1", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"security_group": {"id": "sg-id-1", "name": "default"}}`))
+		})
+
+		resp := postValidate(t, `{
+			"cniVersion": "0.4.0",
+			"type": "openstack-port-cni",
+			"network_id": "net-uuid",
+			"subnet_id": "subnet-uuid",
+			"delegate_plugin": "ovs",
+			"bridge": "br-int",
+			"security_group_ids": "sg-id-1",
+			"extra_create_opts": {"propagate_uplink_status": true}
+		}`)
+
+		want := api.ValidateResponse{Valid: true, NetworkExists: true, SubnetExists: true}
+		if !reflect.DeepEqual(resp, want) {
+			t.Errorf("resp = %+v, want %+v", resp, want)
+		}
+	})
+
+	t.Run("MissingSubnet", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleValidateNetwork(t)
+		th.Mux.HandleFunc("/subnets/missing-subnet", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		resp := postValidate(t, `{"network_id":"net-uuid","subnet_id":"missing-subnet"}`)
+
+		if resp.Valid {
+			t.Error("expected Valid=false")
+		}
+		if !resp.NetworkExists {
+			t.Error("expected NetworkExists=true")
+		}
+		if resp.SubnetExists {
+			t.Error("expected SubnetExists=false")
+		}
+		if len(resp.Errors) != 1 {
+			t.Errorf("errors = %v, want exactly one", resp.Errors)
+		}
+	})
+
+	t.Run("MissingSecurityGroupAndExtension", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleValidateNetwork(t)
+		handleValidateExtensions(t)
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "net-uuid"}}`))
+		})
+		th.Mux.HandleFunc("/security-groups/sg-missing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		resp := postValidate(t, `{"network_id":"net-uuid","subnet_id":"subnet-uuid","security_group_ids":"sg-missing"}`)
+
+		if resp.Valid {
+			t.Error("expected Valid=false")
+		}
+		if !reflect.DeepEqual(resp.MissingExtensions, []string{"security-group"}) {
+			t.Errorf("MissingExtensions = %v, want [security-group]", resp.MissingExtensions)
+		}
+		if !reflect.DeepEqual(resp.MissingSecurityGroups, []string{"sg-missing"}) {
+			t.Errorf("MissingSecurityGroups = %v, want [sg-missing]", resp.MissingSecurityGroups)
+		}
+	})
+
+	t.Run("SubnetOnOtherNetwork", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handleValidateNetwork(t)
+		th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "network_id": "other-net"}}`))
+		})
+
+		resp := postValidate(t, `{"network_id":"net-uuid","subnet_id":"subnet-uuid"}`)
+
+		if resp.Valid {
+			t.Error("expected Valid=false")
+		}
+		if !resp.SubnetExists {
+			t.Error("expected SubnetExists=true")
+		}
+	})
+
+	t.Run("MissingFields", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBufferString(`{}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("WrongMethod", func(t *testing.T) {
+		th.SetupHTTP()
+		defer th.TeardownHTTP()
+
+		handler := newHandler(thclient.ServiceClient(), defaultDaemonConfig())
+		req := httptest.NewRequest(http.MethodGet, "/validate", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+// visibilityRetryDelay is the first pause before re-listing a port that was
+// just created but is not listed yet; it doubles on every retry.
+var visibilityRetryDelay = 50 * time.Millisecond
+
+// recentCreates remembers when this daemon created each port, by network and
+// name, so that a lookup finding no port shortly after its creation is
+// retried instead of trusted: Neutron may not list a port right after
+// creating it. A nil *recentCreates remembers nothing.
+type recentCreates struct {
+	grace time.Duration
+
+	mu      sync.Mutex
+	created map[string]time.Time
+}
+
+func newRecentCreates(grace time.Duration) *recentCreates {
+	return &recentCreates{grace: grace, created: make(map[string]time.Time)}
+}
+
+func recentKey(networkID, name string) string {
+	return networkID + "/" + name
+}
+
+// record notes that the port name was created on networkID now.
+func (r *recentCreates) record(networkID, name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for key, at := range r.created {
+		if now.Sub(at) > r.grace {
+			delete(r.created, key)
+		}
+	}
+	r.created[recentKey(networkID, name)] = now
+}
+
+// forget drops the record of the port name on networkID.
+func (r *recentCreates) forget(networkID, name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.created, recentKey(networkID, name))
+	r.mu.Unlock()
+}
+
+// deadline returns when the grace for the port name on networkID ends, and
+// false when it was not created within the grace.
+func (r *recentCreates) deadline(networkID, name string) (time.Time, bool) {
+	if r == nil {
+		return time.Time{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.created[recentKey(networkID, name)]
+	if !ok {
+		return time.Time{}, false
+	}
+	end := at.Add(r.grace)
+	return end, time.Now().Before(end)
+}
+
+// listPorts lists the ports matching opts. When none match but this daemon
+// created the port within the grace, it re-lists with backoff until the port
+// shows up or the grace ends.
+func listPorts(portClient NeutronPortClient, opts ports.ListOpts, recent *recentCreates) ([]ports.Port, error) {
+	allPorts, err := portClient.List(opts)
+	if err != nil || len(allPorts) > 0 {
+		return allPorts, err
+	}
+	end, ok := recent.deadline(opts.NetworkID, opts.Name)
+	if !ok {
+		return allPorts, nil
+	}
+	for delay := visibilityRetryDelay; ; delay *= 2 {
+		remaining := time.Until(end)
+		if remaining <= 0 {
+			return allPorts, nil
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		allPorts, err = portClient.List(opts)
+		if err != nil || len(allPorts) > 0 {
+			return allPorts, err
+		}
+	}
+}
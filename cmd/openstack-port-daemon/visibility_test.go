@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	"openstack-port/internal/api"
+)
+
+func shortVisibilityRetryDelay(t *testing.T) {
+	t.Helper()
+	old := visibilityRetryDelay
+	visibilityRetryDelay = time.Millisecond
+	t.Cleanup(func() { visibilityRetryDelay = old })
+}
+
+func TestListPortsRetriesRecentCreate(t *testing.T) {
+	shortVisibilityRetryDelay(t)
+	fake := newFakePortClient(ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"})
+	fake.hiddenLists = 3
+	recent := newRecentCreates(time.Second)
+	recent.record("net-uuid", "k8s-pod-abcdef123456")
+
+	got, err := listPorts(fake, ports.ListOpts{Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"}, recent)
+	if err != nil {
+		t.Fatalf("listPorts() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "port-a" {
+		t.Errorf("listPorts() = %+v, want port-a", got)
+	}
+	if fake.lists != 4 {
+		t.Errorf("lists = %d, want 4", fake.lists)
+	}
+}
+
+func TestListPortsTrustsEmptyWithoutRecentCreate(t *testing.T) {
+	fake := newFakePortClient(ports.Port{ID: "port-a", Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"})
+	fake.hiddenLists = 1
+	recent := newRecentCreates(time.Second)
+	recent.record("net-uuid", "k8s-pod-other0000000")
+
+	got, err := listPorts(fake, ports.ListOpts{Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"}, recent)
+	if err != nil {
+		t.Fatalf("listPorts() error = %v", err)
+	}
+	if len(got) != 0 || fake.lists != 1 {
+		t.Errorf("listPorts() = %+v after %d lists, want nothing after 1", got, fake.lists)
+	}
+}
+
+func TestListPortsGivesUpAfterGrace(t *testing.T) {
+	shortVisibilityRetryDelay(t)
+	fake := newFakePortClient()
+	fake.hiddenLists = 1000
+	recent := newRecentCreates(50 * time.Millisecond)
+	recent.record("net-uuid", "k8s-pod-abcdef123456")
+
+	start := time.Now()
+	got, err := listPorts(fake, ports.ListOpts{Name: "k8s-pod-abcdef123456", NetworkID: "net-uuid"}, recent)
+	if err != nil {
+		t.Fatalf("listPorts() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("listPorts() = %+v, want nothing", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("listPorts() took %v, want it bounded by the grace", elapsed)
+	}
+}
+
+func TestDelFindsPortNotYetListed(t *testing.T) {
+	shortVisibilityRetryDelay(t)
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	th.Mux.HandleFunc("/subnets/subnet-uuid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subnet": {"id": "subnet-uuid", "cidr": "10.0.0.0/24", "gateway_ip": "10.0.0.1", "network_id": "net-uuid"}}`))
+	})
+	fake := newFakePortClient()
+	handler := newHandlerWithPortClient(newNeutronClientRef(thclient.ServiceClient(), nil), fake, defaultDaemonConfig())
+
+	req := httptest.NewRequest(http.MethodPost, "/add", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid","subnet_id":"subnet-uuid"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ADD status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	fake.mu.Lock()
+	fake.hiddenLists = 2
+	fake.mu.Unlock()
+	req = httptest.NewRequest(http.MethodPost, "/del", bytes.NewBufferString(`{"container_id":"abcdef1234567890","network_id":"net-uuid"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DEL status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp api.DelResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(resp.DeletedPortIDs, []string{"port-1"}) {
+		t.Errorf("DeletedPortIDs = %v, want [port-1]", resp.DeletedPortIDs)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// EUI64InterfaceID returns the modified EUI-64 interface identifier SLAAC
+// derives from the 48-bit mac (RFC 4291 appendix A), e.g.
+// "f816:3eff:feaa:bbcc" for fa:16:3e:aa:bb:cc.
+func EUI64InterfaceID(mac string) (string, error) {
+	id, err := eui64(mac)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x:%x:%x:%x",
+		uint16(id[0])<<8|uint16(id[1]), uint16(id[2])<<8|uint16(id[3]),
+		uint16(id[4])<<8|uint16(id[5]), uint16(id[6])<<8|uint16(id[7])), nil
+}
+
+// MatchesEUI64 reports whether the IPv6 address ip ends with the EUI-64
+// interface identifier of mac, as an address assigned by SLAAC does.
+func MatchesEUI64(ip, mac string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() != nil {
+		return false
+	}
+	id, err := eui64(mac)
+	if err != nil {
+		return false
+	}
+	return string(addr.To16()[8:]) == string(id[:])
+}
+
+func eui64(mac string) ([8]byte, error) {
+	var id [8]byte
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return id, err
+	}
+	if len(hw) != 6 {
+		return id, fmt.Errorf("mac %q is not a 48-bit address", mac)
+	}
+	id = [8]byte{hw[0] ^ 0x02, hw[1], hw[2], 0xff, 0xfe, hw[3], hw[4], hw[5]}
+	return id, nil
+}
@@ -0,0 +1,48 @@
+package api
+
+import "testing"
+
+func TestEUI64InterfaceID(t *testing.T) {
+	tests := []struct {
+		mac     string
+		want    string
+		wantErr bool
+	}{
+		{mac: "fa:16:3e:aa:bb:cc", want: "f816:3eff:feaa:bbcc"},
+		{mac: "52:54:00:12:34:56", want: "5054:ff:fe12:3456"},
+		{mac: "00:00:00:00:00:00", want: "200:ff:fe00:0"},
+		{mac: "FA-16-3E-AA-BB-CC", want: "f816:3eff:feaa:bbcc"},
+		{mac: "02:00:5e:10:00:00:00:01", wantErr: true},
+		{mac: "not-a-mac", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := EUI64InterfaceID(tt.mac)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("EUI64InterfaceID(%q) error = %v, wantErr %v", tt.mac, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("EUI64InterfaceID(%q) = %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesEUI64(t *testing.T) {
+	const mac = "fa:16:3e:aa:bb:cc"
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "2001:db8::f816:3eff:feaa:bbcc", want: true},
+		{ip: "2001:db8:0:1:f816:3eff:feaa:bbcc", want: true},
+		{ip: "2001:db8::fa16:3eff:feaa:bbcc"},
+		{ip: "2001:db8::5"},
+		{ip: "10.0.0.5"},
+		{ip: "bogus"},
+	}
+	for _, tt := range tests {
+		if got := MatchesEUI64(tt.ip, mac); got != tt.want {
+			t.Errorf("MatchesEUI64(%q, %q) = %t, want %t", tt.ip, mac, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// PortSpec holds the Neutron port attributes of an ADD. The CNI fills it in
+// from its network config and the daemon translates it into the port create
+// request, so that both validate and map the attributes the same way. It is
+// embedded in AddRequest, keeping its fields at the top level of the JSON
+// body.
+type PortSpec struct {
+	SecurityGroupIDs []string `json:"security_group_ids,omitempty"`
+	// IPAddress requests a specific fixed IP on the subnet. A request for an
+	// address already in use fails with 409 Conflict.
+	IPAddress string `json:"ip_address,omitempty"`
+	// IPCount asks for that many fixed IPs on the subnet, the first being
+	// IPAddress when set. Zero means one.
+	IPCount int `json:"ip_count,omitempty"`
+	// AdminStateDown creates the port with admin_state_up=false; the CNI
+	// brings it up via /up once the delegate has wired it into OVS.
+	AdminStateDown bool `json:"admin_state_down,omitempty"`
+	// ExtraCreateOpts holds additional Neutron port attributes (e.g.
+	// propagate_uplink_status) merged into the port create request body.
+	ExtraCreateOpts map[string]interface{} `json:"extra_create_opts,omitempty"`
+	// BindingProfile, when set, creates the port as an OVN remote-managed
+	// (Smart-NIC) port with this binding:profile.
+	BindingProfile *BindingProfile `json:"binding_profile,omitempty"`
+	// Bandwidth, when set, gives the port a QoS policy the daemon creates
+	// with these rates; DEL deletes it with the port.
+	Bandwidth *Bandwidth `json:"bandwidth,omitempty"`
+	// Tags are Neutron tags set on the port besides the daemon's static
+	// ones.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// IsZero reports whether s asks for nothing beyond a plain port.
+func (s PortSpec) IsZero() bool {
+	return len(s.SecurityGroupIDs) == 0 && s.IPAddress == "" && s.IPCount <= 1 && !s.AdminStateDown &&
+		len(s.ExtraCreateOpts) == 0 && s.BindingProfile == nil && s.Bandwidth == nil && len(s.Tags) == 0
+}
+
+// Validate checks s before any port is created: the binding profile and
+// bandwidth must be complete, and extra create options may neither set a
+// managed field nor contradict another attribute of s.
+func (s PortSpec) Validate() error {
+	if s.IPAddress != "" && net.ParseIP(s.IPAddress) == nil {
+		return fmt.Errorf("invalid ip_address %q", s.IPAddress)
+	}
+	if s.IPCount < 0 || s.IPCount > MaxIPCount {
+		return fmt.Errorf("ip_count must be between 1 and %d, got %d", MaxIPCount, s.IPCount)
+	}
+	if err := ValidateExtraCreateOpts(s.ExtraCreateOpts); err != nil {
+		return err
+	}
+	if err := ValidateTags(s.Tags); err != nil {
+		return err
+	}
+	if s.BindingProfile != nil {
+		if err := s.BindingProfile.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Bandwidth != nil {
+		if err := s.Bandwidth.Validate(); err != nil {
+			return err
+		}
+		if _, ok := s.ExtraCreateOpts["qos_policy_id"]; ok {
+			return fmt.Errorf("extra_create_opts qos_policy_id conflicts with bandwidth")
+		}
+	}
+	if _, ok := s.ExtraCreateOpts["admin_state_up"]; ok && s.AdminStateDown {
+		return fmt.Errorf("extra_create_opts admin_state_up conflicts with admin_state_down")
+	}
+	return nil
+}
+
+// MaxIPCount bounds PortSpec.IPCount, keeping a single pod from draining a
+// subnet.
+const MaxIPCount = 16
+
+// ManagedPortFields are the port attributes the daemon sets itself, or
+// that tie the port to its container; extra create options are not
+// allowed to override them.
+var ManagedPortFields = map[string]bool{
+	"name":              true,
+	"description":       true,
+	"device_id":         true,
+	"device_owner":      true,
+	"network_id":        true,
+	"fixed_ips":         true,
+	"security_groups":   true,
+	"binding:host_id":   true,
+	"binding:profile":   true,
+	"binding:vnic_type": true,
+	"tags":              true,
+}
+
+// MaxTagLength is the longest tag Neutron accepts.
+const MaxTagLength = 255
+
+// ValidateTags checks that every tag is one Neutron accepts and can filter
+// ports by: not empty, at most MaxTagLength long and without a comma, which
+// separates tags in list filters.
+func ValidateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || len(tag) > MaxTagLength || strings.Contains(tag, ",") {
+			return fmt.Errorf("invalid tag %q: want 1 to %d characters without a comma", tag, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// ValidateExtraCreateOpts rejects extra create options that would override
+// a daemon-managed port attribute.
+func ValidateExtraCreateOpts(extra map[string]interface{}) error {
+	var rejected []string
+	for key := range extra {
+		if ManagedPortFields[key] {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return fmt.Errorf("extra_create_opts may not override managed fields: %s", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// Validate checks that p carries the keys OVN needs to bind a
+// remote-managed port.
+func (p *BindingProfile) Validate() error {
+	var missing []string
+	if p.PCISlot == "" {
+		missing = append(missing, "pci_slot")
+	}
+	if p.CardSerialNumber == "" {
+		missing = append(missing, "card_serial_number")
+	}
+	if p.PFMACAddress == "" {
+		missing = append(missing, "pf_mac_address")
+	}
+	if p.VFNum == nil {
+		missing = append(missing, "vf_num")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("binding_profile is missing %s", strings.Join(missing, ", "))
+	}
+	if _, err := net.ParseMAC(p.PFMACAddress); err != nil {
+		return fmt.Errorf("invalid binding_profile pf_mac_address %q", p.PFMACAddress)
+	}
+	if *p.VFNum < 0 {
+		return fmt.Errorf("invalid binding_profile vf_num %d", *p.VFNum)
+	}
+	return nil
+}
+
+// Validate checks that b asks for at least one rule and that its rates are
+// consistent.
+func (b *Bandwidth) Validate() error {
+	if b.MinKbps < 0 || b.MaxKbps < 0 || b.MaxBurstKbps < 0 {
+		return fmt.Errorf("bandwidth rates must not be negative")
+	}
+	if b.MinKbps == 0 && b.MaxKbps == 0 {
+		return fmt.Errorf("bandwidth needs min_kbps or max_kbps")
+	}
+	if b.MaxBurstKbps > 0 && b.MaxKbps == 0 {
+		return fmt.Errorf("bandwidth max_burst_kbps requires max_kbps")
+	}
+	if b.MinKbps > 0 && b.MaxKbps > 0 && b.MinKbps > b.MaxKbps {
+		return fmt.Errorf("bandwidth min_kbps %d exceeds max_kbps %d", b.MinKbps, b.MaxKbps)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPortSpecValidate(t *testing.T) {
+	vfNum := 1
+	profile := &BindingProfile{PCISlot: "0000:03:00.5", CardSerialNumber: "MT2113X00000", PFMACAddress: "00:53:00:00:00:42", VFNum: &vfNum}
+	tests := []struct {
+		name    string
+		spec    PortSpec
+		wantErr string
+	}{
+		{name: "empty"},
+		{name: "full", spec: PortSpec{
+			SecurityGroupIDs: []string{"sg-1"},
+			IPAddress:        "10.0.0.5",
+			AdminStateDown:   true,
+			ExtraCreateOpts:  map[string]interface{}{"propagate_uplink_status": true},
+			BindingProfile:   profile,
+			Bandwidth:        &Bandwidth{MaxKbps: 10000},
+		}},
+		{name: "bad ip", spec: PortSpec{IPAddress: "10.0.0"}, wantErr: `invalid ip_address "10.0.0"`},
+		{name: "two ips", spec: PortSpec{IPCount: 2}},
+		{name: "too many ips", spec: PortSpec{IPCount: MaxIPCount + 1}, wantErr: "ip_count must be between 1 and 16"},
+		{name: "negative ip count", spec: PortSpec{IPCount: -1}, wantErr: "ip_count"},
+		{name: "tags", spec: PortSpec{Tags: []string{"team=payments"}}},
+		{name: "empty tag", spec: PortSpec{Tags: []string{""}}, wantErr: `invalid tag ""`},
+		{name: "tag with comma", spec: PortSpec{Tags: []string{"a,b"}}, wantErr: `invalid tag "a,b"`},
+		{name: "tag too long", spec: PortSpec{Tags: []string{strings.Repeat("t", MaxTagLength+1)}}, wantErr: "invalid tag"},
+		{name: "tags in extra", spec: PortSpec{ExtraCreateOpts: map[string]interface{}{"tags": []string{"x"}}}, wantErr: "managed fields: tags"},
+		{name: "managed extra", spec: PortSpec{ExtraCreateOpts: map[string]interface{}{"fixed_ips": nil}}, wantErr: "managed fields: fixed_ips"},
+		{name: "incomplete profile", spec: PortSpec{BindingProfile: &BindingProfile{PCISlot: "0000:03:00.5"}}, wantErr: "binding_profile is missing"},
+		{name: "empty bandwidth", spec: PortSpec{Bandwidth: &Bandwidth{}}, wantErr: "min_kbps or max_kbps"},
+		{
+			name:    "qos policy with bandwidth",
+			spec:    PortSpec{Bandwidth: &Bandwidth{MinKbps: 1000}, ExtraCreateOpts: map[string]interface{}{"qos_policy_id": "p"}},
+			wantErr: "conflicts with bandwidth",
+		},
+		{
+			name:    "admin state up with admin state down",
+			spec:    PortSpec{AdminStateDown: true, ExtraCreateOpts: map[string]interface{}{"admin_state_up": true}},
+			wantErr: "conflicts with admin_state_down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPortSpecIsZero(t *testing.T) {
+	if !(PortSpec{}).IsZero() {
+		t.Error("PortSpec{}.IsZero() = false")
+	}
+	if (PortSpec{AdminStateDown: true}).IsZero() {
+		t.Error("IsZero() = true with admin_state_down set")
+	}
+	if (PortSpec{IPCount: 2}).IsZero() {
+		t.Error("IsZero() = true with ip_count 2")
+	}
+	if (PortSpec{Tags: []string{"team=payments"}}).IsZero() {
+		t.Error("IsZero() = true with tags")
+	}
+}
+
+func TestValidateExtraCreateOpts(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]interface{}
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"unmanaged field", map[string]interface{}{"propagate_uplink_status": true}, false},
+		{"network_id", map[string]interface{}{"network_id": "x"}, true},
+		{"fixed_ips", map[string]interface{}{"fixed_ips": []interface{}{}}, true},
+		{"name", map[string]interface{}{"name": "x"}, true},
+		{"description", map[string]interface{}{"description": "x"}, true},
+		{"device_id", map[string]interface{}{"device_id": "x"}, true},
+		{"device_owner", map[string]interface{}{"device_owner": "x"}, true},
+		{"security_groups", map[string]interface{}{"security_groups": []interface{}{}}, true},
+		{"binding:profile", map[string]interface{}{"binding:profile": "x"}, true},
+		{"binding:vnic_type", map[string]interface{}{"binding:vnic_type": "x"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateExtraCreateOpts(tt.extra)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateExtraCreateOpts(%v) error = %v, wantErr %v", tt.extra, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBindingProfileValidate(t *testing.T) {
+	vfNum, negative := 3, -1
+	valid := BindingProfile{
+		PCISlot:          "0000:03:00.5",
+		CardSerialNumber: "MT2113X00000",
+		PFMACAddress:     "00:53:00:00:00:42",
+		VFNum:            &vfNum,
+	}
+	tests := []struct {
+		name    string
+		mutate  func(p *BindingProfile)
+		wantErr string
+	}{
+		{name: "valid", mutate: func(p *BindingProfile) {}},
+		{name: "missing fields", mutate: func(p *BindingProfile) { p.PCISlot, p.VFNum = "", nil }, wantErr: "missing pci_slot, vf_num"},
+		{name: "bad mac", mutate: func(p *BindingProfile) { p.PFMACAddress = "not-a-mac" }, wantErr: "pf_mac_address"},
+		{name: "negative vf", mutate: func(p *BindingProfile) { p.VFNum = &negative }, wantErr: "vf_num -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.mutate(&p)
+			err := p.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBandwidthValidate(t *testing.T) {
+	tests := []struct {
+		bandwidth Bandwidth
+		wantErr   string
+	}{
+		{bandwidth: Bandwidth{MaxKbps: 10000, MaxBurstKbps: 1000}},
+		{bandwidth: Bandwidth{MinKbps: 1000}},
+		{bandwidth: Bandwidth{MinKbps: 1000, MaxKbps: 10000}},
+		{bandwidth: Bandwidth{}, wantErr: "min_kbps or max_kbps"},
+		{bandwidth: Bandwidth{MaxKbps: -1}, wantErr: "negative"},
+		{bandwidth: Bandwidth{MinKbps: 1000, MaxBurstKbps: 100}, wantErr: "requires max_kbps"},
+		{bandwidth: Bandwidth{MinKbps: 2000, MaxKbps: 1000}, wantErr: "exceeds max_kbps"},
+	}
+	for _, tt := range tests {
+		err := tt.bandwidth.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Validate(%+v) error = %v", tt.bandwidth, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate(%+v) error = %v, want it to contain %q", tt.bandwidth, err, tt.wantErr)
+		}
+	}
+}
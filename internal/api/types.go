@@ -2,6 +2,14 @@
 // between the thin CNI plugin and the thick daemon over a Unix domain socket.
 package api
 
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
 const (
 	// SocketPath is the default Unix domain socket path for the daemon.
 	SocketPath = "/var/run/openstack-cni/cni.sock"
@@ -9,10 +17,132 @@ const (
 
 // AddRequest is sent by the thin CNI to create a Neutron port.
 type AddRequest struct {
-	ContainerID      string   `json:"container_id"`
-	NetworkID        string   `json:"network_id"`
-	SubnetID         string   `json:"subnet_id"`
-	SecurityGroupIDs []string `json:"security_group_ids,omitempty"`
+	ContainerID string `json:"container_id"`
+	NetworkID   string `json:"network_id"`
+	SubnetID    string `json:"subnet_id"`
+	// SegmentID scopes the allocation to a segment of a routed network. When
+	// set without SubnetID the daemon picks the segment's subnet.
+	SegmentID string `json:"segment_id,omitempty"`
+	// SubnetIDs lists further candidate subnets, tried in order after
+	// SubnetID when a subnet has no free address left.
+	SubnetIDs []string `json:"subnet_ids,omitempty"`
+	// SubnetName selects the subnet of NetworkID with that name, in place
+	// of SubnetID. Without SubnetName, SubnetID, SegmentID or SubnetIDs,
+	// the subnet is picked among all subnets of NetworkID. SubnetMatch
+	// decides what several candidate subnets resolve to; empty means
+	// SubnetMatchError.
+	SubnetName  string `json:"subnet_name,omitempty"`
+	SubnetMatch string `json:"subnet_match,omitempty"`
+	// PortSpec holds the attributes of the port to create.
+	PortSpec
+	// GatewayIP overrides the gateway derived from the subnet. It must lie
+	// inside the subnet unless OnLink is set.
+	GatewayIP string `json:"gateway_ip,omitempty"`
+	OnLink    bool   `json:"on_link,omitempty"`
+	// PrefixLength, when set, overrides the prefix length of the subnet's
+	// CIDR on the pod's addresses. It must be valid for the subnet's IP
+	// version: 0 to 32 for IPv4, 0 to 128 for IPv6.
+	PrefixLength *int `json:"prefix_length,omitempty"`
+	// FallbackIPAM keeps a port that Neutron created without an IP on the
+	// subnet instead of failing, so the CNI can allocate locally.
+	FallbackIPAM bool `json:"fallback_ipam,omitempty"`
+	// MTU overrides the network's MTU for the pod interface.
+	MTU int `json:"mtu,omitempty"`
+	// RouterID, when set, has the daemon add a route on that router to each
+	// of RouterRouteDestinations with the pod IP as next hop. Requires the
+	// daemon to allow router routes.
+	RouterID                string   `json:"router_id,omitempty"`
+	RouterRouteDestinations []string `json:"router_route_destinations,omitempty"`
+	// PortNaming selects the port naming strategy (see package portname);
+	// empty uses the daemon's. DEL, CHECK and UP must send the same value.
+	PortNaming string `json:"port_naming,omitempty"`
+	// CleanupToken asks for a cleanup token in the response.
+	CleanupToken bool `json:"cleanup_token,omitempty"`
+	// EndpointOverride, when set, sends the request's Neutron calls to this
+	// endpoint instead of the catalog's, e.g. a canary Neutron. Requires
+	// the daemon to allow endpoint overrides. DEL, CHECK and UP must send
+	// the same value.
+	EndpointOverride string `json:"endpoint_override,omitempty"`
+	// PodNamespace and PodName identify the pod, when the runtime passed
+	// them in CNI_ARGS, for the daemon's port events and rate limit. Without
+	// NetworkID, the daemon picks the network of PodNamespace.
+	PodNamespace string `json:"pod_namespace,omitempty"`
+	PodName      string `json:"pod_name,omitempty"`
+}
+
+// Subnet name match strategies, as set in AddRequest.SubnetMatch.
+const (
+	// SubnetMatchError fails an ADD whose subnet name matches several
+	// subnets.
+	SubnetMatchError = "error"
+	// SubnetMatchFirst takes the first matching subnet Neutron lists.
+	SubnetMatchFirst = "first"
+	// SubnetMatchIPv4 and SubnetMatchIPv6 keep the matching subnets of that
+	// IP version, failing unless exactly one is left.
+	SubnetMatchIPv4 = "ipv4"
+	SubnetMatchIPv6 = "ipv6"
+)
+
+// ValidateSubnetMatch checks that match names a subnet match strategy;
+// empty is accepted as the default.
+func ValidateSubnetMatch(match string) error {
+	switch match {
+	case "", SubnetMatchError, SubnetMatchFirst, SubnetMatchIPv4, SubnetMatchIPv6:
+		return nil
+	}
+	return fmt.Errorf("invalid subnet_match %q (want %s, %s, %s or %s)", match, SubnetMatchError, SubnetMatchFirst, SubnetMatchIPv4, SubnetMatchIPv6)
+}
+
+// Route is a static IPAM route to Dst, a CIDR, via the gateway GW. An empty
+// GW routes through the interface's default gateway.
+type Route struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
+
+// ValidateRoutes checks that every route has a CIDR destination and, when
+// set, a gateway IP of the same address family.
+func ValidateRoutes(routes []Route) error {
+	for _, r := range routes {
+		_, dst, err := net.ParseCIDR(r.Dst)
+		if err != nil {
+			return fmt.Errorf("invalid route dst %q: want a CIDR", r.Dst)
+		}
+		if r.GW == "" {
+			continue
+		}
+		gw := net.ParseIP(r.GW)
+		if gw == nil {
+			return fmt.Errorf("invalid route gw %q for dst %s", r.GW, r.Dst)
+		}
+		if (gw.To4() == nil) != (dst.IP.To4() == nil) {
+			return fmt.Errorf("route gw %s does not match the address family of dst %s", r.GW, r.Dst)
+		}
+	}
+	return nil
+}
+
+// Bandwidth is the egress bandwidth of a pod port, in kilobits per second:
+// MaxKbps (with an optional MaxBurstKbps, in kilobits) becomes a bandwidth
+// limit rule and MinKbps a minimum bandwidth rule. At least one of MinKbps
+// and MaxKbps is required.
+type Bandwidth struct {
+	MinKbps      int `json:"min_kbps,omitempty"`
+	MaxKbps      int `json:"max_kbps,omitempty"`
+	MaxBurstKbps int `json:"max_burst_kbps,omitempty"`
+}
+
+// BindingProfile is the binding:profile of an OVN port plugged through a
+// Smart-NIC (binding:vnic_type remote-managed), identifying the VF that
+// backs the pod interface. PCISlot, CardSerialNumber, PFMACAddress and
+// VFNum are required.
+type BindingProfile struct {
+	PCISlot          string `json:"pci_slot"`
+	PCIVendorInfo    string `json:"pci_vendor_info,omitempty"`
+	PhysicalNetwork  string `json:"physical_network,omitempty"`
+	CardSerialNumber string `json:"card_serial_number"`
+	PFMACAddress     string `json:"pf_mac_address"`
+	VFNum            *int   `json:"vf_num"`
 }
 
 // AddResponse returns the Neutron port details needed for OVS delegation.
@@ -22,31 +152,355 @@ type AddResponse struct {
 	IPAddress    string `json:"ip_address"`
 	PrefixLength string `json:"prefix_length"`
 	GatewayIP    string `json:"gateway_ip"`
+	// IPAddresses lists every address of the port on the subnet, starting
+	// with IPAddress, when there are several (see PortSpec.IPCount).
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+	// HostRoutes are the host_routes of the subnet the port was allocated
+	// on, for the CNI to add to the pod's IPAM routes.
+	HostRoutes []Route `json:"host_routes,omitempty"`
+	// FixedIPs lists every fixed IP Neutron assigned to the port, on any
+	// subnet, for diagnostics.
+	FixedIPs []FixedIP `json:"fixed_ips,omitempty"`
+	// SubnetID is the subnet the port was allocated on, which differs from
+	// the requested subnet_id when a candidate of subnet_ids was used.
+	SubnetID string `json:"subnet_id,omitempty"`
+	// DelegatedPrefix is the IPv6 prefix delegated to the subnet when it is
+	// an IPv6 prefix delegation (PD) subnet.
+	DelegatedPrefix string `json:"delegated_prefix,omitempty"`
+	// SubnetCIDR is the CIDR of the subnet the port was allocated on. It
+	// seeds the CNI's fallback IPAM when IPAddress is empty.
+	SubnetCIDR string `json:"subnet_cidr,omitempty"`
+	// FallbackStart and FallbackEnd bound the addresses the CNI's fallback
+	// IPAM may allocate when IPAddress is empty: a block of SubnetCIDR
+	// outside Neutron's allocation pools, allowed on the port.
+	FallbackStart string `json:"fallback_start,omitempty"`
+	FallbackEnd   string `json:"fallback_end,omitempty"`
+	// IPVersion is the IP version of the subnet, 4 or 6, so that callers
+	// building routes and IPAM need not infer it from IPAddress.
+	IPVersion int `json:"ip_version,omitempty"`
+	// InterfaceID is the EUI-64 interface identifier of MACAddress, set on
+	// IPv6 subnets whose ipv6_address_mode is slaac or dhcpv6-stateless, so
+	// that the address the pod autoconfigures can be checked against it.
+	InterfaceID string `json:"interface_id,omitempty"`
+	// ProjectID is the Keystone project owning the port, from its
+	// project_id or, on older Neutron, tenant_id.
+	ProjectID string `json:"project_id,omitempty"`
+	// NetworkID is the network of the port, which the daemon picks from
+	// the pod's namespace when the request has none.
+	NetworkID string `json:"network_id,omitempty"`
+	// SecurityGroups are the IDs of the security groups Neutron applied to
+	// the port, the project's default group included when none was asked
+	// for, so callers can confirm them.
+	SecurityGroups []string `json:"security_groups,omitempty"`
+	// VIFType is the port's binding:vif_type, reported for ports created
+	// with a BindingProfile to confirm how Neutron bound them.
+	VIFType string `json:"vif_type,omitempty"`
+	// Created is true when the port was created for this request, and
+	// false when an existing spare port from the warm pool was reused.
+	Created bool `json:"created"`
+	// MTU is the pod interface MTU: the requested override, or else the
+	// network's MTU. Zero means unknown.
+	MTU int `json:"mtu,omitempty"`
+	// CleanupToken, when requested, authorizes a single DelRequest to delete
+	// exactly this port, as long as it is still the container's port.
+	CleanupToken string `json:"cleanup_token,omitempty"`
+	// Warnings lists conditions operators should know about that did not
+	// fail the ADD, e.g. a feature skipped because a Neutron extension is
+	// unavailable. The CNI prints them to stderr.
+	Warnings []string `json:"warnings,omitempty"`
 }
 
-// DelRequest is sent by the thin CNI to delete a Neutron port.
+// DelRequest is sent by the thin CNI to delete a Neutron port. A request
+// carrying a CleanupToken deletes the port the token was issued for instead
+// of looking ports up by container.
 type DelRequest struct {
 	ContainerID string `json:"container_id"`
 	NetworkID   string `json:"network_id"`
+	// Strict fails the delete on any Neutron error other than 404 instead
+	// of logging it and carrying on with the remaining ports.
+	Strict bool `json:"strict,omitempty"`
+	// RouterID removes the routes via the pod's IPs from that router.
+	RouterID     string `json:"router_id,omitempty"`
+	PortNaming   string `json:"port_naming,omitempty"`
+	CleanupToken string `json:"cleanup_token,omitempty"`
+	// DetachOnly keeps the ports: they are unbound and set down instead of
+	// deleted, so their addresses are preserved for a later ADD asking for
+	// one of them with ip_address to adopt.
+	DetachOnly bool `json:"detach_only,omitempty"`
+	// QoSPolicy deletes the bandwidth QoS policy the ADD created along with
+	// the port.
+	QoSPolicy        bool   `json:"qos_policy,omitempty"`
+	EndpointOverride string `json:"endpoint_override,omitempty"`
+	PodNamespace     string `json:"pod_namespace,omitempty"`
+	PodName          string `json:"pod_name,omitempty"`
 }
 
-// DelResponse acknowledges a delete operation.
+// DelResponse acknowledges a delete operation and lists the Neutron ports
+// that were actually deleted, those returned to the warm pool or detached
+// instead, and those whose delete failed. Ports already gone count as none of them.
+// A strict delete with failures is answered with a 500 carrying this body,
+// OK false and Error set, so it also decodes as an ErrorResponse.
 type DelResponse struct {
+	OK              bool          `json:"ok"`
+	DeletedPortIDs  []string      `json:"deleted_port_ids,omitempty"`
+	PooledPortIDs   []string      `json:"pooled_port_ids,omitempty"`
+	DetachedPortIDs []string      `json:"detached_port_ids,omitempty"`
+	FailedPorts     []PortFailure `json:"failed_ports,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// PortFailure is a Neutron port an operation failed on, and why.
+type PortFailure struct {
+	PortID string `json:"port_id"`
+	Error  string `json:"error"`
+}
+
+// UpRequest is sent by the thin CNI to set a port created with
+// AdminStateDown to admin_state_up=true.
+type UpRequest struct {
+	ContainerID      string `json:"container_id"`
+	NetworkID        string `json:"network_id"`
+	PortID           string `json:"port_id"`
+	PortNaming       string `json:"port_naming,omitempty"`
+	EndpointOverride string `json:"endpoint_override,omitempty"`
+}
+
+// UpResponse acknowledges an up operation.
+type UpResponse struct {
 	OK bool `json:"ok"`
 }
 
 // CheckRequest is sent by the thin CNI to verify a Neutron port exists.
 type CheckRequest struct {
-	ContainerID string `json:"container_id"`
-	NetworkID   string `json:"network_id"`
+	ContainerID      string `json:"container_id"`
+	NetworkID        string `json:"network_id"`
+	PortNaming       string `json:"port_naming,omitempty"`
+	EndpointOverride string `json:"endpoint_override,omitempty"`
+	PodNamespace     string `json:"pod_namespace,omitempty"`
 }
 
-// CheckResponse reports whether the Neutron port exists.
+// CheckResponse reports whether the Neutron port exists and, when it does,
+// the matched port's identity and revision so reconcilers can detect ports
+// that were modified outside of the CNI, and its creation time (RFC 3339)
+// for TTL-based garbage collection. NetworkID is the network the port was
+// found on and MatchCount the number of ports matching the lookup, more than
+// one pointing at leaked duplicates. HostID, VIFType and DeviceID are the
+// port's binding:host_id, binding:vif_type and device_id, to verify it is
+// bound to the expected host and device. When it does not, Reason and Detail
+// explain why and Filter echoes the lookup so a wrong network_id stands out.
 type CheckResponse struct {
-	Exists bool `json:"exists"`
+	Exists         bool         `json:"exists"`
+	PortID         string       `json:"port_id,omitempty"`
+	NetworkID      string       `json:"network_id,omitempty"`
+	MatchCount     int          `json:"match_count,omitempty"`
+	ProjectID      string       `json:"project_id,omitempty"`
+	RevisionNumber int          `json:"revision_number,omitempty"`
+	Status         string       `json:"status,omitempty"`
+	CreatedAt      string       `json:"created_at,omitempty"`
+	HostID         string       `json:"host_id,omitempty"`
+	VIFType        string       `json:"vif_type,omitempty"`
+	DeviceID       string       `json:"device_id,omitempty"`
+	Reason         string       `json:"reason,omitempty"`
+	Detail         string       `json:"detail,omitempty"`
+	Filter         *CheckFilter `json:"filter,omitempty"`
+}
+
+// CheckReasonNoMatchingPort is the CheckResponse.Reason reported when no
+// port matched the filter.
+const CheckReasonNoMatchingPort = "no_matching_port"
+
+// CheckFilter is the Neutron port filter used by a check.
+type CheckFilter struct {
+	Name      string `json:"name"`
+	NetworkID string `json:"network_id"`
+}
+
+// PortResponse is the daemon's view of the port of a container, returned by
+// GET /port: the port's identity, addresses and state, and its binding
+// attributes. MatchCount is the number of ports matching the lookup, more
+// than one pointing at leaked duplicates; the first one is shown.
+type PortResponse struct {
+	PortID         string                 `json:"port_id"`
+	Name           string                 `json:"name"`
+	NetworkID      string                 `json:"network_id"`
+	MACAddress     string                 `json:"mac_address"`
+	FixedIPs       []FixedIP              `json:"fixed_ips,omitempty"`
+	Status         string                 `json:"status,omitempty"`
+	AdminStateUp   bool                   `json:"admin_state_up"`
+	DeviceID       string                 `json:"device_id,omitempty"`
+	DeviceOwner    string                 `json:"device_owner,omitempty"`
+	ProjectID      string                 `json:"project_id,omitempty"`
+	RevisionNumber int                    `json:"revision_number,omitempty"`
+	CreatedAt      string                 `json:"created_at,omitempty"`
+	HostID         string                 `json:"host_id,omitempty"`
+	VIFType        string                 `json:"vif_type,omitempty"`
+	VNICType       string                 `json:"vnic_type,omitempty"`
+	BindingProfile map[string]interface{} `json:"binding_profile,omitempty"`
+	MatchCount     int                    `json:"match_count"`
+}
+
+// ReauthResponse reports a successful re-authentication and, when known, the
+// new token's expiry in RFC 3339 format.
+type ReauthResponse struct {
+	OK        bool   `json:"ok"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// DrainNodeRequest asks the daemon to delete the ports it manages that are
+// bound (binding:host_id) to HostID, e.g. before decommissioning a node.
+// DryRun only lists them.
+type DrainNodeRequest struct {
+	HostID string `json:"host_id"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// DrainNodeResponse lists the ports a drain deleted, or would delete on a
+// dry run, and those whose delete failed. With failures it is sent with a
+// 500, OK false and Error set.
+type DrainNodeResponse struct {
+	OK          bool          `json:"ok"`
+	DryRun      bool          `json:"dry_run,omitempty"`
+	PortIDs     []string      `json:"port_ids,omitempty"`
+	FailedPorts []PortFailure `json:"failed_ports,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// PortManifest lists the ports the daemon manages, as written by
+// openstack-port-daemon --dump-ports, for backup or offline reconciliation
+// of pods and ports, e.g. before a cluster migration.
+type PortManifest struct {
+	Ports []ManifestPort `json:"ports"`
+}
+
+// ManifestPort is a daemon-managed port in a PortManifest. ContainerID is
+// the container the port is attached to, its DeviceID, and Pod the pod, as
+// namespace/name, when the runtime named it at ADD. Its Name also ties it
+// to a container through the port naming strategy. Pooled marks a warm
+// pool spare not attached to any container, Detached a port kept by a
+// detach-only DEL for the pod that will adopt its address.
+type ManifestPort struct {
+	PortID      string    `json:"port_id"`
+	Name        string    `json:"name"`
+	NetworkID   string    `json:"network_id"`
+	MACAddress  string    `json:"mac_address"`
+	FixedIPs    []FixedIP `json:"fixed_ips,omitempty"`
+	DeviceID    string    `json:"device_id,omitempty"`
+	HostID      string    `json:"host_id,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Pod         string    `json:"pod,omitempty"`
+	Pooled      bool      `json:"pooled,omitempty"`
+	Detached    bool      `json:"detached,omitempty"`
+}
+
+// FixedIP is a fixed IP of a Neutron port and the subnet it is on.
+type FixedIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
+}
+
+// HealthResponse reports that the daemon is serving, how long it has been
+// up, and when it last completed a Neutron call successfully (RFC 3339,
+// omitted until the first one).
+type HealthResponse struct {
+	Status             string `json:"status"`
+	UptimeSeconds      int64  `json:"uptime_seconds"`
+	LastNeutronSuccess string `json:"last_neutron_success,omitempty"`
+}
+
+// ObserveRequest reports the duration of a port allocation phase timed by
+// the CNI, such as the delegate ADD, for the daemon's latency metrics.
+type ObserveRequest struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// PhaseDelegate is the ObserveRequest phase of the delegate ADD.
+const PhaseDelegate = "delegate"
+
+// ObserveResponse acknowledges an observation.
+type ObserveResponse struct {
+	OK bool `json:"ok"`
 }
 
-// ErrorResponse is returned when the daemon encounters an error.
+// ReportRequest carries the delegate's result of a successful ADD, so the
+// daemon can log the pod's interfaces and IPs with the port it created.
+type ReportRequest struct {
+	ContainerID string          `json:"container_id"`
+	NetworkID   string          `json:"network_id"`
+	PortID      string          `json:"port_id"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// ReportResponse acknowledges a report.
+type ReportResponse struct {
+	OK bool `json:"ok"`
+}
+
+// ErrorResponse is returned when the daemon encounters an error. Reason,
+// when set, is a machine-readable code for errors a client may want to
+// tell apart, e.g. AddReasonNoIPOnSubnet.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AddReasonNoIPOnSubnet is the ErrorResponse.Reason of an ADD whose port
+// was created with addresses, but none on the requested subnet.
+const AddReasonNoIPOnSubnet = "no_ip_on_subnet"
+
+// ReasonRateLimited is the ErrorResponse.Reason of a 429 refusing an ADD or
+// DEL of a pod over its rate limit. Unlike a daemon shedding load, it does
+// not clear within a few quick retries.
+const ReasonRateLimited = "rate_limited"
+
+// ValidateRequest is a PluginConf-shaped body (for example the config of a
+// NetworkAttachmentDefinition) to be checked against the cloud without
+// creating anything. Unrelated config fields are ignored.
+type ValidateRequest struct {
+	NetworkID        string                 `json:"network_id"`
+	SubnetID         string                 `json:"subnet_id"`
+	SegmentID        string                 `json:"segment_id,omitempty"`
+	SecurityGroupIDs string                 `json:"security_group_ids,omitempty"`
+	RouterID         string                 `json:"router_id,omitempty"`
+	ExtraCreateOpts  map[string]interface{} `json:"extra_create_opts,omitempty"`
+}
+
+// ValidateResponse reports what the daemon found when resolving a
+// ValidateRequest. Valid is true only when every check passed; Errors holds
+// a human-readable reason for each failed check.
+type ValidateResponse struct {
+	Valid                 bool     `json:"valid"`
+	NetworkExists         bool     `json:"network_exists"`
+	SubnetExists          bool     `json:"subnet_exists"`
+	MissingExtensions     []string `json:"missing_extensions,omitempty"`
+	MissingSecurityGroups []string `json:"missing_security_groups,omitempty"`
+	Errors                []string `json:"errors,omitempty"`
+}
+
+// ResultPortIDKey is the top-level key of the CNI result under which the
+// plugin reports the Neutron port ID when report_port_id is set, so that
+// controllers can annotate the pod with it.
+const ResultPortIDKey = "neutron_port_id"
+
+// ParseSecurityGroupIDs splits a comma-separated security_group_ids config
+// value, trimming whitespace and dropping empty entries.
+func ParseSecurityGroupIDs(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if trimmed := strings.TrimSpace(id); trimmed != "" {
+			ids = append(ids, trimmed)
+		}
+	}
+	return ids
+}
+
+// ValidateEndpointOverride checks that endpoint, an endpoint_override, is
+// an absolute http or https URL without query or fragment.
+func ValidateEndpointOverride(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid endpoint_override %q: want an http or https URL", endpoint)
+	}
+	return nil
 }
@@ -46,11 +46,14 @@ func TestAddRequestJSON(t *testing.T) {
 
 func TestAddResponseJSON(t *testing.T) {
 	orig := AddResponse{
-		PortID:       "port-1",
-		MACAddress:   "fa:16:3e:aa:bb:cc",
-		IPAddress:    "10.0.0.5",
-		PrefixLength: "24",
-		GatewayIP:    "10.0.0.1",
+		PortID:          "port-1",
+		MACAddress:      "fa:16:3e:aa:bb:cc",
+		IPAddress:       "10.0.0.5",
+		IPAddresses:     []string{"10.0.0.5", "10.0.0.6"},
+		PrefixLength:    "24",
+		GatewayIP:       "10.0.0.1",
+		DelegatedPrefix: "2001:db8:1::/64",
+		SubnetCIDR:      "10.0.0.0/24",
 	}
 	data, err := json.Marshal(orig)
 	if err != nil {
@@ -60,7 +63,7 @@ func TestAddResponseJSON(t *testing.T) {
 	if err := json.Unmarshal(data, &got); err != nil {
 		t.Fatalf("Unmarshal: %v", err)
 	}
-	if got != orig {
+	if !reflect.DeepEqual(got, orig) {
 		t.Errorf("round-trip mismatch: got %+v, want %+v", got, orig)
 	}
 }
@@ -87,6 +90,7 @@ func TestDelResponseJSON(t *testing.T) {
 	}{
 		{"OK true", DelResponse{OK: true}},
 		{"OK false", DelResponse{OK: false}},
+		{"With deleted port IDs", DelResponse{OK: true, DeletedPortIDs: []string{"port-1", "port-2"}}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -98,7 +102,7 @@ func TestDelResponseJSON(t *testing.T) {
 			if err := json.Unmarshal(data, &got); err != nil {
 				t.Fatalf("Unmarshal: %v", err)
 			}
-			if got != tc.resp {
+			if !reflect.DeepEqual(got, tc.resp) {
 				t.Errorf("round-trip mismatch: got %+v, want %+v", got, tc.resp)
 			}
 		})
@@ -127,6 +131,8 @@ func TestCheckResponseJSON(t *testing.T) {
 	}{
 		{"Exists true", CheckResponse{Exists: true}},
 		{"Exists false", CheckResponse{Exists: false}},
+		{"With port details", CheckResponse{Exists: true, PortID: "p", ProjectID: "proj", RevisionNumber: 3, Status: "ACTIVE"}},
+		{"With not-found reason", CheckResponse{Exists: false, Reason: CheckReasonNoMatchingPort, Detail: "d", Filter: &CheckFilter{Name: "k8s-pod-c", NetworkID: "n"}}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -138,7 +144,7 @@ func TestCheckResponseJSON(t *testing.T) {
 			if err := json.Unmarshal(data, &got); err != nil {
 				t.Fatalf("Unmarshal: %v", err)
 			}
-			if got != tc.resp {
+			if !reflect.DeepEqual(got, tc.resp) {
 				t.Errorf("round-trip mismatch: got %+v, want %+v", got, tc.resp)
 			}
 		})
@@ -219,6 +225,12 @@ func TestJSONFieldNames(t *testing.T) {
 			target:   &DelResponse{},
 			expected: &DelResponse{OK: true},
 		},
+		{
+			name:     "DelResponseWithDeletedPortIDs",
+			jsonStr:  `{"ok":true,"deleted_port_ids":["p1","p2"]}`,
+			target:   &DelResponse{},
+			expected: &DelResponse{OK: true, DeletedPortIDs: []string{"p1", "p2"}},
+		},
 		{
 			name:    "CheckRequest",
 			jsonStr: `{"container_id":"c","network_id":"n"}`,
@@ -234,6 +246,18 @@ func TestJSONFieldNames(t *testing.T) {
 			target:   &CheckResponse{},
 			expected: &CheckResponse{Exists: true},
 		},
+		{
+			name:    "CheckResponseWithPortDetails",
+			jsonStr: `{"exists":true,"port_id":"p","project_id":"proj","revision_number":3,"status":"ACTIVE"}`,
+			target:  &CheckResponse{},
+			expected: &CheckResponse{
+				Exists:         true,
+				PortID:         "p",
+				ProjectID:      "proj",
+				RevisionNumber: 3,
+				Status:         "ACTIVE",
+			},
+		},
 		{
 			name:     "ErrorResponse",
 			jsonStr:  `{"error":"bad"}`,
@@ -245,10 +269,41 @@ func TestJSONFieldNames(t *testing.T) {
 			jsonStr: `{"container_id":"c","network_id":"n","subnet_id":"s","security_group_ids":["sg-1","sg-2"]}`,
 			target:  &AddRequest{},
 			expected: &AddRequest{
-				ContainerID:      "c",
-				NetworkID:        "n",
-				SubnetID:         "s",
-				SecurityGroupIDs: []string{"sg-1", "sg-2"},
+				ContainerID: "c",
+				NetworkID:   "n",
+				SubnetID:    "s",
+				PortSpec:    PortSpec{SecurityGroupIDs: []string{"sg-1", "sg-2"}},
+			},
+		},
+		{
+			name:    "AddRequestWithSegmentID",
+			jsonStr: `{"container_id":"c","network_id":"n","segment_id":"seg"}`,
+			target:  &AddRequest{},
+			expected: &AddRequest{
+				ContainerID: "c",
+				NetworkID:   "n",
+				SegmentID:   "seg",
+			},
+		},
+		{
+			name:    "AddRequestWithSubnetIDs",
+			jsonStr: `{"container_id":"c","network_id":"n","subnet_ids":["s1","s2"]}`,
+			target:  &AddRequest{},
+			expected: &AddRequest{
+				ContainerID: "c",
+				NetworkID:   "n",
+				SubnetIDs:   []string{"s1", "s2"},
+			},
+		},
+		{
+			name:    "AddRequestWithExtraCreateOpts",
+			jsonStr: `{"container_id":"c","network_id":"n","subnet_id":"s","extra_create_opts":{"propagate_uplink_status":true}}`,
+			target:  &AddRequest{},
+			expected: &AddRequest{
+				ContainerID: "c",
+				NetworkID:   "n",
+				SubnetID:    "s",
+				PortSpec:    PortSpec{ExtraCreateOpts: map[string]interface{}{"propagate_uplink_status": true}},
 			},
 		},
 	}
@@ -266,10 +321,10 @@ func TestJSONFieldNames(t *testing.T) {
 
 func TestAddRequestSecurityGroupIDsRoundTrip(t *testing.T) {
 	orig := AddRequest{
-		ContainerID:      "ctr-1",
-		NetworkID:        "net-1",
-		SubnetID:         "sub-1",
-		SecurityGroupIDs: []string{"sg-id-1", "sg-id-2"},
+		ContainerID: "ctr-1",
+		NetworkID:   "net-1",
+		SubnetID:    "sub-1",
+		PortSpec:    PortSpec{SecurityGroupIDs: []string{"sg-id-1", "sg-id-2"}},
 	}
 	data, err := json.Marshal(orig)
 	if err != nil {
@@ -309,3 +364,74 @@ func TestAddRequestSecurityGroupIDsOmittedWhenEmpty(t *testing.T) {
 		t.Error("expected 'security_group_ids' to be omitted when empty")
 	}
 }
+
+func TestParseSecurityGroupIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"single", "sg-1", []string{"sg-1"}},
+		{"trims whitespace", " sg-1 , sg-2 ", []string{"sg-1", "sg-2"}},
+		{"drops empty entries", "sg-1,,sg-2,", []string{"sg-1", "sg-2"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ParseSecurityGroupIDs(tc.in); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseSecurityGroupIDs(%q) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateEndpointOverride(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{"https://neutron-canary.example:9696", false},
+		{"http://10.0.0.2:9696/networking/", false},
+		{"neutron-canary.example:9696", true},
+		{"ftp://neutron-canary.example", true},
+		{"https://", true},
+		{"https://neutron-canary.example/?debug=1", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateEndpointOverride(tt.endpoint); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateEndpointOverride(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []Route
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", routes: []Route{{Dst: "10.96.0.0/12", GW: "10.0.0.254"}, {Dst: "fd00:96::/108"}}},
+		{name: "dst not a CIDR", routes: []Route{{Dst: "10.96.0.0"}}, wantErr: true},
+		{name: "bad gw", routes: []Route{{Dst: "10.96.0.0/12", GW: "gateway"}}, wantErr: true},
+		{name: "mixed families", routes: []Route{{Dst: "10.96.0.0/12", GW: "fd00::1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRoutes(tt.routes); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRoutes() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSubnetMatch(t *testing.T) {
+	for _, match := range []string{"", SubnetMatchError, SubnetMatchFirst, SubnetMatchIPv4, SubnetMatchIPv6} {
+		if err := ValidateSubnetMatch(match); err != nil {
+			t.Errorf("ValidateSubnetMatch(%q) error = %v", match, err)
+		}
+	}
+	if err := ValidateSubnetMatch("last"); err == nil {
+		t.Error("ValidateSubnetMatch(\"last\") accepted an unknown strategy")
+	}
+}
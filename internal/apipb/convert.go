@@ -0,0 +1,365 @@
+// Package apipb holds the protobuf and gRPC mirror of package api, used when
+// the CNI talks to the daemon over gRPC instead of HTTP. The .pb.go files
+// are generated from daemon.proto; this file converts between the generated
+// messages and the api types.
+package apipb
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"openstack-port/internal/api"
+)
+
+// FromAddRequest converts r into its protobuf form. It fails when
+// ExtraCreateOpts holds a value protobuf cannot represent.
+func FromAddRequest(r api.AddRequest) (*AddRequest, error) {
+	var extra *structpb.Struct
+	if r.ExtraCreateOpts != nil {
+		var err error
+		if extra, err = structpb.NewStruct(r.ExtraCreateOpts); err != nil {
+			return nil, err
+		}
+	}
+	var profile *BindingProfile
+	if p := r.BindingProfile; p != nil {
+		profile = &BindingProfile{
+			PciSlot:          p.PCISlot,
+			PciVendorInfo:    p.PCIVendorInfo,
+			PhysicalNetwork:  p.PhysicalNetwork,
+			CardSerialNumber: p.CardSerialNumber,
+			PfMacAddress:     p.PFMACAddress,
+		}
+		if p.VFNum != nil {
+			vfNum := int32(*p.VFNum)
+			profile.VfNum = &vfNum
+		}
+	}
+	var prefixLength *int32
+	if r.PrefixLength != nil {
+		n := int32(*r.PrefixLength)
+		prefixLength = &n
+	}
+	return &AddRequest{
+		ContainerId:             r.ContainerID,
+		NetworkId:               r.NetworkID,
+		SubnetId:                r.SubnetID,
+		SegmentId:               r.SegmentID,
+		SubnetIds:               r.SubnetIDs,
+		SubnetName:              r.SubnetName,
+		SubnetMatch:             r.SubnetMatch,
+		SecurityGroupIds:        r.SecurityGroupIDs,
+		IpAddress:               r.IPAddress,
+		IpCount:                 int32(r.IPCount),
+		GatewayIp:               r.GatewayIP,
+		OnLink:                  r.OnLink,
+		FallbackIpam:            r.FallbackIPAM,
+		AdminStateDown:          r.AdminStateDown,
+		Mtu:                     int32(r.MTU),
+		RouterId:                r.RouterID,
+		RouterRouteDestinations: r.RouterRouteDestinations,
+		PortNaming:              r.PortNaming,
+		CleanupToken:            r.CleanupToken,
+		EndpointOverride:        r.EndpointOverride,
+		ExtraCreateOpts:         extra,
+		BindingProfile:          profile,
+		Bandwidth:               fromBandwidth(r.Bandwidth),
+		Tags:                    r.Tags,
+		PodNamespace:            r.PodNamespace,
+		PodName:                 r.PodName,
+		PrefixLength:            prefixLength,
+	}, nil
+}
+
+// ToAPI converts m into an api.AddRequest.
+func (m *AddRequest) ToAPI() api.AddRequest {
+	var extra map[string]interface{}
+	if m.GetExtraCreateOpts() != nil {
+		extra = m.GetExtraCreateOpts().AsMap()
+	}
+	var profile *api.BindingProfile
+	if p := m.GetBindingProfile(); p != nil {
+		profile = &api.BindingProfile{
+			PCISlot:          p.GetPciSlot(),
+			PCIVendorInfo:    p.GetPciVendorInfo(),
+			PhysicalNetwork:  p.GetPhysicalNetwork(),
+			CardSerialNumber: p.GetCardSerialNumber(),
+			PFMACAddress:     p.GetPfMacAddress(),
+		}
+		if p.VfNum != nil {
+			vfNum := int(p.GetVfNum())
+			profile.VFNum = &vfNum
+		}
+	}
+	var prefixLength *int
+	if m.PrefixLength != nil {
+		n := int(m.GetPrefixLength())
+		prefixLength = &n
+	}
+	return api.AddRequest{
+		ContainerID: m.GetContainerId(),
+		NetworkID:   m.GetNetworkId(),
+		SubnetID:    m.GetSubnetId(),
+		SegmentID:   m.GetSegmentId(),
+		SubnetIDs:   m.GetSubnetIds(),
+		SubnetName:  m.GetSubnetName(),
+		SubnetMatch: m.GetSubnetMatch(),
+		PortSpec: api.PortSpec{
+			SecurityGroupIDs: m.GetSecurityGroupIds(),
+			IPAddress:        m.GetIpAddress(),
+			IPCount:          int(m.GetIpCount()),
+			AdminStateDown:   m.GetAdminStateDown(),
+			ExtraCreateOpts:  extra,
+			BindingProfile:   profile,
+			Bandwidth:        m.GetBandwidth().toAPI(),
+			Tags:             m.GetTags(),
+		},
+		GatewayIP:               m.GetGatewayIp(),
+		OnLink:                  m.GetOnLink(),
+		PrefixLength:            prefixLength,
+		FallbackIPAM:            m.GetFallbackIpam(),
+		MTU:                     int(m.GetMtu()),
+		RouterID:                m.GetRouterId(),
+		RouterRouteDestinations: m.GetRouterRouteDestinations(),
+		PortNaming:              m.GetPortNaming(),
+		CleanupToken:            m.GetCleanupToken(),
+		EndpointOverride:        m.GetEndpointOverride(),
+		PodNamespace:            m.GetPodNamespace(),
+		PodName:                 m.GetPodName(),
+	}
+}
+
+func fromBandwidth(b *api.Bandwidth) *Bandwidth {
+	if b == nil {
+		return nil
+	}
+	return &Bandwidth{MinKbps: int32(b.MinKbps), MaxKbps: int32(b.MaxKbps), MaxBurstKbps: int32(b.MaxBurstKbps)}
+}
+
+func (m *Bandwidth) toAPI() *api.Bandwidth {
+	if m == nil {
+		return nil
+	}
+	return &api.Bandwidth{MinKbps: int(m.GetMinKbps()), MaxKbps: int(m.GetMaxKbps()), MaxBurstKbps: int(m.GetMaxBurstKbps())}
+}
+
+func fromFixedIPs(ips []api.FixedIP) []*FixedIP {
+	var out []*FixedIP
+	for _, ip := range ips {
+		out = append(out, &FixedIP{SubnetId: ip.SubnetID, IpAddress: ip.IPAddress})
+	}
+	return out
+}
+
+func fixedIPsToAPI(ips []*FixedIP) []api.FixedIP {
+	var out []api.FixedIP
+	for _, ip := range ips {
+		out = append(out, api.FixedIP{SubnetID: ip.GetSubnetId(), IPAddress: ip.GetIpAddress()})
+	}
+	return out
+}
+
+func fromRoutes(routes []api.Route) []*Route {
+	var out []*Route
+	for _, r := range routes {
+		out = append(out, &Route{Dst: r.Dst, Gw: r.GW})
+	}
+	return out
+}
+
+func routesToAPI(routes []*Route) []api.Route {
+	var out []api.Route
+	for _, r := range routes {
+		out = append(out, api.Route{Dst: r.GetDst(), GW: r.GetGw()})
+	}
+	return out
+}
+
+// FromAddResponse converts r into its protobuf form.
+func FromAddResponse(r api.AddResponse) *AddResponse {
+	return &AddResponse{
+		PortId:          r.PortID,
+		MacAddress:      r.MACAddress,
+		IpAddress:       r.IPAddress,
+		IpAddresses:     r.IPAddresses,
+		FixedIps:        fromFixedIPs(r.FixedIPs),
+		HostRoutes:      fromRoutes(r.HostRoutes),
+		PrefixLength:    r.PrefixLength,
+		GatewayIp:       r.GatewayIP,
+		SubnetId:        r.SubnetID,
+		DelegatedPrefix: r.DelegatedPrefix,
+		SubnetCidr:      r.SubnetCIDR,
+		IpVersion:       int32(r.IPVersion),
+		InterfaceId:     r.InterfaceID,
+		Created:         r.Created,
+		ProjectId:       r.ProjectID,
+		NetworkId:       r.NetworkID,
+		SecurityGroups:  r.SecurityGroups,
+		FallbackStart:   r.FallbackStart,
+		FallbackEnd:     r.FallbackEnd,
+		VifType:         r.VIFType,
+		Mtu:             int32(r.MTU),
+		CleanupToken:    r.CleanupToken,
+		Warnings:        r.Warnings,
+	}
+}
+
+// ToAPI converts m into an api.AddResponse.
+func (m *AddResponse) ToAPI() api.AddResponse {
+	return api.AddResponse{
+		PortID:          m.GetPortId(),
+		MACAddress:      m.GetMacAddress(),
+		IPAddress:       m.GetIpAddress(),
+		IPAddresses:     m.GetIpAddresses(),
+		FixedIPs:        fixedIPsToAPI(m.GetFixedIps()),
+		HostRoutes:      routesToAPI(m.GetHostRoutes()),
+		PrefixLength:    m.GetPrefixLength(),
+		GatewayIP:       m.GetGatewayIp(),
+		SubnetID:        m.GetSubnetId(),
+		DelegatedPrefix: m.GetDelegatedPrefix(),
+		SubnetCIDR:      m.GetSubnetCidr(),
+		IPVersion:       int(m.GetIpVersion()),
+		InterfaceID:     m.GetInterfaceId(),
+		Created:         m.GetCreated(),
+		ProjectID:       m.GetProjectId(),
+		NetworkID:       m.GetNetworkId(),
+		SecurityGroups:  m.GetSecurityGroups(),
+		FallbackStart:   m.GetFallbackStart(),
+		FallbackEnd:     m.GetFallbackEnd(),
+		VIFType:         m.GetVifType(),
+		MTU:             int(m.GetMtu()),
+		CleanupToken:    m.GetCleanupToken(),
+		Warnings:        m.GetWarnings(),
+	}
+}
+
+// FromDelRequest converts r into its protobuf form.
+func FromDelRequest(r api.DelRequest) *DelRequest {
+	return &DelRequest{
+		ContainerId:      r.ContainerID,
+		NetworkId:        r.NetworkID,
+		Strict:           r.Strict,
+		RouterId:         r.RouterID,
+		PortNaming:       r.PortNaming,
+		CleanupToken:     r.CleanupToken,
+		DetachOnly:       r.DetachOnly,
+		QosPolicy:        r.QoSPolicy,
+		EndpointOverride: r.EndpointOverride,
+		PodNamespace:     r.PodNamespace,
+		PodName:          r.PodName,
+	}
+}
+
+// ToAPI converts m into an api.DelRequest.
+func (m *DelRequest) ToAPI() api.DelRequest {
+	return api.DelRequest{
+		ContainerID:      m.GetContainerId(),
+		NetworkID:        m.GetNetworkId(),
+		Strict:           m.GetStrict(),
+		RouterID:         m.GetRouterId(),
+		PortNaming:       m.GetPortNaming(),
+		CleanupToken:     m.GetCleanupToken(),
+		DetachOnly:       m.GetDetachOnly(),
+		QoSPolicy:        m.GetQosPolicy(),
+		EndpointOverride: m.GetEndpointOverride(),
+		PodNamespace:     m.GetPodNamespace(),
+		PodName:          m.GetPodName(),
+	}
+}
+
+// FromDelResponse converts r into its protobuf form.
+func FromDelResponse(r api.DelResponse) *DelResponse {
+	resp := &DelResponse{
+		Ok:              r.OK,
+		DeletedPortIds:  r.DeletedPortIDs,
+		PooledPortIds:   r.PooledPortIDs,
+		DetachedPortIds: r.DetachedPortIDs,
+		Error:           r.Error,
+	}
+	for _, f := range r.FailedPorts {
+		resp.FailedPorts = append(resp.FailedPorts, &PortFailure{PortId: f.PortID, Error: f.Error})
+	}
+	return resp
+}
+
+// ToAPI converts m into an api.DelResponse.
+func (m *DelResponse) ToAPI() api.DelResponse {
+	resp := api.DelResponse{
+		OK:              m.GetOk(),
+		DeletedPortIDs:  m.GetDeletedPortIds(),
+		PooledPortIDs:   m.GetPooledPortIds(),
+		DetachedPortIDs: m.GetDetachedPortIds(),
+		Error:           m.GetError(),
+	}
+	for _, f := range m.GetFailedPorts() {
+		resp.FailedPorts = append(resp.FailedPorts, api.PortFailure{PortID: f.GetPortId(), Error: f.GetError()})
+	}
+	return resp
+}
+
+// FromCheckRequest converts r into its protobuf form.
+func FromCheckRequest(r api.CheckRequest) *CheckRequest {
+	return &CheckRequest{
+		ContainerId:      r.ContainerID,
+		NetworkId:        r.NetworkID,
+		PortNaming:       r.PortNaming,
+		EndpointOverride: r.EndpointOverride,
+		PodNamespace:     r.PodNamespace,
+	}
+}
+
+// ToAPI converts m into an api.CheckRequest.
+func (m *CheckRequest) ToAPI() api.CheckRequest {
+	return api.CheckRequest{
+		ContainerID:      m.GetContainerId(),
+		NetworkID:        m.GetNetworkId(),
+		PortNaming:       m.GetPortNaming(),
+		EndpointOverride: m.GetEndpointOverride(),
+		PodNamespace:     m.GetPodNamespace(),
+	}
+}
+
+// FromCheckResponse converts r into its protobuf form.
+func FromCheckResponse(r api.CheckResponse) *CheckResponse {
+	resp := &CheckResponse{
+		Exists:         r.Exists,
+		PortId:         r.PortID,
+		NetworkId:      r.NetworkID,
+		MatchCount:     int32(r.MatchCount),
+		ProjectId:      r.ProjectID,
+		RevisionNumber: int32(r.RevisionNumber),
+		Status:         r.Status,
+		CreatedAt:      r.CreatedAt,
+		HostId:         r.HostID,
+		VifType:        r.VIFType,
+		DeviceId:       r.DeviceID,
+		Reason:         r.Reason,
+		Detail:         r.Detail,
+	}
+	if r.Filter != nil {
+		resp.Filter = &CheckFilter{Name: r.Filter.Name, NetworkId: r.Filter.NetworkID}
+	}
+	return resp
+}
+
+// ToAPI converts m into an api.CheckResponse.
+func (m *CheckResponse) ToAPI() api.CheckResponse {
+	resp := api.CheckResponse{
+		Exists:         m.GetExists(),
+		PortID:         m.GetPortId(),
+		NetworkID:      m.GetNetworkId(),
+		MatchCount:     int(m.GetMatchCount()),
+		ProjectID:      m.GetProjectId(),
+		RevisionNumber: int(m.GetRevisionNumber()),
+		Status:         m.GetStatus(),
+		CreatedAt:      m.GetCreatedAt(),
+		HostID:         m.GetHostId(),
+		VIFType:        m.GetVifType(),
+		DeviceID:       m.GetDeviceId(),
+		Reason:         m.GetReason(),
+		Detail:         m.GetDetail(),
+	}
+	if f := m.GetFilter(); f != nil {
+		resp.Filter = &api.CheckFilter{Name: f.GetName(), NetworkID: f.GetNetworkId()}
+	}
+	return resp
+}
@@ -0,0 +1,1517 @@
+// Protobuf mirror of the daemon's JSON API (package api), served over gRPC
+// as an alternative to HTTP. Field names match the JSON keys.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: internal/apipb/daemon.proto
+
+package apipb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AddRequest struct {
+	state                   protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId             string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	NetworkId               string                 `protobuf:"bytes,2,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	SubnetId                string                 `protobuf:"bytes,3,opt,name=subnet_id,json=subnetId,proto3" json:"subnet_id,omitempty"`
+	SegmentId               string                 `protobuf:"bytes,4,opt,name=segment_id,json=segmentId,proto3" json:"segment_id,omitempty"`
+	SubnetIds               []string               `protobuf:"bytes,5,rep,name=subnet_ids,json=subnetIds,proto3" json:"subnet_ids,omitempty"`
+	SecurityGroupIds        []string               `protobuf:"bytes,6,rep,name=security_group_ids,json=securityGroupIds,proto3" json:"security_group_ids,omitempty"`
+	IpAddress               string                 `protobuf:"bytes,7,opt,name=ip_address,json=ipAddress,proto3" json:"ip_address,omitempty"`
+	GatewayIp               string                 `protobuf:"bytes,8,opt,name=gateway_ip,json=gatewayIp,proto3" json:"gateway_ip,omitempty"`
+	OnLink                  bool                   `protobuf:"varint,9,opt,name=on_link,json=onLink,proto3" json:"on_link,omitempty"`
+	FallbackIpam            bool                   `protobuf:"varint,10,opt,name=fallback_ipam,json=fallbackIpam,proto3" json:"fallback_ipam,omitempty"`
+	AdminStateDown          bool                   `protobuf:"varint,11,opt,name=admin_state_down,json=adminStateDown,proto3" json:"admin_state_down,omitempty"`
+	Mtu                     int32                  `protobuf:"varint,12,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	RouterId                string                 `protobuf:"bytes,13,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	RouterRouteDestinations []string               `protobuf:"bytes,14,rep,name=router_route_destinations,json=routerRouteDestinations,proto3" json:"router_route_destinations,omitempty"`
+	PortNaming              string                 `protobuf:"bytes,15,opt,name=port_naming,json=portNaming,proto3" json:"port_naming,omitempty"`
+	CleanupToken            bool                   `protobuf:"varint,16,opt,name=cleanup_token,json=cleanupToken,proto3" json:"cleanup_token,omitempty"`
+	ExtraCreateOpts         *structpb.Struct       `protobuf:"bytes,17,opt,name=extra_create_opts,json=extraCreateOpts,proto3" json:"extra_create_opts,omitempty"`
+	BindingProfile          *BindingProfile        `protobuf:"bytes,18,opt,name=binding_profile,json=bindingProfile,proto3" json:"binding_profile,omitempty"`
+	Bandwidth               *Bandwidth             `protobuf:"bytes,19,opt,name=bandwidth,proto3" json:"bandwidth,omitempty"`
+	IpCount                 int32                  `protobuf:"varint,20,opt,name=ip_count,json=ipCount,proto3" json:"ip_count,omitempty"`
+	EndpointOverride        string                 `protobuf:"bytes,21,opt,name=endpoint_override,json=endpointOverride,proto3" json:"endpoint_override,omitempty"`
+	SubnetName              string                 `protobuf:"bytes,22,opt,name=subnet_name,json=subnetName,proto3" json:"subnet_name,omitempty"`
+	SubnetMatch             string                 `protobuf:"bytes,23,opt,name=subnet_match,json=subnetMatch,proto3" json:"subnet_match,omitempty"`
+	Tags                    []string               `protobuf:"bytes,24,rep,name=tags,proto3" json:"tags,omitempty"`
+	PodNamespace            string                 `protobuf:"bytes,25,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodName                 string                 `protobuf:"bytes,26,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	PrefixLength            *int32                 `protobuf:"varint,27,opt,name=prefix_length,json=prefixLength,proto3,oneof" json:"prefix_length,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *AddRequest) Reset() {
+	*x = AddRequest{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddRequest) ProtoMessage() {}
+
+func (x *AddRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddRequest.ProtoReflect.Descriptor instead.
+func (*AddRequest) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AddRequest) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *AddRequest) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+func (x *AddRequest) GetSubnetId() string {
+	if x != nil {
+		return x.SubnetId
+	}
+	return ""
+}
+
+func (x *AddRequest) GetSegmentId() string {
+	if x != nil {
+		return x.SegmentId
+	}
+	return ""
+}
+
+func (x *AddRequest) GetSubnetIds() []string {
+	if x != nil {
+		return x.SubnetIds
+	}
+	return nil
+}
+
+func (x *AddRequest) GetSecurityGroupIds() []string {
+	if x != nil {
+		return x.SecurityGroupIds
+	}
+	return nil
+}
+
+func (x *AddRequest) GetIpAddress() string {
+	if x != nil {
+		return x.IpAddress
+	}
+	return ""
+}
+
+func (x *AddRequest) GetGatewayIp() string {
+	if x != nil {
+		return x.GatewayIp
+	}
+	return ""
+}
+
+func (x *AddRequest) GetOnLink() bool {
+	if x != nil {
+		return x.OnLink
+	}
+	return false
+}
+
+func (x *AddRequest) GetFallbackIpam() bool {
+	if x != nil {
+		return x.FallbackIpam
+	}
+	return false
+}
+
+func (x *AddRequest) GetAdminStateDown() bool {
+	if x != nil {
+		return x.AdminStateDown
+	}
+	return false
+}
+
+func (x *AddRequest) GetMtu() int32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+func (x *AddRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *AddRequest) GetRouterRouteDestinations() []string {
+	if x != nil {
+		return x.RouterRouteDestinations
+	}
+	return nil
+}
+
+func (x *AddRequest) GetPortNaming() string {
+	if x != nil {
+		return x.PortNaming
+	}
+	return ""
+}
+
+func (x *AddRequest) GetCleanupToken() bool {
+	if x != nil {
+		return x.CleanupToken
+	}
+	return false
+}
+
+func (x *AddRequest) GetExtraCreateOpts() *structpb.Struct {
+	if x != nil {
+		return x.ExtraCreateOpts
+	}
+	return nil
+}
+
+func (x *AddRequest) GetBindingProfile() *BindingProfile {
+	if x != nil {
+		return x.BindingProfile
+	}
+	return nil
+}
+
+func (x *AddRequest) GetBandwidth() *Bandwidth {
+	if x != nil {
+		return x.Bandwidth
+	}
+	return nil
+}
+
+func (x *AddRequest) GetIpCount() int32 {
+	if x != nil {
+		return x.IpCount
+	}
+	return 0
+}
+
+func (x *AddRequest) GetEndpointOverride() string {
+	if x != nil {
+		return x.EndpointOverride
+	}
+	return ""
+}
+
+func (x *AddRequest) GetSubnetName() string {
+	if x != nil {
+		return x.SubnetName
+	}
+	return ""
+}
+
+func (x *AddRequest) GetSubnetMatch() string {
+	if x != nil {
+		return x.SubnetMatch
+	}
+	return ""
+}
+
+func (x *AddRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *AddRequest) GetPodNamespace() string {
+	if x != nil {
+		return x.PodNamespace
+	}
+	return ""
+}
+
+func (x *AddRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *AddRequest) GetPrefixLength() int32 {
+	if x != nil && x.PrefixLength != nil {
+		return *x.PrefixLength
+	}
+	return 0
+}
+
+type Bandwidth struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MinKbps       int32                  `protobuf:"varint,1,opt,name=min_kbps,json=minKbps,proto3" json:"min_kbps,omitempty"`
+	MaxKbps       int32                  `protobuf:"varint,2,opt,name=max_kbps,json=maxKbps,proto3" json:"max_kbps,omitempty"`
+	MaxBurstKbps  int32                  `protobuf:"varint,3,opt,name=max_burst_kbps,json=maxBurstKbps,proto3" json:"max_burst_kbps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Bandwidth) Reset() {
+	*x = Bandwidth{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Bandwidth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Bandwidth) ProtoMessage() {}
+
+func (x *Bandwidth) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Bandwidth.ProtoReflect.Descriptor instead.
+func (*Bandwidth) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Bandwidth) GetMinKbps() int32 {
+	if x != nil {
+		return x.MinKbps
+	}
+	return 0
+}
+
+func (x *Bandwidth) GetMaxKbps() int32 {
+	if x != nil {
+		return x.MaxKbps
+	}
+	return 0
+}
+
+func (x *Bandwidth) GetMaxBurstKbps() int32 {
+	if x != nil {
+		return x.MaxBurstKbps
+	}
+	return 0
+}
+
+type BindingProfile struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	PciSlot          string                 `protobuf:"bytes,1,opt,name=pci_slot,json=pciSlot,proto3" json:"pci_slot,omitempty"`
+	PciVendorInfo    string                 `protobuf:"bytes,2,opt,name=pci_vendor_info,json=pciVendorInfo,proto3" json:"pci_vendor_info,omitempty"`
+	PhysicalNetwork  string                 `protobuf:"bytes,3,opt,name=physical_network,json=physicalNetwork,proto3" json:"physical_network,omitempty"`
+	CardSerialNumber string                 `protobuf:"bytes,4,opt,name=card_serial_number,json=cardSerialNumber,proto3" json:"card_serial_number,omitempty"`
+	PfMacAddress     string                 `protobuf:"bytes,5,opt,name=pf_mac_address,json=pfMacAddress,proto3" json:"pf_mac_address,omitempty"`
+	VfNum            *int32                 `protobuf:"varint,6,opt,name=vf_num,json=vfNum,proto3,oneof" json:"vf_num,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *BindingProfile) Reset() {
+	*x = BindingProfile{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BindingProfile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BindingProfile) ProtoMessage() {}
+
+func (x *BindingProfile) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BindingProfile.ProtoReflect.Descriptor instead.
+func (*BindingProfile) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BindingProfile) GetPciSlot() string {
+	if x != nil {
+		return x.PciSlot
+	}
+	return ""
+}
+
+func (x *BindingProfile) GetPciVendorInfo() string {
+	if x != nil {
+		return x.PciVendorInfo
+	}
+	return ""
+}
+
+func (x *BindingProfile) GetPhysicalNetwork() string {
+	if x != nil {
+		return x.PhysicalNetwork
+	}
+	return ""
+}
+
+func (x *BindingProfile) GetCardSerialNumber() string {
+	if x != nil {
+		return x.CardSerialNumber
+	}
+	return ""
+}
+
+func (x *BindingProfile) GetPfMacAddress() string {
+	if x != nil {
+		return x.PfMacAddress
+	}
+	return ""
+}
+
+func (x *BindingProfile) GetVfNum() int32 {
+	if x != nil && x.VfNum != nil {
+		return *x.VfNum
+	}
+	return 0
+}
+
+type AddResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	PortId          string                 `protobuf:"bytes,1,opt,name=port_id,json=portId,proto3" json:"port_id,omitempty"`
+	MacAddress      string                 `protobuf:"bytes,2,opt,name=mac_address,json=macAddress,proto3" json:"mac_address,omitempty"`
+	IpAddress       string                 `protobuf:"bytes,3,opt,name=ip_address,json=ipAddress,proto3" json:"ip_address,omitempty"`
+	PrefixLength    string                 `protobuf:"bytes,4,opt,name=prefix_length,json=prefixLength,proto3" json:"prefix_length,omitempty"`
+	GatewayIp       string                 `protobuf:"bytes,5,opt,name=gateway_ip,json=gatewayIp,proto3" json:"gateway_ip,omitempty"`
+	SubnetId        string                 `protobuf:"bytes,6,opt,name=subnet_id,json=subnetId,proto3" json:"subnet_id,omitempty"`
+	DelegatedPrefix string                 `protobuf:"bytes,7,opt,name=delegated_prefix,json=delegatedPrefix,proto3" json:"delegated_prefix,omitempty"`
+	SubnetCidr      string                 `protobuf:"bytes,8,opt,name=subnet_cidr,json=subnetCidr,proto3" json:"subnet_cidr,omitempty"`
+	Mtu             int32                  `protobuf:"varint,9,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	CleanupToken    string                 `protobuf:"bytes,10,opt,name=cleanup_token,json=cleanupToken,proto3" json:"cleanup_token,omitempty"`
+	IpVersion       int32                  `protobuf:"varint,11,opt,name=ip_version,json=ipVersion,proto3" json:"ip_version,omitempty"`
+	Created         bool                   `protobuf:"varint,12,opt,name=created,proto3" json:"created,omitempty"`
+	VifType         string                 `protobuf:"bytes,13,opt,name=vif_type,json=vifType,proto3" json:"vif_type,omitempty"`
+	IpAddresses     []string               `protobuf:"bytes,14,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	InterfaceId     string                 `protobuf:"bytes,15,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	Warnings        []string               `protobuf:"bytes,16,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	FixedIps        []*FixedIP             `protobuf:"bytes,17,rep,name=fixed_ips,json=fixedIps,proto3" json:"fixed_ips,omitempty"`
+	ProjectId       string                 `protobuf:"bytes,18,opt,name=project_id,json=projectId,proto3" json:"project_id,omitempty"`
+	HostRoutes      []*Route               `protobuf:"bytes,19,rep,name=host_routes,json=hostRoutes,proto3" json:"host_routes,omitempty"`
+	NetworkId       string                 `protobuf:"bytes,20,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	SecurityGroups  []string               `protobuf:"bytes,21,rep,name=security_groups,json=securityGroups,proto3" json:"security_groups,omitempty"`
+	FallbackStart   string                 `protobuf:"bytes,22,opt,name=fallback_start,json=fallbackStart,proto3" json:"fallback_start,omitempty"`
+	FallbackEnd     string                 `protobuf:"bytes,23,opt,name=fallback_end,json=fallbackEnd,proto3" json:"fallback_end,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *AddResponse) Reset() {
+	*x = AddResponse{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddResponse) ProtoMessage() {}
+
+func (x *AddResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddResponse.ProtoReflect.Descriptor instead.
+func (*AddResponse) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AddResponse) GetPortId() string {
+	if x != nil {
+		return x.PortId
+	}
+	return ""
+}
+
+func (x *AddResponse) GetMacAddress() string {
+	if x != nil {
+		return x.MacAddress
+	}
+	return ""
+}
+
+func (x *AddResponse) GetIpAddress() string {
+	if x != nil {
+		return x.IpAddress
+	}
+	return ""
+}
+
+func (x *AddResponse) GetPrefixLength() string {
+	if x != nil {
+		return x.PrefixLength
+	}
+	return ""
+}
+
+func (x *AddResponse) GetGatewayIp() string {
+	if x != nil {
+		return x.GatewayIp
+	}
+	return ""
+}
+
+func (x *AddResponse) GetSubnetId() string {
+	if x != nil {
+		return x.SubnetId
+	}
+	return ""
+}
+
+func (x *AddResponse) GetDelegatedPrefix() string {
+	if x != nil {
+		return x.DelegatedPrefix
+	}
+	return ""
+}
+
+func (x *AddResponse) GetSubnetCidr() string {
+	if x != nil {
+		return x.SubnetCidr
+	}
+	return ""
+}
+
+func (x *AddResponse) GetMtu() int32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+func (x *AddResponse) GetCleanupToken() string {
+	if x != nil {
+		return x.CleanupToken
+	}
+	return ""
+}
+
+func (x *AddResponse) GetIpVersion() int32 {
+	if x != nil {
+		return x.IpVersion
+	}
+	return 0
+}
+
+func (x *AddResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+func (x *AddResponse) GetVifType() string {
+	if x != nil {
+		return x.VifType
+	}
+	return ""
+}
+
+func (x *AddResponse) GetIpAddresses() []string {
+	if x != nil {
+		return x.IpAddresses
+	}
+	return nil
+}
+
+func (x *AddResponse) GetInterfaceId() string {
+	if x != nil {
+		return x.InterfaceId
+	}
+	return ""
+}
+
+func (x *AddResponse) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+func (x *AddResponse) GetFixedIps() []*FixedIP {
+	if x != nil {
+		return x.FixedIps
+	}
+	return nil
+}
+
+func (x *AddResponse) GetProjectId() string {
+	if x != nil {
+		return x.ProjectId
+	}
+	return ""
+}
+
+func (x *AddResponse) GetHostRoutes() []*Route {
+	if x != nil {
+		return x.HostRoutes
+	}
+	return nil
+}
+
+func (x *AddResponse) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+func (x *AddResponse) GetSecurityGroups() []string {
+	if x != nil {
+		return x.SecurityGroups
+	}
+	return nil
+}
+
+func (x *AddResponse) GetFallbackStart() string {
+	if x != nil {
+		return x.FallbackStart
+	}
+	return ""
+}
+
+func (x *AddResponse) GetFallbackEnd() string {
+	if x != nil {
+		return x.FallbackEnd
+	}
+	return ""
+}
+
+type Route struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dst           string                 `protobuf:"bytes,1,opt,name=dst,proto3" json:"dst,omitempty"`
+	Gw            string                 `protobuf:"bytes,2,opt,name=gw,proto3" json:"gw,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Route) Reset() {
+	*x = Route{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Route) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Route) ProtoMessage() {}
+
+func (x *Route) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Route.ProtoReflect.Descriptor instead.
+func (*Route) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Route) GetDst() string {
+	if x != nil {
+		return x.Dst
+	}
+	return ""
+}
+
+func (x *Route) GetGw() string {
+	if x != nil {
+		return x.Gw
+	}
+	return ""
+}
+
+type FixedIP struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SubnetId      string                 `protobuf:"bytes,1,opt,name=subnet_id,json=subnetId,proto3" json:"subnet_id,omitempty"`
+	IpAddress     string                 `protobuf:"bytes,2,opt,name=ip_address,json=ipAddress,proto3" json:"ip_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FixedIP) Reset() {
+	*x = FixedIP{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FixedIP) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FixedIP) ProtoMessage() {}
+
+func (x *FixedIP) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FixedIP.ProtoReflect.Descriptor instead.
+func (*FixedIP) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FixedIP) GetSubnetId() string {
+	if x != nil {
+		return x.SubnetId
+	}
+	return ""
+}
+
+func (x *FixedIP) GetIpAddress() string {
+	if x != nil {
+		return x.IpAddress
+	}
+	return ""
+}
+
+type DelRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId      string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	NetworkId        string                 `protobuf:"bytes,2,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	Strict           bool                   `protobuf:"varint,3,opt,name=strict,proto3" json:"strict,omitempty"`
+	RouterId         string                 `protobuf:"bytes,4,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	PortNaming       string                 `protobuf:"bytes,5,opt,name=port_naming,json=portNaming,proto3" json:"port_naming,omitempty"`
+	CleanupToken     string                 `protobuf:"bytes,6,opt,name=cleanup_token,json=cleanupToken,proto3" json:"cleanup_token,omitempty"`
+	DetachOnly       bool                   `protobuf:"varint,7,opt,name=detach_only,json=detachOnly,proto3" json:"detach_only,omitempty"`
+	QosPolicy        bool                   `protobuf:"varint,8,opt,name=qos_policy,json=qosPolicy,proto3" json:"qos_policy,omitempty"`
+	EndpointOverride string                 `protobuf:"bytes,9,opt,name=endpoint_override,json=endpointOverride,proto3" json:"endpoint_override,omitempty"`
+	PodNamespace     string                 `protobuf:"bytes,10,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodName          string                 `protobuf:"bytes,11,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *DelRequest) Reset() {
+	*x = DelRequest{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DelRequest) ProtoMessage() {}
+
+func (x *DelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DelRequest.ProtoReflect.Descriptor instead.
+func (*DelRequest) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DelRequest) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *DelRequest) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+func (x *DelRequest) GetStrict() bool {
+	if x != nil {
+		return x.Strict
+	}
+	return false
+}
+
+func (x *DelRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *DelRequest) GetPortNaming() string {
+	if x != nil {
+		return x.PortNaming
+	}
+	return ""
+}
+
+func (x *DelRequest) GetCleanupToken() string {
+	if x != nil {
+		return x.CleanupToken
+	}
+	return ""
+}
+
+func (x *DelRequest) GetDetachOnly() bool {
+	if x != nil {
+		return x.DetachOnly
+	}
+	return false
+}
+
+func (x *DelRequest) GetQosPolicy() bool {
+	if x != nil {
+		return x.QosPolicy
+	}
+	return false
+}
+
+func (x *DelRequest) GetEndpointOverride() string {
+	if x != nil {
+		return x.EndpointOverride
+	}
+	return ""
+}
+
+func (x *DelRequest) GetPodNamespace() string {
+	if x != nil {
+		return x.PodNamespace
+	}
+	return ""
+}
+
+func (x *DelRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+type DelResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Ok              bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	DeletedPortIds  []string               `protobuf:"bytes,2,rep,name=deleted_port_ids,json=deletedPortIds,proto3" json:"deleted_port_ids,omitempty"`
+	PooledPortIds   []string               `protobuf:"bytes,3,rep,name=pooled_port_ids,json=pooledPortIds,proto3" json:"pooled_port_ids,omitempty"`
+	FailedPorts     []*PortFailure         `protobuf:"bytes,4,rep,name=failed_ports,json=failedPorts,proto3" json:"failed_ports,omitempty"`
+	Error           string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	DetachedPortIds []string               `protobuf:"bytes,6,rep,name=detached_port_ids,json=detachedPortIds,proto3" json:"detached_port_ids,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DelResponse) Reset() {
+	*x = DelResponse{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DelResponse) ProtoMessage() {}
+
+func (x *DelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DelResponse.ProtoReflect.Descriptor instead.
+func (*DelResponse) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DelResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *DelResponse) GetDeletedPortIds() []string {
+	if x != nil {
+		return x.DeletedPortIds
+	}
+	return nil
+}
+
+func (x *DelResponse) GetPooledPortIds() []string {
+	if x != nil {
+		return x.PooledPortIds
+	}
+	return nil
+}
+
+func (x *DelResponse) GetFailedPorts() []*PortFailure {
+	if x != nil {
+		return x.FailedPorts
+	}
+	return nil
+}
+
+func (x *DelResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *DelResponse) GetDetachedPortIds() []string {
+	if x != nil {
+		return x.DetachedPortIds
+	}
+	return nil
+}
+
+type PortFailure struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PortId        string                 `protobuf:"bytes,1,opt,name=port_id,json=portId,proto3" json:"port_id,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortFailure) Reset() {
+	*x = PortFailure{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortFailure) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortFailure) ProtoMessage() {}
+
+func (x *PortFailure) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortFailure.ProtoReflect.Descriptor instead.
+func (*PortFailure) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *PortFailure) GetPortId() string {
+	if x != nil {
+		return x.PortId
+	}
+	return ""
+}
+
+func (x *PortFailure) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type CheckRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId      string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	NetworkId        string                 `protobuf:"bytes,2,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	PortNaming       string                 `protobuf:"bytes,3,opt,name=port_naming,json=portNaming,proto3" json:"port_naming,omitempty"`
+	EndpointOverride string                 `protobuf:"bytes,4,opt,name=endpoint_override,json=endpointOverride,proto3" json:"endpoint_override,omitempty"`
+	PodNamespace     string                 `protobuf:"bytes,5,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CheckRequest) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetPortNaming() string {
+	if x != nil {
+		return x.PortNaming
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetEndpointOverride() string {
+	if x != nil {
+		return x.EndpointOverride
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetPodNamespace() string {
+	if x != nil {
+		return x.PodNamespace
+	}
+	return ""
+}
+
+type CheckFilter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	NetworkId     string                 `protobuf:"bytes,2,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckFilter) Reset() {
+	*x = CheckFilter{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckFilter) ProtoMessage() {}
+
+func (x *CheckFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckFilter.ProtoReflect.Descriptor instead.
+func (*CheckFilter) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CheckFilter) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CheckFilter) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Exists         bool                   `protobuf:"varint,1,opt,name=exists,proto3" json:"exists,omitempty"`
+	PortId         string                 `protobuf:"bytes,2,opt,name=port_id,json=portId,proto3" json:"port_id,omitempty"`
+	ProjectId      string                 `protobuf:"bytes,3,opt,name=project_id,json=projectId,proto3" json:"project_id,omitempty"`
+	RevisionNumber int32                  `protobuf:"varint,4,opt,name=revision_number,json=revisionNumber,proto3" json:"revision_number,omitempty"`
+	Status         string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Reason         string                 `protobuf:"bytes,6,opt,name=reason,proto3" json:"reason,omitempty"`
+	Detail         string                 `protobuf:"bytes,7,opt,name=detail,proto3" json:"detail,omitempty"`
+	Filter         *CheckFilter           `protobuf:"bytes,8,opt,name=filter,proto3" json:"filter,omitempty"`
+	CreatedAt      string                 `protobuf:"bytes,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	NetworkId      string                 `protobuf:"bytes,10,opt,name=network_id,json=networkId,proto3" json:"network_id,omitempty"`
+	MatchCount     int32                  `protobuf:"varint,11,opt,name=match_count,json=matchCount,proto3" json:"match_count,omitempty"`
+	HostId         string                 `protobuf:"bytes,12,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	VifType        string                 `protobuf:"bytes,13,opt,name=vif_type,json=vifType,proto3" json:"vif_type,omitempty"`
+	DeviceId       string                 `protobuf:"bytes,14,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_internal_apipb_daemon_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_apipb_daemon_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_internal_apipb_daemon_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *CheckResponse) GetExists() bool {
+	if x != nil {
+		return x.Exists
+	}
+	return false
+}
+
+func (x *CheckResponse) GetPortId() string {
+	if x != nil {
+		return x.PortId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetProjectId() string {
+	if x != nil {
+		return x.ProjectId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetRevisionNumber() int32 {
+	if x != nil {
+		return x.RevisionNumber
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetFilter() *CheckFilter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetNetworkId() string {
+	if x != nil {
+		return x.NetworkId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetMatchCount() int32 {
+	if x != nil {
+		return x.MatchCount
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetVifType() string {
+	if x != nil {
+		return x.VifType
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+var File_internal_apipb_daemon_proto protoreflect.FileDescriptor
+
+const file_internal_apipb_daemon_proto_rawDesc = "" +
+	"\n" +
+	"\x1binternal/apipb/daemon.proto\x12\x10openstackport.v1\x1a\x1cgoogle/protobuf/struct.proto\"\x95\b\n" +
+	"\n" +
+	"AddRequest\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\x02 \x01(\tR\tnetworkId\x12\x1b\n" +
+	"\tsubnet_id\x18\x03 \x01(\tR\bsubnetId\x12\x1d\n" +
+	"\n" +
+	"segment_id\x18\x04 \x01(\tR\tsegmentId\x12\x1d\n" +
+	"\n" +
+	"subnet_ids\x18\x05 \x03(\tR\tsubnetIds\x12,\n" +
+	"\x12security_group_ids\x18\x06 \x03(\tR\x10securityGroupIds\x12\x1d\n" +
+	"\n" +
+	"ip_address\x18\a \x01(\tR\tipAddress\x12\x1d\n" +
+	"\n" +
+	"gateway_ip\x18\b \x01(\tR\tgatewayIp\x12\x17\n" +
+	"\aon_link\x18\t \x01(\bR\x06onLink\x12#\n" +
+	"\rfallback_ipam\x18\n" +
+	" \x01(\bR\ffallbackIpam\x12(\n" +
+	"\x10admin_state_down\x18\v \x01(\bR\x0eadminStateDown\x12\x10\n" +
+	"\x03mtu\x18\f \x01(\x05R\x03mtu\x12\x1b\n" +
+	"\trouter_id\x18\r \x01(\tR\brouterId\x12:\n" +
+	"\x19router_route_destinations\x18\x0e \x03(\tR\x17routerRouteDestinations\x12\x1f\n" +
+	"\vport_naming\x18\x0f \x01(\tR\n" +
+	"portNaming\x12#\n" +
+	"\rcleanup_token\x18\x10 \x01(\bR\fcleanupToken\x12C\n" +
+	"\x11extra_create_opts\x18\x11 \x01(\v2\x17.google.protobuf.StructR\x0fextraCreateOpts\x12I\n" +
+	"\x0fbinding_profile\x18\x12 \x01(\v2 .openstackport.v1.BindingProfileR\x0ebindingProfile\x129\n" +
+	"\tbandwidth\x18\x13 \x01(\v2\x1b.openstackport.v1.BandwidthR\tbandwidth\x12\x19\n" +
+	"\bip_count\x18\x14 \x01(\x05R\aipCount\x12+\n" +
+	"\x11endpoint_override\x18\x15 \x01(\tR\x10endpointOverride\x12\x1f\n" +
+	"\vsubnet_name\x18\x16 \x01(\tR\n" +
+	"subnetName\x12!\n" +
+	"\fsubnet_match\x18\x17 \x01(\tR\vsubnetMatch\x12\x12\n" +
+	"\x04tags\x18\x18 \x03(\tR\x04tags\x12#\n" +
+	"\rpod_namespace\x18\x19 \x01(\tR\fpodNamespace\x12\x19\n" +
+	"\bpod_name\x18\x1a \x01(\tR\apodName\x12(\n" +
+	"\rprefix_length\x18\x1b \x01(\x05H\x00R\fprefixLength\x88\x01\x01B\x10\n" +
+	"\x0e_prefix_length\"g\n" +
+	"\tBandwidth\x12\x19\n" +
+	"\bmin_kbps\x18\x01 \x01(\x05R\aminKbps\x12\x19\n" +
+	"\bmax_kbps\x18\x02 \x01(\x05R\amaxKbps\x12$\n" +
+	"\x0emax_burst_kbps\x18\x03 \x01(\x05R\fmaxBurstKbps\"\xf9\x01\n" +
+	"\x0eBindingProfile\x12\x19\n" +
+	"\bpci_slot\x18\x01 \x01(\tR\apciSlot\x12&\n" +
+	"\x0fpci_vendor_info\x18\x02 \x01(\tR\rpciVendorInfo\x12)\n" +
+	"\x10physical_network\x18\x03 \x01(\tR\x0fphysicalNetwork\x12,\n" +
+	"\x12card_serial_number\x18\x04 \x01(\tR\x10cardSerialNumber\x12$\n" +
+	"\x0epf_mac_address\x18\x05 \x01(\tR\fpfMacAddress\x12\x1a\n" +
+	"\x06vf_num\x18\x06 \x01(\x05H\x00R\x05vfNum\x88\x01\x01B\t\n" +
+	"\a_vf_num\"\xa3\x06\n" +
+	"\vAddResponse\x12\x17\n" +
+	"\aport_id\x18\x01 \x01(\tR\x06portId\x12\x1f\n" +
+	"\vmac_address\x18\x02 \x01(\tR\n" +
+	"macAddress\x12\x1d\n" +
+	"\n" +
+	"ip_address\x18\x03 \x01(\tR\tipAddress\x12#\n" +
+	"\rprefix_length\x18\x04 \x01(\tR\fprefixLength\x12\x1d\n" +
+	"\n" +
+	"gateway_ip\x18\x05 \x01(\tR\tgatewayIp\x12\x1b\n" +
+	"\tsubnet_id\x18\x06 \x01(\tR\bsubnetId\x12)\n" +
+	"\x10delegated_prefix\x18\a \x01(\tR\x0fdelegatedPrefix\x12\x1f\n" +
+	"\vsubnet_cidr\x18\b \x01(\tR\n" +
+	"subnetCidr\x12\x10\n" +
+	"\x03mtu\x18\t \x01(\x05R\x03mtu\x12#\n" +
+	"\rcleanup_token\x18\n" +
+	" \x01(\tR\fcleanupToken\x12\x1d\n" +
+	"\n" +
+	"ip_version\x18\v \x01(\x05R\tipVersion\x12\x18\n" +
+	"\acreated\x18\f \x01(\bR\acreated\x12\x19\n" +
+	"\bvif_type\x18\r \x01(\tR\avifType\x12!\n" +
+	"\fip_addresses\x18\x0e \x03(\tR\vipAddresses\x12!\n" +
+	"\finterface_id\x18\x0f \x01(\tR\vinterfaceId\x12\x1a\n" +
+	"\bwarnings\x18\x10 \x03(\tR\bwarnings\x126\n" +
+	"\tfixed_ips\x18\x11 \x03(\v2\x19.openstackport.v1.FixedIPR\bfixedIps\x12\x1d\n" +
+	"\n" +
+	"project_id\x18\x12 \x01(\tR\tprojectId\x128\n" +
+	"\vhost_routes\x18\x13 \x03(\v2\x17.openstackport.v1.RouteR\n" +
+	"hostRoutes\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\x14 \x01(\tR\tnetworkId\x12'\n" +
+	"\x0fsecurity_groups\x18\x15 \x03(\tR\x0esecurityGroups\x12%\n" +
+	"\x0efallback_start\x18\x16 \x01(\tR\rfallbackStart\x12!\n" +
+	"\ffallback_end\x18\x17 \x01(\tR\vfallbackEnd\")\n" +
+	"\x05Route\x12\x10\n" +
+	"\x03dst\x18\x01 \x01(\tR\x03dst\x12\x0e\n" +
+	"\x02gw\x18\x02 \x01(\tR\x02gw\"E\n" +
+	"\aFixedIP\x12\x1b\n" +
+	"\tsubnet_id\x18\x01 \x01(\tR\bsubnetId\x12\x1d\n" +
+	"\n" +
+	"ip_address\x18\x02 \x01(\tR\tipAddress\"\xf6\x02\n" +
+	"\n" +
+	"DelRequest\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\x02 \x01(\tR\tnetworkId\x12\x16\n" +
+	"\x06strict\x18\x03 \x01(\bR\x06strict\x12\x1b\n" +
+	"\trouter_id\x18\x04 \x01(\tR\brouterId\x12\x1f\n" +
+	"\vport_naming\x18\x05 \x01(\tR\n" +
+	"portNaming\x12#\n" +
+	"\rcleanup_token\x18\x06 \x01(\tR\fcleanupToken\x12\x1f\n" +
+	"\vdetach_only\x18\a \x01(\bR\n" +
+	"detachOnly\x12\x1d\n" +
+	"\n" +
+	"qos_policy\x18\b \x01(\bR\tqosPolicy\x12+\n" +
+	"\x11endpoint_override\x18\t \x01(\tR\x10endpointOverride\x12#\n" +
+	"\rpod_namespace\x18\n" +
+	" \x01(\tR\fpodNamespace\x12\x19\n" +
+	"\bpod_name\x18\v \x01(\tR\apodName\"\xf3\x01\n" +
+	"\vDelResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12(\n" +
+	"\x10deleted_port_ids\x18\x02 \x03(\tR\x0edeletedPortIds\x12&\n" +
+	"\x0fpooled_port_ids\x18\x03 \x03(\tR\rpooledPortIds\x12@\n" +
+	"\ffailed_ports\x18\x04 \x03(\v2\x1d.openstackport.v1.PortFailureR\vfailedPorts\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12*\n" +
+	"\x11detached_port_ids\x18\x06 \x03(\tR\x0fdetachedPortIds\"<\n" +
+	"\vPortFailure\x12\x17\n" +
+	"\aport_id\x18\x01 \x01(\tR\x06portId\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\xc3\x01\n" +
+	"\fCheckRequest\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\x02 \x01(\tR\tnetworkId\x12\x1f\n" +
+	"\vport_naming\x18\x03 \x01(\tR\n" +
+	"portNaming\x12+\n" +
+	"\x11endpoint_override\x18\x04 \x01(\tR\x10endpointOverride\x12#\n" +
+	"\rpod_namespace\x18\x05 \x01(\tR\fpodNamespace\"@\n" +
+	"\vCheckFilter\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\x02 \x01(\tR\tnetworkId\"\xb7\x03\n" +
+	"\rCheckResponse\x12\x16\n" +
+	"\x06exists\x18\x01 \x01(\bR\x06exists\x12\x17\n" +
+	"\aport_id\x18\x02 \x01(\tR\x06portId\x12\x1d\n" +
+	"\n" +
+	"project_id\x18\x03 \x01(\tR\tprojectId\x12'\n" +
+	"\x0frevision_number\x18\x04 \x01(\x05R\x0erevisionNumber\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x16\n" +
+	"\x06reason\x18\x06 \x01(\tR\x06reason\x12\x16\n" +
+	"\x06detail\x18\a \x01(\tR\x06detail\x125\n" +
+	"\x06filter\x18\b \x01(\v2\x1d.openstackport.v1.CheckFilterR\x06filter\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\t \x01(\tR\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"network_id\x18\n" +
+	" \x01(\tR\tnetworkId\x12\x1f\n" +
+	"\vmatch_count\x18\v \x01(\x05R\n" +
+	"matchCount\x12\x17\n" +
+	"\ahost_id\x18\f \x01(\tR\x06hostId\x12\x19\n" +
+	"\bvif_type\x18\r \x01(\tR\avifType\x12\x1b\n" +
+	"\tdevice_id\x18\x0e \x01(\tR\bdeviceId2\xda\x01\n" +
+	"\x06Daemon\x12B\n" +
+	"\x03Add\x12\x1c.openstackport.v1.AddRequest\x1a\x1d.openstackport.v1.AddResponse\x12B\n" +
+	"\x03Del\x12\x1c.openstackport.v1.DelRequest\x1a\x1d.openstackport.v1.DelResponse\x12H\n" +
+	"\x05Check\x12\x1e.openstackport.v1.CheckRequest\x1a\x1f.openstackport.v1.CheckResponseB\x1fZ\x1dopenstack-port/internal/apipbb\x06proto3"
+
+var (
+	file_internal_apipb_daemon_proto_rawDescOnce sync.Once
+	file_internal_apipb_daemon_proto_rawDescData []byte
+)
+
+func file_internal_apipb_daemon_proto_rawDescGZIP() []byte {
+	file_internal_apipb_daemon_proto_rawDescOnce.Do(func() {
+		file_internal_apipb_daemon_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_internal_apipb_daemon_proto_rawDesc), len(file_internal_apipb_daemon_proto_rawDesc)))
+	})
+	return file_internal_apipb_daemon_proto_rawDescData
+}
+
+var file_internal_apipb_daemon_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_internal_apipb_daemon_proto_goTypes = []any{
+	(*AddRequest)(nil),      // 0: openstackport.v1.AddRequest
+	(*Bandwidth)(nil),       // 1: openstackport.v1.Bandwidth
+	(*BindingProfile)(nil),  // 2: openstackport.v1.BindingProfile
+	(*AddResponse)(nil),     // 3: openstackport.v1.AddResponse
+	(*Route)(nil),           // 4: openstackport.v1.Route
+	(*FixedIP)(nil),         // 5: openstackport.v1.FixedIP
+	(*DelRequest)(nil),      // 6: openstackport.v1.DelRequest
+	(*DelResponse)(nil),     // 7: openstackport.v1.DelResponse
+	(*PortFailure)(nil),     // 8: openstackport.v1.PortFailure
+	(*CheckRequest)(nil),    // 9: openstackport.v1.CheckRequest
+	(*CheckFilter)(nil),     // 10: openstackport.v1.CheckFilter
+	(*CheckResponse)(nil),   // 11: openstackport.v1.CheckResponse
+	(*structpb.Struct)(nil), // 12: google.protobuf.Struct
+}
+var file_internal_apipb_daemon_proto_depIdxs = []int32{
+	12, // 0: openstackport.v1.AddRequest.extra_create_opts:type_name -> google.protobuf.Struct
+	2,  // 1: openstackport.v1.AddRequest.binding_profile:type_name -> openstackport.v1.BindingProfile
+	1,  // 2: openstackport.v1.AddRequest.bandwidth:type_name -> openstackport.v1.Bandwidth
+	5,  // 3: openstackport.v1.AddResponse.fixed_ips:type_name -> openstackport.v1.FixedIP
+	4,  // 4: openstackport.v1.AddResponse.host_routes:type_name -> openstackport.v1.Route
+	8,  // 5: openstackport.v1.DelResponse.failed_ports:type_name -> openstackport.v1.PortFailure
+	10, // 6: openstackport.v1.CheckResponse.filter:type_name -> openstackport.v1.CheckFilter
+	0,  // 7: openstackport.v1.Daemon.Add:input_type -> openstackport.v1.AddRequest
+	6,  // 8: openstackport.v1.Daemon.Del:input_type -> openstackport.v1.DelRequest
+	9,  // 9: openstackport.v1.Daemon.Check:input_type -> openstackport.v1.CheckRequest
+	3,  // 10: openstackport.v1.Daemon.Add:output_type -> openstackport.v1.AddResponse
+	7,  // 11: openstackport.v1.Daemon.Del:output_type -> openstackport.v1.DelResponse
+	11, // 12: openstackport.v1.Daemon.Check:output_type -> openstackport.v1.CheckResponse
+	10, // [10:13] is the sub-list for method output_type
+	7,  // [7:10] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_internal_apipb_daemon_proto_init() }
+func file_internal_apipb_daemon_proto_init() {
+	if File_internal_apipb_daemon_proto != nil {
+		return
+	}
+	file_internal_apipb_daemon_proto_msgTypes[0].OneofWrappers = []any{}
+	file_internal_apipb_daemon_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_apipb_daemon_proto_rawDesc), len(file_internal_apipb_daemon_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_internal_apipb_daemon_proto_goTypes,
+		DependencyIndexes: file_internal_apipb_daemon_proto_depIdxs,
+		MessageInfos:      file_internal_apipb_daemon_proto_msgTypes,
+	}.Build()
+	File_internal_apipb_daemon_proto = out.File
+	file_internal_apipb_daemon_proto_goTypes = nil
+	file_internal_apipb_daemon_proto_depIdxs = nil
+}
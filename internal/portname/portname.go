@@ -0,0 +1,96 @@
+// Package portname names the Neutron ports created for containers. The CNI
+// plugin and the daemon resolve the same strategy so that DEL, CHECK and UP
+// find the port ADD created.
+package portname
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Prefix starts every container port name.
+const Prefix = "k8s-pod-"
+
+// MaxLength is the longest port name Neutron accepts.
+const MaxLength = 255
+
+// hashLength is the number of hex digits of the container ID's SHA-256 in
+// hashed names.
+const hashLength = 16
+
+// Strategy names, as set in port_naming and OPENSTACK_CNI_PORT_NAMING.
+const (
+	StrategyDefault = "default"
+	StrategyFullID  = "full_id"
+	StrategyHashed  = "hashed"
+)
+
+// PortNamer derives the Neutron port name of a container. Names must be
+// deterministic, since DEL and CHECK look the port up by name.
+type PortNamer interface {
+	PortName(containerID string) string
+}
+
+// DefaultNamer uses the first 12 characters of the container ID, like
+// docker's short IDs.
+type DefaultNamer struct{}
+
+func (DefaultNamer) PortName(containerID string) string {
+	id := containerID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return Prefix + id
+}
+
+// FullIDNamer uses the whole container ID, or the hashed form when that
+// would make the name longer than MaxLength.
+type FullIDNamer struct{}
+
+func (FullIDNamer) PortName(containerID string) string {
+	return fit(Prefix, containerID)
+}
+
+// HashedNamer uses the first 16 hex digits of the SHA-256 of the container
+// ID, so IDs sharing a prefix do not collide.
+type HashedNamer struct{}
+
+func (HashedNamer) PortName(containerID string) string {
+	return hashed(Prefix, containerID)
+}
+
+// fit returns prefix followed by containerID when that fits in MaxLength,
+// so that Neutron does not reject the create, and the hashed name
+// otherwise.
+func fit(prefix, containerID string) string {
+	if len(prefix)+len(containerID) <= MaxLength {
+		return prefix + containerID
+	}
+	return hashed(prefix, containerID)
+}
+
+// hashed returns prefix followed by the first hashLength hex digits of the
+// SHA-256 of containerID, cutting prefix short if needed to fit MaxLength.
+func hashed(prefix, containerID string) string {
+	if len(prefix) > MaxLength-hashLength {
+		prefix = prefix[:MaxLength-hashLength]
+	}
+	sum := sha256.Sum256([]byte(containerID))
+	return prefix + hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// New returns the namer for strategy. An empty strategy selects
+// DefaultNamer.
+func New(strategy string) (PortNamer, error) {
+	switch strategy {
+	case "", StrategyDefault:
+		return DefaultNamer{}, nil
+	case StrategyFullID:
+		return FullIDNamer{}, nil
+	case StrategyHashed:
+		return HashedNamer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown port naming strategy %q (want %s, %s or %s)", strategy, StrategyDefault, StrategyFullID, StrategyHashed)
+	}
+}
@@ -0,0 +1,103 @@
+package portname
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultNamer(t *testing.T) {
+	tests := []struct {
+		name        string
+		containerID string
+		want        string
+	}{
+		{"long ID truncated", "abcdef1234567890abcdef", "k8s-pod-abcdef123456"},
+		{"exactly 12 chars", "abcdef123456", "k8s-pod-abcdef123456"},
+		{"short ID unchanged", "abc", "k8s-pod-abc"},
+		{"empty string", "", "k8s-pod-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultNamer{}.PortName(tt.containerID)
+			if got != tt.want {
+				t.Errorf("PortName(%q) = %q, want %q", tt.containerID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFullIDNamer(t *testing.T) {
+	if got := (FullIDNamer{}).PortName("abcdef1234567890abcdef"); got != "k8s-pod-abcdef1234567890abcdef" {
+		t.Errorf("PortName() = %q, want k8s-pod-abcdef1234567890abcdef", got)
+	}
+	longID := strings.Repeat("a", MaxLength)
+	if got, want := (FullIDNamer{}).PortName(longID), (HashedNamer{}).PortName(longID); got != want {
+		t.Errorf("PortName() of a %d character ID = %q, want the hashed name %q", len(longID), got, want)
+	}
+}
+
+func TestFit(t *testing.T) {
+	id := "abcdef1234567890aaaa"
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "fits", prefix: Prefix, want: Prefix + id},
+		{name: "exactly MaxLength", prefix: strings.Repeat("p", MaxLength-len(id)), want: strings.Repeat("p", MaxLength-len(id)) + id},
+		{name: "long prefix", prefix: strings.Repeat("p", MaxLength-len(id)+1), want: strings.Repeat("p", MaxLength-len(id)+1) + "84954927a8450b94"},
+		{name: "prefix over MaxLength", prefix: strings.Repeat("p", MaxLength+1), want: strings.Repeat("p", MaxLength-hashLength) + "84954927a8450b94"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fit(tt.prefix, id)
+			if got != tt.want {
+				t.Errorf("fit() = %q, want %q", got, tt.want)
+			}
+			if len(got) > MaxLength {
+				t.Errorf("fit() returned %d characters, more than %d", len(got), MaxLength)
+			}
+		})
+	}
+}
+
+func TestHashedNamer(t *testing.T) {
+	n := HashedNamer{}
+	a := n.PortName("abcdef1234567890aaaa")
+	b := n.PortName("abcdef1234567890bbbb")
+
+	if want := "k8s-pod-84954927a8450b94"; a != want {
+		t.Errorf("PortName() = %q, want %q", a, want)
+	}
+	if a == b {
+		t.Errorf("IDs sharing a 16 character prefix both map to %q", a)
+	}
+	if a != n.PortName("abcdef1234567890aaaa") {
+		t.Error("PortName() is not deterministic")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     PortNamer
+		wantErr  bool
+	}{
+		{strategy: "", want: DefaultNamer{}},
+		{strategy: StrategyDefault, want: DefaultNamer{}},
+		{strategy: StrategyFullID, want: FullIDNamer{}},
+		{strategy: StrategyHashed, want: HashedNamer{}},
+		{strategy: "pod_uid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			got, err := New(tt.strategy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New(%q) error = %v, wantErr %t", tt.strategy, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("New(%q) = %T, want %T", tt.strategy, got, tt.want)
+			}
+		})
+	}
+}